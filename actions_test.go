@@ -0,0 +1,152 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package frisbee
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/loopholelabs/logging"
+	"github.com/loopholelabs/testing/conn/pair"
+
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+type actionsTestKey struct{}
+
+// actionsTestHandlerTable returns a HandlerTable where operation 10 returns UPDATE, operation 11 records whether
+// its context has been updated, and operation 12 returns SHUTDOWN. If echo is true, the packets are sent back.
+func actionsTestHandlerTable(echo bool, updated *atomic.Int64) HandlerTable {
+	respond := func(incoming *packet.Packet) *packet.Packet {
+		if echo {
+			return incoming
+		}
+		return nil
+	}
+	return HandlerTable{
+		10: func(_ context.Context, incoming *packet.Packet) (*packet.Packet, Action) {
+			return respond(incoming), UPDATE
+		},
+		11: func(ctx context.Context, incoming *packet.Packet) (*packet.Packet, Action) {
+			if ctx.Value(actionsTestKey{}) != nil {
+				updated.Add(1)
+			}
+			return respond(incoming), NONE
+		},
+		12: func(_ context.Context, incoming *packet.Packet) (*packet.Packet, Action) {
+			return respond(incoming), SHUTDOWN
+		},
+	}
+}
+
+func writeActionsTestPacket(t *testing.T, c *Client, operation uint16) {
+	p := packet.Get()
+	p.Metadata.Operation = operation
+	require.NoError(t, c.WritePacket(p))
+	packet.Put(p)
+	require.NoError(t, c.Flush())
+}
+
+func testServerActions(t *testing.T, concurrency uint64) {
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+
+	var updated, updates atomic.Int64
+	s, err := NewServer(actionsTestHandlerTable(false, &updated), context.Background(), WithLogger(emptyLogger))
+	require.NoError(t, err)
+	s.SetConcurrency(concurrency)
+	s.UpdateContext = func(ctx context.Context, _ *Async) context.Context {
+		updates.Add(1)
+		return context.WithValue(ctx, actionsTestKey{}, struct{}{})
+	}
+
+	c, err := NewClient(HandlerTable{}, context.Background(), WithLogger(emptyLogger))
+	require.NoError(t, err)
+
+	serverConn, clientConn, err := pair.New()
+	require.NoError(t, err)
+	go s.ServeConn(serverConn)
+	require.NoError(t, c.FromConn(clientConn))
+
+	writeActionsTestPacket(t, c, 10)
+	require.Eventually(t, func() bool {
+		return updates.Load() == 1
+	}, DefaultDeadline, time.Millisecond*10)
+
+	// Packets handled after the UPDATE action use the updated context
+	writeActionsTestPacket(t, c, 11)
+	require.Eventually(t, func() bool {
+		return updated.Load() == 1
+	}, DefaultDeadline, time.Millisecond*10)
+
+	writeActionsTestPacket(t, c, 12)
+	require.Eventually(t, func() bool {
+		return s.shutdown.Load()
+	}, DefaultDeadline, time.Millisecond*10)
+	s.wg.Wait()
+	require.Eventually(t, c.Closed, DefaultDeadline, time.Millisecond*10)
+	_ = c.Close()
+}
+
+func TestServerActionsSingle(t *testing.T) {
+	t.Parallel()
+	testServerActions(t, 1)
+}
+
+func TestServerActionsUnlimited(t *testing.T) {
+	t.Parallel()
+	testServerActions(t, 0)
+}
+
+func TestServerActionsLimited(t *testing.T) {
+	t.Parallel()
+	testServerActions(t, 4)
+}
+
+func TestClientActions(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+
+	s, err := NewServer(HandlerTable{
+		10: func(_ context.Context, incoming *packet.Packet) (*packet.Packet, Action) {
+			return incoming, NONE
+		},
+		11: func(_ context.Context, incoming *packet.Packet) (*packet.Packet, Action) {
+			return incoming, NONE
+		},
+		12: func(_ context.Context, incoming *packet.Packet) (*packet.Packet, Action) {
+			return incoming, NONE
+		},
+	}, context.Background(), WithLogger(emptyLogger))
+	require.NoError(t, err)
+	s.SetConcurrency(1)
+
+	var updated, updates atomic.Int64
+	c, err := NewClient(actionsTestHandlerTable(false, &updated), context.Background(), WithLogger(emptyLogger))
+	require.NoError(t, err)
+	c.UpdateContext = func(ctx context.Context, _ *Async) context.Context {
+		updates.Add(1)
+		return context.WithValue(ctx, actionsTestKey{}, struct{}{})
+	}
+
+	serverConn, clientConn, err := pair.New()
+	require.NoError(t, err)
+	go s.ServeConn(serverConn)
+	require.NoError(t, c.FromConn(clientConn))
+
+	writeActionsTestPacket(t, c, 11)
+	writeActionsTestPacket(t, c, 10)
+	writeActionsTestPacket(t, c, 11)
+	require.Eventually(t, func() bool {
+		return updated.Load() == 1 && updates.Load() == 1
+	}, DefaultDeadline, time.Millisecond*10)
+
+	writeActionsTestPacket(t, c, 12)
+	require.Eventually(t, c.Closed, DefaultDeadline, time.Millisecond*10)
+
+	require.NoError(t, s.Shutdown())
+}
@@ -6,7 +6,6 @@ import (
 	"bufio"
 	"context"
 	"crypto/tls"
-	"encoding/binary"
 	"errors"
 	"net"
 	"sync"
@@ -14,10 +13,10 @@ import (
 	"time"
 
 	"github.com/loopholelabs/common/pkg/queue"
-	"github.com/loopholelabs/logging/loggers/noop"
 	"github.com/loopholelabs/logging/types"
 
 	"github.com/loopholelabs/frisbee-go/internal/dialer"
+	"github.com/loopholelabs/frisbee-go/pkg/capture"
 	"github.com/loopholelabs/frisbee-go/pkg/metadata"
 	"github.com/loopholelabs/frisbee-go/pkg/packet"
 )
@@ -43,52 +42,157 @@ type Async struct {
 	streams            map[uint16]*Stream
 	newStreamHandlerMu sync.Mutex
 	newStreamHandler   NewStreamHandler
+	metrics            Metrics
+	pingSent           atomic.Int64
+	tap                Tap
+	compressor         *compressor
+	headerVersion      uint8
+	writeVersion       uint8
+	checksums          bool
+	writeChecksums     bool
+	extensions         bool
+	advertised         atomic.Bool
+	peerExtensions     atomic.Bool
+	extensionsCh       chan struct{}
+	session            atomic.Pointer[session]
+	sessionHandler     sessionHandler
+	sessionPending     atomic.Bool
+	deliveries         *deliveries
+	ownsDeliveries     bool
+	received           *deduplicator
+	header             []byte
+	id                 uint64
 }
 
 // ConnectAsync creates a new TCP connection (using net.Dial) and wraps it in a frisbee connection
 func ConnectAsync(addr string, keepAlive time.Duration, logger types.Logger, TLSConfig *tls.Config, streamHandler ...NewStreamHandler) (*Async, error) {
+	return ConnectAsyncWithOptions(addr, loadOptions(WithKeepAlive(keepAlive), WithLogger(logger), WithTLS(TLSConfig)), streamHandler...)
+}
+
+// ConnectAsyncWithOptions creates a new TCP connection (using net.Dial) and wraps it in a frisbee connection
+// that is configured using the given Options
+func ConnectAsyncWithOptions(addr string, options *Options, streamHandler ...NewStreamHandler) (*Async, error) {
+	if options == nil {
+		options = loadOptions()
+	}
+
+	conn, err := dial(addr, options)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewAsyncWithOptions(conn, options, streamHandler...), nil
+}
+
+// dial creates a new TCP connection (using net.Dial, and TLS if it is configured by the Options)
+func dial(addr string, options *Options) (net.Conn, error) {
 	var conn net.Conn
 	var err error
 
 	d := dialer.NewRetry()
 
-	if TLSConfig != nil {
-		conn, err = d.DialTLS("tcp", addr, TLSConfig)
+	if options.TLSConfig != nil {
+		conn, err = d.DialTLS("tcp", addr, options.TLSConfig)
 	} else {
 		conn, err = d.Dial("tcp", addr)
 		if err == nil {
 			_ = conn.(*net.TCPConn).SetKeepAlive(true)
-			_ = conn.(*net.TCPConn).SetKeepAlivePeriod(keepAlive)
+			_ = conn.(*net.TCPConn).SetKeepAlivePeriod(options.KeepAlive)
 		}
 	}
 
-	if err != nil {
-		return nil, err
-	}
-
-	return NewAsync(conn, logger, streamHandler...), nil
+	return conn, err
 }
 
 // NewAsync takes an existing net.Conn object and wraps it in a frisbee connection
 func NewAsync(c net.Conn, logger types.Logger, streamHandler ...NewStreamHandler) (conn *Async) {
-	conn = &Async{
-		conn:     c,
-		writer:   bufio.NewWriterSize(c, DefaultBufferSize),
-		incoming: queue.NewCircular[packet.Packet, *packet.Packet](DefaultBufferSize),
-		flushCh:  make(chan struct{}, 3),
-		closeCh:  make(chan struct{}),
-		streams:  make(map[uint16]*Stream),
-		logger:   logger,
+	return NewAsyncWithOptions(c, loadOptions(WithLogger(logger)), streamHandler...)
+}
+
+// NewAsyncWithOptions takes an existing net.Conn object and wraps it in a frisbee connection
+// that is configured using the given Options. If a pre-shared key is set (see WithPSK), the
+// net.Conn is wrapped in a PSKConn first.
+func NewAsyncWithOptions(c net.Conn, options *Options, streamHandler ...NewStreamHandler) (conn *Async) {
+	return newAsync(c, options, connState{}, streamHandler...)
+}
+
+// connState is the state that a Client or Server shares with the connections that it creates
+type connState struct {
+	// session is the session that the connection is part of (if it is not nil), and sessionHandler is called for the
+	// SESSION packets that the connection receives. If a sessionHandler is given without a session, the connection is waiting
+	// for the other side to start or resume a session, and the sessionHandler is called with nil if a packet arrives that is
+	// not part of one.
+	session        *session
+	sessionHandler sessionHandler
+
+	// deliveries tracks the packets written with WriteReliable, and received deduplicates the ones that are read.
+	// If reliable delivery is enabled and they are nil, the connection creates its own.
+	deliveries *deliveries
+	received   *deduplicator
+}
+
+// newAsync creates a new frisbee connection that shares the given state
+func newAsync(c net.Conn, options *Options, state connState, streamHandler ...NewStreamHandler) (conn *Async) {
+	if options == nil {
+		options = loadOptions()
+	} else {
+		options = loadOptions(WithOptions(*options))
 	}
 
-	if logger == nil {
-		conn.logger = noop.New(types.InfoLevel)
+	if options.PSK != nil {
+		c = NewPSKConn(c, options.PSK)
+	}
+
+	conn = &Async{
+		conn:          c,
+		writer:        bufio.NewWriterSize(c, DefaultBufferSize),
+		incoming:      queue.NewCircular[packet.Packet, *packet.Packet](DefaultBufferSize),
+		flushCh:       make(chan struct{}, 3),
+		closeCh:       make(chan struct{}),
+		extensionsCh:  make(chan struct{}),
+		streams:       make(map[uint16]*Stream),
+		logger:        options.Logger,
+		metrics:       options.Metrics,
+		tap:           options.Tap,
+		compressor:    newCompressor(options.Compression),
+		headerVersion: headerVersion(options),
+		writeVersion:  metadata.Version1,
+		checksums:     options.Checksums,
+		extensions:    options.Extensions,
+		id:            asyncIDs.Add(1),
 	}
 
 	if len(streamHandler) > 0 && streamHandler[0] != nil {
 		conn.newStreamHandler = streamHandler[0]
 	}
 
+	conn.session.Store(state.session)
+	conn.sessionHandler = state.sessionHandler
+	conn.sessionPending.Store(state.session == nil && state.sessionHandler != nil)
+
+	if options.Reliable != nil {
+		conn.deliveries, conn.received = state.deliveries, state.received
+		if conn.deliveries == nil {
+			conn.deliveries = newDeliveries(options.Reliable, func(p *packet.Packet) error {
+				return conn.writePacket(p, true)
+			})
+			conn.ownsDeliveries = true
+		}
+		if conn.received == nil {
+			conn.received = newDeduplicator(options.Reliable.DedupWindow)
+		}
+	}
+
+	conn.metrics.ConnectionOpened()
+
+	if p := newNegotiatePacket(conn.compressor, conn.headerVersion, conn.checksums, conn.extensions); p != nil {
+		conn.advertised.Store(true)
+		if err := conn.writePacket(p, false); err != nil {
+			conn.Logger().Debug().Err(err).Msg("error while writing NEGOTIATE packet")
+		}
+		packet.Put(p)
+	}
+
 	conn.wg.Add(1)
 	go conn.flushLoop()
 
@@ -152,6 +256,11 @@ func (c *Async) HandshakeContext(ctx context.Context) error {
 	return NotTLSConnectionError
 }
 
+// ID returns the unique ID of the frisbee connection, which is used to identify it in packet captures
+func (c *Async) ID() uint64 {
+	return c.id
+}
+
 // LocalAddr returns the local address of the underlying net.Conn
 func (c *Async) LocalAddr() net.Addr {
 	return c.conn.LocalAddr()
@@ -167,6 +276,12 @@ func (c *Async) CloseChannel() <-chan struct{} {
 	return c.closeCh
 }
 
+// ExtensionsChannel returns a channel that is closed once the other side of the frisbee connection has advertised
+// that it can receive packet headers and trace contexts (see WithExtensions)
+func (c *Async) ExtensionsChannel() <-chan struct{} {
+	return c.extensionsCh
+}
+
 // WritePacket takes a packet.Packet and queues it up to send asynchronously.
 //
 // If packet.Metadata.ContentLength == 0, then the content array's length must be 0. Otherwise, it is required that packet.Metadata.ContentLength == len(content).
@@ -177,6 +292,23 @@ func (c *Async) WritePacket(p *packet.Packet) error {
 	return c.writePacket(p, true)
 }
 
+// WriteError queues up an ERROR packet for the packet with the given ID, to tell the
+// other side of the connection that the packet could not be handled (see ErrorFrame)
+func (c *Async) WriteError(id uint16, code ErrorCode, message string) error {
+	p := NewErrorPacket(id, code, message)
+	err := c.writePacket(p, true)
+	packet.Put(p)
+	return err
+}
+
+// writeResponse queues up a packet returned by a Handler, which may be an ERROR packet (see TypedHandler)
+func (c *Async) writeResponse(p *packet.Packet) error {
+	if p.Metadata.Operation == ERROR {
+		return c.writePacket(p, true)
+	}
+	return c.WritePacket(p)
+}
+
 // ReadPacket is a blocking function that will wait until a Frisbee packet is available and then return it (and its content).
 // In the event that the connection is closed, ReadPacket will return an error.
 func (c *Async) ReadPacket() (*packet.Packet, error) {
@@ -254,7 +386,9 @@ func (c *Async) Closed() bool {
 
 // Raw shuts off all of frisbee's underlying functionality and converts the frisbee connection into a normal TCP connection (net.Conn)
 func (c *Async) Raw() net.Conn {
-	_ = c.close()
+	if c.close() == nil {
+		c.metrics.ConnectionClosed(nil)
+	}
 	return c.conn
 }
 
@@ -269,6 +403,23 @@ func (c *Async) NewStream(id uint16) (stream *Stream) {
 	return
 }
 
+// writeStreamPacket implements streamConn
+func (c *Async) writeStreamPacket(p *packet.Packet) error {
+	return c.writePacket(p, true)
+}
+
+// deleteStream implements streamConn
+func (c *Async) deleteStream(id uint16) {
+	c.streamsMu.Lock()
+	delete(c.streams, id)
+	c.streamsMu.Unlock()
+}
+
+// streamMetrics implements streamConn
+func (c *Async) streamMetrics() Metrics {
+	return c.metrics
+}
+
 // SetNewStreamHandler sets the callback handler for new streams.
 //
 // It's important to note that this handler is called for new streams and if it is
@@ -288,66 +439,116 @@ func (c *Async) Close() error {
 	if err != nil && errors.Is(err, ConnectionClosed) {
 		return nil
 	}
+	c.metrics.ConnectionClosed(nil)
 	_ = c.conn.Close()
 	return err
 }
 
 // write packet is the internal write packet function that does not check for reserved operations.
 func (c *Async) writePacket(p *packet.Packet, closeOnErr bool) error {
+	return c.write(p, closeOnErr, true)
+}
+
+// retransmitPacket writes a packet that has already been recorded by the connection's session (see session.resume)
+func (c *Async) retransmitPacket(p *packet.Packet) error {
+	return c.write(p, true, false)
+}
+
+// write writes the packet to the write buffer, and records it in the connection's session (if it has one
+// and the packet is part of it) when record is true
+func (c *Async) write(p *packet.Packet, closeOnErr bool, record bool) error {
 	if int(p.Metadata.ContentLength) != p.Content.Len() {
 		return InvalidContentLength
 	}
 
-	encodedMetadata := metadata.GetBuffer()
-	binary.BigEndian.PutUint16(encodedMetadata[metadata.IdOffset:metadata.IdOffset+metadata.IdSize], p.Metadata.Id)
-	binary.BigEndian.PutUint16(encodedMetadata[metadata.OperationOffset:metadata.OperationOffset+metadata.OperationSize], p.Metadata.Operation)
-	binary.BigEndian.PutUint32(encodedMetadata[metadata.ContentLengthOffset:metadata.ContentLengthOffset+metadata.ContentLengthSize], p.Metadata.ContentLength)
+	content := p.Content.Bytes()
+	extensions := p.Extensions
+	if c.peerExtensions.Load() {
+		var err error
+		extensions, err = extensions.WithHeaders(p.Headers)
+		if err != nil {
+			return err
+		}
+	} else {
+		if len(p.Headers) > 0 {
+			return HeadersNotNegotiated
+		}
+		extensions = extensions.Without(metadata.ExtensionTrace)
+	}
+	if c.compressor != nil && p.Metadata.ContentLength > 0 && (p.Metadata.Operation > RESERVED9 || p.Metadata.Operation == STREAM) {
+		if compressed, flags := c.compressor.compress(content); compressed != nil {
+			content = compressed
+			extensions = append(extensions[:len(extensions):len(extensions)], metadata.Extension{Type: metadata.ExtensionFlags, Value: []byte{flags}})
+		}
+	}
 
 	c.Lock()
 	if c.closed.Load() {
 		c.Unlock()
 		return ConnectionClosed
 	}
-	err := c.conn.SetWriteDeadline(time.Now().Add(DefaultDeadline))
+	headerSize, extensionsSize, err := c.writeLocked(p, content, extensions)
 	if err != nil {
 		c.Unlock()
 		if c.closed.Load() {
-			c.Logger().Debug().Err(ConnectionClosed).Uint16("Packet ID", p.Metadata.Id).Msg("error while setting write deadline before writing packet")
+			c.Logger().Debug().Err(ConnectionClosed).Uint16("Packet ID", p.Metadata.Id).Msg("error while writing packet")
 			return ConnectionClosed
 		}
-		c.Logger().Debug().Err(err).Uint16("Packet ID", p.Metadata.Id).Msg("error while setting write deadline before writing packet")
-		if closeOnErr {
+		c.Logger().Debug().Err(err).Uint16("Packet ID", p.Metadata.Id).Msg("error while writing packet")
+		if closeOnErr && headerSize >= 0 {
 			return c.closeWithError(err)
 		}
 		return err
 	}
-	_, err = c.writer.Write(encodedMetadata[:])
-	metadata.PutBuffer(encodedMetadata)
+	if s := c.session.Load(); record && s != nil && isSessionPacket(p.Metadata.Operation) {
+		s.record(p)
+	}
+	c.Unlock()
+
+	if extensionsSize > 0 {
+		c.metrics.PacketWritten(EXTENSION, extensionsSize)
+	}
+	c.metrics.PacketWritten(p.Metadata.Operation, headerSize+len(content))
+	if c.tap != nil {
+		c.tap.Tap(capture.DirectionWrite, c.id, p)
+	}
+
+	return nil
+}
+
+// writeLocked writes the packet with the given content and extensions to the write buffer using the current header version
+// (followed by its checksum if checksums are used), and returns the size of the packet's header and checksum and the size
+// of the EXTENSION packet that was written before it (if any).
+//
+// It must be called with the lock held. If the packet cannot be encoded, nothing is written and a negative header size
+// is returned, because the connection can still be used.
+func (c *Async) writeLocked(p *packet.Packet, content []byte, extensions metadata.Extensions) (int, int, error) {
+	var extensionsSize int
+	var err error
+	c.header, extensionsSize, err = appendHeader(c.header[:0], c.writeVersion, c.writeChecksums, p, len(content), extensions)
+	if err != nil {
+		return -1, 0, err
+	}
+	headerSize := len(c.header)
+	err = c.conn.SetWriteDeadline(time.Now().Add(DefaultDeadline))
 	if err != nil {
-		c.Unlock()
-		if c.closed.Load() {
-			c.Logger().Debug().Err(ConnectionClosed).Uint16("Packet ID", p.Metadata.Id).Msg("error while writing encoded metadata")
-			return ConnectionClosed
-		}
-		c.Logger().Debug().Err(err).Uint16("Packet ID", p.Metadata.Id).Msg("error while writing encoded metadata")
-		if closeOnErr {
-			return c.closeWithError(err)
+		return 0, 0, err
+	}
+	_, err = c.writer.Write(c.header)
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(content) != 0 {
+		_, err = c.writer.Write(content)
+		if err != nil {
+			return 0, 0, err
 		}
-		return err
 	}
-	if p.Metadata.ContentLength != 0 {
-		_, err = c.writer.Write(p.Content.Bytes()[:p.Metadata.ContentLength])
+	if c.writeChecksums {
+		c.header = appendChecksum(c.header, c.header[extensionsSize:headerSize], content)
+		_, err = c.writer.Write(c.header[headerSize:])
 		if err != nil {
-			c.Unlock()
-			if c.closed.Load() {
-				c.Logger().Debug().Err(ConnectionClosed).Uint16("Packet ID", p.Metadata.Id).Msg("error while writing packet content")
-				return ConnectionClosed
-			}
-			c.Logger().Debug().Err(err).Uint16("Packet ID", p.Metadata.Id).Msg("error while writing packet content")
-			if closeOnErr {
-				return c.closeWithError(err)
-			}
-			return err
+			return 0, 0, err
 		}
 	}
 
@@ -358,8 +559,37 @@ func (c *Async) writePacket(p *packet.Packet, closeOnErr bool) error {
 		}
 	}
 
+	return len(c.header) - extensionsSize, extensionsSize, nil
+}
+
+// switchFormat tells the other side of the connection that every packet that follows uses the given
+// header version (and checksums if they are used) with a NEGOTIATE packet, and then switches to them
+func (c *Async) switchFormat(version uint8, checksums bool) error {
+	p := newSwitchPacket(version, checksums)
+	defer packet.Put(p)
+
+	c.Lock()
+	if c.closed.Load() {
+		c.Unlock()
+		return ConnectionClosed
+	}
+	if c.writeVersion == version && c.writeChecksums == checksums {
+		c.Unlock()
+		return nil
+	}
+	headerSize, _, err := c.writeLocked(p, p.Content.Bytes(), nil)
+	if err != nil {
+		c.Unlock()
+		return err
+	}
+	c.writeVersion = version
+	c.writeChecksums = checksums
 	c.Unlock()
 
+	c.metrics.PacketWritten(NEGOTIATE, headerSize+int(p.Metadata.ContentLength))
+	if c.tap != nil {
+		c.tap.Tap(capture.DirectionWrite, c.id, p)
+	}
 	return nil
 }
 
@@ -384,6 +614,9 @@ func (c *Async) flush() error {
 			c.Logger().Error().Err(err).Msg("error while flushing data")
 			return err
 		}
+		c.Unlock()
+		c.metrics.Flushed()
+		return nil
 	}
 	c.Unlock()
 	return nil
@@ -391,7 +624,6 @@ func (c *Async) flush() error {
 
 func (c *Async) close() error {
 	c.staleMu.Lock()
-	c.streamsMu.Lock()
 	if c.closed.CompareAndSwap(false, true) {
 		c.Logger().Debug().Msg("connection close called, killing goroutines")
 		c.Lock()
@@ -404,10 +636,15 @@ func (c *Async) close() error {
 		_ = c.conn.SetDeadline(emptyTime)
 		c.stale = c.incoming.Drain()
 		c.staleMu.Unlock()
+		// The streams are only locked once the read loop has stopped, since it uses them to deliver STREAM packets
+		c.streamsMu.Lock()
 		for _, stream := range c.streams {
 			_ = stream.closeSend(false)
 		}
 		c.streamsMu.Unlock()
+		if c.ownsDeliveries {
+			c.deliveries.close()
+		}
 		c.Lock()
 		if c.writer.Buffered() > 0 {
 			_ = c.conn.SetWriteDeadline(time.Now().Add(DefaultDeadline))
@@ -418,7 +655,6 @@ func (c *Async) close() error {
 		return nil
 	}
 	c.staleMu.Unlock()
-	c.streamsMu.Unlock()
 	return ConnectionClosed
 }
 
@@ -426,13 +662,20 @@ func (c *Async) closeWithError(err error) error {
 	c.errorMu.Lock()
 	defer c.errorMu.Unlock()
 
+	previous := c.error
 	c.error = err
 	closeError := c.close()
 	if closeError != nil {
 		c.Logger().Debug().Err(closeError).Msgf("attempted to close connection with error `%s`, but got error while closing", err)
-		c.error = errors.Join(closeError, err)
-		return c.error
+		closeError = errors.Join(closeError, err)
+		// The error that closed the connection is kept
+		c.error = previous
+		if c.error == nil {
+			c.error = closeError
+		}
+		return closeError
 	}
+	c.metrics.ConnectionClosed(err)
 	_ = c.conn.Close()
 	return err
 }
@@ -453,6 +696,16 @@ func (c *Async) flushLoop() {
 	}
 }
 
+// pingOutstanding returns how long the oldest PING sent by the connection has been waiting for a PONG,
+// or 0 if every PING has been answered
+func (c *Async) pingOutstanding(now time.Time) time.Duration {
+	sent := c.pingSent.Load()
+	if sent == 0 {
+		return 0
+	}
+	return now.Sub(time.Unix(0, sent))
+}
+
 func (c *Async) pingLoop() {
 	ticker := time.NewTicker(DefaultPingInterval)
 	defer ticker.Stop()
@@ -463,6 +716,7 @@ func (c *Async) pingLoop() {
 			c.wg.Done()
 			return
 		case <-ticker.C:
+			c.pingSent.CompareAndSwap(0, time.Now().UnixNano())
 			err = c.writePacket(PINGPacket, false)
 			if err != nil {
 				c.wg.Done()
@@ -474,216 +728,239 @@ func (c *Async) pingLoop() {
 }
 
 func (c *Async) readLoop() {
-	buf := make([]byte, DefaultBufferSize)
-	var index int
-	var stream *Stream
-	var isStream bool
-	var newStreamHandler NewStreamHandler
+	reader := bufio.NewReaderSize(deadlineReader{conn: c.conn}, DefaultBufferSize)
+	header := make([]byte, metadata.V2Size)
+	version := metadata.Version1
+	checksums := false
+	var extensions metadata.Extensions
+	var extensionsId uint16
 	for {
-		buf = buf[:cap(buf)]
-		if len(buf) < metadata.Size {
-			c.Logger().Debug().Err(InvalidBufferLength).Msg("error during read loop, calling closeWithError")
+		p, size, err := readPacket(reader, version, checksums, header)
+		if err != nil {
+			reportChecksumMismatch(c.metrics, err)
+			c.Logger().Debug().Err(err).Msg("error while reading packet during read loop, calling closeWithError")
 			c.wg.Done()
-			_ = c.closeWithError(InvalidBufferLength)
+			_ = c.closeWithError(err)
 			return
 		}
+		c.metrics.PacketRead(p.Metadata.Operation, size)
 
-		var n int
-		var err error
-		for n < metadata.Size {
-			var nn int
-			err = c.conn.SetReadDeadline(time.Now().Add(DefaultDeadline))
+		if p.Metadata.Operation == EXTENSION {
+			c.Logger().Trace().Msg("EXTENSION Packet received by read loop")
+			extensionsId = p.Metadata.Id
+			err = extensions.Decode(p.Content.Bytes())
+			packet.Put(p)
+			if err != nil {
+				c.Logger().Debug().Err(err).Msg("error while decoding packet extensions")
+				c.wg.Done()
+				_ = c.closeWithError(err)
+				return
+			}
+			continue
+		}
+		if len(extensions) > 0 {
+			if extensionsId == p.Metadata.Id {
+				p.Extensions, extensions = extensions, p.Extensions
+			}
+			extensions.Reset()
+		}
+		err = p.Extensions.ExtractHeaders(&p.Headers)
+		if err != nil {
+			c.Logger().Debug().Err(err).Msg("error while decoding packet headers")
+			packet.Put(p)
+			c.wg.Done()
+			_ = c.closeWithError(err)
+			return
+		}
+		if flags, ok := p.Extensions.Get(metadata.ExtensionFlags); ok {
+			err = c.decompress(p, flags)
 			if err != nil {
-				c.Logger().Debug().Err(err).Msg("error setting read deadline during read loop, calling closeWithError")
+				c.Logger().Debug().Err(err).Msg("error while decompressing packet content")
+				packet.Put(p)
 				c.wg.Done()
 				_ = c.closeWithError(err)
 				return
 			}
-			nn, err = c.conn.Read(buf[n:])
-			n += nn
+		}
+		if c.tap != nil {
+			c.tap.Tap(capture.DirectionRead, c.id, p)
+		}
+		if isSessionPacket(p.Metadata.Operation) {
+			if c.sessionPending.CompareAndSwap(true, false) {
+				err = c.sessionHandler(c, nil)
+			}
+			if s := c.session.Load(); s != nil && err == nil && s.receive() {
+				err = c.writeSessionAck(s)
+			}
 			if err != nil {
-				if n < metadata.Size {
-					c.wg.Done()
-					_ = c.closeWithError(err)
-					return
-				}
-				break
+				c.Logger().Debug().Err(err).Msg("error while handling session during read loop, calling closeWithError")
+				packet.Put(p)
+				c.wg.Done()
+				_ = c.closeWithError(err)
+				return
 			}
 		}
 
-		index = 0
-		for index < n {
-			p := packet.Get()
-			p.Metadata.Id = binary.BigEndian.Uint16(buf[index+metadata.IdOffset : index+metadata.IdOffset+metadata.IdSize])
-			p.Metadata.Operation = binary.BigEndian.Uint16(buf[index+metadata.OperationOffset : index+metadata.OperationOffset+metadata.OperationSize])
-			p.Metadata.ContentLength = binary.BigEndian.Uint32(buf[index+metadata.ContentLengthOffset : index+metadata.ContentLengthOffset+metadata.ContentLengthSize])
-			index += metadata.Size
-
-			switch p.Metadata.Operation {
-			case PING:
-				c.Logger().Trace().Msg("PING Packet received by read loop, sending back PONG packet")
-				err = c.writePacket(PONGPacket, false)
-				if err != nil {
-					c.wg.Done()
-					_ = c.closeWithError(err)
-					return
-				}
+		if id, ok := p.Extensions.Get(metadata.ExtensionMessageID); ok {
+			var duplicate bool
+			duplicate, err = c.receiveReliable(p, id)
+			if err != nil {
+				c.Logger().Debug().Err(err).Msg("error while acknowledging packet during read loop, calling closeWithError")
 				packet.Put(p)
-			case PONG:
-				c.Logger().Trace().Msg("PONG Packet received by read loop")
+				c.wg.Done()
+				_ = c.closeWithError(err)
+				return
+			}
+			if duplicate {
+				c.Logger().Trace().Msg("Duplicate Packet discarded by read loop")
 				packet.Put(p)
-			case STREAM:
-				c.Logger().Trace().Msg("STREAM Packet received by read loop")
-				isStream = true
-				c.newStreamHandlerMu.Lock()
-				newStreamHandler = c.newStreamHandler
-				c.newStreamHandlerMu.Unlock()
-				if newStreamHandler != nil || p.Metadata.ContentLength == 0 {
-					c.streamsMu.Lock()
-					stream = c.streams[p.Metadata.Id]
-					c.streamsMu.Unlock()
-				}
-				fallthrough
-			default:
-				if p.Metadata.ContentLength > 0 {
-					if n-index < int(p.Metadata.ContentLength) {
-						minSize := int(p.Metadata.ContentLength) - p.Content.Write(buf[index:n])
-						n = 0
-						for cap(buf) < minSize {
-							buf = append(buf[:cap(buf)], 0)
-						}
-						buf = buf[:cap(buf)]
-						for n < minSize {
-							var nn int
-							err = c.conn.SetReadDeadline(time.Now().Add(DefaultDeadline))
-							if err != nil {
-								c.wg.Done()
-								_ = c.closeWithError(err)
-								return
-							}
-							nn, err = c.conn.Read(buf[n:])
-							n += nn
-							if err != nil {
-								if n < minSize {
-									c.wg.Done()
-									_ = c.closeWithError(err)
-									return
-								}
-								break
-							}
-						}
-						p.Content.Write(buf[:minSize])
-						index = minSize
-					} else {
-						index += p.Content.Write(buf[index : index+int(p.Metadata.ContentLength)])
-					}
-				}
-				if !isStream {
-					err = c.incoming.Push(p)
-					if err != nil {
-						c.Logger().Debug().Err(err).Msg("error while pushing to incoming packet queue")
-						c.wg.Done()
-						_ = c.closeWithError(err)
-						return
-					}
-				} else {
-					if p.Metadata.ContentLength == 0 {
-						if stream != nil {
-							stream.close()
-							c.streamsMu.Lock()
-							delete(c.streams, p.Metadata.Id)
-							c.streamsMu.Unlock()
-						}
-						packet.Put(p)
-					} else {
-						if newStreamHandler == nil {
-							c.Logger().Debug().Msg("STREAM Packet discarded by read loop")
-							packet.Put(p)
-						} else {
-							if stream == nil {
-								stream = newStream(p.Metadata.Id, c)
-								c.streamsMu.Lock()
-								c.streams[p.Metadata.Id] = stream
-								c.streamsMu.Unlock()
-								go newStreamHandler(stream)
-							}
-							err = stream.queue.Push(p)
-							if err != nil {
-								c.Logger().Debug().Err(err).Msg("error while pushing to a stream queue packet queue")
-								c.wg.Done()
-								_ = c.closeWithError(err)
-								return
-							}
-						}
-					}
-				}
-				newStreamHandler = nil
-				stream = nil
-				isStream = false
+				continue
+			}
+		}
+
+		switch p.Metadata.Operation {
+		case PING:
+			c.Logger().Trace().Msg("PING Packet received by read loop, sending back PONG packet")
+			packet.Put(p)
+			err = c.writePacket(PONGPacket, false)
+			if s := c.session.Load(); s != nil && err == nil && s.unacknowledged() {
+				err = c.writeSessionAck(s)
 			}
-			if n == index {
-				index = 0
-				buf = buf[:cap(buf)]
-				if len(buf) < metadata.Size {
-					c.wg.Done()
-					_ = c.closeWithError(InvalidBufferLength)
-					return
-				}
-				n = 0
-				for n < metadata.Size {
-					var nn int
-					err = c.conn.SetReadDeadline(time.Now().Add(DefaultDeadline))
-					if err != nil {
-						c.wg.Done()
-						_ = c.closeWithError(err)
-						return
-					}
-					nn, err = c.conn.Read(buf[n:])
-					n += nn
-					if err != nil {
-						if n < metadata.Size {
-							c.wg.Done()
-							_ = c.closeWithError(err)
-							return
-						}
-						break
-					}
-				}
-			} else if n-index < metadata.Size {
-				copy(buf, buf[index:n])
-				n -= index
-				index = n
-
-				buf = buf[:cap(buf)]
-				minSize := metadata.Size - index
-				if len(buf) < minSize {
-					c.wg.Done()
-					_ = c.closeWithError(InvalidBufferLength)
-					return
-				}
-				n = 0
-				for n < minSize {
-					var nn int
-					err = c.conn.SetReadDeadline(time.Now().Add(DefaultDeadline))
-					if err != nil {
-						c.wg.Done()
-						_ = c.closeWithError(err)
-						return
-					}
-					nn, err = c.conn.Read(buf[index+n:])
-					n += nn
-					if err != nil {
-						if n < minSize {
-							c.wg.Done()
-							_ = c.closeWithError(err)
-							return
-						}
-						break
-					}
-				}
-				n += index
-				index = 0
+		case PONG:
+			c.Logger().Trace().Msg("PONG Packet received by read loop")
+			packet.Put(p)
+			if sent := c.pingSent.Swap(0); sent != 0 {
+				c.metrics.PingRTT(time.Duration(time.Now().UnixNano() - sent))
+			}
+		case NEGOTIATE:
+			c.Logger().Trace().Msg("NEGOTIATE Packet received by read loop")
+			version, checksums, err = c.negotiate(p, version, checksums)
+			packet.Put(p)
+		case SESSION:
+			c.Logger().Trace().Msg("SESSION Packet received by read loop")
+			err = c.receiveSession(p)
+			packet.Put(p)
+		case ACK:
+			c.Logger().Trace().Msg("ACK Packet received by read loop")
+			err = c.receiveAck(p)
+			packet.Put(p)
+		case STREAM:
+			c.Logger().Trace().Msg("STREAM Packet received by read loop")
+			err = c.receiveStream(p)
+		default:
+			err = c.incoming.Push(p)
+			if err == nil {
+				c.metrics.IncomingQueueDepth(c.incoming.Length())
 			}
 		}
+		if err != nil {
+			c.Logger().Debug().Err(err).Msg("error while handling packet during read loop, calling closeWithError")
+			c.wg.Done()
+			_ = c.closeWithError(err)
+			return
+		}
+	}
+}
+
+// negotiate handles a NEGOTIATE packet from the other side of the connection, and returns
+// the header version of the packets that follow it and whether they are followed by checksums
+func (c *Async) negotiate(p *packet.Packet, version uint8, checksums bool) (uint8, bool, error) {
+	n, err := decodeNegotiation(p.Content.Bytes())
+	if err != nil {
+		return version, checksums, err
+	}
+	if n.switchVersion != 0 {
+		return n.switchVersion, n.checksums, nil
+	}
+	if n.extensions {
+		if !c.peerExtensions.Swap(true) {
+			close(c.extensionsCh)
+		}
+		// Peers that advertise extensions understand NEGOTIATE packets, so this side's advertisement is sent in reply if it has not been
+		if c.advertised.CompareAndSwap(false, true) {
+			reply := newNegotiatePacket(c.compressor, c.headerVersion, c.checksums, true)
+			err = c.writePacket(reply, false)
+			packet.Put(reply)
+			if err != nil {
+				return version, checksums, err
+			}
+		}
+	}
+	if n.hasCompression && c.compressor != nil {
+		c.compressor.negotiate(n.compression)
+	}
+	if writeVersion, writeChecksums := negotiatedFormat(n, c.headerVersion, c.checksums); writeVersion >= metadata.Version2 || writeChecksums {
+		if err = c.switchFormat(writeVersion, writeChecksums); err != nil {
+			return version, checksums, err
+		}
+	}
+	return version, checksums, nil
+}
+
+// receiveStream delivers a STREAM packet to its stream, creating the stream (and calling the
+// NewStreamHandler) if it does not exist yet, and closes the stream if the packet is empty
+func (c *Async) receiveStream(p *packet.Packet) error {
+	c.newStreamHandlerMu.Lock()
+	newStreamHandler := c.newStreamHandler
+	c.newStreamHandlerMu.Unlock()
+	c.streamsMu.Lock()
+	stream := c.streams[p.Metadata.Id]
+	c.streamsMu.Unlock()
+
+	if p.Metadata.ContentLength == 0 {
+		if stream != nil {
+			stream.close()
+			c.streamsMu.Lock()
+			delete(c.streams, p.Metadata.Id)
+			c.streamsMu.Unlock()
+		}
+		packet.Put(p)
+		return nil
+	}
+	if newStreamHandler == nil {
+		c.Logger().Debug().Msg("STREAM Packet discarded by read loop")
+		packet.Put(p)
+		return nil
+	}
+	if stream == nil {
+		stream = newStream(p.Metadata.Id, c)
+		c.streamsMu.Lock()
+		c.streams[p.Metadata.Id] = stream
+		c.streamsMu.Unlock()
+		go newStreamHandler(stream)
+	}
+	return stream.queue.Push(p)
+}
+
+// decompress replaces the content of the packet with its decompressed content
+// if the flags specify that it was compressed
+func (c *Async) decompress(p *packet.Packet, flags []byte) error {
+	if len(flags) != 1 {
+		return metadata.DecodingErr
+	}
+	p.Extensions.Delete(metadata.ExtensionFlags)
+	if flags[0]&metadata.FlagCompressionMask == 0 {
+		return nil
+	}
+	decompressed, err := c.compressor.decompress(p.Content.Bytes(), flags[0])
+	if err != nil {
+		return err
+	}
+	p.Content.Reset()
+	p.Content.Write(decompressed)
+	p.Metadata.ContentLength = uint32(len(decompressed))
+	return nil
+}
+
+// deadlineReader sets a read deadline on the underlying net.Conn before every read,
+// so that the read loop notices when the other side of the connection stops responding
+type deadlineReader struct {
+	conn net.Conn
+}
+
+func (r deadlineReader) Read(b []byte) (int, error) {
+	if err := r.conn.SetReadDeadline(time.Now().Add(DefaultDeadline)); err != nil {
+		return 0, err
 	}
+	return r.conn.Read(b)
 }
@@ -0,0 +1,269 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package frisbee
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+var (
+	BroadcastQueueFull = errors.New("broadcast queue is full")
+	UnknownConnection  = errors.New("connection is not being served by the server")
+)
+
+// DefaultBroadcastQueueSize is the default number of broadcast packets that can be queued for each connection
+const DefaultBroadcastQueueSize = 64
+
+// WithBroadcastQueueSize sets the number of packets that the frisbee server queues for each connection when broadcasting
+// (see Server.Broadcast). Once a connection's queue is full, broadcasting to it fails with BroadcastQueueFull until its
+// packets have been written, so slow connections do not block the others. By default, DefaultBroadcastQueueSize is used.
+func WithBroadcastQueueSize(size int) Option {
+	return func(opts *Options) {
+		opts.BroadcastQueueSize = size
+	}
+}
+
+// BroadcastError is returned by Server.Broadcast and Server.BroadcastGroup when the
+// packet could not be queued for some of the connections, and holds the error of each one
+type BroadcastError struct {
+	Errors map[*Async]error
+}
+
+// Error implements the error interface
+func (e *BroadcastError) Error() string {
+	return fmt.Sprintf("broadcast failed for %d connections", len(e.Errors))
+}
+
+// Unwrap returns the error of every connection, so that errors.Is can be used with them (for example, with BroadcastQueueFull)
+func (e *BroadcastError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// serverConn holds the broadcast queue and the groups of a connection that is being served
+type serverConn struct {
+	queue  chan *broadcastPacket
+	groups map[string]struct{}
+
+	// closed is set (while the server's connectionsMu is held) once the broadcast queue has been drained
+	// because the connection was closed, so that no more packets are queued for it
+	closed bool
+}
+
+// broadcastPacket is a copy of a broadcast packet that is shared by the queues of every connection it is sent to,
+// and is returned to the packet pool once it has been written to (or discarded by) all of them
+type broadcastPacket struct {
+	packet *packet.Packet
+	refs   atomic.Int32
+}
+
+func (b *broadcastPacket) release() {
+	if b.refs.Add(-1) == 0 {
+		packet.Put(b.packet)
+	}
+}
+
+// Connections returns the connections that the server is currently serving
+func (s *Server) Connections() []*Async {
+	s.connectionsMu.Lock()
+	defer s.connectionsMu.Unlock()
+	conns := make([]*Async, 0, len(s.connections))
+	for conn := range s.connections {
+		conns = append(conns, conn)
+	}
+	return conns
+}
+
+// Join adds the connection to the named group, so that it receives the packets broadcast with BroadcastGroup.
+// Connections leave all of their groups once they are closed. If the server is not serving the connection
+// (because it has been closed, or it belongs to another server), UnknownConnection is returned.
+//
+// Groups belong to connections, so when sessions are enabled (see WithSessions), resumed connections must join them again.
+func (s *Server) Join(group string, conn *Async) error {
+	s.connectionsMu.Lock()
+	defer s.connectionsMu.Unlock()
+	sc, ok := s.connections[conn]
+	if !ok {
+		return UnknownConnection
+	}
+	if sc.groups == nil {
+		sc.groups = make(map[string]struct{})
+	}
+	sc.groups[group] = struct{}{}
+	members, ok := s.groups[group]
+	if !ok {
+		members = make(map[*Async]struct{})
+		s.groups[group] = members
+	}
+	members[conn] = struct{}{}
+	return nil
+}
+
+// Leave removes the connection from the named group
+func (s *Server) Leave(group string, conn *Async) {
+	s.connectionsMu.Lock()
+	defer s.connectionsMu.Unlock()
+	if sc, ok := s.connections[conn]; ok {
+		delete(sc.groups, group)
+	}
+	s.leaveLocked(group, conn)
+}
+
+// Group returns the connections in the named group
+func (s *Server) Group(group string) []*Async {
+	s.connectionsMu.Lock()
+	defer s.connectionsMu.Unlock()
+	conns := make([]*Async, 0, len(s.groups[group]))
+	for conn := range s.groups[group] {
+		conns = append(conns, conn)
+	}
+	return conns
+}
+
+// Broadcast sends a copy of the packet to every connection that the server is serving and for which filter returns
+// true (or to every connection if filter is nil), and leaves the packet itself to the caller.
+//
+// The copy is queued for each connection and written by a separate goroutine (see WithBroadcastQueueSize), so Broadcast
+// does not block on slow connections. If the packet cannot be queued for some of the connections, they are skipped and a
+// *BroadcastError with the error of each one is returned. Connections that fail to write a queued packet are closed
+// with the error, which is returned by their Error function (for example, from the server's OnClosed function).
+func (s *Server) Broadcast(p *packet.Packet, filter func(*Async) bool) error {
+	conns := s.Connections()
+	if filter != nil {
+		selected := conns[:0]
+		for _, conn := range conns {
+			if filter(conn) {
+				selected = append(selected, conn)
+			}
+		}
+		conns = selected
+	}
+	return s.broadcast(p, conns)
+}
+
+// BroadcastGroup sends a copy of the packet to every connection in the named group (see Join), like Broadcast
+func (s *Server) BroadcastGroup(group string, p *packet.Packet) error {
+	return s.broadcast(p, s.Group(group))
+}
+
+// broadcast queues a copy of the packet for each of the given connections
+func (s *Server) broadcast(p *packet.Packet, conns []*Async) error {
+	if p.Metadata.Operation <= RESERVED9 {
+		return InvalidOperation
+	}
+	if int(p.Metadata.ContentLength) != p.Content.Len() {
+		return InvalidContentLength
+	}
+	if len(conns) == 0 {
+		return nil
+	}
+
+	b := &broadcastPacket{packet: clonePacket(p)}
+	b.refs.Store(int32(len(conns)) + 1)
+	var errs map[*Async]error
+	for _, conn := range conns {
+		err := s.enqueueBroadcast(conn, b)
+		if err == nil {
+			continue
+		}
+		if errs == nil {
+			errs = make(map[*Async]error)
+		}
+		errs[conn] = err
+		b.release()
+	}
+	b.release()
+
+	if errs != nil {
+		return &BroadcastError{Errors: errs}
+	}
+	return nil
+}
+
+// enqueueBroadcast queues the packet in the broadcast queue of the connection, and starts writing
+// the packets in it the first time that something is broadcast to the connection
+func (s *Server) enqueueBroadcast(conn *Async, b *broadcastPacket) error {
+	s.connectionsMu.Lock()
+	defer s.connectionsMu.Unlock()
+	sc, ok := s.connections[conn]
+	if !ok || sc.closed || conn.Closed() {
+		return ConnectionClosed
+	}
+	if sc.queue == nil {
+		size := s.options.BroadcastQueueSize
+		if size <= 0 {
+			size = DefaultBroadcastQueueSize
+		}
+		sc.queue = make(chan *broadcastPacket, size)
+		// The connection is still being served, so the server's wait group cannot be done yet
+		s.wg.Add(1)
+		go s.writeBroadcasts(conn, sc)
+	}
+	select {
+	case sc.queue <- b:
+		return nil
+	default:
+		return BroadcastQueueFull
+	}
+}
+
+// writeBroadcasts writes the packets in the broadcast queue to the connection until it is closed,
+// and closes the connection with the error if a packet cannot be written
+func (s *Server) writeBroadcasts(conn *Async, sc *serverConn) {
+	defer s.wg.Done()
+	for {
+		select {
+		case b := <-sc.queue:
+			if err := conn.WritePacket(b.packet); err != nil {
+				conn.Logger().Debug().Err(err).Msg("error while writing broadcast packet, calling closeWithError")
+				_ = conn.closeWithError(err)
+			}
+			b.release()
+		case <-conn.CloseChannel():
+			// Packets are only queued while connectionsMu is held, so none can be queued once closed is set
+			s.connectionsMu.Lock()
+			sc.closed = true
+			for {
+				select {
+				case b := <-sc.queue:
+					b.release()
+				default:
+					s.connectionsMu.Unlock()
+					return
+				}
+			}
+		}
+	}
+}
+
+// leaveLocked removes the connection from the named group, and
+// assumes that the server's connectionsMu is held
+func (s *Server) leaveLocked(group string, conn *Async) {
+	members, ok := s.groups[group]
+	if !ok {
+		return
+	}
+	delete(members, conn)
+	if len(members) == 0 {
+		delete(s.groups, group)
+	}
+}
+
+// removeConnection stops serving the connection and removes it from all of its groups
+func (s *Server) removeConnection(conn *Async) {
+	s.connectionsMu.Lock()
+	defer s.connectionsMu.Unlock()
+	if sc, ok := s.connections[conn]; ok {
+		for group := range sc.groups {
+			s.leaveLocked(group, conn)
+		}
+		delete(s.connections, conn)
+	}
+}
@@ -0,0 +1,217 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package frisbee
+
+import (
+	"context"
+	"crypto/rand"
+	"math"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/loopholelabs/logging"
+	"github.com/loopholelabs/testing/conn/pair"
+
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+// newBroadcastClient connects a new client to the server, and returns it with the channel that it receives packet IDs on
+func newBroadcastClient(t *testing.T, s *Server) (*Client, chan uint16) {
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+	received := make(chan uint16, 16)
+	handlerTable := make(HandlerTable)
+	handlerTable[32] = func(_ context.Context, incoming *packet.Packet) (outgoing *packet.Packet, action Action) {
+		received <- incoming.Metadata.Id
+		return
+	}
+	c, err := NewClient(handlerTable, context.Background(), WithLogger(emptyLogger))
+	require.NoError(t, err)
+	serverConn, clientConn, err := pair.New()
+	require.NoError(t, err)
+	s.ServeConn(serverConn)
+	require.NoError(t, c.FromConn(clientConn))
+	return c, received
+}
+
+func expectBroadcast(t *testing.T, received chan uint16, id uint16) {
+	select {
+	case actual := <-received:
+		assert.Equal(t, id, actual)
+	case <-time.After(time.Second * 5):
+		t.Fatal("timed out waiting for broadcast packet")
+	}
+}
+
+func TestServerBroadcast(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+	s, err := NewServer(make(HandlerTable), context.Background(), WithLogger(emptyLogger))
+	require.NoError(t, err)
+
+	clients := make([]*Client, 3)
+	received := make([]chan uint16, 3)
+	for i := range clients {
+		clients[i], received[i] = newBroadcastClient(t, s)
+	}
+	require.Eventually(t, func() bool {
+		return len(s.Connections()) == len(clients)
+	}, time.Second*5, time.Millisecond*10)
+	conns := s.Connections()
+
+	require.NoError(t, s.Join("group", conns[0]))
+	require.NoError(t, s.Join("group", conns[1]))
+	// Connections are compared by pointer, since they are being used concurrently
+	members := s.Group("group")
+	require.Len(t, members, 2)
+	for _, conn := range members {
+		assert.True(t, conn == conns[0] || conn == conns[1])
+	}
+	assert.ErrorIs(t, s.Join("group", new(Async)), UnknownConnection)
+
+	p := packet.Get()
+	p.Metadata.Operation = PING
+	assert.ErrorIs(t, s.Broadcast(p, nil), InvalidOperation)
+	p.Metadata.Operation = 32
+
+	p.Metadata.Id = 1
+	require.NoError(t, s.Broadcast(p, nil))
+	for i := range received {
+		expectBroadcast(t, received[i], 1)
+	}
+
+	p.Metadata.Id = 2
+	require.NoError(t, s.Broadcast(p, func(conn *Async) bool {
+		return conn == conns[2]
+	}))
+	p.Metadata.Id = 3
+	require.NoError(t, s.BroadcastGroup("group", p))
+	s.Leave("group", conns[0])
+	p.Metadata.Id = 4
+	require.NoError(t, s.BroadcastGroup("group", p))
+	packet.Put(p)
+
+	// Each connection is identified by the order of the packets that it receives, since the connections are not ordered
+	var ids [][]uint16
+	for i := range received {
+		var connIds []uint16
+		for len(connIds) == 0 || len(received[i]) > 0 {
+			select {
+			case id := <-received[i]:
+				connIds = append(connIds, id)
+			case <-time.After(time.Second * 5):
+				t.Fatal("timed out waiting for broadcast packet")
+			}
+			time.Sleep(time.Millisecond * 50)
+		}
+		ids = append(ids, connIds)
+	}
+	assert.ElementsMatch(t, [][]uint16{{2}, {3}, {3, 4}}, ids)
+
+	// Connections leave their groups once they are closed
+	require.NoError(t, conns[1].Close())
+	require.Eventually(t, func() bool {
+		return len(s.Group("group")) == 0
+	}, time.Second*5, time.Millisecond*10)
+
+	for _, c := range clients {
+		require.NoError(t, c.Close())
+	}
+	require.NoError(t, s.Shutdown())
+}
+
+func TestServerBroadcastSlowConnection(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+	s, err := NewServer(make(HandlerTable), context.Background(), WithLogger(emptyLogger), WithBroadcastQueueSize(1))
+	require.NoError(t, err)
+
+	c, received := newBroadcastClient(t, s)
+	require.Eventually(t, func() bool {
+		return len(s.Connections()) == 1
+	}, time.Second*5, time.Millisecond*10)
+	fast := s.Connections()[0]
+
+	// Nothing is ever read from the slow connection, so its writes block once the pipe is full
+	slowServer, slowClient := net.Pipe()
+	s.ServeConn(slowServer)
+	require.Eventually(t, func() bool {
+		return len(s.Connections()) == 2
+	}, time.Second*5, time.Millisecond*10)
+
+	p := packet.Get()
+	p.Metadata.Operation = 32
+	p.Content.Write(make([]byte, 1<<20))
+	_, _ = rand.Read(p.Content.Bytes())
+	p.Metadata.ContentLength = uint32(p.Content.Len())
+
+	var broadcastErr *BroadcastError
+	sent := 0
+	for sent < 8 && broadcastErr == nil {
+		p.Metadata.Id = uint16(sent)
+		err = s.Broadcast(p, nil)
+		if err != nil {
+			require.ErrorAs(t, err, &broadcastErr)
+		}
+		// The fast connection receives every packet, even though the slow connection is blocked
+		expectBroadcast(t, received, uint16(sent))
+		sent++
+	}
+	packet.Put(p)
+	require.NotNil(t, broadcastErr)
+	assert.ErrorIs(t, broadcastErr, BroadcastQueueFull)
+	_, ok := broadcastErr.Errors[fast]
+	assert.False(t, ok)
+	assert.Len(t, broadcastErr.Errors, 1)
+
+	require.NoError(t, slowClient.Close())
+	require.NoError(t, c.Close())
+	require.NoError(t, s.Shutdown())
+}
+
+func TestServerBroadcastWriteError(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+	closed := make(chan error, 1)
+	s, err := NewServer(make(HandlerTable), context.Background(), WithLogger(emptyLogger), WithHeaderVersion(metadata.Version2))
+	require.NoError(t, err)
+	require.NoError(t, s.SetOnClosed(func(conn *Async, _ error) {
+		closed <- conn.Error()
+	}))
+
+	c, err := NewClient(make(HandlerTable), context.Background(), WithLogger(emptyLogger), WithHeaderVersion(metadata.Version2))
+	require.NoError(t, err)
+	serverConn, clientConn, err := pair.New()
+	require.NoError(t, err)
+	s.ServeConn(serverConn)
+	require.NoError(t, c.FromConn(clientConn))
+	require.Eventually(t, func() bool {
+		conns := s.Connections()
+		return len(conns) == 1 && asyncWriteVersion(conns[0]) == metadata.Version2
+	}, time.Second*5, time.Millisecond*10)
+
+	// The extensions are too large to be encoded in a version 2 header, so the packet cannot be written
+	p := packet.Get()
+	p.Metadata.Operation = 32
+	require.NoError(t, p.Extensions.Set(32, make([]byte, math.MaxUint16/2)))
+	require.NoError(t, p.Extensions.Set(33, make([]byte, math.MaxUint16/2)))
+	require.NoError(t, s.Broadcast(p, nil))
+	packet.Put(p)
+
+	select {
+	case err = <-closed:
+		assert.ErrorIs(t, err, metadata.ExtensionTooLargeErr)
+	case <-time.After(time.Second * 5):
+		t.Fatal("timed out waiting for connection to be closed")
+	}
+
+	require.NoError(t, c.Close())
+	require.NoError(t, s.Shutdown())
+}
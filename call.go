@@ -0,0 +1,110 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package frisbee
+
+import (
+	"context"
+	"math"
+	"sync"
+
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+// calls keeps track of the packets sent by Client.Call that are waiting for a response
+type calls struct {
+	mu      sync.Mutex
+	nextID  uint16
+	pending map[uint16]chan *packet.Packet
+}
+
+// add allocates a packet ID that is not being used by another call, and returns the channel that its response will be sent to
+func (c *calls) add() (uint16, chan *packet.Packet, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.pending == nil {
+		c.pending = make(map[uint16]chan *packet.Packet)
+	}
+	if len(c.pending) > math.MaxUint16 {
+		return 0, nil, NoFreeIDs
+	}
+	for {
+		id := c.nextID
+		c.nextID++
+		if _, ok := c.pending[id]; !ok {
+			response := make(chan *packet.Packet, 1)
+			c.pending[id] = response
+			return id, response, nil
+		}
+	}
+}
+
+// remove stops waiting for a response to the call with the given ID
+func (c *calls) remove(id uint16) {
+	c.mu.Lock()
+	delete(c.pending, id)
+	c.mu.Unlock()
+}
+
+// deliver sends the packet to the call that is waiting for it, and returns false if there is no such call
+func (c *calls) deliver(p *packet.Packet) bool {
+	if p.Metadata.Operation <= RESERVED9 && p.Metadata.Operation != ERROR {
+		return false
+	}
+	c.mu.Lock()
+	response, ok := c.pending[p.Metadata.Id]
+	if ok {
+		delete(c.pending, p.Metadata.Id)
+	}
+	c.mu.Unlock()
+	if ok {
+		response <- p
+	}
+	return ok
+}
+
+// Call sends the given packet to the server with a packet ID allocated by the client (overwriting
+// p.Metadata.Id), flushes it, and waits for the server to respond with a packet that has the same ID.
+// The response is not passed to the client's handler table, and must be returned to the
+// packet pool (using packet.Put) by the caller once it is no longer needed.
+//
+// If the server responds with an ERROR packet, its *ErrorFrame is returned as the error. If the context is
+// cancelled or the client is closed before a response arrives, the call stops waiting and an error is returned.
+//
+// Packets that are written with WritePacket while calls are in flight should not reuse the IDs of pending calls,
+// since the server's responses to them would be mistaken for the responses to the calls.
+func (c *Client) Call(ctx context.Context, p *packet.Packet) (*packet.Packet, error) {
+	id, response, err := c.calls.add()
+	if err != nil {
+		return nil, err
+	}
+	p.Metadata.Id = id
+	InjectTrace(ctx, p)
+	err = c.conn.Load().WritePacket(p)
+	if err == nil {
+		err = c.conn.Load().Flush()
+	}
+	if err != nil {
+		c.calls.remove(id)
+		return nil, err
+	}
+
+	select {
+	case p = <-response:
+	case <-ctx.Done():
+		c.calls.remove(id)
+		return nil, ctx.Err()
+	case <-c.CloseChannel():
+		c.calls.remove(id)
+		return nil, ConnectionClosed
+	}
+
+	if p.Metadata.Operation == ERROR {
+		errorFrame, err := ParseErrorFrame(p)
+		packet.Put(p)
+		if err != nil {
+			return nil, err
+		}
+		return nil, errorFrame
+	}
+	return p, nil
+}
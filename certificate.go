@@ -0,0 +1,207 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package frisbee
+
+import (
+	"crypto/tls"
+	"errors"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	CertificateFileEmpty = errors.New("certificate and key file paths cannot be empty")
+	OnReloadErrorNil     = errors.New("OnReloadError function cannot be nil")
+	ReloaderClosed       = errors.New("certificate reloader closed")
+)
+
+// DefaultCertificatePollInterval is the default interval at which a CertificateReloader checks
+// its certificate and key files for changes when Watch is called with an interval of 0
+const DefaultCertificatePollInterval = time.Second * 10
+
+var (
+	defaultOnReloadError = func(_ error) {}
+)
+
+// CertificateReloader loads a TLS certificate and key pair from disk and allows it to be
+// swapped out while a frisbee Server or Client is running.
+//
+// New TLS handshakes always use the most recently loaded certificate, while connections that
+// have already completed their handshake are left untouched. If a reload fails, the previously
+// loaded certificate continues to be served and the OnReloadError hook is called.
+type CertificateReloader struct {
+	certFile string
+	keyFile  string
+
+	certificate atomic.Pointer[tls.Certificate]
+
+	reloadMu    sync.Mutex
+	certModTime time.Time
+	keyModTime  time.Time
+
+	onReloadErrorMu sync.RWMutex
+	onReloadError   func(error)
+
+	closed  atomic.Bool
+	closeCh chan struct{}
+	wg      sync.WaitGroup
+}
+
+// NewCertificateReloader returns a CertificateReloader for the given certificate and key files.
+// The certificate is loaded immediately, and an error is returned if it cannot be loaded.
+func NewCertificateReloader(certFile string, keyFile string) (*CertificateReloader, error) {
+	if certFile == "" || keyFile == "" {
+		return nil, CertificateFileEmpty
+	}
+
+	r := &CertificateReloader{
+		certFile:      certFile,
+		keyFile:       keyFile,
+		onReloadError: defaultOnReloadError,
+		closeCh:       make(chan struct{}),
+	}
+
+	return r, r.load()
+}
+
+// SetOnReloadError sets the function that is called whenever reloading the certificate fails.
+// When this happens the previously loaded certificate continues to be served. If f is nil, it returns an error.
+func (r *CertificateReloader) SetOnReloadError(f func(error)) error {
+	if f == nil {
+		return OnReloadErrorNil
+	}
+	r.onReloadErrorMu.Lock()
+	r.onReloadError = f
+	r.onReloadErrorMu.Unlock()
+	return nil
+}
+
+// Reload loads the certificate and key files from disk and, if successful, uses them for all new
+// TLS handshakes. If loading fails, the previously loaded certificate is kept, the OnReloadError
+// hook is called, and the error is returned.
+func (r *CertificateReloader) Reload() error {
+	if r.closed.Load() {
+		return ReloaderClosed
+	}
+	err := r.load()
+	if err != nil {
+		r.onReloadErrorMu.RLock()
+		onReloadError := r.onReloadError
+		r.onReloadErrorMu.RUnlock()
+		onReloadError(err)
+	}
+	return err
+}
+
+// Certificate returns the most recently loaded certificate
+func (r *CertificateReloader) Certificate() *tls.Certificate {
+	return r.certificate.Load()
+}
+
+// GetCertificate returns the most recently loaded certificate, and is meant to be used
+// as the tls.Config.GetCertificate function of a frisbee Server
+func (r *CertificateReloader) GetCertificate(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.certificate.Load(), nil
+}
+
+// GetClientCertificate returns the most recently loaded certificate, and is meant to be used
+// as the tls.Config.GetClientCertificate function of a frisbee Client
+func (r *CertificateReloader) GetClientCertificate(_ *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return r.certificate.Load(), nil
+}
+
+// TLSConfig returns a clone of the given tls.Config (or an empty one if config is nil) that
+// serves certificates from the CertificateReloader. The result can be passed to WithTLS.
+func (r *CertificateReloader) TLSConfig(config *tls.Config) *tls.Config {
+	if config == nil {
+		config = new(tls.Config)
+	}
+	config = config.Clone()
+	config.Certificates = nil
+	config.GetCertificate = r.GetCertificate
+	config.GetClientCertificate = r.GetClientCertificate
+	return config
+}
+
+// Watch starts a goroutine that checks the certificate and key files for changes every interval
+// and reloads them when they have been modified. If interval is 0, DefaultCertificatePollInterval is used.
+//
+// Watch should only be called once, and the goroutine is stopped by calling Close.
+func (r *CertificateReloader) Watch(interval time.Duration) {
+	if interval == 0 {
+		interval = DefaultCertificatePollInterval
+	}
+	r.wg.Add(1)
+	go r.watchLoop(interval)
+}
+
+// Close stops the goroutine started by Watch. Certificates that have already been
+// loaded continue to be served.
+func (r *CertificateReloader) Close() error {
+	if r.closed.CompareAndSwap(false, true) {
+		close(r.closeCh)
+		r.wg.Wait()
+		return nil
+	}
+	return ReloaderClosed
+}
+
+// changed returns whether the modification time of either the certificate or the key file
+// differs from the one that was recorded when the certificate was last loaded successfully
+func (r *CertificateReloader) changed() (bool, error) {
+	certInfo, err := os.Stat(r.certFile)
+	if err != nil {
+		return false, err
+	}
+	keyInfo, err := os.Stat(r.keyFile)
+	if err != nil {
+		return false, err
+	}
+	r.reloadMu.Lock()
+	defer r.reloadMu.Unlock()
+	return !certInfo.ModTime().Equal(r.certModTime) || !keyInfo.ModTime().Equal(r.keyModTime), nil
+}
+
+func (r *CertificateReloader) load() error {
+	r.reloadMu.Lock()
+	defer r.reloadMu.Unlock()
+
+	certInfo, err := os.Stat(r.certFile)
+	if err != nil {
+		return err
+	}
+	keyInfo, err := os.Stat(r.keyFile)
+	if err != nil {
+		return err
+	}
+
+	certificate, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+
+	r.certificate.Store(&certificate)
+	r.certModTime = certInfo.ModTime()
+	r.keyModTime = keyInfo.ModTime()
+	return nil
+}
+
+func (r *CertificateReloader) watchLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.closeCh:
+			r.wg.Done()
+			return
+		case <-ticker.C:
+			changed, err := r.changed()
+			if err == nil && !changed {
+				continue
+			}
+			_ = r.Reload()
+		}
+	}
+}
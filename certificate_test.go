@@ -0,0 +1,154 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package frisbee
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/loopholelabs/logging"
+)
+
+func writeTestCertificate(t testing.TB, certFile string, keyFile string, serial int64) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "frisbee"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	require.NoError(t, err)
+	err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	require.NoError(t, err)
+}
+
+func peerSerial(t testing.TB, conn *Async) int64 {
+	state, err := conn.ConnectionState()
+	require.NoError(t, err)
+	require.NotEmpty(t, state.PeerCertificates)
+	return state.PeerCertificates[0].SerialNumber.Int64()
+}
+
+func TestCertificateReloader(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	writeTestCertificate(t, certFile, keyFile, 1)
+
+	_, err := NewCertificateReloader("", keyFile)
+	require.ErrorIs(t, err, CertificateFileEmpty)
+
+	reloader, err := NewCertificateReloader(certFile, keyFile)
+	require.NoError(t, err)
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", reloader.TLSConfig(nil))
+	require.NoError(t, err)
+
+	accepted := make(chan *Async, 2)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- NewAsync(conn, emptyLogger)
+		}
+	}()
+
+	clientConfig := &tls.Config{InsecureSkipVerify: true}
+
+	first, err := ConnectAsync(listener.Addr().String(), time.Minute, emptyLogger, clientConfig)
+	require.NoError(t, err)
+	require.NoError(t, first.Handshake())
+	firstServer := <-accepted
+	assert.Equal(t, int64(1), peerSerial(t, first))
+
+	writeTestCertificate(t, certFile, keyFile, 2)
+	require.NoError(t, reloader.Reload())
+
+	second, err := ConnectAsync(listener.Addr().String(), time.Minute, emptyLogger, clientConfig)
+	require.NoError(t, err)
+	require.NoError(t, second.Handshake())
+	secondServer := <-accepted
+	assert.Equal(t, int64(2), peerSerial(t, second))
+	assert.Equal(t, int64(1), peerSerial(t, first))
+
+	reloadErrCh := make(chan error, 1)
+	require.ErrorIs(t, reloader.SetOnReloadError(nil), OnReloadErrorNil)
+	require.NoError(t, reloader.SetOnReloadError(func(err error) {
+		reloadErrCh <- err
+	}))
+
+	err = os.WriteFile(keyFile, []byte("invalid"), 0600)
+	require.NoError(t, err)
+	require.Error(t, reloader.Reload())
+	assert.Error(t, <-reloadErrCh)
+
+	third, err := ConnectAsync(listener.Addr().String(), time.Minute, emptyLogger, clientConfig)
+	require.NoError(t, err)
+	require.NoError(t, third.Handshake())
+	thirdServer := <-accepted
+	assert.Equal(t, int64(2), peerSerial(t, third))
+
+	require.NoError(t, listener.Close())
+	for _, conn := range []*Async{first, second, third, firstServer, secondServer, thirdServer} {
+		assert.NoError(t, conn.Close())
+	}
+	assert.NoError(t, reloader.Close())
+	assert.ErrorIs(t, reloader.Close(), ReloaderClosed)
+}
+
+func TestCertificateReloaderWatch(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	writeTestCertificate(t, certFile, keyFile, 1)
+
+	reloader, err := NewCertificateReloader(certFile, keyFile)
+	require.NoError(t, err)
+	reloader.Watch(time.Millisecond * 10)
+
+	writeTestCertificate(t, certFile, keyFile, 2)
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(certFile, future, future))
+	require.NoError(t, os.Chtimes(keyFile, future, future))
+
+	require.Eventually(t, func() bool {
+		certificate, err := x509.ParseCertificate(reloader.Certificate().Certificate[0])
+		return err == nil && certificate.SerialNumber.Int64() == 2
+	}, DefaultDeadline, time.Millisecond*10)
+
+	assert.NoError(t, reloader.Close())
+}
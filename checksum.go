@@ -0,0 +1,54 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package frisbee
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+)
+
+// checksumSize is the size of the CRC32C checksum that follows every packet once checksums have been negotiated
+const checksumSize = 4
+
+// castagnoli is the CRC32C table used to compute packet checksums
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// ChecksumError is the error that a connection is closed with when the checksum of a packet
+// it reads does not match the packet's header and content, which means that the packet was corrupted in transit
+type ChecksumError struct {
+	Id        uint16
+	Operation uint16
+	Expected  uint32
+	Actual    uint32
+}
+
+// Error implements the error interface
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("corrupt packet (id %d, operation %d): checksum 0x%08x does not match 0x%08x", e.Id, e.Operation, e.Actual, e.Expected)
+}
+
+// WithChecksums enables CRC32C checksums for the connections of the frisbee client or server.
+//
+// When a connection is opened, both sides advertise that they support checksums with a NEGOTIATE packet, and if they both do,
+// a CRC32C checksum of each packet's header and content is sent after every packet that follows. Connections that read a packet
+// whose checksum does not match are closed with a *ChecksumError, and the mismatch is reported to Metrics.ChecksumMismatch.
+// If the other side does not support checksums, packets are sent without them.
+func WithChecksums() Option {
+	return func(opts *Options) {
+		opts.Checksums = true
+	}
+}
+
+// checksum returns the CRC32C checksum of the given header and content
+func checksum(header []byte, content []byte) uint32 {
+	return crc32.Update(crc32.Checksum(header, castagnoli), castagnoli, content)
+}
+
+// reportChecksumMismatch reports a *ChecksumError to the given Metrics, and ignores every other error
+func reportChecksumMismatch(metrics Metrics, err error) {
+	var checksumErr *ChecksumError
+	if errors.As(err, &checksumErr) {
+		metrics.ChecksumMismatch()
+	}
+}
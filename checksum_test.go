@@ -0,0 +1,169 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package frisbee
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/loopholelabs/logging"
+
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/loopholelabs/frisbee-go/pkg/metrics"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+func asyncWriteChecksums(c *Async) bool {
+	c.Lock()
+	defer c.Unlock()
+	return c.writeChecksums
+}
+
+// encodePacket returns the packet encoded as it is sent on the wire, with its checksum xor-ed with corrupt if checksums are used
+func encodePacket(t *testing.T, version uint8, checksums bool, p *packet.Packet, corrupt uint32) []byte {
+	b, extensionsSize, err := appendHeader(nil, version, checksums, p, p.Content.Len(), p.Extensions)
+	require.NoError(t, err)
+	headerSize := len(b)
+	b = append(b, p.Content.Bytes()...)
+	if checksums {
+		sum := checksum(b[extensionsSize:headerSize], p.Content.Bytes()) ^ corrupt
+		b = append(b, byte(sum>>24), byte(sum>>16), byte(sum>>8), byte(sum))
+	}
+	return b
+}
+
+func TestChecksums(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+
+	for _, version := range []uint8{metadata.Version1, metadata.Version2} {
+		options := &Options{Logger: emptyLogger, HeaderVersion: version, Checksums: true}
+
+		asyncReader, asyncWriter := net.Pipe()
+		readerConn := NewAsyncWithOptions(asyncReader, options)
+		writerConn := NewAsyncWithOptions(asyncWriter, options)
+
+		syncReader, syncWriter := net.Pipe()
+		syncReaderConn := NewSyncWithOptions(syncReader, options)
+		syncWriterConn := NewSyncWithOptions(syncWriter, options)
+
+		require.Eventually(t, func() bool {
+			return asyncWriteChecksums(readerConn) && asyncWriteChecksums(writerConn) && asyncWriteVersion(writerConn) == version
+		}, time.Second, time.Millisecond)
+
+		// Sync connections only learn that the other side supports checksums when they read its NEGOTIATE packet
+		syncWriteErr := make(chan error, 1)
+		go func() {
+			p, err := syncWriterConn.ReadPacket()
+			if err != nil {
+				syncWriteErr <- err
+				return
+			}
+			packet.Put(p)
+			for i := 0; i < 3; i++ {
+				p := newHeaderTestPacket(uint32(i), 10, []byte("checksummed"))
+				_ = p.Extensions.Set(32, []byte("extension"))
+				if err := syncWriterConn.WritePacket(p); err != nil {
+					syncWriteErr <- err
+					return
+				}
+				packet.Put(p)
+			}
+			syncWriteErr <- nil
+		}()
+
+		for i := 0; i < 3; i++ {
+			p := newHeaderTestPacket(uint32(i), 10, []byte("checksummed"))
+			require.NoError(t, p.Extensions.Set(32, []byte("extension")))
+			require.NoError(t, writerConn.WritePacket(p))
+			packet.Put(p)
+		}
+		p := newHeaderTestPacket(0, 10, []byte("advertisement"))
+		require.NoError(t, syncReaderConn.WritePacket(p))
+		packet.Put(p)
+
+		for _, conn := range []Conn{readerConn, syncReaderConn} {
+			for i := 0; i < 3; i++ {
+				p, err := conn.ReadPacket()
+				require.NoError(t, err)
+				assert.Equal(t, uint16(i), p.Metadata.Id)
+				assert.Equal(t, []byte("checksummed"), p.Content.Bytes())
+				extension, ok := p.Extensions.Get(32)
+				require.True(t, ok)
+				assert.Equal(t, []byte("extension"), extension)
+				packet.Put(p)
+			}
+		}
+		require.NoError(t, <-syncWriteErr)
+
+		syncWriterConn.Lock()
+		assert.True(t, syncWriterConn.writeChecksums)
+		syncWriterConn.Unlock()
+
+		assert.NoError(t, readerConn.Close())
+		assert.NoError(t, writerConn.Close())
+		assert.NoError(t, syncReaderConn.Close())
+		assert.NoError(t, syncWriterConn.Close())
+	}
+}
+
+func TestChecksumMismatch(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+
+	for _, version := range []uint8{metadata.Version1, metadata.Version2} {
+		readerMetrics := metrics.New()
+		reader, raw := net.Pipe()
+		readerConn := NewAsyncWithOptions(reader, &Options{Logger: emptyLogger, Metrics: readerMetrics, HeaderVersion: version, Checksums: true})
+		go func() {
+			_, _ = io.Copy(io.Discard, raw)
+		}()
+
+		negotiatePacket := newNegotiatePacket(nil, version, true, false)
+		_, err := raw.Write(encodePacket(t, metadata.Version1, false, negotiatePacket, 0))
+		require.NoError(t, err)
+		packet.Put(negotiatePacket)
+
+		switchPacket := newSwitchPacket(version, true)
+		_, err = raw.Write(encodePacket(t, metadata.Version1, false, switchPacket, 0))
+		require.NoError(t, err)
+		packet.Put(switchPacket)
+
+		p := newHeaderTestPacket(1, 10, []byte("intact"))
+		require.NoError(t, p.Extensions.Set(32, []byte("extension")))
+		_, err = raw.Write(encodePacket(t, version, true, p, 0))
+		require.NoError(t, err)
+		packet.Put(p)
+
+		read, err := readerConn.ReadPacket()
+		require.NoError(t, err)
+		assert.Equal(t, []byte("intact"), read.Content.Bytes())
+		packet.Put(read)
+
+		p = newHeaderTestPacket(2, 10, []byte("corrupt"))
+		_, err = raw.Write(encodePacket(t, version, true, p, 1))
+		require.NoError(t, err)
+		packet.Put(p)
+
+		_, err = readerConn.ReadPacket()
+		require.ErrorIs(t, err, ConnectionClosed)
+
+		var checksumErr *ChecksumError
+		require.True(t, errors.As(readerConn.Error(), &checksumErr))
+		assert.Equal(t, uint16(2), checksumErr.Id)
+		assert.Equal(t, uint16(10), checksumErr.Operation)
+		assert.Equal(t, checksumErr.Expected, checksumErr.Actual^1)
+		assert.Equal(t, uint64(1), readerMetrics.Snapshot().ChecksumMismatches)
+
+		_ = raw.Close()
+		assert.NoError(t, readerConn.Close())
+	}
+}
@@ -4,33 +4,63 @@ package frisbee
 
 import (
 	"context"
+	"errors"
 	"net"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/loopholelabs/logging/types"
 
+	"github.com/loopholelabs/frisbee-go/pkg/outbox"
 	"github.com/loopholelabs/frisbee-go/pkg/packet"
 )
 
 // Client connects to a frisbee Server and can send and receive frisbee packets
 type Client struct {
-	conn             *Async
-	handlerTable     HandlerTable
+	conn             atomic.Pointer[Async]
+	handlerTable     *atomicHandlerTable
 	options          *Options
 	closed           atomic.Bool
 	wg               sync.WaitGroup
 	heartbeatChannel chan struct{}
+	closeCh          chan struct{}
+
+	// addr, streamHandler, and session are used to reconnect to the server and resume the session when sessions are enabled
+	addr          string
+	streamHandler []NewStreamHandler
+	session       *session
+
+	// deliveries and received are shared by the client's connections when reliable delivery is enabled
+	deliveries *deliveries
+	received   *deduplicator
+
+	// outbox holds the packets that are written while the client is not connected (see WithOutbox)
+	outbox *outbox.Outbox
 
 	baseContext       context.Context
 	baseContextCancel context.CancelFunc
 
+	// calls are the packets sent by Call that are waiting for a response
+	calls calls
+
+	// intercept is run before the handler table is consulted for every incoming packet, and
+	// takes ownership of the packet if it returns true
+	intercept func(*packet.Packet) bool
+
+	// ErrorHandler is called whenever an ERROR packet is received (for example, from a Proxy
+	// that could not reach an upstream server) with the ErrorFrame it contained and the ID of
+	// the packet that could not be handled. If it is nil, ERROR packets are discarded.
+	ErrorHandler func(ctx context.Context, id uint16, err *ErrorFrame)
+
 	// PacketContext is used to define packet-specific contexts based on the incoming packet
-	// and is run whenever a new packet arrives
+	// and is run whenever a new packet arrives. If the incoming packet carries a TraceContext,
+	// it will already have been added to the context (see TraceFromContext). It can also read
+	// and modify the packet's Headers (see packet.Packet.Header) before the handler runs.
 	PacketContext func(context.Context, *packet.Packet) context.Context
 
 	// UpdateContext is used to update a handler-specific context whenever the returned
-	// Action from a handler is UPDATE
+	// Action from a handler is UPDATE, and the returned context is used for every packet after that
 	UpdateContext func(context.Context, *Async) context.Context
 
 	// StreamContext is used to update a handler-specific context whenever a new stream is created
@@ -41,10 +71,9 @@ type Client struct {
 // NewClient returns an uninitialized frisbee Client with the registered ClientRouter.
 // The ConnectAsync method must then be called to dial the server and initialize the connection.
 func NewClient(handlerTable HandlerTable, ctx context.Context, opts ...Option) (*Client, error) {
-	for i := uint16(0); i < RESERVED9; i++ {
-		if _, ok := handlerTable[i]; ok {
-			return nil, InvalidHandlerTable
-		}
+	table, err := newAtomicHandlerTable(handlerTable)
+	if err != nil {
+		return nil, err
 	}
 
 	options := loadOptions(opts...)
@@ -52,28 +81,62 @@ func NewClient(handlerTable HandlerTable, ctx context.Context, opts ...Option) (
 
 	baseContext, baseContextCancel := context.WithCancel(ctx)
 
-	return &Client{
-		handlerTable:      handlerTable,
+	c := &Client{
+		handlerTable:      table,
 		baseContext:       baseContext,
 		baseContextCancel: baseContextCancel,
 		options:           options,
 		heartbeatChannel:  heartbeatChannel,
-	}, nil
+		closeCh:           make(chan struct{}),
+	}
+
+	if options.Reliable != nil {
+		c.deliveries = newDeliveries(options.Reliable, func(p *packet.Packet) error {
+			return c.conn.Load().writePacket(p, true)
+		})
+		c.received = newDeduplicator(options.Reliable.DedupWindow)
+	}
+
+	if options.Outbox != nil {
+		c.outbox, err = outbox.Open(*options.Outbox)
+		if err != nil {
+			baseContextCancel()
+			return nil, err
+		}
+	}
+
+	return c, nil
 }
 
 // Connect actually connects to the given frisbee server, and starts the reactor goroutines
 // to receive and handle incoming packets. If this function is called, FromConn should not be called.
+//
+// If sessions are enabled (see WithSessions), a session is started with the server, and the client
+// reconnects to the server and resumes the session whenever the connection drops.
 func (c *Client) Connect(addr string, streamHandler ...NewStreamHandler) error {
 	c.Logger().Debug().Msgf("Connecting to %s", addr)
 	var frisbeeConn *Async
 	var err error
-	frisbeeConn, err = ConnectAsync(addr, c.options.KeepAlive, c.Logger(), c.options.TLSConfig, streamHandler...)
+	if c.options.Sessions != nil {
+		c.addr = addr
+		c.streamHandler = streamHandler
+		c.session = newSession(SessionID{}, c.options.Sessions)
+		frisbeeConn, err = c.connectSession()
+	} else {
+		var conn net.Conn
+		conn, err = dial(addr, c.options)
+		if err == nil {
+			frisbeeConn = newAsync(conn, c.options, connState{deliveries: c.deliveries, received: c.received}, streamHandler...)
+		}
+	}
 	if err != nil {
 		return err
 	}
-	c.conn = frisbeeConn
+	c.conn.Store(frisbeeConn)
 	c.Logger().Info().Msgf("Connected to %s", addr)
 
+	c.waitExtensions(frisbeeConn)
+	c.drainOutbox(frisbeeConn)
 	c.wg.Add(1)
 	go c.handleConn()
 	c.Logger().Debug().Msgf("Connection handler started for %s", addr)
@@ -82,14 +145,34 @@ func (c *Client) Connect(addr string, streamHandler ...NewStreamHandler) error {
 
 // FromConn takes a pre-existing connection to a Frisbee server and starts the reactor goroutines
 // to receive and handle incoming packets. If this function is called, Connect should not be called.
+//
+// Sessions (see WithSessions) are not used by clients that are created with FromConn, since they cannot reconnect.
 func (c *Client) FromConn(conn net.Conn, streamHandler ...NewStreamHandler) error {
-	c.conn = NewAsync(conn, c.Logger(), streamHandler...)
+	c.conn.Store(newAsync(conn, c.options, connState{deliveries: c.deliveries, received: c.received}, streamHandler...))
+	c.waitExtensions(c.conn.Load())
+	c.drainOutbox(c.conn.Load())
 	c.wg.Add(1)
 	go c.handleConn()
-	c.Logger().Debug().Msgf("Connection handler started for %s", c.conn.RemoteAddr())
+	c.Logger().Debug().Msgf("Connection handler started for %s", c.conn.Load().RemoteAddr())
 	return nil
 }
 
+// waitExtensions waits for up to ExtensionsTimeout for the server to advertise extensions if they are
+// enabled, so that the headers and trace contexts of the first packets can be sent (see WithExtensions)
+func (c *Client) waitExtensions(conn *Async) {
+	if !c.options.Extensions {
+		return
+	}
+	timer := time.NewTimer(ExtensionsTimeout)
+	defer timer.Stop()
+	select {
+	case <-conn.ExtensionsChannel():
+	case <-conn.CloseChannel():
+	case <-timer.C:
+		c.Logger().Warn().Msg("timed out waiting for the server to advertise extensions")
+	}
+}
+
 // Closed checks whether this client has been closed
 func (c *Client) Closed() bool {
 	return c.closed.Load()
@@ -97,55 +180,99 @@ func (c *Client) Closed() bool {
 
 // Error checks whether this client has an error
 func (c *Client) Error() error {
-	return c.conn.Error()
+	return c.conn.Load().Error()
 }
 
 // Close closes the frisbee client and kills all the goroutines
 func (c *Client) Close() error {
 	if c.closed.CompareAndSwap(false, true) {
 		c.baseContextCancel()
-		err := c.conn.Close()
-		if err != nil {
-			return err
+		close(c.closeCh)
+		if c.deliveries != nil {
+			c.deliveries.close()
 		}
-		c.wg.Wait()
-		return nil
+		var err error
+		if conn := c.conn.Load(); conn != nil {
+			err = conn.Close()
+		}
+		if err == nil {
+			c.wg.Wait()
+		}
+		if c.outbox != nil {
+			err = errors.Join(err, c.outbox.Close())
+		}
+		return err
+	}
+	if conn := c.conn.Load(); conn != nil {
+		return conn.Close()
 	}
-	return c.conn.Close()
+	return nil
 }
 
-// WritePacket sends a frisbee packet.Packet from the client to the server
+// WritePacket sends a frisbee packet.Packet from the client to the server. If an outbox is used (see WithOutbox),
+// the packet is appended to it instead while the client is not connected or while it is being drained.
 func (c *Client) WritePacket(p *packet.Packet) error {
-	return c.conn.WritePacket(p)
+	if c.outbox != nil {
+		return c.writeOutbox(p)
+	}
+	return c.conn.Load().WritePacket(p)
+}
+
+// WriteReliable sends a copy of the frisbee packet.Packet from the client to the server with at-least-once delivery
+// (see WithReliableDelivery), and returns a Delivery that completes once the server has acknowledged it. Unlike the
+// packets written with Async.WriteReliable, packets that have not been acknowledged when the client reconnects (see
+// WithSessions) are sent again on the new connection, and only fail with ConnectionClosed once the client is closed.
+func (c *Client) WriteReliable(p *packet.Packet) (*Delivery, error) {
+	if p.Metadata.Operation <= RESERVED9 {
+		return nil, InvalidOperation
+	}
+	if c.deliveries == nil {
+		return nil, ReliableDeliveryDisabled
+	}
+	if c.conn.Load() == nil {
+		return nil, ConnectionNotInitialized
+	}
+	return c.deliveries.send(p)
+}
+
+// WritePacketContext sends a frisbee packet.Packet from the client to the server, and
+// attaches the TraceContext carried by ctx (if there is one) to the packet
+func (c *Client) WritePacketContext(ctx context.Context, p *packet.Packet) error {
+	InjectTrace(ctx, p)
+	return c.WritePacket(p)
 }
 
 // Flush flushes any queued frisbee Packets from the client to the server
 func (c *Client) Flush() error {
-	return c.conn.Flush()
+	return c.conn.Load().Flush()
 }
 
-// CloseChannel returns a channel that can be listened to see if this client has been closed
+// CloseChannel returns a channel that can be listened to see if this client has been closed.
+// If sessions are enabled (see WithSessions), the channel is not closed while the client is reconnecting.
 func (c *Client) CloseChannel() <-chan struct{} {
-	return c.conn.CloseChannel()
+	if c.options.Sessions != nil {
+		return c.closeCh
+	}
+	return c.conn.Load().CloseChannel()
 }
 
 // Raw converts the frisbee client into a normal net.Conn object, and returns it.
 // This is especially useful in proxying and streaming scenarios.
 func (c *Client) Raw() (net.Conn, error) {
-	if c.conn == nil {
+	if c.conn.Load() == nil {
 		return nil, ConnectionNotInitialized
 	}
 	if c.closed.CompareAndSwap(false, true) {
-		conn := c.conn.Raw()
+		conn := c.conn.Load().Raw()
 		c.wg.Wait()
 		return conn, nil
 	}
-	return c.conn.Raw(), nil
+	return c.conn.Load().Raw(), nil
 }
 
 // Stream returns a new Stream object that can be used to send and receive frisbee packets
 func (c *Client) Stream(id uint16) *Stream {
-	return c.conn.NewStream(id)
+	return c.conn.Load().NewStream(id)
 }
 
 // SetStreamHandler sets the callback handler for new streams.
@@ -157,15 +284,43 @@ func (c *Client) Stream(id uint16) *Stream {
 // avoid blocking the read loop. This means that the handler must be thread-safe.
 func (c *Client) SetStreamHandler(f func(context.Context, *Stream)) {
 	if f == nil {
-		c.conn.SetNewStreamHandler(nil)
+		c.conn.Load().SetNewStreamHandler(nil)
 	}
-	c.conn.SetNewStreamHandler(func(s *Stream) {
+	handler := func(s *Stream) {
 		streamCtx := c.baseContext
 		if c.StreamContext != nil {
 			streamCtx = c.StreamContext(streamCtx, s)
 		}
 		f(streamCtx, s)
-	})
+	}
+	c.streamHandler = []NewStreamHandler{handler}
+	c.conn.Load().SetNewStreamHandler(handler)
+}
+
+// SetHandlerTable replaces the handler table for the client with a copy of the given one.
+//
+// This function can be called while the client is connected, and packets that are already
+// being handled will finish with the handler that they started with.
+func (c *Client) SetHandlerTable(handlerTable HandlerTable) error {
+	return c.handlerTable.store(handlerTable)
+}
+
+// GetHandlerTable returns a copy of the handler table for the client. Modifying the copy
+// has no effect, use SetHandlerTable, RegisterHandler, or UnregisterHandler instead.
+func (c *Client) GetHandlerTable() HandlerTable {
+	return c.handlerTable.load()
+}
+
+// RegisterHandler adds (or replaces) the handler for the given operation,
+// and can be called while the client is connected.
+func (c *Client) RegisterHandler(operation uint16, handler Handler) error {
+	return c.handlerTable.register(operation, handler)
+}
+
+// UnregisterHandler removes the handler for the given operation, and can be called while the client is connected.
+// Packets with the operation that arrive afterwards are discarded.
+func (c *Client) UnregisterHandler(operation uint16) {
+	c.handlerTable.unregister(operation)
 }
 
 // Logger returns the client's logger (useful for ClientRouter functions)
@@ -179,27 +334,45 @@ func (c *Client) handleConn() {
 	var action Action
 	var err error
 	var handlerFunc Handler
+	ctx := c.baseContext
 	for {
 		if c.closed.Load() {
 			c.wg.Done()
 			return
 		}
-		p, err = c.conn.ReadPacket()
+		p, err = c.conn.Load().ReadPacket()
+		if err != nil && c.options.Sessions != nil && c.session != nil && !c.closed.Load() {
+			err = c.reconnect()
+			if err == nil {
+				continue
+			}
+		}
 		if err != nil {
 			c.Logger().Debug().Err(err).Msg("error while getting packet frisbee connection")
 			c.wg.Done()
 			_ = c.Close()
 			return
 		}
-		handlerFunc = c.handlerTable[p.Metadata.Operation]
-		if handlerFunc != nil {
-			packetCtx := c.baseContext
-			if c.PacketContext != nil {
-				packetCtx = c.PacketContext(packetCtx, p)
+		if c.calls.deliver(p) {
+			continue
+		}
+		if c.intercept != nil && c.intercept(p) {
+			continue
+		}
+		if p.Metadata.Operation == ERROR {
+			if c.ErrorHandler != nil {
+				if errorFrame, err := ParseErrorFrame(p); err == nil {
+					c.ErrorHandler(c.baseContext, p.Metadata.Id, errorFrame)
+				}
 			}
-			outgoing, action = handlerFunc(packetCtx, p)
+			packet.Put(p)
+			continue
+		}
+		handlerFunc = c.handlerTable.get(p.Metadata.Operation)
+		if handlerFunc != nil {
+			outgoing, action = c.options.handlePacket(ctx, c.PacketContext, handlerFunc, p)
 			if outgoing != nil && outgoing.Metadata.ContentLength == uint32(outgoing.Content.Len()) {
-				err = c.conn.WritePacket(outgoing)
+				err = c.conn.Load().writeResponse(outgoing)
 				if outgoing != p {
 					packet.Put(outgoing)
 				}
@@ -216,10 +389,19 @@ func (c *Client) handleConn() {
 			switch action {
 			case NONE:
 			case CLOSE:
-				c.Logger().Debug().Msgf("Closing connection %s because of CLOSE action", c.conn.RemoteAddr())
+				c.Logger().Debug().Msgf("Closing connection %s because of CLOSE action", c.conn.Load().RemoteAddr())
 				c.wg.Done()
 				_ = c.Close()
 				return
+			case SHUTDOWN:
+				c.Logger().Debug().Msgf("Closing connection %s because of SHUTDOWN action", c.conn.Load().RemoteAddr())
+				c.wg.Done()
+				_ = c.Close()
+				return
+			case UPDATE:
+				if c.UpdateContext != nil {
+					ctx = c.UpdateContext(ctx, c.conn.Load())
+				}
 			}
 		} else {
 			packet.Put(p)
@@ -178,7 +178,7 @@ func TestClientStaleClose(t *testing.T) {
 	packet.Put(p)
 	<-finished
 
-	_, err = c.conn.ReadPacket()
+	_, err = c.conn.Load().ReadPacket()
 	assert.ErrorIs(t, err, ConnectionClosed)
 
 	err = c.Close()
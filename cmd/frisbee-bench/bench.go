@@ -0,0 +1,263 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/loopholelabs/frisbee-go"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+var (
+	errTimeout = errors.New("timed out waiting for a response")
+)
+
+// config describes a benchmark run
+type config struct {
+	address     string
+	options     *frisbee.Options
+	connections int
+	workers     int
+	duration    time.Duration
+	packets     int64
+	sizes       []int
+	operations  []uint16
+	oneway      bool
+	stream      bool
+	timeout     time.Duration
+}
+
+// mode returns the name of the benchmark mode described by the config
+func (c *config) mode() string {
+	switch {
+	case c.stream && c.oneway:
+		return "stream-oneway"
+	case c.stream:
+		return "stream-request"
+	case c.oneway:
+		return "oneway"
+	default:
+		return "request"
+	}
+}
+
+// worker sends packets over a single connection (or stream), and records the results
+type worker struct {
+	id        uint16
+	conn      *frisbee.Async
+	stream    *frisbee.Stream
+	responses chan *packet.Packet
+	recorder  *recorder
+}
+
+// bench runs the benchmark described by the config and returns its results
+func bench(ctx context.Context, c config) (*Result, error) {
+	maxSize := 0
+	for _, size := range c.sizes {
+		if size > maxSize {
+			maxSize = size
+		}
+	}
+	payload := make([]byte, maxSize)
+	_, _ = rand.Read(payload)
+
+	conns := make([]*frisbee.Async, 0, c.connections)
+	defer func() {
+		for _, conn := range conns {
+			_ = conn.Close()
+		}
+	}()
+
+	var readers sync.WaitGroup
+	workers := make([]*worker, 0, c.connections*c.workers)
+	for i := 0; i < c.connections; i++ {
+		// Incoming stream packets are discarded by connections without a stream handler,
+		// even for streams that were opened locally, so streams opened by the server are closed instead
+		conn, err := frisbee.ConnectAsyncWithOptions(c.address, c.options, func(s *frisbee.Stream) {
+			_ = s.Close()
+		})
+		if err != nil {
+			return nil, err
+		}
+		conns = append(conns, conn)
+
+		connWorkers := make([]*worker, c.workers)
+		for j := range connWorkers {
+			connWorkers[j] = &worker{
+				id:        uint16(j),
+				conn:      conn,
+				responses: make(chan *packet.Packet, 1),
+				recorder:  newRecorder(),
+			}
+			if c.stream {
+				connWorkers[j].stream = conn.NewStream(uint16(j))
+				readers.Add(1)
+				go connWorkers[j].readStream(&readers)
+			}
+		}
+		if !c.stream {
+			readers.Add(1)
+			go readConn(conn, connWorkers, &readers)
+		}
+		workers = append(workers, connWorkers...)
+	}
+
+	runCtx, cancel := context.WithTimeout(ctx, c.duration)
+	defer cancel()
+
+	var remaining atomic.Int64
+	remaining.Store(c.packets)
+
+	var errOnce sync.Once
+	var runErr error
+
+	var wg sync.WaitGroup
+	start := time.Now()
+	for _, w := range workers {
+		wg.Add(1)
+		go func(w *worker) {
+			defer wg.Done()
+			if err := w.run(runCtx, &c, payload, &remaining); err != nil {
+				errOnce.Do(func() {
+					runErr = err
+					cancel()
+				})
+			}
+		}(w)
+	}
+	wg.Wait()
+	for _, conn := range conns {
+		if err := conn.Flush(); err != nil && runErr == nil {
+			runErr = err
+		}
+	}
+	elapsed := time.Since(start)
+
+	for _, conn := range conns {
+		_ = conn.Close()
+	}
+	conns = conns[:0]
+	readers.Wait()
+
+	if runErr != nil {
+		return nil, runErr
+	}
+
+	recorders := make([]*recorder, 0, len(workers))
+	for _, w := range workers {
+		recorders = append(recorders, w.recorder)
+	}
+	result := newResult(c.mode(), c.connections, c.workers, elapsed, recorders)
+	return &result, nil
+}
+
+// run sends packets until the context is canceled or there are no packets remaining
+func (w *worker) run(ctx context.Context, c *config, payload []byte, remaining *atomic.Int64) error {
+	p := packet.Get()
+	defer packet.Put(p)
+
+	timer := time.NewTimer(c.timeout)
+	timer.Stop()
+
+	for i := 0; ctx.Err() == nil; i++ {
+		if c.packets > 0 && remaining.Add(-1) < 0 {
+			return nil
+		}
+
+		size := c.sizes[i%len(c.sizes)]
+		p.Reset()
+		p.Metadata.Id = w.id
+		p.Metadata.Operation = c.operations[i%len(c.operations)]
+		p.Content.Write(payload[:size])
+		p.Metadata.ContentLength = uint32(size)
+
+		start := time.Now()
+		var err error
+		if w.stream != nil {
+			err = w.stream.WritePacket(p)
+		} else {
+			err = w.conn.WritePacket(p)
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		if c.oneway {
+			w.recorder.record(time.Since(start), size)
+			continue
+		}
+
+		if err = w.conn.Flush(); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		timer.Reset(c.timeout)
+		select {
+		case response := <-w.responses:
+			w.recorder.record(time.Since(start), size)
+			w.recorder.response(int(response.Metadata.ContentLength))
+			packet.Put(response)
+			if !timer.Stop() {
+				<-timer.C
+			}
+		case <-timer.C:
+			return errTimeout
+		case <-ctx.Done():
+			if !timer.Stop() {
+				<-timer.C
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
+// readStream delivers the packets received on the worker's stream to its responses channel
+func (w *worker) readStream(wg *sync.WaitGroup) {
+	defer wg.Done()
+	for {
+		p, err := w.stream.ReadPacket()
+		if err != nil {
+			return
+		}
+		w.deliver(p)
+	}
+}
+
+// deliver passes a response to the worker, or discards it if the worker already has a pending response
+func (w *worker) deliver(p *packet.Packet) {
+	select {
+	case w.responses <- p:
+	default:
+		w.recorder.discarded.Add(1)
+		packet.Put(p)
+	}
+}
+
+// readConn delivers the packets received on the connection to the worker with a matching ID
+func readConn(conn *frisbee.Async, workers []*worker, wg *sync.WaitGroup) {
+	defer wg.Done()
+	for {
+		p, err := conn.ReadPacket()
+		if err != nil {
+			return
+		}
+		if id := int(p.Metadata.Id); id < len(workers) {
+			workers[id].deliver(p)
+		} else {
+			packet.Put(p)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+// SPDX-License-Identifier: Apache-2.0
+
+// Command frisbee-bench is a load generator for frisbee servers. It drives a server with a configurable
+// number of connections and concurrent workers, and reports the throughput and latency percentiles it observed.
+//
+// Usage:
+//
+//	frisbee-bench [flags] <address>
+//	frisbee-bench -serve [flags] <listen address>
+//
+// By default every worker sends a request and waits for the response before sending the next one, which
+// requires a server that responds to the benchmarked operations. With -oneway, workers send packets as quickly
+// as possible without waiting for responses. With -stream, each worker opens its own stream and sends its packets
+// through it instead. The -serve flag starts an echo server that can be used as the target of a benchmark, and
+// should be combined with -oneway when it is the target of a one-way benchmark so that it discards packets instead.
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/loopholelabs/frisbee-go"
+	"github.com/loopholelabs/frisbee-go/internal/cli"
+)
+
+var (
+	errUsage             = errors.New("usage")
+	errInvalidOperation  = fmt.Errorf("operations must be between %d and 65535", frisbee.RESERVED9+1)
+	errInvalidSize       = errors.New("packet sizes must be between 0 and 4294967295")
+	errInvalidStreamSize = errors.New("packet sizes must be greater than 0 when using -stream")
+	errInvalidWorkers    = errors.New("-connections must be greater than 0, and -workers must be between 1 and 65536")
+)
+
+func main() {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	code := run(ctx, os.Args[1:], os.Stdout, os.Stderr)
+	cancel()
+	os.Exit(code)
+}
+
+func run(ctx context.Context, args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("frisbee-bench", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		_, _ = fmt.Fprintf(flags.Output(), "Usage:\n\n\tfrisbee-bench [flags] <address>\n\tfrisbee-bench -serve [flags] <listen address>\n\n"+
+			"Drive a frisbee server with load and report the throughput and latency percentiles.\n\n")
+		flags.PrintDefaults()
+	}
+
+	var connection cli.Connection
+	connection.Register(flags)
+	connections := flags.Int("connections", 1, "number of connections to open")
+	workers := flags.Int("workers", 1, "number of concurrent workers per connection")
+	duration := flags.Duration("duration", time.Second*10, "how long to run the benchmark for")
+	packets := flags.Int64("packets", 0, "stop after this many packets have been sent in total (0 runs for -duration)")
+	sizes := flags.String("size", "512", "comma-separated list of packet content sizes in bytes, used in turn")
+	operations := flags.String("op", "10", "comma-separated list of packet operations, used in turn")
+	oneway := flags.Bool("oneway", false, "send packets without waiting for responses (with -serve, discard packets instead of echoing them)")
+	stream := flags.Bool("stream", false, "send packets through a stream per worker instead of as individual packets")
+	jsonOutput := flags.Bool("json", false, "print the results as JSON")
+	serve := flags.Bool("serve", false, "start an echo server on the given address instead of running a benchmark (-cert and -key enable TLS)")
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		_, _ = fmt.Fprintf(stderr, "expected exactly one address\n")
+		flags.Usage()
+		return 2
+	}
+
+	err := func() error {
+		parsedOperations, err := parseOperations(*operations)
+		if err != nil {
+			return err
+		}
+
+		options, err := connection.Options()
+		if err != nil {
+			return err
+		}
+
+		if *serve {
+			listener, err := net.Listen("tcp", flags.Arg(0))
+			if err != nil {
+				return err
+			}
+			if options.TLSConfig != nil {
+				listener = tls.NewListener(listener, options.TLSConfig)
+			}
+			_, _ = fmt.Fprintf(stderr, "serving on %s\n", listener.Addr())
+			return serveEcho(ctx, listener, parsedOperations, *oneway, options)
+		}
+
+		parsedSizes, err := parseSizes(*sizes)
+		if err != nil {
+			return err
+		}
+		if *stream {
+			for _, size := range parsedSizes {
+				if size == 0 {
+					return errInvalidStreamSize
+				}
+			}
+		}
+		if *connections < 1 || *workers < 1 || *workers > 1<<16 {
+			return errInvalidWorkers
+		}
+
+		result, err := bench(ctx, config{
+			address:     flags.Arg(0),
+			options:     options,
+			connections: *connections,
+			workers:     *workers,
+			duration:    *duration,
+			packets:     *packets,
+			sizes:       parsedSizes,
+			operations:  parsedOperations,
+			oneway:      *oneway,
+			stream:      *stream,
+			timeout:     connection.Timeout,
+		})
+		if err != nil {
+			return err
+		}
+
+		if *jsonOutput {
+			encoder := json.NewEncoder(stdout)
+			encoder.SetIndent("", "  ")
+			return encoder.Encode(result)
+		}
+		result.WriteText(stdout)
+		return nil
+	}()
+	if err != nil {
+		_, _ = fmt.Fprintf(stderr, "frisbee-bench: %s\n", err)
+		return 1
+	}
+	return 0
+}
+
+// parseOperations parses a comma-separated list of packet operations
+func parseOperations(list string) ([]uint16, error) {
+	var operations []uint16
+	for _, field := range strings.Split(list, ",") {
+		operation, err := strconv.ParseUint(strings.TrimSpace(field), 10, 16)
+		if err != nil || operation <= uint64(frisbee.RESERVED9) {
+			return nil, errInvalidOperation
+		}
+		operations = append(operations, uint16(operation))
+	}
+	return operations, nil
+}
+
+// parseSizes parses a comma-separated list of packet content sizes
+func parseSizes(list string) ([]int, error) {
+	var sizes []int
+	for _, field := range strings.Split(list, ",") {
+		size, err := strconv.ParseUint(strings.TrimSpace(field), 10, 32)
+		if err != nil {
+			return nil, errInvalidSize
+		}
+		sizes = append(sizes, int(size))
+	}
+	return sizes, nil
+}
@@ -0,0 +1,120 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/goleak"
+
+	"github.com/loopholelabs/frisbee-go"
+)
+
+func TestMain(m *testing.M) {
+	goleak.VerifyTestMain(m)
+}
+
+func startServer(t *testing.T, discard bool) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- serveEcho(ctx, listener, []uint16{10, 11}, discard, &frisbee.Options{})
+	}()
+	t.Cleanup(func() {
+		cancel()
+		assert.NoError(t, <-errCh)
+	})
+
+	return listener.Addr().String()
+}
+
+func TestBench(t *testing.T) {
+	echo := startServer(t, false)
+	sink := startServer(t, true)
+
+	for _, test := range []struct {
+		mode    string
+		address string
+		oneway  bool
+		args    []string
+	}{
+		{mode: "request", address: echo},
+		{mode: "stream-request", address: echo, args: []string{"-stream"}},
+		{mode: "oneway", address: sink, oneway: true, args: []string{"-oneway"}},
+		{mode: "stream-oneway", address: sink, oneway: true, args: []string{"-stream", "-oneway"}},
+	} {
+		t.Run(test.mode, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			args := append([]string{"-json", "-packets", "200", "-connections", "2", "-workers", "3", "-size", "16,32", "-op", "10,11"}, test.args...)
+			code := run(context.Background(), append(args, test.address), &stdout, &stderr)
+			require.Equal(t, 0, code, stderr.String())
+
+			var result Result
+			require.NoError(t, json.Unmarshal(stdout.Bytes(), &result))
+			assert.Equal(t, test.mode, result.Mode)
+			assert.Equal(t, 2, result.Connections)
+			assert.Equal(t, 3, result.Workers)
+			assert.Equal(t, int64(200), result.Packets)
+			assert.GreaterOrEqual(t, result.Bytes, int64(200*16))
+			assert.LessOrEqual(t, result.Bytes, int64(200*32))
+			if test.oneway {
+				assert.Zero(t, result.Responses)
+			} else {
+				assert.Equal(t, int64(200), result.Responses)
+				assert.Equal(t, result.Bytes, result.ResponseBytes)
+			}
+			assert.Positive(t, result.Latency.Min)
+			assert.LessOrEqual(t, result.Latency.Min, result.Latency.P50)
+			assert.LessOrEqual(t, result.Latency.P50, result.Latency.P99)
+			assert.LessOrEqual(t, result.Latency.P99, result.Latency.Max)
+		})
+	}
+
+	var stdout, stderr bytes.Buffer
+	code := run(context.Background(), []string{"-duration", "50ms", echo}, &stdout, &stderr)
+	require.Equal(t, 0, code, stderr.String())
+	assert.Contains(t, stdout.String(), "mode:         request")
+	assert.Contains(t, stdout.String(), "latency:")
+}
+
+func TestBenchTimeout(t *testing.T) {
+	sink := startServer(t, true)
+
+	var stdout, stderr bytes.Buffer
+	code := run(context.Background(), []string{"-timeout", "50ms", sink}, &stdout, &stderr)
+	assert.Equal(t, 1, code)
+	assert.Contains(t, stderr.String(), errTimeout.Error())
+}
+
+func TestInvalidFlags(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	assert.Equal(t, 2, run(context.Background(), nil, &stdout, &stderr))
+	assert.Equal(t, 1, run(context.Background(), []string{"-op", "9", "127.0.0.1:0"}, &stdout, &stderr))
+	assert.Contains(t, stderr.String(), errInvalidOperation.Error())
+	assert.Equal(t, 1, run(context.Background(), []string{"-stream", "-size", "0", "127.0.0.1:0"}, &stdout, &stderr))
+	assert.Contains(t, stderr.String(), errInvalidStreamSize.Error())
+	assert.Equal(t, 1, run(context.Background(), []string{"-workers", "0", "127.0.0.1:0"}, &stdout, &stderr))
+	assert.Contains(t, stderr.String(), errInvalidWorkers.Error())
+}
+
+func TestPercentile(t *testing.T) {
+	samples := make([]time.Duration, 1000)
+	for i := range samples {
+		samples[i] = time.Duration(i + 1)
+	}
+	assert.Equal(t, time.Duration(500), percentile(samples, 0.5))
+	assert.Equal(t, time.Duration(990), percentile(samples, 0.99))
+	assert.Equal(t, time.Duration(999), percentile(samples, 0.999))
+	assert.Equal(t, time.Duration(1000), percentile(samples, 1))
+	assert.Zero(t, percentile(nil, 0.5))
+}
@@ -0,0 +1,163 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// maxSamples is the maximum number of latency samples kept by each worker. Once it is reached,
+// reservoir sampling is used to keep a uniform sample of every latency the worker recorded.
+const maxSamples = 1 << 16
+
+// recorder collects the results of a single worker. Only discarded can be used concurrently.
+type recorder struct {
+	packets       int64
+	bytes         int64
+	responses     int64
+	responseBytes int64
+	total         time.Duration
+	min           time.Duration
+	max           time.Duration
+	samples       []time.Duration
+	rand          *rand.Rand
+	discarded     atomic.Int64
+}
+
+func newRecorder() *recorder {
+	return &recorder{
+		rand: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// record records a packet that was sent with the given content size, along with the latency that was observed
+func (r *recorder) record(latency time.Duration, size int) {
+	r.packets++
+	r.bytes += int64(size)
+	r.total += latency
+	if r.min == 0 || latency < r.min {
+		r.min = latency
+	}
+	if latency > r.max {
+		r.max = latency
+	}
+	if len(r.samples) < maxSamples {
+		r.samples = append(r.samples, latency)
+	} else if j := r.rand.Int63n(r.packets); j < maxSamples {
+		r.samples[j] = latency
+	}
+}
+
+// response records a response that was received with the given content size
+func (r *recorder) response(size int) {
+	r.responses++
+	r.responseBytes += int64(size)
+}
+
+// Latency is a summary of the latencies observed during a benchmark. In request mode it is the time between
+// sending a request and receiving its response, and in one-way mode it is the time it takes to write a packet.
+type Latency struct {
+	Min  time.Duration `json:"min_ns"`
+	Mean time.Duration `json:"mean_ns"`
+	P50  time.Duration `json:"p50_ns"`
+	P90  time.Duration `json:"p90_ns"`
+	P99  time.Duration `json:"p99_ns"`
+	P999 time.Duration `json:"p999_ns"`
+	Max  time.Duration `json:"max_ns"`
+}
+
+// Result is the result of a benchmark run
+type Result struct {
+	Mode             string        `json:"mode"`
+	Connections      int           `json:"connections"`
+	Workers          int           `json:"workers"`
+	Elapsed          time.Duration `json:"elapsed_ns"`
+	Packets          int64         `json:"packets"`
+	Bytes            int64         `json:"bytes"`
+	Responses        int64         `json:"responses"`
+	ResponseBytes    int64         `json:"response_bytes"`
+	Discarded        int64         `json:"discarded"`
+	PacketsPerSecond float64       `json:"packets_per_second"`
+	BytesPerSecond   float64       `json:"bytes_per_second"`
+	Latency          Latency       `json:"latency"`
+}
+
+func newResult(mode string, connections int, workers int, elapsed time.Duration, recorders []*recorder) Result {
+	result := Result{
+		Mode:        mode,
+		Connections: connections,
+		Workers:     workers,
+		Elapsed:     elapsed,
+	}
+
+	var total time.Duration
+	var samples []time.Duration
+	for _, r := range recorders {
+		result.Packets += r.packets
+		result.Bytes += r.bytes
+		result.Responses += r.responses
+		result.ResponseBytes += r.responseBytes
+		result.Discarded += r.discarded.Load()
+		total += r.total
+		if r.packets > 0 && (result.Latency.Min == 0 || r.min < result.Latency.Min) {
+			result.Latency.Min = r.min
+		}
+		if r.max > result.Latency.Max {
+			result.Latency.Max = r.max
+		}
+		samples = append(samples, r.samples...)
+	}
+
+	if elapsed > 0 {
+		result.PacketsPerSecond = float64(result.Packets) / elapsed.Seconds()
+		result.BytesPerSecond = float64(result.Bytes) / elapsed.Seconds()
+	}
+
+	if result.Packets > 0 {
+		result.Latency.Mean = total / time.Duration(result.Packets)
+		sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+		result.Latency.P50 = percentile(samples, 0.5)
+		result.Latency.P90 = percentile(samples, 0.9)
+		result.Latency.P99 = percentile(samples, 0.99)
+		result.Latency.P999 = percentile(samples, 0.999)
+	}
+
+	return result
+}
+
+// percentile returns the q-th quantile of the sorted samples using the nearest-rank method
+func percentile(sorted []time.Duration, q float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(q*float64(len(sorted))+0.5) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank]
+}
+
+// WriteText writes a human-readable summary of the result to w
+func (r *Result) WriteText(w io.Writer) {
+	_, _ = fmt.Fprintf(w, "mode:         %s\n", r.Mode)
+	_, _ = fmt.Fprintf(w, "connections:  %d (%d workers each)\n", r.Connections, r.Workers)
+	_, _ = fmt.Fprintf(w, "elapsed:      %s\n", r.Elapsed.Round(time.Millisecond))
+	_, _ = fmt.Fprintf(w, "packets:      %d (%.1f/s)\n", r.Packets, r.PacketsPerSecond)
+	_, _ = fmt.Fprintf(w, "bytes:        %d (%.2f MiB/s)\n", r.Bytes, r.BytesPerSecond/(1<<20))
+	if r.Mode == "request" || r.Mode == "stream-request" {
+		_, _ = fmt.Fprintf(w, "responses:    %d (%d bytes)\n", r.Responses, r.ResponseBytes)
+	}
+	if r.Discarded > 0 {
+		_, _ = fmt.Fprintf(w, "discarded:    %d unexpected responses\n", r.Discarded)
+	}
+	_, _ = fmt.Fprintf(w, "latency:      min=%s mean=%s p50=%s p90=%s p99=%s p99.9=%s max=%s\n",
+		r.Latency.Min, r.Latency.Mean, r.Latency.P50, r.Latency.P90, r.Latency.P99, r.Latency.P999, r.Latency.Max)
+}
@@ -0,0 +1,65 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"net"
+
+	"github.com/loopholelabs/frisbee-go"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+// serveEcho runs a frisbee server on the listener that responds to every packet with the given operations,
+// and to every stream packet, with the packet itself. If discard is true the packets are discarded instead,
+// which is what one-way benchmarks expect. It returns once the context is canceled.
+func serveEcho(ctx context.Context, listener net.Listener, operations []uint16, discard bool, options *frisbee.Options) error {
+	handlerTable := make(frisbee.HandlerTable)
+	for _, operation := range operations {
+		handlerTable[operation] = func(_ context.Context, incoming *packet.Packet) (*packet.Packet, frisbee.Action) {
+			if discard {
+				return nil, frisbee.NONE
+			}
+			return incoming, frisbee.NONE
+		}
+	}
+
+	s, err := frisbee.NewServer(handlerTable, ctx, frisbee.WithOptions(*options))
+	if err != nil {
+		return err
+	}
+
+	err = s.SetStreamHandler(func(_ context.Context, stream *frisbee.Stream) {
+		for {
+			p, err := stream.ReadPacket()
+			if err != nil {
+				return
+			}
+			if discard {
+				packet.Put(p)
+				continue
+			}
+			err = stream.WritePacket(p)
+			packet.Put(p)
+			if err != nil {
+				return
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- s.StartWithListener(listener)
+	}()
+
+	select {
+	case err = <-errCh:
+		_ = s.Shutdown()
+		return err
+	case <-ctx.Done():
+		return s.Shutdown()
+	}
+}
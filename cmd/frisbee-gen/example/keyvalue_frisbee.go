@@ -0,0 +1,345 @@
+// Code generated by frisbee-gen. DO NOT EDIT.
+
+package example
+
+import (
+	"context"
+
+	"github.com/loopholelabs/polyglot/v2"
+
+	"github.com/loopholelabs/frisbee-go"
+)
+
+// These are the frisbee operations used by the KeyValue methods:
+const (
+	KeyValueGetOperation    uint16 = 10
+	KeyValuePutOperation    uint16 = 20
+	KeyValueDeleteOperation uint16 = 11
+)
+
+// NewKeyValueHandlerTable returns a frisbee.HandlerTable that calls the methods of the given KeyValue
+func NewKeyValueHandlerTable(service KeyValue) frisbee.HandlerTable {
+	return frisbee.HandlerTable{
+		KeyValueGetOperation:    frisbee.TypedHandler[GetRequest, GetResponse](service.Get),
+		KeyValuePutOperation:    frisbee.TypedHandler[PutRequest, PutResponse](service.Put),
+		KeyValueDeleteOperation: frisbee.TypedHandler[DeleteRequest, DeleteResponse](service.Delete),
+	}
+}
+
+// KeyValueClient implements KeyValue by calling its methods on a frisbee Server
+type KeyValueClient struct {
+	client *frisbee.Client
+}
+
+var _ KeyValue = (*KeyValueClient)(nil)
+
+// NewKeyValueClient returns a KeyValueClient that uses the given frisbee.Client, which must already be connected
+func NewKeyValueClient(client *frisbee.Client) *KeyValueClient {
+	return &KeyValueClient{client: client}
+}
+
+// Get calls the Get method on the server
+func (c *KeyValueClient) Get(ctx context.Context, req *GetRequest) (*GetResponse, error) {
+	return frisbee.CallTyped[GetRequest, GetResponse](ctx, c.client, KeyValueGetOperation, req)
+}
+
+// Put calls the Put method on the server
+func (c *KeyValueClient) Put(ctx context.Context, req *PutRequest) (*PutResponse, error) {
+	return frisbee.CallTyped[PutRequest, PutResponse](ctx, c.client, KeyValuePutOperation, req)
+}
+
+// Delete calls the Delete method on the server
+func (c *KeyValueClient) Delete(ctx context.Context, req *DeleteRequest) (*DeleteResponse, error) {
+	return frisbee.CallTyped[DeleteRequest, DeleteResponse](ctx, c.client, KeyValueDeleteOperation, req)
+}
+
+// Encode encodes the DeleteRequest into the given polyglot.Buffer
+func (x *DeleteRequest) Encode(b *polyglot.Buffer) {
+	if x == nil {
+		polyglot.Encoder(b).Nil()
+		return
+	}
+	polyglot.Encoder(b).String(x.Key)
+}
+
+// Decode decodes the DeleteRequest from the given bytes
+func (x *DeleteRequest) Decode(b []byte) error {
+	d := polyglot.Decoder(b)
+	if d.Nil() {
+		return nil
+	}
+	return x.frisbeeDecode(d)
+}
+
+func (x *DeleteRequest) frisbeeDecode(d *polyglot.BufferDecoder) error {
+	var err error
+	x.Key, err = d.String()
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Encode encodes the DeleteResponse into the given polyglot.Buffer
+func (x *DeleteResponse) Encode(b *polyglot.Buffer) {
+	if x == nil {
+		polyglot.Encoder(b).Nil()
+		return
+	}
+	polyglot.Encoder(b).Bool(x.Deleted)
+}
+
+// Decode decodes the DeleteResponse from the given bytes
+func (x *DeleteResponse) Decode(b []byte) error {
+	d := polyglot.Decoder(b)
+	if d.Nil() {
+		return nil
+	}
+	return x.frisbeeDecode(d)
+}
+
+func (x *DeleteResponse) frisbeeDecode(d *polyglot.BufferDecoder) error {
+	var err error
+	x.Deleted, err = d.Bool()
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Encode encodes the Entry into the given polyglot.Buffer
+func (x *Entry) Encode(b *polyglot.Buffer) {
+	if x == nil {
+		polyglot.Encoder(b).Nil()
+		return
+	}
+	polyglot.Encoder(b).String(x.Key)
+	polyglot.Encoder(b).Bytes(x.Value)
+	polyglot.Encoder(b).Uint64(x.Version)
+	x.Meta.Encode(b)
+}
+
+// Decode decodes the Entry from the given bytes
+func (x *Entry) Decode(b []byte) error {
+	d := polyglot.Decoder(b)
+	if d.Nil() {
+		return nil
+	}
+	return x.frisbeeDecode(d)
+}
+
+func (x *Entry) frisbeeDecode(d *polyglot.BufferDecoder) error {
+	var err error
+	x.Key, err = d.String()
+	if err != nil {
+		return err
+	}
+	x.Value, err = d.Bytes(nil)
+	if err != nil {
+		return err
+	}
+	x.Version, err = d.Uint64()
+	if err != nil {
+		return err
+	}
+	x.Meta = nil
+	if !d.Nil() {
+		x.Meta = new(Metadata)
+		err = x.Meta.frisbeeDecode(d)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Encode encodes the GetRequest into the given polyglot.Buffer
+func (x *GetRequest) Encode(b *polyglot.Buffer) {
+	if x == nil {
+		polyglot.Encoder(b).Nil()
+		return
+	}
+	polyglot.Encoder(b).Slice(uint32(len(x.Keys)), polyglot.StringKind)
+	for _, v := range x.Keys {
+		polyglot.Encoder(b).String(v)
+	}
+}
+
+// Decode decodes the GetRequest from the given bytes
+func (x *GetRequest) Decode(b []byte) error {
+	d := polyglot.Decoder(b)
+	if d.Nil() {
+		return nil
+	}
+	return x.frisbeeDecode(d)
+}
+
+func (x *GetRequest) frisbeeDecode(d *polyglot.BufferDecoder) error {
+	var err error
+	var size uint32
+	size, err = d.Slice(polyglot.StringKind)
+	if err != nil {
+		return err
+	}
+	x.Keys = make([]string, size)
+	for i := range x.Keys {
+		x.Keys[i], err = d.String()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Encode encodes the GetResponse into the given polyglot.Buffer
+func (x *GetResponse) Encode(b *polyglot.Buffer) {
+	if x == nil {
+		polyglot.Encoder(b).Nil()
+		return
+	}
+	polyglot.Encoder(b).Slice(uint32(len(x.Entries)), polyglot.AnyKind)
+	for _, v := range x.Entries {
+		v.Encode(b)
+	}
+}
+
+// Decode decodes the GetResponse from the given bytes
+func (x *GetResponse) Decode(b []byte) error {
+	d := polyglot.Decoder(b)
+	if d.Nil() {
+		return nil
+	}
+	return x.frisbeeDecode(d)
+}
+
+func (x *GetResponse) frisbeeDecode(d *polyglot.BufferDecoder) error {
+	var err error
+	var size uint32
+	size, err = d.Slice(polyglot.AnyKind)
+	if err != nil {
+		return err
+	}
+	x.Entries = make([]*Entry, size)
+	for i := range x.Entries {
+		if !d.Nil() {
+			x.Entries[i] = new(Entry)
+			err = x.Entries[i].frisbeeDecode(d)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Encode encodes the Metadata into the given polyglot.Buffer
+func (x *Metadata) Encode(b *polyglot.Buffer) {
+	if x == nil {
+		polyglot.Encoder(b).Nil()
+		return
+	}
+	polyglot.Encoder(b).String(x.Owner)
+	polyglot.Encoder(b).Int64(x.Expires)
+	polyglot.Encoder(b).Slice(uint32(len(x.Tags)), polyglot.StringKind)
+	for _, v := range x.Tags {
+		polyglot.Encoder(b).String(v)
+	}
+	polyglot.Encoder(b).Bool(x.Pinned)
+}
+
+// Decode decodes the Metadata from the given bytes
+func (x *Metadata) Decode(b []byte) error {
+	d := polyglot.Decoder(b)
+	if d.Nil() {
+		return nil
+	}
+	return x.frisbeeDecode(d)
+}
+
+func (x *Metadata) frisbeeDecode(d *polyglot.BufferDecoder) error {
+	var err error
+	var size uint32
+	x.Owner, err = d.String()
+	if err != nil {
+		return err
+	}
+	x.Expires, err = d.Int64()
+	if err != nil {
+		return err
+	}
+	size, err = d.Slice(polyglot.StringKind)
+	if err != nil {
+		return err
+	}
+	x.Tags = make([]string, size)
+	for i := range x.Tags {
+		x.Tags[i], err = d.String()
+		if err != nil {
+			return err
+		}
+	}
+	x.Pinned, err = d.Bool()
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Encode encodes the PutRequest into the given polyglot.Buffer
+func (x *PutRequest) Encode(b *polyglot.Buffer) {
+	if x == nil {
+		polyglot.Encoder(b).Nil()
+		return
+	}
+	polyglot.Encoder(b).String(x.Key)
+	polyglot.Encoder(b).Bytes(x.Value)
+}
+
+// Decode decodes the PutRequest from the given bytes
+func (x *PutRequest) Decode(b []byte) error {
+	d := polyglot.Decoder(b)
+	if d.Nil() {
+		return nil
+	}
+	return x.frisbeeDecode(d)
+}
+
+func (x *PutRequest) frisbeeDecode(d *polyglot.BufferDecoder) error {
+	var err error
+	x.Key, err = d.String()
+	if err != nil {
+		return err
+	}
+	x.Value, err = d.Bytes(nil)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Encode encodes the PutResponse into the given polyglot.Buffer
+func (x *PutResponse) Encode(b *polyglot.Buffer) {
+	if x == nil {
+		polyglot.Encoder(b).Nil()
+		return
+	}
+	polyglot.Encoder(b).Uint64(x.Version)
+}
+
+// Decode decodes the PutResponse from the given bytes
+func (x *PutResponse) Decode(b []byte) error {
+	d := polyglot.Decoder(b)
+	if d.Nil() {
+		return nil
+	}
+	return x.frisbeeDecode(d)
+}
+
+func (x *PutResponse) frisbeeDecode(d *polyglot.BufferDecoder) error {
+	var err error
+	x.Version, err = d.Uint64()
+	if err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+// SPDX-License-Identifier: Apache-2.0
+
+// Package example is an example of a service whose frisbee code is generated by frisbee-gen.
+package example
+
+import (
+	"context"
+)
+
+//go:generate go run github.com/loopholelabs/frisbee-go/cmd/frisbee-gen -type KeyValue
+
+// KeyValue is a simple key-value store
+type KeyValue interface {
+	// Get returns the values of the given keys
+	Get(context.Context, *GetRequest) (*GetResponse, error)
+
+	// Put sets the value of a key
+	//
+	//frisbee:op 20
+	Put(context.Context, *PutRequest) (*PutResponse, error)
+
+	// Delete removes a key
+	Delete(ctx context.Context, req *DeleteRequest) (*DeleteResponse, error)
+}
+
+type GetRequest struct {
+	Keys []string
+}
+
+type GetResponse struct {
+	Entries []*Entry
+}
+
+type Entry struct {
+	Key     string
+	Value   []byte
+	Version uint64
+	Meta    *Metadata
+}
+
+type Metadata struct {
+	Owner   string
+	Expires int64
+	Tags    []string
+	Pinned  bool
+}
+
+type PutRequest struct {
+	Key   string
+	Value []byte
+}
+
+type PutResponse struct {
+	Version uint64
+}
+
+type DeleteRequest struct {
+	Key string
+}
+
+type DeleteResponse struct {
+	Deleted bool
+}
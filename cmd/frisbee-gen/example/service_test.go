@@ -0,0 +1,107 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package example
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/goleak"
+
+	"github.com/loopholelabs/logging"
+	"github.com/loopholelabs/testing/conn/pair"
+
+	"github.com/loopholelabs/frisbee-go"
+)
+
+func TestMain(m *testing.M) {
+	goleak.VerifyTestMain(m)
+}
+
+type store struct {
+	mu      sync.Mutex
+	entries map[string]*Entry
+}
+
+func (s *store) Get(_ context.Context, req *GetRequest) (*GetResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	resp := new(GetResponse)
+	for _, key := range req.Keys {
+		resp.Entries = append(resp.Entries, s.entries[key])
+	}
+	return resp, nil
+}
+
+func (s *store) Put(_ context.Context, req *PutRequest) (*PutResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.entries[req.Key]
+	if !ok {
+		entry = &Entry{Key: req.Key, Meta: &Metadata{Owner: "test", Tags: []string{"a", "b"}}}
+		s.entries[req.Key] = entry
+	}
+	entry.Value = req.Value
+	entry.Version++
+	return &PutResponse{Version: entry.Version}, nil
+}
+
+func (s *store) Delete(_ context.Context, req *DeleteRequest) (*DeleteResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.entries[req.Key]; !ok {
+		return nil, &frisbee.ErrorFrame{Code: frisbee.ErrorUnknown, Message: "key not found"}
+	}
+	delete(s.entries, req.Key)
+	return &DeleteResponse{Deleted: true}, nil
+}
+
+func TestKeyValue(t *testing.T) {
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+
+	s, err := frisbee.NewServer(NewKeyValueHandlerTable(&store{entries: make(map[string]*Entry)}), context.Background(), frisbee.WithLogger(emptyLogger))
+	require.NoError(t, err)
+	c, err := frisbee.NewClient(frisbee.HandlerTable{}, context.Background(), frisbee.WithLogger(emptyLogger))
+	require.NoError(t, err)
+
+	serverConn, clientConn, err := pair.New()
+	require.NoError(t, err)
+	go s.ServeConn(serverConn)
+	require.NoError(t, c.FromConn(clientConn))
+
+	var kv KeyValue = NewKeyValueClient(c)
+	ctx := context.Background()
+
+	put, err := kv.Put(ctx, &PutRequest{Key: "key", Value: []byte("first")})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), put.Version)
+	put, err = kv.Put(ctx, &PutRequest{Key: "key", Value: []byte("second")})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), put.Version)
+
+	get, err := kv.Get(ctx, &GetRequest{Keys: []string{"key", "missing"}})
+	require.NoError(t, err)
+	require.Len(t, get.Entries, 2)
+	assert.Equal(t, &Entry{
+		Key:     "key",
+		Value:   []byte("second"),
+		Version: 2,
+		Meta:    &Metadata{Owner: "test", Tags: []string{"a", "b"}},
+	}, get.Entries[0])
+	assert.Nil(t, get.Entries[1])
+
+	deleted, err := kv.Delete(ctx, &DeleteRequest{Key: "key"})
+	require.NoError(t, err)
+	assert.True(t, deleted.Deleted)
+
+	var errorFrame *frisbee.ErrorFrame
+	_, err = kv.Delete(ctx, &DeleteRequest{Key: "key"})
+	require.ErrorAs(t, err, &errorFrame)
+	assert.Equal(t, "key not found", errorFrame.Message)
+
+	require.NoError(t, c.Close())
+	require.NoError(t, s.Shutdown())
+}
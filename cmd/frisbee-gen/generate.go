@@ -0,0 +1,171 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"text/template"
+)
+
+// generatedHeader marks files generated by frisbee-gen, which are skipped when parsing a package
+const generatedHeader = "// Code generated by frisbee-gen. DO NOT EDIT."
+
+var generatedTemplate = template.Must(template.New("generated").Parse(`{{ "" }}// Code generated by frisbee-gen. DO NOT EDIT.
+
+package {{ .Package }}
+
+import (
+	"context"
+
+	{{ if .Messages }}"github.com/loopholelabs/polyglot/v2"
+
+	{{ end }}"github.com/loopholelabs/frisbee-go"
+)
+
+{{ range $service := .Services }}
+// These are the frisbee operations used by the {{ .Name }} methods:
+const (
+{{- range .Methods }}
+	{{ $service.Name }}{{ .Name }}Operation uint16 = {{ .Operation }}
+{{- end }}
+)
+
+// New{{ .Name }}HandlerTable returns a frisbee.HandlerTable that calls the methods of the given {{ .Name }}
+func New{{ .Name }}HandlerTable(service {{ .Name }}) frisbee.HandlerTable {
+	return frisbee.HandlerTable{
+{{- range .Methods }}
+		{{ $service.Name }}{{ .Name }}Operation: frisbee.TypedHandler[{{ .Request }}, {{ .Response }}](service.{{ .Name }}),
+{{- end }}
+	}
+}
+
+// {{ .Name }}Client implements {{ .Name }} by calling its methods on a frisbee Server
+type {{ .Name }}Client struct {
+	client *frisbee.Client
+}
+
+var _ {{ .Name }} = (*{{ .Name }}Client)(nil)
+
+// New{{ .Name }}Client returns a {{ .Name }}Client that uses the given frisbee.Client, which must already be connected
+func New{{ .Name }}Client(client *frisbee.Client) *{{ .Name }}Client {
+	return &{{ .Name }}Client{client: client}
+}
+{{ range .Methods }}
+// {{ .Name }} calls the {{ .Name }} method on the server
+func (c *{{ $service.Name }}Client) {{ .Name }}(ctx context.Context, req *{{ .Request }}) (*{{ .Response }}, error) {
+	return frisbee.CallTyped[{{ .Request }}, {{ .Response }}](ctx, c.client, {{ $service.Name }}{{ .Name }}Operation, req)
+}
+{{ end }}
+{{ end }}
+
+{{- range .Messages }}
+// Encode encodes the {{ .Name }} into the given polyglot.Buffer
+func (x *{{ .Name }}) Encode(b *polyglot.Buffer) {
+	if x == nil {
+		polyglot.Encoder(b).Nil()
+		return
+	}
+{{- range .Fields }}
+{{- if eq .Kind 0 }}
+	polyglot.Encoder(b).{{ .Method }}(x.{{ .Name }})
+{{- else if eq .Kind 1 }}
+	polyglot.Encoder(b).Bytes(x.{{ .Name }})
+{{- else if eq .Kind 2 }}
+	polyglot.Encoder(b).Slice(uint32(len(x.{{ .Name }})), polyglot.{{ .Method }}Kind)
+	for _, v := range x.{{ .Name }} {
+		polyglot.Encoder(b).{{ .Method }}(v)
+	}
+{{- else if eq .Kind 3 }}
+	x.{{ .Name }}.Encode(b)
+{{- else }}
+	polyglot.Encoder(b).Slice(uint32(len(x.{{ .Name }})), polyglot.AnyKind)
+	for _, v := range x.{{ .Name }} {
+		v.Encode(b)
+	}
+{{- end }}
+{{- end }}
+}
+
+// Decode decodes the {{ .Name }} from the given bytes
+func (x *{{ .Name }}) Decode(b []byte) error {
+	d := polyglot.Decoder(b)
+	if d.Nil() {
+		return nil
+	}
+	return x.frisbeeDecode(d)
+}
+
+func (x *{{ .Name }}) frisbeeDecode(d *polyglot.BufferDecoder) error {
+{{- if .Fields }}
+	var err error
+{{- end }}
+{{- if .HasSlices }}
+	var size uint32
+{{- end }}
+{{- range .Fields }}
+{{- if eq .Kind 0 }}
+	x.{{ .Name }}, err = d.{{ .Method }}()
+	if err != nil {
+		return err
+	}
+{{- else if eq .Kind 1 }}
+	x.{{ .Name }}, err = d.Bytes(nil)
+	if err != nil {
+		return err
+	}
+{{- else if eq .Kind 2 }}
+	size, err = d.Slice(polyglot.{{ .Method }}Kind)
+	if err != nil {
+		return err
+	}
+	x.{{ .Name }} = make([]{{ .Type }}, size)
+	for i := range x.{{ .Name }} {
+		x.{{ .Name }}[i], err = d.{{ .Method }}()
+		if err != nil {
+			return err
+		}
+	}
+{{- else if eq .Kind 3 }}
+	x.{{ .Name }} = nil
+	if !d.Nil() {
+		x.{{ .Name }} = new({{ .Type }})
+		err = x.{{ .Name }}.frisbeeDecode(d)
+		if err != nil {
+			return err
+		}
+	}
+{{- else }}
+	size, err = d.Slice(polyglot.AnyKind)
+	if err != nil {
+		return err
+	}
+	x.{{ .Name }} = make([]*{{ .Type }}, size)
+	for i := range x.{{ .Name }} {
+		if !d.Nil() {
+			x.{{ .Name }}[i] = new({{ .Type }})
+			err = x.{{ .Name }}[i].frisbeeDecode(d)
+			if err != nil {
+				return err
+			}
+		}
+	}
+{{- end }}
+{{- end }}
+	return nil
+}
+{{ end }}`))
+
+// generate returns the formatted source code for the model
+func generate(m *model) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := generatedTemplate.Execute(&buf, m); err != nil {
+		return nil, err
+	}
+	source, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("error while formatting generated code: %w\n%s", err, buf.Bytes())
+	}
+	return source, nil
+}
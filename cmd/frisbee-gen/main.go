@@ -0,0 +1,105 @@
+// SPDX-License-Identifier: Apache-2.0
+
+// Command frisbee-gen generates frisbee code for the service interfaces in a Go package.
+//
+// A service interface has methods with the signature
+//
+//	Method(context.Context, *Request) (*Response, error)
+//
+// where Request and Response are structs declared in the same package. For each service, frisbee-gen generates an
+// operation constant for every method (numbered from -base, in the order the methods are declared), a function that
+// builds a frisbee.HandlerTable from an implementation of the service, and a client that implements the service by
+// calling the methods on a frisbee server. It also generates polyglot Encode and Decode methods for every message
+// struct that does not already have them.
+//
+// To keep the operation of a method from changing when methods are added or reordered, it can be pinned with a
+// comment on the method:
+//
+//	//frisbee:op 42
+//
+// The fields of message structs can be strings, bools, fixed-size integers, floats, byte slices, slices of those,
+// pointers to other message structs, and slices of pointers to other message structs.
+//
+// Usage:
+//
+//	frisbee-gen -type <Service>[,<Service>...] [flags] [directory]
+//
+// It is usually run with go:generate, in which case the directory defaults to the current one:
+//
+//	//go:generate go run github.com/loopholelabs/frisbee-go/cmd/frisbee-gen -type EchoService
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	errUsage = errors.New("usage")
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stderr))
+}
+
+func run(args []string, stderr io.Writer) int {
+	err := gen(args, stderr)
+	if err != nil {
+		if err == errUsage {
+			return 2
+		}
+		_, _ = fmt.Fprintf(stderr, "frisbee-gen: %s\n", err)
+		return 1
+	}
+	return 0
+}
+
+func gen(args []string, stderr io.Writer) error {
+	flags := flag.NewFlagSet("frisbee-gen", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	types := flags.String("type", "", "comma-separated list of service interfaces to generate code for")
+	output := flags.String("output", "", "output file name (default <directory>/<first type in lower case>_frisbee.go)")
+	base := flags.Uint("base", 10, "operation of the first method that does not have a //frisbee:op comment")
+	flags.Usage = func() {
+		_, _ = fmt.Fprintf(stderr, "usage: frisbee-gen -type <Service>[,<Service>...] [flags] [directory]\n\n")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return errUsage
+	}
+	if *types == "" || flags.NArg() > 1 {
+		flags.Usage()
+		return errUsage
+	}
+	if *base < 10 || *base > 0xFFFF {
+		return errInvalidOp
+	}
+
+	dir := "."
+	if flags.NArg() == 1 {
+		dir = flags.Arg(0)
+	}
+	names := strings.Split(*types, ",")
+	if *output == "" {
+		*output = filepath.Join(dir, strings.ToLower(names[0])+"_frisbee.go")
+	}
+
+	p, err := parseDir(dir)
+	if err != nil {
+		return err
+	}
+	m, err := p.build(names, uint16(*base))
+	if err != nil {
+		return err
+	}
+	source, err := generate(m)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(*output, source, 0644)
+}
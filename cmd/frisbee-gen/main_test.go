@@ -0,0 +1,146 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateExample(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "keyvalue_frisbee.go")
+	var stderr bytes.Buffer
+	require.Equal(t, 0, run([]string{"-type", "KeyValue", "-output", output, "example"}, &stderr), stderr.String())
+
+	expected, err := os.ReadFile(filepath.Join("example", "keyvalue_frisbee.go"))
+	require.NoError(t, err)
+	actual, err := os.ReadFile(output)
+	require.NoError(t, err)
+	assert.Equal(t, string(expected), string(actual), "the example is out of date, run go generate ./cmd/frisbee-gen/example")
+}
+
+func TestRunUsage(t *testing.T) {
+	var stderr bytes.Buffer
+	assert.Equal(t, 2, run(nil, &stderr))
+	assert.Contains(t, stderr.String(), "usage: frisbee-gen")
+
+	stderr.Reset()
+	assert.Equal(t, 2, run([]string{"-type", "A", "a", "b"}, &stderr))
+
+	stderr.Reset()
+	assert.Equal(t, 1, run([]string{"-type", "A", "-base", "9", "example"}, &stderr))
+	assert.Contains(t, stderr.String(), errInvalidOp.Error())
+}
+
+func TestGenerateErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		err    error
+	}{
+		{
+			name:   "missing service",
+			source: "type Other interface{}",
+			err:    errServiceNotFound,
+		},
+		{
+			name:   "not an interface",
+			source: "type Service struct{}",
+			err:    errServiceNotFound,
+		},
+		{
+			name:   "invalid signature",
+			source: "type Service interface { Do(*Request) (*Response, error) }\ntype Request struct{}\ntype Response struct{}",
+			err:    errInvalidMethod,
+		},
+		{
+			name:   "reserved operation",
+			source: "type Service interface {\n//frisbee:op 4\nDo(context.Context, *Request) (*Response, error) }\ntype Request struct{}\ntype Response struct{}",
+			err:    errInvalidOp,
+		},
+		{
+			name:   "duplicate operation",
+			source: "type Service interface {\n//frisbee:op 12\nA(context.Context, *Request) (*Response, error)\n//frisbee:op 12\nB(context.Context, *Request) (*Response, error) }\ntype Request struct{}\ntype Response struct{}",
+			err:    errDuplicateOp,
+		},
+		{
+			name:   "missing message",
+			source: "type Service interface { Do(context.Context, *Request) (*Response, error) }\ntype Request struct{}",
+			err:    errMessageNotFound,
+		},
+		{
+			name:   "unsupported field",
+			source: "type Service interface { Do(context.Context, *Request) (*Response, error) }\ntype Request struct{ Values map[string]string }\ntype Response struct{}",
+			err:    errUnsupportedType,
+		},
+		{
+			name:   "unsized integer",
+			source: "type Service interface { Do(context.Context, *Request) (*Response, error) }\ntype Request struct{ Value int }\ntype Response struct{}",
+			err:    errUnsupportedType,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			source := "package service\n\nimport \"context\"\n\nvar _ context.Context\n\n" + test.source + "\n"
+			require.NoError(t, os.WriteFile(filepath.Join(dir, "service.go"), []byte(source), 0644))
+			p, err := parseDir(dir)
+			require.NoError(t, err)
+			_, err = p.build([]string{"Service"}, 10)
+			assert.ErrorIs(t, err, test.err)
+		})
+	}
+}
+
+func TestGenerateOperations(t *testing.T) {
+	dir := t.TempDir()
+	source := `package service
+
+import "context"
+
+type First interface {
+	A(context.Context, *Message) (*Message, error)
+	//frisbee:op 11
+	B(context.Context, *Message) (*Message, error)
+	C(context.Context, *Message) (*Message, error)
+}
+
+type Second interface {
+	D(context.Context, *Message) (*Message, error)
+}
+
+type Message struct {
+	Value string
+}
+`
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "service.go"), []byte(source), 0644))
+	p, err := parseDir(dir)
+	require.NoError(t, err)
+	m, err := p.build([]string{"First", "Second"}, 10)
+	require.NoError(t, err)
+
+	operations := make(map[string]uint16)
+	for _, s := range m.Services {
+		for _, method := range s.Methods {
+			operations[method.Name] = method.Operation
+		}
+	}
+	assert.Equal(t, map[string]uint16{"A": 10, "B": 11, "C": 12, "D": 13}, operations)
+	require.Len(t, m.Messages, 1)
+	assert.Equal(t, "Message", m.Messages[0].Name)
+
+	// Generated files are skipped when parsing, so running frisbee-gen again gives the same result
+	var stderr bytes.Buffer
+	require.Equal(t, 0, run([]string{"-type", "First,Second", dir}, &stderr), stderr.String())
+	first, err := os.ReadFile(filepath.Join(dir, "first_frisbee.go"))
+	require.NoError(t, err)
+	require.Equal(t, 0, run([]string{"-type", "First,Second", dir}, &stderr), stderr.String())
+	second, err := os.ReadFile(filepath.Join(dir, "first_frisbee.go"))
+	require.NoError(t, err)
+	assert.Equal(t, first, second)
+}
@@ -0,0 +1,414 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// opDirective pins the operation of a method, so that adding or reordering methods does not change it
+const opDirective = "//frisbee:op "
+
+var (
+	errServiceNotFound = errors.New("service interface not found")
+	errInvalidMethod   = errors.New("methods must have the signature func(context.Context, *Request) (*Response, error)")
+	errInvalidOp       = errors.New("operations must be between 10 and 65535")
+	errDuplicateOp     = errors.New("operation is used by more than one method")
+	errUnsupportedType = errors.New("unsupported field type")
+	errMessageNotFound = errors.New("message struct not found")
+)
+
+// scalars maps the Go types that polyglot can encode directly to the name of their polyglot encoder and decoder methods
+var scalars = map[string]string{
+	"string":  "String",
+	"bool":    "Bool",
+	"byte":    "Uint8",
+	"uint8":   "Uint8",
+	"uint16":  "Uint16",
+	"uint32":  "Uint32",
+	"uint64":  "Uint64",
+	"int32":   "Int32",
+	"int64":   "Int64",
+	"float32": "Float32",
+	"float64": "Float64",
+}
+
+// fieldKind describes how a message field is encoded
+type fieldKind int
+
+const (
+	scalarField fieldKind = iota
+	bytesField
+	scalarSliceField
+	messageField
+	messageSliceField
+)
+
+type field struct {
+	Name string
+	Kind fieldKind
+
+	// Type is the Go type of a scalar or scalar slice element, or the name of the message struct
+	Type string
+
+	// Method is the name of the polyglot encoder and decoder method for scalars
+	Method string
+}
+
+type message struct {
+	Name   string
+	Fields []field
+}
+
+// HasSlices returns true if the message has any slice fields
+func (m message) HasSlices() bool {
+	for _, f := range m.Fields {
+		if f.Kind == scalarSliceField || f.Kind == messageSliceField {
+			return true
+		}
+	}
+	return false
+}
+
+type method struct {
+	Name      string
+	Operation uint16
+	Request   string
+	Response  string
+}
+
+type service struct {
+	Name    string
+	Methods []method
+}
+
+// model is everything that frisbee-gen generates code for
+type model struct {
+	Package  string
+	Services []service
+	Messages []message
+}
+
+// pkg is a parsed Go package directory
+type pkg struct {
+	name    string
+	fset    *token.FileSet
+	types   map[string]*ast.TypeSpec
+	methods map[string]map[string]bool
+}
+
+// parseDir parses every Go file in the directory, apart from test files and files generated by frisbee-gen
+func parseDir(dir string) (*pkg, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		return nil, err
+	}
+	p := &pkg{
+		fset:    token.NewFileSet(),
+		types:   make(map[string]*ast.TypeSpec),
+		methods: make(map[string]map[string]bool),
+	}
+	for _, match := range matches {
+		if strings.HasSuffix(match, "_test.go") {
+			continue
+		}
+		source, err := os.ReadFile(match)
+		if err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(string(source), generatedHeader) {
+			continue
+		}
+		file, err := parser.ParseFile(p.fset, match, source, parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+		if p.name == "" {
+			p.name = file.Name.Name
+		}
+		for _, decl := range file.Decls {
+			switch decl := decl.(type) {
+			case *ast.GenDecl:
+				for _, spec := range decl.Specs {
+					if spec, ok := spec.(*ast.TypeSpec); ok {
+						p.types[spec.Name.Name] = spec
+					}
+				}
+			case *ast.FuncDecl:
+				if decl.Recv == nil || len(decl.Recv.List) != 1 {
+					continue
+				}
+				receiver := decl.Recv.List[0].Type
+				if star, ok := receiver.(*ast.StarExpr); ok {
+					receiver = star.X
+				}
+				if ident, ok := receiver.(*ast.Ident); ok {
+					if p.methods[ident.Name] == nil {
+						p.methods[ident.Name] = make(map[string]bool)
+					}
+					p.methods[ident.Name][decl.Name.Name] = true
+				}
+			}
+		}
+	}
+	if p.name == "" {
+		return nil, fmt.Errorf("no Go files found in %s", dir)
+	}
+	return p, nil
+}
+
+// build creates the model for the given service interfaces, assigning operations starting at base
+func (p *pkg) build(names []string, base uint16) (*model, error) {
+	m := &model{Package: p.name}
+	used := make(map[uint16]string)
+	var unassigned []*method
+	messages := make(map[string]bool)
+	for _, name := range names {
+		spec, ok := p.types[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", errServiceNotFound, name)
+		}
+		iface, ok := spec.Type.(*ast.InterfaceType)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s is not an interface", errServiceNotFound, name)
+		}
+		s := service{Name: name}
+		for _, f := range iface.Methods.List {
+			fn, ok := f.Type.(*ast.FuncType)
+			if !ok || len(f.Names) != 1 {
+				return nil, fmt.Errorf("%s: %w", p.fset.Position(f.Pos()), errInvalidMethod)
+			}
+			request, response, ok := signature(fn)
+			if !ok {
+				return nil, fmt.Errorf("%s: %s.%s: %w", p.fset.Position(f.Pos()), name, f.Names[0].Name, errInvalidMethod)
+			}
+			s.Methods = append(s.Methods, method{Name: f.Names[0].Name, Request: request, Response: response})
+			operation, pinned, err := directive(f.Doc)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s.%s: %w", p.fset.Position(f.Pos()), name, f.Names[0].Name, err)
+			}
+			if pinned {
+				if other, ok := used[operation]; ok {
+					return nil, fmt.Errorf("%w: %d (%s and %s.%s)", errDuplicateOp, operation, other, name, f.Names[0].Name)
+				}
+				used[operation] = name + "." + f.Names[0].Name
+				s.Methods[len(s.Methods)-1].Operation = operation
+			}
+			messages[request] = true
+			messages[response] = true
+		}
+		m.Services = append(m.Services, s)
+	}
+
+	for i := range m.Services {
+		for j := range m.Services[i].Methods {
+			if m.Services[i].Methods[j].Operation == 0 {
+				unassigned = append(unassigned, &m.Services[i].Methods[j])
+			}
+		}
+	}
+	next := uint32(base)
+	for _, method := range unassigned {
+		for next <= 0xFFFF {
+			if _, ok := used[uint16(next)]; !ok {
+				break
+			}
+			next++
+		}
+		if next > 0xFFFF {
+			return nil, errInvalidOp
+		}
+		method.Operation = uint16(next)
+		used[uint16(next)] = method.Name
+		next++
+	}
+
+	// Requests and responses that already have Encode and Decode methods (for example, because they were generated
+	// by polyglot) are used as they are, but nested messages must be generated so that they can share a decoder
+	var pending []string
+	for name := range messages {
+		if !p.encodes(name) {
+			pending = append(pending, name)
+		}
+	}
+	generated := make(map[string]bool)
+	for len(pending) > 0 {
+		name := pending[0]
+		pending = pending[1:]
+		if generated[name] {
+			continue
+		}
+		generated[name] = true
+		msg, nested, err := p.message(name)
+		if err != nil {
+			return nil, err
+		}
+		for _, n := range nested {
+			if p.encodes(n) {
+				return nil, fmt.Errorf("%w: %s is nested in %s but already has Encode and Decode methods", errUnsupportedType, n, name)
+			}
+		}
+		m.Messages = append(m.Messages, msg)
+		pending = append(pending, nested...)
+	}
+	sort.Slice(m.Messages, func(i, j int) bool {
+		return m.Messages[i].Name < m.Messages[j].Name
+	})
+	return m, nil
+}
+
+// encodes returns true if the named type already has Encode or Decode methods
+func (p *pkg) encodes(name string) bool {
+	return p.methods[name]["Encode"] || p.methods[name]["Decode"]
+}
+
+// message creates the message for the named struct, and returns the names of the messages nested in it
+func (p *pkg) message(name string) (message, []string, error) {
+	spec, ok := p.types[name]
+	if !ok {
+		return message{}, nil, fmt.Errorf("%w: %s", errMessageNotFound, name)
+	}
+	st, ok := spec.Type.(*ast.StructType)
+	if !ok {
+		return message{}, nil, fmt.Errorf("%w: %s is not a struct", errMessageNotFound, name)
+	}
+	m := message{Name: name}
+	var nested []string
+	for _, f := range st.Fields.List {
+		if len(f.Names) == 0 {
+			return message{}, nil, fmt.Errorf("%s: %w: embedded fields are not supported", p.fset.Position(f.Pos()), errUnsupportedType)
+		}
+		kind, typ, ok := fieldType(f.Type)
+		if !ok {
+			return message{}, nil, fmt.Errorf("%s: %w: %s", p.fset.Position(f.Pos()), errUnsupportedType, typeString(f.Type))
+		}
+		if kind == messageField || kind == messageSliceField {
+			nested = append(nested, typ)
+		}
+		for _, n := range f.Names {
+			if n.Name == "_" {
+				continue
+			}
+			m.Fields = append(m.Fields, field{Name: n.Name, Kind: kind, Type: typ, Method: scalars[typ]})
+		}
+	}
+	return m, nested, nil
+}
+
+// signature returns the request and response message names of a method with the signature
+// func(context.Context, *Request) (*Response, error)
+func signature(fn *ast.FuncType) (string, string, bool) {
+	if fn.Params == nil || fn.Results == nil || len(fn.Params.List) != 2 || len(fn.Results.List) != 2 {
+		return "", "", false
+	}
+	for _, list := range []*ast.FieldList{fn.Params, fn.Results} {
+		for _, f := range list.List {
+			if len(f.Names) > 1 {
+				return "", "", false
+			}
+		}
+	}
+	if typeString(fn.Params.List[0].Type) != "context.Context" || typeString(fn.Results.List[1].Type) != "error" {
+		return "", "", false
+	}
+	request, ok := messagePointer(fn.Params.List[1].Type)
+	if !ok {
+		return "", "", false
+	}
+	response, ok := messagePointer(fn.Results.List[0].Type)
+	if !ok {
+		return "", "", false
+	}
+	return request, response, true
+}
+
+// directive returns the operation pinned by a //frisbee:op comment, if there is one
+func directive(doc *ast.CommentGroup) (uint16, bool, error) {
+	if doc == nil {
+		return 0, false, nil
+	}
+	for _, c := range doc.List {
+		if !strings.HasPrefix(c.Text, opDirective) {
+			continue
+		}
+		operation, err := strconv.ParseUint(strings.TrimSpace(strings.TrimPrefix(c.Text, opDirective)), 10, 16)
+		if err != nil || operation < 10 {
+			return 0, false, errInvalidOp
+		}
+		return uint16(operation), true, nil
+	}
+	return 0, false, nil
+}
+
+// fieldType returns how a field with the given type is encoded
+func fieldType(expr ast.Expr) (fieldKind, string, bool) {
+	switch expr := expr.(type) {
+	case *ast.Ident:
+		if _, ok := scalars[expr.Name]; ok {
+			return scalarField, expr.Name, true
+		}
+	case *ast.StarExpr:
+		if name, ok := messagePointer(expr); ok {
+			return messageField, name, true
+		}
+	case *ast.ArrayType:
+		if expr.Len != nil {
+			return 0, "", false
+		}
+		if elt, ok := expr.Elt.(*ast.Ident); ok {
+			if elt.Name == "byte" || elt.Name == "uint8" {
+				return bytesField, "", true
+			}
+			if _, ok := scalars[elt.Name]; ok {
+				return scalarSliceField, elt.Name, true
+			}
+		}
+		if name, ok := messagePointer(expr.Elt); ok {
+			return messageSliceField, name, true
+		}
+	}
+	return 0, "", false
+}
+
+// messagePointer returns the name of the struct that the expression points to, if it is a pointer to a local type
+func messagePointer(expr ast.Expr) (string, bool) {
+	star, ok := expr.(*ast.StarExpr)
+	if !ok {
+		return "", false
+	}
+	ident, ok := star.X.(*ast.Ident)
+	if !ok || scalars[ident.Name] != "" {
+		return "", false
+	}
+	return ident.Name, true
+}
+
+// typeString returns a short description of a type expression for error messages
+func typeString(expr ast.Expr) string {
+	switch expr := expr.(type) {
+	case *ast.Ident:
+		return expr.Name
+	case *ast.SelectorExpr:
+		return typeString(expr.X) + "." + expr.Sel.Name
+	case *ast.StarExpr:
+		return "*" + typeString(expr.X)
+	case *ast.ArrayType:
+		if expr.Len != nil {
+			return "[...]" + typeString(expr.Elt)
+		}
+		return "[]" + typeString(expr.Elt)
+	case *ast.MapType:
+		return "map[" + typeString(expr.Key) + "]" + typeString(expr.Value)
+	default:
+		return fmt.Sprintf("%T", expr)
+	}
+}
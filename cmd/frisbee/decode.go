@@ -0,0 +1,67 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/loopholelabs/frisbee-go/pkg/capture"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+func decode(args []string, stdout io.Writer, stderr io.Writer) error {
+	flags := flag.NewFlagSet("decode", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		_, _ = fmt.Fprintf(flags.Output(), "Usage: frisbee decode [flags] <capture file>\n\nDecode a packet capture file into human-readable text.\n\n")
+		flags.PrintDefaults()
+	}
+
+	format := flags.String("format", formatHex, "format used to print packet content (hex, text, or none)")
+	operation := flags.Int("op", -1, "only print packets with this operation")
+	connection := flags.Uint64("conn", 0, "only print packets from this connection ID")
+	control := flags.Bool("control", false, "also print PING and PONG packets")
+
+	path, err := parse(flags, args, "capture file")
+	if err != nil {
+		return err
+	}
+	if err = validFormat(*format); err != nil {
+		return err
+	}
+
+	r, err := capture.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = r.Close()
+	}()
+
+	return decodeCapture(r, stdout, *format, *operation, *connection, *control)
+}
+
+// decodeCapture prints every record in the capture that matches the given filters
+func decodeCapture(r *capture.Reader, w io.Writer, format string, operation int, connection uint64, control bool) error {
+	for {
+		record, err := r.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		p := record.Packet
+		if (operation < 0 || int(p.Metadata.Operation) == operation) &&
+			(connection == 0 || record.Connection == connection) &&
+			(control || p.Metadata.Operation > 1) {
+			prefix := fmt.Sprintf("%s %-5s conn=%d ", record.Timestamp.UTC().Format(time.RFC3339Nano), record.Direction, record.Connection)
+			printPacket(w, prefix, p, format)
+		}
+		packet.Put(p)
+	}
+}
@@ -0,0 +1,69 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+var (
+	errUsage = errors.New("usage")
+
+	errMultipleInputs = errors.New("only one of -hex, -file, and -stdin can be used")
+)
+
+// contentFlags are the flags used to provide the content of a packet
+type contentFlags struct {
+	hex   string
+	file  string
+	stdin bool
+}
+
+func (c *contentFlags) register(flags *flag.FlagSet) {
+	flags.StringVar(&c.hex, "hex", "", "packet content as a hex string")
+	flags.StringVar(&c.file, "file", "", "read the packet content from `file`")
+	flags.BoolVar(&c.stdin, "stdin", false, "read the packet content from stdin")
+}
+
+// content returns the packet content described by the flags
+func (c *contentFlags) content(stdin io.Reader) ([]byte, error) {
+	inputs := 0
+	for _, set := range []bool{c.hex != "", c.file != "", c.stdin} {
+		if set {
+			inputs++
+		}
+	}
+	if inputs > 1 {
+		return nil, errMultipleInputs
+	}
+
+	switch {
+	case c.hex != "":
+		return hex.DecodeString(strings.Join(strings.Fields(c.hex), ""))
+	case c.file != "":
+		return os.ReadFile(c.file)
+	case c.stdin:
+		return io.ReadAll(stdin)
+	default:
+		return nil, nil
+	}
+}
+
+// parse parses the flags and returns the single positional argument
+func parse(flags *flag.FlagSet, args []string, argument string) (string, error) {
+	if err := flags.Parse(args); err != nil {
+		return "", errUsage
+	}
+	if flags.NArg() != 1 {
+		_, _ = fmt.Fprintf(flags.Output(), "expected exactly one %s\n", argument)
+		flags.Usage()
+		return "", errUsage
+	}
+	return flags.Arg(0), nil
+}
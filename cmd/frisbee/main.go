@@ -0,0 +1,68 @@
+// SPDX-License-Identifier: Apache-2.0
+
+// Command frisbee is a tool for debugging frisbee servers. It can send packets to a server and
+// print the responses, pipe stdin and stdout through a stream, and decode packet capture files.
+//
+// Usage:
+//
+//	frisbee send [flags] <address>
+//	frisbee stream [flags] <address>
+//	frisbee decode [flags] <capture file>
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+const usage = `frisbee is a tool for debugging frisbee servers.
+
+Usage:
+
+	frisbee <command> [flags] <argument>
+
+The commands are:
+
+	send      send a packet to a server and print the responses
+	stream    open a stream to a server and pipe stdin and stdout through it
+	decode    decode a packet capture file into human-readable text
+
+Use "frisbee <command> -h" for more information about a command.
+`
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	if len(args) == 0 {
+		_, _ = fmt.Fprint(stderr, usage)
+		return 2
+	}
+
+	var err error
+	switch args[0] {
+	case "send":
+		err = send(args[1:], stdin, stdout, stderr)
+	case "stream":
+		err = stream(args[1:], stdin, stdout, stderr)
+	case "decode":
+		err = decode(args[1:], stdout, stderr)
+	case "help", "-h", "-help", "--help":
+		_, _ = fmt.Fprint(stdout, usage)
+		return 0
+	default:
+		_, _ = fmt.Fprintf(stderr, "frisbee: unknown command %q\n\n%s", args[0], usage)
+		return 2
+	}
+
+	if err != nil {
+		if err == errUsage {
+			return 2
+		}
+		_, _ = fmt.Fprintf(stderr, "frisbee %s: %s\n", args[0], err)
+		return 1
+	}
+	return 0
+}
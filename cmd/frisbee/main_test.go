@@ -0,0 +1,164 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/goleak"
+
+	"github.com/loopholelabs/frisbee-go"
+	"github.com/loopholelabs/frisbee-go/pkg/capture"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+func TestMain(m *testing.M) {
+	goleak.VerifyTestMain(m)
+}
+
+func TestRunUsage(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	assert.Equal(t, 2, run(nil, nil, &stdout, &stderr))
+	assert.Contains(t, stderr.String(), "The commands are")
+
+	stderr.Reset()
+	assert.Equal(t, 2, run([]string{"unknown"}, nil, &stdout, &stderr))
+	assert.Contains(t, stderr.String(), `unknown command "unknown"`)
+
+	stderr.Reset()
+	assert.Equal(t, 2, run([]string{"send"}, nil, &stdout, &stderr))
+	assert.Contains(t, stderr.String(), "expected exactly one address")
+
+	stderr.Reset()
+	assert.Equal(t, 1, run([]string{"send", "-op", "1", "127.0.0.1:0"}, nil, &stdout, &stderr))
+	assert.Contains(t, stderr.String(), errInvalidOperation.Error())
+
+	stdout.Reset()
+	assert.Equal(t, 0, run([]string{"help"}, nil, &stdout, &stderr))
+	assert.Contains(t, stdout.String(), "The commands are")
+}
+
+func TestContentFlags(t *testing.T) {
+	c := contentFlags{hex: "de ad be ef"}
+	data, err := c.content(nil)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0xde, 0xad, 0xbe, 0xef}, data)
+
+	c = contentFlags{stdin: true}
+	data, err = c.content(strings.NewReader("hello"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("hello"), data)
+
+	c = contentFlags{hex: "00", stdin: true}
+	_, err = c.content(strings.NewReader("hello"))
+	assert.ErrorIs(t, err, errMultipleInputs)
+
+	c = contentFlags{}
+	data, err = c.content(nil)
+	require.NoError(t, err)
+	assert.Empty(t, data)
+}
+
+func TestSend(t *testing.T) {
+	handlerTable := make(frisbee.HandlerTable)
+	handlerTable[10] = func(_ context.Context, incoming *packet.Packet) (*packet.Packet, frisbee.Action) {
+		incoming.Metadata.Operation = 11
+		return incoming, frisbee.NONE
+	}
+
+	s, err := frisbee.NewServer(handlerTable, context.Background())
+	require.NoError(t, err)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		_ = s.StartWithListener(listener)
+	}()
+
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"send", "-op", "10", "-id", "7", "-format", "text", "-stdin", listener.Addr().String()}, strings.NewReader("hello"), &stdout, &stderr)
+	assert.Equal(t, 0, code, stderr.String())
+	assert.Equal(t, "id=7 op=11 len=5\n\"hello\"\n", stdout.String())
+
+	stdout.Reset()
+	code = run([]string{"send", "-op", "12", "-timeout", "50ms", listener.Addr().String()}, nil, &stdout, &stderr)
+	assert.Equal(t, 1, code)
+	assert.Contains(t, stderr.String(), errTimeout.Error())
+
+	require.NoError(t, s.Shutdown())
+}
+
+func TestDecode(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.fbcap")
+	w, err := capture.Create(path)
+	require.NoError(t, err)
+
+	timestamp := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	p := packet.Get()
+	p.Metadata.Id = 1
+	p.Metadata.Operation = 10
+	p.Content.Write([]byte("hi"))
+	p.Metadata.ContentLength = 2
+	require.NoError(t, w.WritePacket(timestamp, capture.DirectionRead, 3, p))
+
+	p.Metadata.Operation = frisbee.PING
+	p.Content.Reset()
+	p.Metadata.ContentLength = 0
+	require.NoError(t, w.WritePacket(timestamp, capture.DirectionWrite, 3, p))
+	packet.Put(p)
+	require.NoError(t, w.Close())
+
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"decode", "-format", "text", path}, nil, &stdout, &stderr)
+	assert.Equal(t, 0, code, stderr.String())
+	assert.Equal(t, "2024-01-02T03:04:05Z read  conn=3 id=1 op=10 len=2\n\"hi\"\n", stdout.String())
+
+	stdout.Reset()
+	code = run([]string{"decode", "-control", "-format", "none", path}, nil, &stdout, &stderr)
+	assert.Equal(t, 0, code, stderr.String())
+	assert.Equal(t, "2024-01-02T03:04:05Z read  conn=3 id=1 op=10 len=2\n2024-01-02T03:04:05Z write conn=3 id=1 op=PING len=0\n", stdout.String())
+}
+
+func TestStream(t *testing.T) {
+	s, err := frisbee.NewServer(make(frisbee.HandlerTable), context.Background())
+	require.NoError(t, err)
+
+	require.NoError(t, s.SetStreamHandler(func(_ context.Context, stream *frisbee.Stream) {
+		for {
+			p, err := stream.ReadPacket()
+			if err != nil {
+				return
+			}
+			p.Content.Write([]byte("!"))
+			p.Metadata.ContentLength++
+			done := strings.HasSuffix(string(p.Content.Bytes()), "done!")
+			_ = stream.WritePacket(p)
+			packet.Put(p)
+			if done {
+				_ = stream.Close()
+				return
+			}
+		}
+	}))
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		_ = s.StartWithListener(listener)
+	}()
+
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"stream", "-id", "3", listener.Addr().String()}, strings.NewReader("done"), &stdout, &stderr)
+	assert.Equal(t, 0, code, stderr.String())
+	assert.Equal(t, "done!", stdout.String())
+
+	require.NoError(t, s.Shutdown())
+}
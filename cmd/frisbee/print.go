@@ -0,0 +1,99 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/loopholelabs/frisbee-go"
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+var (
+	errInvalidFormat = errors.New("invalid content format, must be one of hex, text, or none")
+)
+
+// These are the formats that can be used to print packet content:
+const (
+	formatHex  = "hex"
+	formatText = "text"
+	formatNone = "none"
+)
+
+func validFormat(format string) error {
+	switch format {
+	case formatHex, formatText, formatNone:
+		return nil
+	default:
+		return errInvalidFormat
+	}
+}
+
+// operationName returns the name of reserved operations, and the operation number for all others
+func operationName(operation uint16) string {
+	switch operation {
+	case frisbee.PING:
+		return "PING"
+	case frisbee.PONG:
+		return "PONG"
+	case frisbee.STREAM:
+		return "STREAM"
+	case frisbee.EXTENSION:
+		return "EXTENSION"
+	case frisbee.ERROR:
+		return "ERROR"
+	case frisbee.NEGOTIATE:
+		return "NEGOTIATE"
+	case frisbee.SESSION:
+		return "SESSION"
+	case frisbee.ACK:
+		return "ACK"
+	default:
+		return fmt.Sprintf("%d", operation)
+	}
+}
+
+// describe returns a single-line description of the packet's metadata, extensions, and headers
+func describe(p *packet.Packet) string {
+	b := new(strings.Builder)
+	_, _ = fmt.Fprintf(b, "id=%d op=%s len=%d", p.Metadata.ExtendedId(), operationName(p.Metadata.Operation), p.Metadata.ContentLength)
+	for _, extension := range p.Extensions {
+		switch extension.Type {
+		case metadata.ExtensionTrace:
+			if trace, ok := frisbee.ExtractTrace(p); ok {
+				_, _ = fmt.Fprintf(b, " trace=%s", trace)
+				continue
+			}
+		case metadata.ExtensionMessageID:
+			if len(extension.Value) == 8 {
+				_, _ = fmt.Fprintf(b, " msgid=%d", binary.BigEndian.Uint64(extension.Value))
+				continue
+			}
+		}
+		_, _ = fmt.Fprintf(b, " ext%d=%x", extension.Type, extension.Value)
+	}
+	for _, header := range p.Headers {
+		_, _ = fmt.Fprintf(b, " header:%s=%q", header.Key, header.Value)
+	}
+	return b.String()
+}
+
+// printPacket prints a description of the packet, followed by its content in the given format
+func printPacket(w io.Writer, prefix string, p *packet.Packet, format string) {
+	_, _ = fmt.Fprintf(w, "%s%s\n", prefix, describe(p))
+	if p.Content.Len() == 0 {
+		return
+	}
+	switch format {
+	case formatHex:
+		_, _ = io.WriteString(w, hex.Dump(p.Content.Bytes()))
+	case formatText:
+		_, _ = fmt.Fprintf(w, "%q\n", p.Content.Bytes())
+	}
+}
@@ -0,0 +1,130 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/loopholelabs/frisbee-go"
+	"github.com/loopholelabs/frisbee-go/internal/cli"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+var (
+	errInvalidOperation = fmt.Errorf("operation must be between %d and 65535", frisbee.RESERVED9+1)
+	errInvalidID        = errors.New("id must be between 0 and 65535")
+	errTimeout          = errors.New("timed out waiting for responses")
+)
+
+func send(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) error {
+	flags := flag.NewFlagSet("send", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		_, _ = fmt.Fprintf(flags.Output(), "Usage: frisbee send [flags] <address>\n\nSend a packet to a server and print the responses.\n\n")
+		flags.PrintDefaults()
+	}
+
+	var connection cli.Connection
+	var content contentFlags
+	connection.Register(flags)
+	content.register(flags)
+	operation := flags.Uint("op", 0, "packet operation (required, must be greater than 9)")
+	id := flags.Uint("id", 0, "packet ID")
+	responses := flags.Int("responses", 1, "number of responses to wait for before exiting")
+	format := flags.String("format", formatHex, "format used to print response content (hex, text, or none)")
+
+	addr, err := parse(flags, args, "address")
+	if err != nil {
+		return err
+	}
+	if *operation <= uint(frisbee.RESERVED9) || *operation > 0xFFFF {
+		return errInvalidOperation
+	}
+	if *id > 0xFFFF {
+		return errInvalidID
+	}
+	if err = validFormat(*format); err != nil {
+		return err
+	}
+
+	data, err := content.content(stdin)
+	if err != nil {
+		return err
+	}
+
+	options, err := connection.Options()
+	if err != nil {
+		return err
+	}
+
+	conn, err := frisbee.ConnectAsyncWithOptions(addr, options)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	p := packet.Get()
+	p.Metadata.Id = uint16(*id)
+	p.Metadata.Operation = uint16(*operation)
+	p.Content.Write(data)
+	p.Metadata.ContentLength = uint32(len(data))
+	err = conn.WritePacket(p)
+	packet.Put(p)
+	if err != nil {
+		return err
+	}
+	if err = conn.Flush(); err != nil {
+		return err
+	}
+
+	return readResponses(conn, *responses, connection.Timeout, *format, stdout)
+}
+
+// readResponses prints the given number of packets read from the connection, and
+// returns an error if they are not all received before the timeout
+func readResponses(conn *frisbee.Async, responses int, timeout time.Duration, format string, stdout io.Writer) error {
+	if responses <= 0 {
+		return nil
+	}
+
+	packetCh := make(chan *packet.Packet)
+	errCh := make(chan error, 1)
+	go func() {
+		for {
+			p, err := conn.ReadPacket()
+			if err != nil {
+				errCh <- err
+				return
+			}
+			packetCh <- p
+		}
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for i := 0; i < responses; i++ {
+		select {
+		case p := <-packetCh:
+			printPacket(stdout, "", p, format)
+			packet.Put(p)
+		case err := <-errCh:
+			return err
+		case <-timer.C:
+			return errTimeout
+		}
+	}
+
+	_ = conn.Close()
+	select {
+	case p := <-packetCh:
+		packet.Put(p)
+	case <-errCh:
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/loopholelabs/frisbee-go"
+	"github.com/loopholelabs/frisbee-go/internal/cli"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+// streamChunkSize is the maximum amount of data read from stdin and sent as a single stream packet
+const streamChunkSize = 1 << 15
+
+func stream(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) error {
+	flags := flag.NewFlagSet("stream", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		_, _ = fmt.Fprintf(flags.Output(), "Usage: frisbee stream [flags] <address>\n\nOpen a stream to a server, send stdin through it, and copy the data received on it to stdout.\n"+
+			"Once stdin is closed, the stream stays open until the server closes it or -timeout elapses.\n\n")
+		flags.PrintDefaults()
+	}
+
+	var connection cli.Connection
+	connection.Register(flags)
+	id := flags.Uint("id", 0, "stream ID")
+
+	addr, err := parse(flags, args, "address")
+	if err != nil {
+		return err
+	}
+	if *id > 0xFFFF {
+		return errInvalidID
+	}
+
+	options, err := connection.Options()
+	if err != nil {
+		return err
+	}
+
+	// Incoming stream packets are discarded by connections without a stream handler,
+	// even for streams that were opened locally, so streams opened by the server are closed instead
+	conn, err := frisbee.ConnectAsyncWithOptions(addr, options, func(s *frisbee.Stream) {
+		_ = s.Close()
+	})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	s := conn.NewStream(uint16(*id))
+
+	readErrCh := make(chan error, 1)
+	go func() {
+		readErrCh <- copyStream(stdout, s)
+	}()
+
+	buf := make([]byte, streamChunkSize)
+	for {
+		n, err := stdin.Read(buf)
+		if n > 0 {
+			p := packet.Get()
+			p.Content.Write(buf[:n])
+			p.Metadata.ContentLength = uint32(n)
+			writeErr := s.WritePacket(p)
+			packet.Put(p)
+			if writeErr != nil {
+				return writeErr
+			}
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return err
+		}
+	}
+	if err = conn.Flush(); err != nil {
+		return err
+	}
+
+	timer := time.NewTimer(connection.Timeout)
+	defer timer.Stop()
+	select {
+	case err = <-readErrCh:
+		return err
+	case <-timer.C:
+		_ = s.Close()
+		return <-readErrCh
+	}
+}
+
+// copyStream copies the content of the packets received on the stream to w until the stream is closed
+func copyStream(w io.Writer, s *frisbee.Stream) error {
+	for {
+		p, err := s.ReadPacket()
+		if err != nil {
+			if errors.Is(err, frisbee.StreamClosed) {
+				return nil
+			}
+			return err
+		}
+		_, err = w.Write(p.Content.Bytes())
+		packet.Put(p)
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,111 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package frisbee
+
+import (
+	"context"
+
+	"github.com/loopholelabs/frisbee-go/pkg/codec"
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+// EncodeContent replaces the content of the packet with the value encoded using the given Codec,
+// and records the ID of the Codec in the packet's extensions so the receiver can decode it with DecodeContent.
+func EncodeContent(p *packet.Packet, c codec.Codec, v any) error {
+	p.Content.Reset()
+	if err := c.Encode(p.Content, v); err != nil {
+		p.Content.Reset()
+		p.Metadata.ContentLength = 0
+		return err
+	}
+	p.Metadata.ContentLength = uint32(p.Content.Len())
+	return p.Extensions.Set(metadata.ExtensionCodec, []byte{c.ID()})
+}
+
+// ContentCodec returns the registered Codec that the content of the packet was encoded with (see EncodeContent),
+// or the fallback Codec if the packet does not specify one. If the packet specifies a Codec that is not
+// registered, codec.UnknownCodecErr is returned.
+func ContentCodec(p *packet.Packet, fallback codec.Codec) (codec.Codec, error) {
+	value, ok := p.Extensions.Get(metadata.ExtensionCodec)
+	if !ok {
+		if fallback == nil {
+			return nil, codec.UnknownCodecErr
+		}
+		return fallback, nil
+	}
+	if len(value) != 1 {
+		return nil, codec.InvalidCodecErr
+	}
+	c, ok := codec.Get(value[0])
+	if !ok {
+		return nil, codec.UnknownCodecErr
+	}
+	return c, nil
+}
+
+// DecodeContent decodes the content of the packet into the value using the Codec returned by ContentCodec
+func DecodeContent(p *packet.Packet, fallback codec.Codec, v any) error {
+	c, err := ContentCodec(p, fallback)
+	if err != nil {
+		return err
+	}
+	return c.Decode(p.Content.Bytes(), v)
+}
+
+// CodecHandler converts a function that handles decoded requests into a Handler, like TypedHandler does, but
+// decodes each request with the Codec that the client encoded it with (see ContentCodec), and encodes the response
+// with the same Codec. This allows a single operation to serve clients that use different serialization formats.
+//
+// Requests that do not specify a Codec are decoded with the fallback Codec, and requests that specify a Codec that
+// is not registered are answered with an ERROR packet with ErrorInvalidRequest.
+func CodecHandler[Req, Resp any](fallback codec.Codec, handler func(context.Context, *Req) (*Resp, error)) Handler {
+	return func(ctx context.Context, incoming *packet.Packet) (*packet.Packet, Action) {
+		c, err := ContentCodec(incoming, fallback)
+		if err != nil {
+			return NewErrorPacket(incoming.Metadata.Id, ErrorInvalidRequest, err.Error()), NONE
+		}
+		req := new(Req)
+		if err = c.Decode(incoming.Content.Bytes(), req); err != nil {
+			return NewErrorPacket(incoming.Metadata.Id, ErrorInvalidRequest, err.Error()), NONE
+		}
+		resp, err := handler(ctx, req)
+		if err != nil {
+			return errorResponse(incoming.Metadata.Id, err), NONE
+		}
+		if resp == nil {
+			return nil, NONE
+		}
+		incoming.Extensions.Reset()
+		incoming.Headers.Reset()
+		if err = EncodeContent(incoming, c, resp); err != nil {
+			return errorResponse(incoming.Metadata.Id, err), NONE
+		}
+		return incoming, NONE
+	}
+}
+
+// CallCodec encodes the request with the given Codec as the content of a packet with the given operation,
+// sends it using Client.Call, and decodes the content of the response into a new Resp using the Codec that
+// the response specifies (or the given Codec if it does not specify one). ERROR responses are returned as
+// *ErrorFrame errors.
+func CallCodec[Req, Resp any](ctx context.Context, c *Client, operation uint16, cdc codec.Codec, req *Req) (*Resp, error) {
+	p := packet.Get()
+	p.Metadata.Operation = operation
+	if err := EncodeContent(p, cdc, req); err != nil {
+		packet.Put(p)
+		return nil, err
+	}
+	response, err := c.Call(ctx, p)
+	packet.Put(p)
+	if err != nil {
+		return nil, err
+	}
+	resp := new(Resp)
+	err = DecodeContent(response, cdc, resp)
+	packet.Put(response)
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
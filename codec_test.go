@@ -0,0 +1,135 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package frisbee
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/loopholelabs/logging"
+	"github.com/loopholelabs/polyglot/v2"
+	"github.com/loopholelabs/testing/conn/pair"
+
+	"github.com/loopholelabs/frisbee-go/pkg/codec"
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+type codecTestMessage struct {
+	Value string `protobuf:"bytes,1,opt,name=value,proto3"`
+	Count int64  `protobuf:"varint,2,opt,name=count,proto3"`
+}
+
+func (m *codecTestMessage) Encode(b *polyglot.Buffer) {
+	polyglot.Encoder(b).String(m.Value).Int64(m.Count)
+}
+
+func (m *codecTestMessage) Decode(b []byte) (err error) {
+	d := polyglot.Decoder(b)
+	if m.Value, err = d.String(); err != nil {
+		return
+	}
+	m.Count, err = d.Int64()
+	return
+}
+
+func TestEncodeContent(t *testing.T) {
+	t.Parallel()
+
+	p := packet.Get()
+	require.NoError(t, EncodeContent(p, codec.JSON, &codecTestMessage{Value: "json", Count: 1}))
+	assert.Equal(t, `{"Value":"json","Count":1}`, string(p.Content.Bytes()))
+	assert.Equal(t, uint32(p.Content.Len()), p.Metadata.ContentLength)
+
+	c, err := ContentCodec(p, codec.Polyglot)
+	require.NoError(t, err)
+	assert.Equal(t, codec.JSON, c)
+
+	decoded := new(codecTestMessage)
+	require.NoError(t, DecodeContent(p, nil, decoded))
+	assert.Equal(t, &codecTestMessage{Value: "json", Count: 1}, decoded)
+
+	require.Error(t, EncodeContent(p, codec.Polyglot, "not a message"))
+	assert.Zero(t, p.Metadata.ContentLength)
+
+	p.Extensions.Reset()
+	c, err = ContentCodec(p, codec.Polyglot)
+	require.NoError(t, err)
+	assert.Equal(t, codec.Polyglot, c)
+	_, err = ContentCodec(p, nil)
+	assert.ErrorIs(t, err, codec.UnknownCodecErr)
+
+	require.NoError(t, p.Extensions.Set(metadata.ExtensionCodec, []byte{255}))
+	_, err = ContentCodec(p, codec.Polyglot)
+	assert.ErrorIs(t, err, codec.UnknownCodecErr)
+
+	require.NoError(t, p.Extensions.Set(metadata.ExtensionCodec, []byte{1, 2}))
+	_, err = ContentCodec(p, codec.Polyglot)
+	assert.ErrorIs(t, err, codec.InvalidCodecErr)
+	packet.Put(p)
+}
+
+func TestCodecHandler(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+
+	handlerTable := make(HandlerTable)
+	handlerTable[10] = CodecHandler[codecTestMessage, codecTestMessage](codec.Polyglot, func(_ context.Context, req *codecTestMessage) (*codecTestMessage, error) {
+		return &codecTestMessage{Value: strings.ToUpper(req.Value), Count: req.Count + 1}, nil
+	})
+	handlerTable[11] = CodecHandler[codecTestMessage, codecTestMessage](codec.Polyglot, func(_ context.Context, _ *codecTestMessage) (*codecTestMessage, error) {
+		return nil, &ErrorFrame{Code: ErrorBusy, Message: "try again later"}
+	})
+
+	s, err := NewServer(handlerTable, context.Background(), WithLogger(emptyLogger))
+	require.NoError(t, err)
+
+	c, err := NewClient(HandlerTable{}, context.Background(), WithLogger(emptyLogger))
+	require.NoError(t, err)
+
+	serverConn, clientConn, err := pair.New()
+	require.NoError(t, err)
+	go s.ServeConn(serverConn)
+	require.NoError(t, c.FromConn(clientConn))
+
+	// Clients using different codecs can call the same operation
+	for _, cdc := range []codec.Codec{codec.Polyglot, codec.JSON, codec.Gob, codec.Proto} {
+		resp, err := CallCodec[codecTestMessage, codecTestMessage](context.Background(), c, 10, cdc, &codecTestMessage{Value: cdc.Name(), Count: 1})
+		require.NoError(t, err, cdc.Name())
+		assert.Equal(t, &codecTestMessage{Value: strings.ToUpper(cdc.Name()), Count: 2}, resp)
+	}
+
+	// Requests without a codec use the fallback codec
+	resp, err := CallTyped[codecTestMessage, codecTestMessage](context.Background(), c, 10, &codecTestMessage{Value: "typed"})
+	require.NoError(t, err)
+	assert.Equal(t, &codecTestMessage{Value: "TYPED", Count: 1}, resp)
+
+	var errorFrame *ErrorFrame
+	_, err = CallCodec[codecTestMessage, codecTestMessage](context.Background(), c, 11, codec.JSON, &codecTestMessage{})
+	require.ErrorAs(t, err, &errorFrame)
+	assert.Equal(t, ErrorBusy, errorFrame.Code)
+
+	p := packet.Get()
+	p.Metadata.Operation = 10
+	require.NoError(t, p.Extensions.Set(metadata.ExtensionCodec, []byte{255}))
+	_, err = c.Call(context.Background(), p)
+	require.ErrorAs(t, err, &errorFrame)
+	assert.Equal(t, ErrorInvalidRequest, errorFrame.Code)
+
+	require.NoError(t, EncodeContent(p, codec.JSON, &codecTestMessage{}))
+	p.Content.Reset()
+	p.Content.Write([]byte("{"))
+	p.Metadata.ContentLength = 1
+	_, err = c.Call(context.Background(), p)
+	require.ErrorAs(t, err, &errorFrame)
+	assert.Equal(t, ErrorInvalidRequest, errorFrame.Code)
+	packet.Put(p)
+
+	require.NoError(t, c.Close())
+	require.NoError(t, s.Shutdown())
+}
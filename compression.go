@@ -0,0 +1,258 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package frisbee
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+)
+
+var (
+	UnsupportedCompression = errors.New("unsupported compression algorithm")
+	DecompressedTooLarge   = errors.New("decompressed content is too large")
+)
+
+const (
+	// DefaultCompressionThreshold is the default minimum content length of packets that are compressed
+	DefaultCompressionThreshold = 1 << 10
+
+	// DefaultMaxDecompressedSize is the default maximum content length of packets after they are decompressed
+	DefaultMaxDecompressedSize = 1 << 26
+)
+
+// Compression is a compression algorithm that can be used to compress packet content
+type Compression uint8
+
+// These are the supported compression algorithms:
+const (
+	// CompressionNone means that the packet content is not compressed
+	CompressionNone = Compression(iota)
+
+	// CompressionFlate uses compress/flate
+	CompressionFlate
+
+	// CompressionGzip uses compress/gzip
+	CompressionGzip
+
+	// CompressionZlib uses compress/zlib
+	CompressionZlib
+)
+
+// String returns the name of the compression algorithm
+func (c Compression) String() string {
+	switch c {
+	case CompressionNone:
+		return "none"
+	case CompressionFlate:
+		return "flate"
+	case CompressionGzip:
+		return "gzip"
+	case CompressionZlib:
+		return "zlib"
+	default:
+		return "unknown"
+	}
+}
+
+// CompressionOptions configures the compression of packet content for the Async connections of a frisbee client or server.
+//
+// When a connection is opened, both sides advertise the algorithms they support with a NEGOTIATE packet. Each side then
+// compresses the content of the packets it sends using the first of its Algorithms that the other side also supports,
+// and the algorithm is recorded in the packet's ExtensionFlags extension so that the other side can decompress it.
+// If the other side does not support any of the Algorithms (or does not use compression at all), packets are sent uncompressed.
+//
+// Default Values:
+//
+//	options := CompressionOptions {
+//		Threshold: DefaultCompressionThreshold,
+//		MaxDecompressedSize: DefaultMaxDecompressedSize,
+//	}
+type CompressionOptions struct {
+	// Algorithms are the supported algorithms, in order of preference
+	Algorithms []Compression
+
+	// Threshold is the minimum content length of packets that are compressed
+	Threshold int
+
+	// MaxDecompressedSize is the maximum content length of packets after they are decompressed, and
+	// connections that receive packets larger than this are closed with the DecompressedTooLarge error
+	MaxDecompressedSize int
+}
+
+// WithCompression enables the compression of packet content for the frisbee client or server
+// using the given algorithms (in order of preference), see CompressionOptions.
+func WithCompression(options CompressionOptions) Option {
+	return func(opts *Options) {
+		opts.Compression = &options
+	}
+}
+
+var (
+	flateWriters = sync.Pool{New: func() any {
+		w, _ := flate.NewWriter(nil, flate.DefaultCompression)
+		return w
+	}}
+	gzipWriters = sync.Pool{New: func() any {
+		return gzip.NewWriter(nil)
+	}}
+	zlibWriters = sync.Pool{New: func() any {
+		return zlib.NewWriter(nil)
+	}}
+)
+
+// resettableWriter is implemented by the flate, gzip, and zlib writers
+type resettableWriter interface {
+	io.WriteCloser
+	Reset(w io.Writer)
+}
+
+// supported returns whether the compression algorithm is implemented
+func (c Compression) supported() bool {
+	return c > CompressionNone && c <= CompressionZlib
+}
+
+func (c Compression) writers() *sync.Pool {
+	switch c {
+	case CompressionFlate:
+		return &flateWriters
+	case CompressionGzip:
+		return &gzipWriters
+	default:
+		return &zlibWriters
+	}
+}
+
+// compress returns the data compressed using the algorithm
+func (c Compression) compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	pool := c.writers()
+	w := pool.Get().(resettableWriter)
+	w.Reset(&buf)
+	_, err := w.Write(data)
+	if err == nil {
+		err = w.Close()
+	}
+	pool.Put(w)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decompress returns the data decompressed using the algorithm, or DecompressedTooLarge
+// if it would be larger than maxSize bytes
+func (c Compression) decompress(data []byte, maxSize int) ([]byte, error) {
+	var r io.ReadCloser
+	var err error
+	switch c {
+	case CompressionFlate:
+		r = flate.NewReader(bytes.NewReader(data))
+	case CompressionGzip:
+		r, err = gzip.NewReader(bytes.NewReader(data))
+	case CompressionZlib:
+		r, err = zlib.NewReader(bytes.NewReader(data))
+	default:
+		return nil, UnsupportedCompression
+	}
+	if err != nil {
+		return nil, err
+	}
+	decompressed, err := io.ReadAll(io.LimitReader(r, int64(maxSize)+1))
+	_ = r.Close()
+	if err != nil {
+		return nil, err
+	}
+	if len(decompressed) > maxSize {
+		return nil, DecompressedTooLarge
+	}
+	return decompressed, nil
+}
+
+// compressor compresses and decompresses the content of the packets sent and received by an Async connection
+type compressor struct {
+	options   CompressionOptions
+	mu        sync.RWMutex
+	algorithm Compression
+}
+
+func newCompressor(options *CompressionOptions) *compressor {
+	if options == nil || len(options.Algorithms) == 0 {
+		return nil
+	}
+	c := &compressor{options: *options}
+	if c.options.Threshold <= 0 {
+		c.options.Threshold = DefaultCompressionThreshold
+	}
+	if c.options.MaxDecompressedSize <= 0 {
+		c.options.MaxDecompressedSize = DefaultMaxDecompressedSize
+	}
+	return c
+}
+
+// advertisement returns the content of the NEGOTIATE packet that advertises the supported algorithms
+func (c *compressor) advertisement() []byte {
+	advertised := make([]byte, 0, len(c.options.Algorithms))
+	for _, algorithm := range c.options.Algorithms {
+		if algorithm.supported() {
+			advertised = append(advertised, byte(algorithm))
+		}
+	}
+	return advertised
+}
+
+// negotiate chooses the preferred algorithm that is also supported by the other side of the connection
+func (c *compressor) negotiate(advertised []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.algorithm = CompressionNone
+	for _, algorithm := range c.options.Algorithms {
+		if algorithm.supported() && bytes.IndexByte(advertised, byte(algorithm)) >= 0 {
+			c.algorithm = algorithm
+			return
+		}
+	}
+}
+
+// compress returns the compressed content and the flags that must be sent with it, or nil if the
+// content should be sent uncompressed because it is too small or does not compress well
+func (c *compressor) compress(content []byte) ([]byte, byte) {
+	if len(content) < c.options.Threshold {
+		return nil, 0
+	}
+	c.mu.RLock()
+	algorithm := c.algorithm
+	c.mu.RUnlock()
+	if algorithm == CompressionNone {
+		return nil, 0
+	}
+	compressed, err := algorithm.compress(content)
+	if err != nil || len(compressed) >= len(content) {
+		return nil, 0
+	}
+	return compressed, byte(algorithm) & metadata.FlagCompressionMask
+}
+
+// decompress returns the decompressed content for the given flags, which must specify an algorithm that is enabled
+func (c *compressor) decompress(content []byte, flags byte) ([]byte, error) {
+	algorithm := Compression(flags & metadata.FlagCompressionMask)
+	if c == nil || !c.enabled(algorithm) {
+		return nil, UnsupportedCompression
+	}
+	return algorithm.decompress(content, c.options.MaxDecompressedSize)
+}
+
+func (c *compressor) enabled(algorithm Compression) bool {
+	for _, enabled := range c.options.Algorithms {
+		if enabled == algorithm && algorithm.supported() {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,248 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package frisbee
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/loopholelabs/logging"
+	"github.com/loopholelabs/testing/conn/pair"
+
+	"github.com/loopholelabs/frisbee-go/pkg/metrics"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+func negotiatedCompression(c *Async) Compression {
+	if c.compressor == nil {
+		return CompressionNone
+	}
+	c.compressor.mu.RLock()
+	defer c.compressor.mu.RUnlock()
+	return c.compressor.algorithm
+}
+
+func TestCompression(t *testing.T) {
+	t.Parallel()
+
+	for _, algorithm := range []Compression{CompressionFlate, CompressionGzip, CompressionZlib} {
+		data := bytes.Repeat([]byte("highly compressible content "), 1<<10)
+		compressed, err := algorithm.compress(data)
+		require.NoError(t, err, algorithm.String())
+		assert.Less(t, len(compressed), len(data))
+
+		decompressed, err := algorithm.decompress(compressed, len(data))
+		require.NoError(t, err)
+		assert.Equal(t, data, decompressed)
+
+		_, err = algorithm.decompress(compressed, len(data)-1)
+		assert.ErrorIs(t, err, DecompressedTooLarge)
+	}
+	_, err := Compression(7).decompress(nil, 1)
+	assert.ErrorIs(t, err, UnsupportedCompression)
+}
+
+func TestAsyncCompression(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+	readerMetrics := metrics.New()
+	writerMetrics := metrics.New()
+
+	reader, writer := net.Pipe()
+	readerConn := NewAsyncWithOptions(reader, &Options{Logger: emptyLogger, Metrics: readerMetrics, Compression: &CompressionOptions{
+		Algorithms: []Compression{CompressionZlib, CompressionFlate},
+	}})
+	writerConn := NewAsyncWithOptions(writer, &Options{Logger: emptyLogger, Metrics: writerMetrics, Compression: &CompressionOptions{
+		Algorithms: []Compression{CompressionGzip, CompressionFlate, CompressionZlib},
+	}})
+
+	// Each side uses its own preferred algorithm that the other side supports
+	require.Eventually(t, func() bool {
+		return negotiatedCompression(writerConn) == CompressionFlate && negotiatedCompression(readerConn) == CompressionZlib
+	}, time.Second, time.Millisecond)
+
+	compressible := bytes.Repeat([]byte("compressible"), 1<<10)
+	incompressible := make([]byte, 1<<12)
+	_, err := rand.Read(incompressible)
+	require.NoError(t, err)
+
+	for i, content := range [][]byte{compressible, incompressible, []byte("small")} {
+		p := packet.Get()
+		p.Metadata.Id = uint16(i)
+		p.Metadata.Operation = uint16(10 + i)
+		p.Content.Write(content)
+		p.Metadata.ContentLength = uint32(len(content))
+		require.NoError(t, writerConn.WritePacket(p))
+		assert.Equal(t, content, p.Content.Bytes())
+		assert.Empty(t, p.Extensions)
+		packet.Put(p)
+
+		p, err = readerConn.ReadPacket()
+		require.NoError(t, err)
+		assert.Equal(t, uint16(i), p.Metadata.Id)
+		assert.Equal(t, uint32(len(content)), p.Metadata.ContentLength)
+		assert.Equal(t, content, p.Content.Bytes())
+		assert.Empty(t, p.Extensions)
+		packet.Put(p)
+	}
+
+	readerSnapshot := readerMetrics.Snapshot()
+	assert.Less(t, readerSnapshot.Operations[10].BytesRead, uint64(len(compressible)))
+	assert.Equal(t, uint64(1), readerSnapshot.Operations[EXTENSION].PacketsRead)
+	assert.Equal(t, uint64(len(incompressible)+8), readerSnapshot.Operations[11].BytesRead)
+	assert.Equal(t, uint64(len("small")+8), readerSnapshot.Operations[12].BytesRead)
+
+	require.NoError(t, readerConn.Close())
+	require.NoError(t, writerConn.Close())
+}
+
+func TestAsyncCompressionDisabled(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+
+	reader, writer := net.Pipe()
+	readerConn := NewAsyncWithOptions(reader, &Options{Logger: emptyLogger})
+	writerConn := NewAsyncWithOptions(writer, &Options{Logger: emptyLogger, Compression: &CompressionOptions{
+		Algorithms: []Compression{CompressionGzip},
+	}})
+
+	content := bytes.Repeat([]byte("compressible"), 1<<10)
+	p := packet.Get()
+	p.Metadata.Operation = 10
+	p.Content.Write(content)
+	p.Metadata.ContentLength = uint32(len(content))
+	require.NoError(t, writerConn.WritePacket(p))
+	packet.Put(p)
+
+	// The NEGOTIATE packet is handled by the read loop instead of being returned by ReadPacket
+	p, err := readerConn.ReadPacket()
+	require.NoError(t, err)
+	assert.Equal(t, uint16(10), p.Metadata.Operation)
+	assert.Equal(t, content, p.Content.Bytes())
+	packet.Put(p)
+	assert.Equal(t, CompressionNone, negotiatedCompression(writerConn))
+
+	require.NoError(t, readerConn.Close())
+	require.NoError(t, writerConn.Close())
+}
+
+func TestAsyncCompressionLimits(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+
+	reader, writer := net.Pipe()
+	readerConn := NewAsyncWithOptions(reader, &Options{Logger: emptyLogger, Compression: &CompressionOptions{
+		Algorithms:          []Compression{CompressionFlate},
+		MaxDecompressedSize: 1 << 12,
+	}})
+	writerConn := NewAsyncWithOptions(writer, &Options{Logger: emptyLogger, Compression: &CompressionOptions{
+		Algorithms: []Compression{CompressionFlate},
+	}})
+	require.Eventually(t, func() bool {
+		return negotiatedCompression(writerConn) == CompressionFlate
+	}, time.Second, time.Millisecond)
+
+	content := make([]byte, 1<<20)
+	p := packet.Get()
+	p.Metadata.Operation = 10
+	p.Content.Write(content)
+	p.Metadata.ContentLength = uint32(len(content))
+	require.NoError(t, writerConn.WritePacket(p))
+	packet.Put(p)
+
+	_, err := readerConn.ReadPacket()
+	assert.ErrorIs(t, err, ConnectionClosed)
+	assert.ErrorIs(t, readerConn.Error(), DecompressedTooLarge)
+	_ = writerConn.Close()
+
+	// Packets compressed with an algorithm that was not enabled are rejected
+	reader, writer = net.Pipe()
+	readerConn = NewAsyncWithOptions(reader, &Options{Logger: emptyLogger, Compression: &CompressionOptions{
+		Algorithms: []Compression{CompressionFlate},
+	}})
+	writerConn = NewAsyncWithOptions(writer, &Options{Logger: emptyLogger, Compression: &CompressionOptions{
+		Algorithms: []Compression{CompressionGzip},
+	}})
+	writerConn.compressor.negotiate([]byte{byte(CompressionGzip)})
+
+	p = packet.Get()
+	p.Metadata.Operation = 10
+	p.Content.Write(content[:1<<12])
+	p.Metadata.ContentLength = 1 << 12
+	require.NoError(t, writerConn.WritePacket(p))
+	packet.Put(p)
+
+	_, err = readerConn.ReadPacket()
+	assert.ErrorIs(t, err, ConnectionClosed)
+	assert.ErrorIs(t, readerConn.Error(), UnsupportedCompression)
+	_ = writerConn.Close()
+}
+
+func TestServerCompression(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+	compression := WithCompression(CompressionOptions{Algorithms: []Compression{CompressionGzip}, Threshold: 64})
+
+	handlerTable := make(HandlerTable)
+	handlerTable[10] = func(_ context.Context, incoming *packet.Packet) (*packet.Packet, Action) {
+		incoming.Content.Write(incoming.Content.Bytes())
+		incoming.Metadata.ContentLength = uint32(incoming.Content.Len())
+		return incoming, NONE
+	}
+
+	s, err := NewServer(handlerTable, context.Background(), WithLogger(emptyLogger), compression)
+	require.NoError(t, err)
+	require.NoError(t, s.SetStreamHandler(func(_ context.Context, stream *Stream) {
+		p, err := stream.ReadPacket()
+		if err == nil {
+			_ = stream.WritePacket(p)
+			packet.Put(p)
+		}
+	}))
+
+	c, err := NewClient(HandlerTable{}, context.Background(), WithLogger(emptyLogger), compression)
+	require.NoError(t, err)
+
+	serverConn, clientConn, err := pair.New()
+	require.NoError(t, err)
+	go s.ServeConn(serverConn)
+	require.NoError(t, c.FromConn(clientConn))
+
+	content := bytes.Repeat([]byte("echo"), 1<<8)
+	p := packet.Get()
+	p.Metadata.Operation = 10
+	p.Content.Write(content)
+	p.Metadata.ContentLength = uint32(len(content))
+	response, err := c.Call(context.Background(), p)
+	require.NoError(t, err)
+	assert.Equal(t, append(content, content...), response.Content.Bytes())
+	packet.Put(response)
+
+	// Stream packets are only delivered to clients that have a stream handler
+	c.SetStreamHandler(func(context.Context, *Stream) {})
+	stream := c.Stream(1)
+	p.Reset()
+	p.Content.Write(content)
+	p.Metadata.ContentLength = uint32(len(content))
+	require.NoError(t, stream.WritePacket(p))
+	packet.Put(p)
+	response, err = stream.ReadPacket()
+	require.NoError(t, err)
+	assert.Equal(t, content, response.Content.Bytes())
+	packet.Put(response)
+	_ = stream.Close()
+
+	require.NoError(t, c.Close())
+	require.NoError(t, s.Shutdown())
+}
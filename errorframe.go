@@ -0,0 +1,113 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package frisbee
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+var (
+	InvalidErrorFrame = errors.New("invalid error frame")
+)
+
+// errorCodeSize is the size of the ErrorCode at the start of an encoded ErrorFrame
+const errorCodeSize = 2
+
+// ErrorCode identifies why a packet could not be handled in an ErrorFrame
+type ErrorCode uint16
+
+// These are the ErrorCodes that can be sent in an ErrorFrame:
+const (
+	// ErrorUnknown is used when no other ErrorCode applies
+	ErrorUnknown = ErrorCode(iota)
+
+	// ErrorNoRoute is sent by a Proxy when it has no Upstream for a packet
+	ErrorNoRoute
+
+	// ErrorUpstreamUnavailable is sent by a Proxy when it cannot connect or write to an Upstream
+	ErrorUpstreamUnavailable
+
+	// ErrorUpstreamClosed is sent by a Proxy for every packet still waiting on a response when its Upstream connection closes
+	ErrorUpstreamClosed
+
+	// ErrorTooManyRequests is sent by a Proxy when an Upstream connection has no free packet IDs left
+	ErrorTooManyRequests
+
+	// ErrorBusy is sent by a Server when a packet's operation has reached its OperationLimit and its queue is full
+	ErrorBusy
+
+	// ErrorInvalidRequest is sent by a TypedHandler when the content of a packet cannot be decoded
+	ErrorInvalidRequest
+
+	// ErrorUpstreamTimeout is sent by a Proxy when an Upstream does not respond to a packet before its timeout
+	ErrorUpstreamTimeout
+)
+
+// String returns the name of the ErrorCode
+func (c ErrorCode) String() string {
+	switch c {
+	case ErrorUnknown:
+		return "unknown"
+	case ErrorNoRoute:
+		return "no route"
+	case ErrorUpstreamUnavailable:
+		return "upstream unavailable"
+	case ErrorUpstreamClosed:
+		return "upstream closed"
+	case ErrorTooManyRequests:
+		return "too many requests"
+	case ErrorBusy:
+		return "busy"
+	case ErrorInvalidRequest:
+		return "invalid request"
+	case ErrorUpstreamTimeout:
+		return "upstream timeout"
+	default:
+		return fmt.Sprintf("error code %d", uint16(c))
+	}
+}
+
+// ErrorFrame is the content of an ERROR packet, which is sent in place of a response when a packet could not be handled.
+// The ERROR packet uses the ID of the packet that could not be handled, and its content is the ErrorCode encoded as a
+// big-endian uint16 followed by the Message.
+type ErrorFrame struct {
+	Code    ErrorCode
+	Message string
+}
+
+// Error implements the error interface
+func (e *ErrorFrame) Error() string {
+	if e.Message == "" {
+		return e.Code.String()
+	}
+	return e.Code.String() + ": " + e.Message
+}
+
+// NewErrorPacket returns an ERROR packet for the packet with the given ID
+func NewErrorPacket(id uint16, code ErrorCode, message string) *packet.Packet {
+	p := packet.Get()
+	p.Metadata.Id = id
+	p.Metadata.Operation = ERROR
+	var encodedCode [errorCodeSize]byte
+	binary.BigEndian.PutUint16(encodedCode[:], uint16(code))
+	p.Content.Write(encodedCode[:])
+	p.Content.Write([]byte(message))
+	p.Metadata.ContentLength = uint32(p.Content.Len())
+	return p
+}
+
+// ParseErrorFrame returns the ErrorFrame contained in the given ERROR packet
+func ParseErrorFrame(p *packet.Packet) (*ErrorFrame, error) {
+	if p.Metadata.Operation != ERROR || p.Content.Len() < errorCodeSize {
+		return nil, InvalidErrorFrame
+	}
+	b := p.Content.Bytes()
+	return &ErrorFrame{
+		Code:    ErrorCode(binary.BigEndian.Uint16(b[:errorCodeSize])),
+		Message: string(b[errorCodeSize:]),
+	}, nil
+}
@@ -0,0 +1,43 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package frisbee
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+func TestErrorFrame(t *testing.T) {
+	t.Parallel()
+
+	p := NewErrorPacket(32, ErrorUpstreamClosed, "connection reset")
+	assert.Equal(t, uint16(32), p.Metadata.Id)
+	assert.Equal(t, ERROR, p.Metadata.Operation)
+	assert.Equal(t, uint32(p.Content.Len()), p.Metadata.ContentLength)
+
+	errorFrame, err := ParseErrorFrame(p)
+	require.NoError(t, err)
+	assert.Equal(t, ErrorUpstreamClosed, errorFrame.Code)
+	assert.Equal(t, "connection reset", errorFrame.Message)
+	assert.Equal(t, "upstream closed: connection reset", errorFrame.Error())
+	packet.Put(p)
+
+	p = NewErrorPacket(0, ErrorCode(1000), "")
+	errorFrame, err = ParseErrorFrame(p)
+	require.NoError(t, err)
+	assert.Equal(t, "error code 1000", errorFrame.Error())
+
+	p.Metadata.Operation = 10
+	_, err = ParseErrorFrame(p)
+	assert.ErrorIs(t, err, InvalidErrorFrame)
+
+	p.Reset()
+	p.Metadata.Operation = ERROR
+	_, err = ParseErrorFrame(p)
+	assert.ErrorIs(t, err, InvalidErrorFrame)
+	packet.Put(p)
+}
@@ -29,6 +29,7 @@ var (
 //
 //	NONE: used to do nothing (default)
 //	CLOSE: close the frisbee connection
+//	UPDATE: update the connection's context using the UpdateContext function
 //	SHUTDOWN: shutdown the frisbee client or server
 type Action int
 
@@ -39,11 +40,56 @@ const (
 
 	// CLOSE is used to close the frisbee connection
 	CLOSE
+
+	// UPDATE is used to replace the connection's context with the one returned by the UpdateContext function
+	// of the client or server, which is then used for every packet that is handled afterwards
+	UPDATE
+
+	// SHUTDOWN is used to shut down the frisbee server (or close the frisbee client) that the handler belongs to
+	SHUTDOWN
 )
 
-// Handler is the handler function called by frisbee for incoming packets of data, depending on the packet's Metadata.Operation field
+// Handler is the handler function called by frisbee for incoming packets of data, depending on the packet's Metadata.Operation field.
+//
+// Handlers can respond by returning the incoming packet, in which case the request's Headers, TraceContext, and reliable
+// delivery message ID are removed from it before it is sent, so that they are not echoed back to the peer. Handlers that
+// need to send headers with a response must return a new packet.
 type Handler func(ctx context.Context, incoming *packet.Packet) (outgoing *packet.Packet, action Action)
 
+// handlePacket derives the packet-specific context for the given packet (extracting its TraceContext and running
+// packetContext if it is not nil), and then runs the handler function within a span of the Options' Tracer
+func (o *Options) handlePacket(ctx context.Context, packetContext func(context.Context, *packet.Packet) context.Context, handlerFunc Handler, p *packet.Packet) (outgoing *packet.Packet, action Action) {
+	if trace, ok := ExtractTrace(p); ok {
+		ctx = ContextWithTrace(ctx, trace)
+	}
+	if packetContext != nil {
+		ctx = packetContext(ctx, p)
+	}
+	if o.Tracer != nil {
+		ctx = o.Tracer.StartSpan(ctx, p)
+	}
+	operation := p.Metadata.Operation
+	start := time.Now()
+	outgoing, action = handlerFunc(ctx, p)
+	o.Metrics.HandlerLatency(operation, time.Since(start))
+	stripRequest(p, outgoing)
+	if o.Tracer != nil {
+		o.Tracer.EndSpan(ctx, outgoing, action)
+	}
+	return
+}
+
+// stripRequest removes the headers and extensions that only describe the request from
+// the outgoing packet if the handler responded with the incoming packet (see Handler)
+func stripRequest(incoming *packet.Packet, outgoing *packet.Packet) {
+	if outgoing != incoming {
+		return
+	}
+	outgoing.Headers.Reset()
+	outgoing.Extensions.Delete(metadata.ExtensionTrace)
+	outgoing.Extensions.Delete(metadata.ExtensionMessageID)
+}
+
 // HandlerTable is the lookup table for Frisbee handler functions - based on the Metadata.Operation field of a packet,
 // Frisbee will look up the correct handler for that packet.
 type HandlerTable map[uint16]Handler
@@ -60,15 +106,45 @@ const (
 	// receive packets with the same packet ID until a packet with a ContentLength of 0 is received
 	STREAM
 
-	RESERVED3
-	RESERVED4
-	RESERVED5
-	RESERVED6
-	RESERVED7
+	// EXTENSION is used to send header extensions (such as trace contexts) for the packet
+	// with the same ID that immediately follows it
+	EXTENSION
+
+	// ERROR is sent in place of a response when a packet could not be handled (see ErrorFrame)
+	ERROR
+
+	// NEGOTIATE is sent by both sides of a connection when it is opened to advertise the compression algorithms,
+	// header versions, and checksums that they support (see CompressionOptions, WithHeaderVersion, and WithChecksums)
+	NEGOTIATE
+
+	// SESSION is used to start, resume, and acknowledge the packets of a session (see WithSessions)
+	SESSION
+
+	// ACK is used to acknowledge packets that were sent with reliable delivery (see WithReliableDelivery)
+	ACK
+
 	RESERVED8
 	RESERVED9
 )
 
+// These are the names that the reserved packet types had before they were used, and are kept for compatibility:
+const (
+	// Deprecated: use EXTENSION
+	RESERVED3 = EXTENSION
+
+	// Deprecated: use ERROR
+	RESERVED4 = ERROR
+
+	// Deprecated: use NEGOTIATE
+	RESERVED5 = NEGOTIATE
+
+	// Deprecated: use SESSION
+	RESERVED6 = SESSION
+
+	// Deprecated: use ACK
+	RESERVED7 = ACK
+)
+
 var (
 	// PINGPacket is a pre-allocated Frisbee Packet for PING Packets
 	PINGPacket = &packet.Packet{
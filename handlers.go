@@ -0,0 +1,102 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package frisbee
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	HandlerNil = errors.New("handler cannot be nil")
+)
+
+// atomicHandlerTable is a HandlerTable that can be safely updated while packets are being dispatched.
+//
+// The HandlerTable is never modified once it has been stored, so updates copy it, modify the copy, and swap it in.
+// This keeps lookups lock-free, at the cost of making updates proportional to the size of the table.
+type atomicHandlerTable struct {
+	mu    sync.Mutex
+	table atomic.Pointer[HandlerTable]
+}
+
+// validateHandlerTable returns an error if the HandlerTable uses a reserved operation
+func validateHandlerTable(handlerTable HandlerTable) error {
+	for i := uint16(0); i < RESERVED9; i++ {
+		if _, ok := handlerTable[i]; ok {
+			return InvalidHandlerTable
+		}
+	}
+	return nil
+}
+
+// newAtomicHandlerTable returns an atomicHandlerTable that contains a copy of the given HandlerTable
+func newAtomicHandlerTable(handlerTable HandlerTable) (*atomicHandlerTable, error) {
+	a := new(atomicHandlerTable)
+	return a, a.store(handlerTable)
+}
+
+// get returns the Handler for the given operation, or nil if there is none
+func (a *atomicHandlerTable) get(operation uint16) Handler {
+	table := a.table.Load()
+	if table == nil {
+		return nil
+	}
+	return (*table)[operation]
+}
+
+// load returns a copy of the HandlerTable
+func (a *atomicHandlerTable) load() HandlerTable {
+	table := a.table.Load()
+	if table == nil {
+		return make(HandlerTable)
+	}
+	return copyHandlerTable(*table)
+}
+
+// store replaces the HandlerTable with a copy of the given one
+func (a *atomicHandlerTable) store(handlerTable HandlerTable) error {
+	if err := validateHandlerTable(handlerTable); err != nil {
+		return err
+	}
+	table := copyHandlerTable(handlerTable)
+	a.mu.Lock()
+	a.table.Store(&table)
+	a.mu.Unlock()
+	return nil
+}
+
+// register adds (or replaces) the Handler for the given operation
+func (a *atomicHandlerTable) register(operation uint16, handler Handler) error {
+	if operation <= RESERVED9 {
+		return InvalidOperation
+	}
+	if handler == nil {
+		return HandlerNil
+	}
+	a.mu.Lock()
+	table := a.load()
+	table[operation] = handler
+	a.table.Store(&table)
+	a.mu.Unlock()
+	return nil
+}
+
+// unregister removes the Handler for the given operation
+func (a *atomicHandlerTable) unregister(operation uint16) {
+	a.mu.Lock()
+	table := a.load()
+	delete(table, operation)
+	a.table.Store(&table)
+	a.mu.Unlock()
+}
+
+// copyHandlerTable returns a shallow copy of the given HandlerTable
+func copyHandlerTable(handlerTable HandlerTable) HandlerTable {
+	table := make(HandlerTable, len(handlerTable))
+	for operation, handler := range handlerTable {
+		table[operation] = handler
+	}
+	return table
+}
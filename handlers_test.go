@@ -0,0 +1,133 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package frisbee
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/loopholelabs/logging"
+	"github.com/loopholelabs/testing/conn/pair"
+
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+func TestHandlerTableValidation(t *testing.T) {
+	t.Parallel()
+
+	noop := func(_ context.Context, _ *packet.Packet) (*packet.Packet, Action) {
+		return nil, NONE
+	}
+
+	s, err := NewServer(HandlerTable{10: noop}, context.Background())
+	require.NoError(t, err)
+	assert.ErrorIs(t, s.SetHandlerTable(HandlerTable{PING: noop}), InvalidHandlerTable)
+	assert.ErrorIs(t, s.RegisterHandler(PONG, noop), InvalidOperation)
+	assert.ErrorIs(t, s.RegisterHandler(11, nil), HandlerNil)
+
+	// GetHandlerTable returns a copy, so modifying it does not affect the server
+	table := s.GetHandlerTable()
+	assert.Len(t, table, 1)
+	delete(table, 10)
+	assert.NotNil(t, s.handlerTable.get(10))
+
+	require.NoError(t, s.RegisterHandler(11, noop))
+	assert.Len(t, s.GetHandlerTable(), 2)
+	s.UnregisterHandler(10)
+	assert.Nil(t, s.handlerTable.get(10))
+	assert.Len(t, s.GetHandlerTable(), 1)
+
+	c, err := NewClient(nil, context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, c.GetHandlerTable())
+	assert.ErrorIs(t, c.SetHandlerTable(HandlerTable{STREAM: noop}), InvalidHandlerTable)
+	require.NoError(t, c.RegisterHandler(10, noop))
+	assert.NotNil(t, c.handlerTable.get(10))
+	c.UnregisterHandler(10)
+	assert.Nil(t, c.handlerTable.get(10))
+}
+
+func TestHandlerTableRuntimeUpdates(t *testing.T) {
+	t.Parallel()
+
+	const testSize = 200
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+
+	echo := func(_ context.Context, incoming *packet.Packet) (*packet.Packet, Action) {
+		return incoming, NONE
+	}
+
+	s, err := NewServer(HandlerTable{10: echo}, context.Background(), WithLogger(emptyLogger))
+	require.NoError(t, err)
+
+	var responses atomic.Int64
+	c, err := NewClient(HandlerTable{}, context.Background(), WithLogger(emptyLogger))
+	require.NoError(t, err)
+
+	serverConn, clientConn, err := pair.New()
+	require.NoError(t, err)
+	go s.ServeConn(serverConn)
+	require.NoError(t, c.FromConn(clientConn))
+
+	count := func(_ context.Context, _ *packet.Packet) (*packet.Packet, Action) {
+		responses.Add(1)
+		return nil, NONE
+	}
+
+	// Handlers for another operation are registered and unregistered on both
+	// sides while packets are being dispatched
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			_ = s.RegisterHandler(11, echo)
+			_ = c.RegisterHandler(11, count)
+			s.UnregisterHandler(11)
+			c.UnregisterHandler(11)
+		}
+	}()
+
+	require.NoError(t, c.RegisterHandler(10, count))
+	p := packet.Get()
+	p.Metadata.Operation = 10
+	for i := 0; i < testSize; i++ {
+		p.Metadata.Id = uint16(i)
+		require.NoError(t, c.WritePacket(p))
+	}
+	packet.Put(p)
+	require.NoError(t, c.Flush())
+
+	require.Eventually(t, func() bool {
+		return responses.Load() == testSize
+	}, DefaultDeadline, time.Millisecond*10)
+
+	close(done)
+	wg.Wait()
+
+	// Once the server's handler is unregistered, packets for the operation are discarded
+	s.UnregisterHandler(10)
+	p = packet.Get()
+	p.Metadata.Operation = 10
+	require.NoError(t, c.WritePacket(p))
+	packet.Put(p)
+	require.NoError(t, c.Flush())
+	time.Sleep(time.Millisecond * 50)
+	assert.Equal(t, int64(testSize), responses.Load())
+
+	require.NoError(t, c.Close())
+	require.NoError(t, s.Shutdown())
+}
@@ -0,0 +1,174 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package frisbee
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"math"
+
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+var (
+	InvalidExtendedId = errors.New("packet IDs larger than 16 bits require the v2 header")
+)
+
+// appendHeader appends everything that is sent before the content of the packet using the given header version, and
+// returns the result along with the number of bytes used by the EXTENSION packet that carries the extensions in version 1.
+//
+// In version 2 the extensions are encoded in the header itself, and the ExtensionFlags extension is sent as the header's flags byte.
+// If checksums are used, the checksum of the EXTENSION packet is appended after it, while the checksum of the packet itself
+// must be appended after its content (see appendChecksum).
+func appendHeader(b []byte, version uint8, checksums bool, p *packet.Packet, contentLength int, extensions metadata.Extensions) ([]byte, int, error) {
+	if version < metadata.Version2 {
+		if p.Metadata.IdHigh != 0 {
+			return b, 0, InvalidExtendedId
+		}
+		var extensionsSize int
+		if len(extensions) > 0 {
+			start := len(b)
+			b = appendMetadata(b, p.Metadata.Id, EXTENSION, uint32(extensions.EncodedSize()))
+			b = extensions.Encode(b)
+			if checksums {
+				b = binary.BigEndian.AppendUint32(b, checksum(b[start:], nil))
+			}
+			extensionsSize = len(b) - start
+		}
+		return appendMetadata(b, p.Metadata.Id, p.Metadata.Operation, uint32(contentLength)), extensionsSize, nil
+	}
+
+	header := metadata.HeaderV2{
+		Operation:     p.Metadata.Operation,
+		Id:            p.Metadata.ExtendedId(),
+		ContentLength: uint32(contentLength),
+	}
+	extensionsLength := 0
+	for i := range extensions {
+		if extensions[i].Type == metadata.ExtensionFlags && len(extensions[i].Value) == 1 {
+			header.Flags = extensions[i].Value[0]
+			continue
+		}
+		extensionsLength += metadata.ExtensionHeaderSize + len(extensions[i].Value)
+	}
+	if extensionsLength > math.MaxUint16 {
+		return b, 0, metadata.ExtensionTooLargeErr
+	}
+	header.ExtensionsLength = uint16(extensionsLength)
+	b = header.Encode(b)
+	for i := range extensions {
+		if extensions[i].Type == metadata.ExtensionFlags && len(extensions[i].Value) == 1 {
+			continue
+		}
+		b = extensions[i : i+1].Encode(b)
+	}
+	return b, 0, nil
+}
+
+// appendChecksum appends the checksum of the given header (without the EXTENSION packet that
+// may precede it) and content to b, and returns the result
+func appendChecksum(b []byte, header []byte, content []byte) []byte {
+	return binary.BigEndian.AppendUint32(b, checksum(header, content))
+}
+
+// appendMetadata appends an encoded version 1 header
+func appendMetadata(b []byte, id uint16, operation uint16, contentLength uint32) []byte {
+	var encoded [metadata.Size]byte
+	binary.BigEndian.PutUint16(encoded[metadata.IdOffset:metadata.IdOffset+metadata.IdSize], id)
+	binary.BigEndian.PutUint16(encoded[metadata.OperationOffset:metadata.OperationOffset+metadata.OperationSize], operation)
+	binary.BigEndian.PutUint32(encoded[metadata.ContentLengthOffset:metadata.ContentLengthOffset+metadata.ContentLengthSize], contentLength)
+	return append(b, encoded[:]...)
+}
+
+// readPacket reads a single packet that uses the given header version from r, and returns it along with
+// the number of bytes that were read. The header slice is used as scratch space and must be at least metadata.V2Size bytes long.
+//
+// Version 1 EXTENSION packets are returned as they are, while version 2 packets are returned with their
+// extensions (and the ExtensionFlags extension if the header's flags are not 0).
+//
+// If checksums are used, the checksum that follows the packet is verified, and a *ChecksumError is returned if it does not match.
+func readPacket(r io.Reader, version uint8, checksums bool, header []byte) (*packet.Packet, int, error) {
+	p := packet.Get()
+	var size int
+	var sum uint32
+	if version < metadata.Version2 {
+		if _, err := io.ReadFull(r, header[:metadata.Size]); err != nil {
+			packet.Put(p)
+			return nil, 0, err
+		}
+		p.Metadata.Id = binary.BigEndian.Uint16(header[metadata.IdOffset : metadata.IdOffset+metadata.IdSize])
+		p.Metadata.Operation = binary.BigEndian.Uint16(header[metadata.OperationOffset : metadata.OperationOffset+metadata.OperationSize])
+		p.Metadata.ContentLength = binary.BigEndian.Uint32(header[metadata.ContentLengthOffset : metadata.ContentLengthOffset+metadata.ContentLengthSize])
+		size = metadata.Size
+		if checksums {
+			sum = checksum(header[:metadata.Size], nil)
+		}
+	} else {
+		if _, err := io.ReadFull(r, header[:metadata.V2Size]); err != nil {
+			packet.Put(p)
+			return nil, 0, err
+		}
+		var h metadata.HeaderV2
+		if err := h.Decode(header); err != nil {
+			packet.Put(p)
+			return nil, 0, err
+		}
+		p.Metadata.SetExtendedId(h.Id)
+		p.Metadata.Operation = h.Operation
+		p.Metadata.ContentLength = h.ContentLength
+		size = metadata.V2Size + int(h.ExtensionsLength)
+		if checksums {
+			sum = checksum(header[:metadata.V2Size], nil)
+		}
+		if h.ExtensionsLength > 0 {
+			if err := readContent(r, p, int(h.ExtensionsLength)); err != nil {
+				packet.Put(p)
+				return nil, 0, err
+			}
+			if checksums {
+				sum = crc32.Update(sum, castagnoli, p.Content.Bytes())
+			}
+			err := p.Extensions.Decode(p.Content.Bytes())
+			p.Content.Reset()
+			if err != nil {
+				packet.Put(p)
+				return nil, 0, err
+			}
+		}
+		if h.Flags != 0 {
+			_ = p.Extensions.Set(metadata.ExtensionFlags, []byte{h.Flags})
+		}
+	}
+	if p.Metadata.ContentLength > 0 {
+		if err := readContent(r, p, int(p.Metadata.ContentLength)); err != nil {
+			packet.Put(p)
+			return nil, 0, err
+		}
+	}
+	size += int(p.Metadata.ContentLength)
+	if checksums {
+		sum = crc32.Update(sum, castagnoli, p.Content.Bytes())
+		if _, err := io.ReadFull(r, header[:checksumSize]); err != nil {
+			packet.Put(p)
+			return nil, 0, err
+		}
+		if expected := binary.BigEndian.Uint32(header[:checksumSize]); expected != sum {
+			err := &ChecksumError{Id: p.Metadata.Id, Operation: p.Metadata.Operation, Expected: expected, Actual: sum}
+			packet.Put(p)
+			return nil, 0, err
+		}
+		size += checksumSize
+	}
+	return p, size, nil
+}
+
+// readContent reads exactly length bytes from r into the (empty) content of the packet
+func readContent(r io.Reader, p *packet.Packet, length int) error {
+	p.Content.Grow(length)
+	p.Content.MoveOffset(length)
+	_, err := io.ReadFull(r, p.Content.Bytes())
+	return err
+}
@@ -0,0 +1,188 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package frisbee
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/loopholelabs/logging"
+	"github.com/loopholelabs/testing/conn/pair"
+
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/loopholelabs/frisbee-go/pkg/metrics"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+func asyncWriteVersion(c *Async) uint8 {
+	c.Lock()
+	defer c.Unlock()
+	return c.writeVersion
+}
+
+func newHeaderTestPacket(id uint32, operation uint16, content []byte) *packet.Packet {
+	p := packet.Get()
+	p.Metadata.SetExtendedId(id)
+	p.Metadata.Operation = operation
+	p.Content.Write(content)
+	p.Metadata.ContentLength = uint32(len(content))
+	return p
+}
+
+func TestAsyncHeaderV2(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+	readerMetrics := metrics.New()
+
+	reader, writer := net.Pipe()
+	compression := &CompressionOptions{Algorithms: []Compression{CompressionFlate}}
+	readerConn := NewAsyncWithOptions(reader, &Options{Logger: emptyLogger, Metrics: readerMetrics, HeaderVersion: metadata.Version2, Compression: compression})
+	writerConn := NewAsyncWithOptions(writer, &Options{Logger: emptyLogger, HeaderVersion: metadata.Version2, Compression: compression})
+
+	require.Eventually(t, func() bool {
+		return asyncWriteVersion(readerConn) == metadata.Version2 && asyncWriteVersion(writerConn) == metadata.Version2
+	}, time.Second, time.Millisecond)
+
+	p := newHeaderTestPacket(0x00120034, 10, []byte("content"))
+	require.NoError(t, p.Extensions.Set(32, []byte("extension")))
+	require.NoError(t, writerConn.WritePacket(p))
+	packet.Put(p)
+
+	p, err := readerConn.ReadPacket()
+	require.NoError(t, err)
+	assert.Equal(t, uint32(0x00120034), p.Metadata.ExtendedId())
+	assert.Equal(t, uint16(10), p.Metadata.Operation)
+	assert.Equal(t, []byte("content"), p.Content.Bytes())
+	value, ok := p.Extensions.Get(32)
+	require.True(t, ok)
+	assert.Equal(t, []byte("extension"), value)
+	assert.Len(t, p.Extensions, 1)
+	packet.Put(p)
+
+	// Compression flags are sent in the header instead of an extension
+	compressible := bytes.Repeat([]byte("compressible"), 1<<10)
+	p = newHeaderTestPacket(1, 11, compressible)
+	require.NoError(t, writerConn.WritePacket(p))
+	packet.Put(p)
+
+	p, err = readerConn.ReadPacket()
+	require.NoError(t, err)
+	assert.Equal(t, compressible, p.Content.Bytes())
+	assert.Empty(t, p.Extensions)
+	packet.Put(p)
+
+	// Streams use the version 2 header too
+	readerConn.SetNewStreamHandler(func(stream *Stream) {
+		p, err := stream.ReadPacket()
+		if err == nil {
+			_ = stream.WritePacket(p)
+			packet.Put(p)
+		}
+	})
+	writerConn.SetNewStreamHandler(func(*Stream) {})
+	stream := writerConn.NewStream(7)
+	p = newHeaderTestPacket(0, 0, []byte("stream"))
+	require.NoError(t, stream.WritePacket(p))
+	packet.Put(p)
+	p, err = stream.ReadPacket()
+	require.NoError(t, err)
+	assert.Equal(t, []byte("stream"), p.Content.Bytes())
+	packet.Put(p)
+
+	snapshot := readerMetrics.Snapshot()
+	assert.Zero(t, snapshot.Operations[EXTENSION].PacketsRead)
+	assert.Equal(t, uint64(metadata.V2Size+metadata.ExtensionHeaderSize+len("extension")+len("content")), snapshot.Operations[10].BytesRead)
+	assert.Less(t, snapshot.Operations[11].BytesRead, uint64(len(compressible)))
+
+	require.NoError(t, readerConn.Close())
+	require.NoError(t, writerConn.Close())
+}
+
+func TestAsyncHeaderV1Fallback(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+	readerMetrics := metrics.New()
+
+	reader, writer := net.Pipe()
+	readerConn := NewAsyncWithOptions(reader, &Options{Logger: emptyLogger, Metrics: readerMetrics})
+	writerConn := NewAsyncWithOptions(writer, &Options{Logger: emptyLogger, HeaderVersion: metadata.Version2})
+
+	p := newHeaderTestPacket(0x00010000, 10, []byte("extended"))
+	assert.ErrorIs(t, writerConn.WritePacket(p), InvalidExtendedId)
+	packet.Put(p)
+
+	p = newHeaderTestPacket(1, 10, []byte("content"))
+	require.NoError(t, p.Extensions.Set(32, []byte("extension")))
+	require.NoError(t, writerConn.WritePacket(p))
+	packet.Put(p)
+
+	p, err := readerConn.ReadPacket()
+	require.NoError(t, err)
+	assert.Equal(t, uint16(1), p.Metadata.Id)
+	assert.Equal(t, []byte("content"), p.Content.Bytes())
+	value, ok := p.Extensions.Get(32)
+	require.True(t, ok)
+	assert.Equal(t, []byte("extension"), value)
+	packet.Put(p)
+
+	assert.Equal(t, metadata.Version1, asyncWriteVersion(writerConn))
+	assert.Equal(t, uint64(1), readerMetrics.Snapshot().Operations[EXTENSION].PacketsRead)
+
+	require.NoError(t, readerConn.Close())
+	require.NoError(t, writerConn.Close())
+}
+
+func TestSyncHeaderV2(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+
+	// net.Pipe is not used because it has no buffering, so the Async connection would block while
+	// writing its NEGOTIATE packet until the Sync connection reads it
+	syncSide, asyncSide, err := pair.New()
+	require.NoError(t, err)
+	syncConn := NewSyncWithOptions(syncSide, &Options{Logger: emptyLogger, HeaderVersion: metadata.Version2})
+	asyncConn := NewAsyncWithOptions(asyncSide, &Options{Logger: emptyLogger, HeaderVersion: metadata.Version2})
+
+	// The Sync connection advertises the version 2 header with its first packet
+	p := newHeaderTestPacket(1, 10, []byte("first"))
+	require.NoError(t, syncConn.WritePacket(p))
+	packet.Put(p)
+	p, err = asyncConn.ReadPacket()
+	require.NoError(t, err)
+	assert.Equal(t, []byte("first"), p.Content.Bytes())
+	packet.Put(p)
+	assert.Equal(t, metadata.Version2, asyncWriteVersion(asyncConn))
+
+	p = newHeaderTestPacket(0xABCD0001, 11, []byte("second"))
+	require.NoError(t, asyncConn.WritePacket(p))
+	packet.Put(p)
+	p, err = syncConn.ReadPacket()
+	require.NoError(t, err)
+	assert.Equal(t, uint32(0xABCD0001), p.Metadata.ExtendedId())
+	assert.Equal(t, []byte("second"), p.Content.Bytes())
+	packet.Put(p)
+
+	p = newHeaderTestPacket(0xABCD0002, 12, []byte("third"))
+	require.NoError(t, p.Extensions.Set(32, []byte("extension")))
+	require.NoError(t, syncConn.WritePacket(p))
+	packet.Put(p)
+	p, err = asyncConn.ReadPacket()
+	require.NoError(t, err)
+	assert.Equal(t, uint32(0xABCD0002), p.Metadata.ExtendedId())
+	assert.Equal(t, []byte("third"), p.Content.Bytes())
+	value, ok := p.Extensions.Get(32)
+	require.True(t, ok)
+	assert.Equal(t, []byte("extension"), value)
+	packet.Put(p)
+
+	require.NoError(t, asyncConn.Close())
+	require.NoError(t, syncConn.Close())
+}
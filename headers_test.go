@@ -0,0 +1,265 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package frisbee
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/loopholelabs/logging"
+
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+// expectExtensions waits for the other side of the connection to advertise extensions
+func expectExtensions(t *testing.T, conn *Async) {
+	select {
+	case <-conn.ExtensionsChannel():
+	case <-time.After(time.Second * 5):
+		t.Fatal("timed out waiting for extensions to be advertised")
+	}
+}
+
+func TestPacketHeaders(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+
+	for _, version := range []uint8{metadata.Version1, metadata.Version2} {
+		p := newHeaderTestPacket(32, 64, []byte("content"))
+		require.NoError(t, p.SetHeader("authorization", "token"))
+		require.NoError(t, p.SetHeader("tenant", "acme"))
+
+		// Only the readers advertise extensions, and the writers reply with their own advertisements
+		asyncReader, asyncWriter := net.Pipe()
+		readerConn := NewAsyncWithOptions(asyncReader, &Options{Logger: emptyLogger, HeaderVersion: version, Extensions: true})
+		writerConn := NewAsyncWithOptions(asyncWriter, &Options{Logger: emptyLogger, HeaderVersion: version})
+
+		syncReader, syncWriter := net.Pipe()
+		syncReaderConn := NewSyncWithOptions(syncReader, &Options{Logger: emptyLogger, HeaderVersion: version, Extensions: true})
+		syncWriterConn := NewSyncWithOptions(syncWriter, &Options{Logger: emptyLogger, HeaderVersion: version})
+
+		expectExtensions(t, writerConn)
+		expectExtensions(t, readerConn)
+		require.Eventually(t, func() bool {
+			return version == metadata.Version1 || asyncWriteVersion(writerConn) == metadata.Version2
+		}, time.Second, time.Millisecond)
+
+		// Sync connections only advertise when they write, so the reader's advertisement is read by the writer first
+		syncAdvertised := make(chan error, 1)
+		go func() {
+			syncAdvertised <- syncReaderConn.WritePacket(newHeaderTestPacket(0, 32, nil))
+		}()
+		advertisement, err := syncWriterConn.ReadPacket()
+		require.NoError(t, err)
+		packet.Put(advertisement)
+		require.NoError(t, <-syncAdvertised)
+
+		require.NoError(t, writerConn.WritePacket(p))
+		syncWriteErr := make(chan error, 1)
+		go func() {
+			syncWriteErr <- syncWriterConn.WritePacket(p)
+		}()
+
+		for _, conn := range []Conn{readerConn, syncReaderConn} {
+			read, err := conn.ReadPacket()
+			require.NoError(t, err)
+			assert.Equal(t, []byte("content"), read.Content.Bytes())
+			assert.Empty(t, read.Extensions)
+			authorization, ok := read.Header("authorization")
+			require.True(t, ok)
+			assert.Equal(t, "token", authorization)
+			tenant, ok := read.Header("tenant")
+			require.True(t, ok)
+			assert.Equal(t, "acme", tenant)
+			packet.Put(read)
+		}
+		require.NoError(t, <-syncWriteErr)
+
+		p.Headers = append(p.Headers, metadata.Header{Key: "large", Value: make([]byte, metadata.MaxHeadersSize)})
+		assert.ErrorIs(t, writerConn.WritePacket(p), metadata.HeadersTooLargeErr)
+		assert.False(t, writerConn.Closed())
+
+		p.Reset()
+		assert.Empty(t, p.Headers)
+		packet.Put(p)
+
+		assert.NoError(t, readerConn.Close())
+		assert.NoError(t, writerConn.Close())
+		assert.NoError(t, syncReaderConn.Close())
+		assert.NoError(t, syncWriterConn.Close())
+	}
+}
+
+func TestServerPacketContextHeaders(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+
+	type tenantKey struct{}
+	serverHandlerTable := make(HandlerTable)
+	serverHandlerTable[metadata.PacketPing] = func(ctx context.Context, incoming *packet.Packet) (*packet.Packet, Action) {
+		_, ok := incoming.Header("authorization")
+		assert.False(t, ok)
+		tenant, _ := ctx.Value(tenantKey{}).(string)
+		header, _ := incoming.Header("tenant")
+		incoming.Content.Reset()
+		incoming.Content.Write([]byte(tenant + ":" + header))
+		incoming.Metadata.ContentLength = uint32(incoming.Content.Len())
+		return incoming, NONE
+	}
+
+	s, err := NewServer(serverHandlerTable, context.Background(), WithLogger(emptyLogger), WithExtensions())
+	require.NoError(t, err)
+	s.PacketContext = func(ctx context.Context, p *packet.Packet) context.Context {
+		tenant, _ := p.Header("tenant")
+		p.DeleteHeader("authorization")
+		require.NoError(t, p.SetHeader("tenant", strings.ToUpper(tenant)))
+		return context.WithValue(ctx, tenantKey{}, tenant)
+	}
+
+	serverConn, clientConn := net.Pipe()
+	go s.ServeConn(serverConn)
+
+	c := NewAsync(clientConn, emptyLogger)
+	expectExtensions(t, c)
+	p := newHeaderTestPacket(16, metadata.PacketPing, nil)
+	require.NoError(t, p.SetHeader("authorization", "token"))
+	require.NoError(t, p.SetHeader("tenant", "acme"))
+	require.NoError(t, c.WritePacket(p))
+	packet.Put(p)
+
+	p, err = c.ReadPacket()
+	require.NoError(t, err)
+	assert.Equal(t, []byte("acme:ACME"), p.Content.Bytes())
+	// The incoming packet was returned by the handler, so its headers are not echoed back
+	assert.Empty(t, p.Headers)
+	packet.Put(p)
+
+	require.NoError(t, c.Close())
+	require.NoError(t, s.Shutdown())
+}
+
+func TestPacketHeadersUnsupported(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+
+	// The reader does not advertise extensions (like a peer that does not support them), so packets with headers
+	// cannot be written, and the trace context is not sent
+	reader, writer := net.Pipe()
+	writerConn := NewAsyncWithOptions(writer, &Options{Logger: emptyLogger})
+	syncReader, syncWriter := net.Pipe()
+	syncWriterConn := NewSyncWithOptions(syncWriter, &Options{Logger: emptyLogger})
+
+	p := newHeaderTestPacket(1, 32, []byte("content"))
+	require.NoError(t, p.SetHeader("authorization", "token"))
+	assert.ErrorIs(t, writerConn.WritePacket(p), HeadersNotNegotiated)
+	assert.False(t, writerConn.Closed())
+	assert.ErrorIs(t, syncWriterConn.WritePacket(p), HeadersNotNegotiated)
+	require.NoError(t, syncWriterConn.Close())
+	require.NoError(t, syncReader.Close())
+
+	p.DeleteHeader("authorization")
+	trace, err := ParseTraceparent(testTraceparent)
+	require.NoError(t, err)
+	require.True(t, InjectTrace(ContextWithTrace(context.Background(), trace), p))
+	require.NoError(t, writerConn.WritePacket(p))
+	packet.Put(p)
+
+	read, _, err := readPacket(reader, metadata.Version1, false, make([]byte, metadata.V2Size))
+	require.NoError(t, err)
+	assert.Equal(t, uint16(32), read.Metadata.Operation)
+	assert.Equal(t, []byte("content"), read.Content.Bytes())
+	assert.Empty(t, read.Extensions)
+	packet.Put(read)
+
+	require.NoError(t, writerConn.Close())
+	require.NoError(t, reader.Close())
+}
+
+func TestEchoHandlerHeaders(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+
+	trace, err := ParseTraceparent(testTraceparent)
+	require.NoError(t, err)
+
+	echo := func(_ context.Context, incoming *packet.Packet) (*packet.Packet, Action) {
+		return incoming, NONE
+	}
+	received := make(chan *packet.Packet, 1)
+	receive := func(_ context.Context, incoming *packet.Packet) (*packet.Packet, Action) {
+		received <- clonePacket(incoming)
+		return nil, NONE
+	}
+
+	serverHandlerTable := make(HandlerTable)
+	serverHandlerTable[32] = echo
+	serverHandlerTable[33] = receive
+	s, err := NewServer(serverHandlerTable, context.Background(), WithLogger(emptyLogger), WithExtensions())
+	require.NoError(t, err)
+
+	clientHandlerTable := make(HandlerTable)
+	clientHandlerTable[32] = receive
+	clientHandlerTable[33] = func(ctx context.Context, incoming *packet.Packet) (*packet.Packet, Action) {
+		authorization, _ := incoming.Header("authorization")
+		assert.Equal(t, "token", authorization)
+		return echo(ctx, incoming)
+	}
+	c, err := NewClient(clientHandlerTable, context.Background(), WithLogger(emptyLogger), WithExtensions())
+	require.NoError(t, err)
+
+	serverConn, clientConn := net.Pipe()
+	go s.ServeConn(serverConn)
+	// The client waits for the server to advertise extensions, so the headers of its first packet are sent
+	require.NoError(t, c.FromConn(clientConn))
+
+	p := newHeaderTestPacket(1, 32, []byte("echo"))
+	require.NoError(t, p.SetHeader("authorization", "token"))
+	require.True(t, InjectTrace(ContextWithTrace(context.Background(), trace), p))
+	require.NoError(t, c.WritePacket(p))
+	packet.Put(p)
+
+	select {
+	case p = <-received:
+	case <-time.After(time.Second * 5):
+		t.Fatal("timed out waiting for echoed packet")
+	}
+	assert.Equal(t, []byte("echo"), p.Content.Bytes())
+	assert.Empty(t, p.Headers)
+	_, ok := ExtractTrace(p)
+	assert.False(t, ok)
+	packet.Put(p)
+
+	// The client's handlers do not echo the request's headers either
+	require.Eventually(t, func() bool {
+		return len(s.Connections()) == 1
+	}, time.Second, time.Millisecond)
+	conn := s.Connections()[0]
+	expectExtensions(t, conn)
+	p = newHeaderTestPacket(2, 33, []byte("echo"))
+	require.NoError(t, p.SetHeader("authorization", "token"))
+	require.NoError(t, conn.WritePacket(p))
+	packet.Put(p)
+
+	select {
+	case p = <-received:
+	case <-time.After(time.Second * 5):
+		t.Fatal("timed out waiting for echoed packet")
+	}
+	assert.Equal(t, uint16(2), p.Metadata.Id)
+	assert.Empty(t, p.Headers)
+	packet.Put(p)
+
+	require.NoError(t, c.Close())
+	require.NoError(t, s.Shutdown())
+}
@@ -0,0 +1,93 @@
+// SPDX-License-Identifier: Apache-2.0
+
+// Package cli contains the command-line flags that are shared by the frisbee commands.
+package cli
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"os"
+	"time"
+
+	"github.com/loopholelabs/frisbee-go"
+)
+
+var (
+	NoCertificatesErr = errors.New("no certificates found in CA file")
+	KeyPairErr        = errors.New("-cert and -key must be used together")
+)
+
+// DefaultTimeout is the default value of the -timeout flag
+const DefaultTimeout = time.Second * 5
+
+// Connection holds the flags that are used by every command that connects to a frisbee server
+type Connection struct {
+	TLS        bool
+	Insecure   bool
+	CA         string
+	Cert       string
+	Key        string
+	ServerName string
+	Timeout    time.Duration
+}
+
+// Register registers the connection flags with the given FlagSet
+func (c *Connection) Register(flags *flag.FlagSet) {
+	flags.BoolVar(&c.TLS, "tls", false, "connect using TLS")
+	flags.BoolVar(&c.Insecure, "insecure", false, "skip verification of the server's TLS certificate")
+	flags.StringVar(&c.CA, "ca", "", "PEM `file` containing the CA certificates used to verify the server (implies -tls)")
+	flags.StringVar(&c.Cert, "cert", "", "PEM `file` containing the client certificate (implies -tls)")
+	flags.StringVar(&c.Key, "key", "", "PEM `file` containing the client private key (implies -tls)")
+	flags.StringVar(&c.ServerName, "server-name", "", "server name used to verify the server's TLS certificate")
+	flags.DurationVar(&c.Timeout, "timeout", DefaultTimeout, "how long to wait for responses")
+}
+
+// TLSConfig returns the tls.Config described by the flags, or nil if TLS should not be used
+func (c *Connection) TLSConfig() (*tls.Config, error) {
+	if !c.TLS && !c.Insecure && c.CA == "" && c.Cert == "" && c.Key == "" && c.ServerName == "" {
+		return nil, nil
+	}
+
+	config := &tls.Config{
+		InsecureSkipVerify: c.Insecure,
+		ServerName:         c.ServerName,
+	}
+
+	if c.CA != "" {
+		pem, err := os.ReadFile(c.CA)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, NoCertificatesErr
+		}
+	}
+
+	if c.Cert != "" || c.Key != "" {
+		if c.Cert == "" || c.Key == "" {
+			return nil, KeyPairErr
+		}
+		certificate, err := tls.LoadX509KeyPair(c.Cert, c.Key)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+
+	return config, nil
+}
+
+// Options returns the frisbee Options described by the flags
+func (c *Connection) Options() (*frisbee.Options, error) {
+	tlsConfig, err := c.TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	return &frisbee.Options{
+		KeepAlive: time.Minute * 3,
+		TLSConfig: tlsConfig,
+	}, nil
+}
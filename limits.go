@@ -0,0 +1,181 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package frisbee
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+var (
+	InvalidOperationLimit = errors.New("invalid operation limit, concurrency must be greater than 0 and queue cannot be negative")
+)
+
+// OperationLimit limits how many packets with a given operation a Server handles at the same time (see Server.SetOperationLimit).
+type OperationLimit struct {
+	// Concurrency is the maximum number of packets with the operation that are handled at the same time, across all connections
+	Concurrency int
+
+	// Queue is the maximum number of packets with the operation that can wait for a handler once Concurrency has been reached
+	Queue int
+
+	// Reject causes packets that arrive while the queue is full to be rejected with an ErrorBusy ERROR packet
+	// (see ErrorFrame), instead of blocking the connection until there is space in the queue
+	Reject bool
+}
+
+// operationLimiter enforces an OperationLimit
+type operationLimiter struct {
+	reject bool
+
+	// admitted holds a slot for every packet that is being handled or waiting in the queue
+	admitted chan struct{}
+
+	// running holds a slot for every packet that is being handled
+	running chan struct{}
+}
+
+func newOperationLimiter(limit OperationLimit) *operationLimiter {
+	return &operationLimiter{
+		reject:   limit.Reject,
+		admitted: make(chan struct{}, limit.Concurrency+limit.Queue),
+		running:  make(chan struct{}, limit.Concurrency),
+	}
+}
+
+// admit reserves a place in the queue, and returns false if the packet was rejected or the context was cancelled
+func (l *operationLimiter) admit(ctx context.Context) bool {
+	select {
+	case l.admitted <- struct{}{}:
+		return true
+	default:
+	}
+	if l.reject {
+		return false
+	}
+	select {
+	case l.admitted <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// acquire waits until the admitted packet can be handled, and returns false if the context was cancelled first
+func (l *operationLimiter) acquire(ctx context.Context) bool {
+	select {
+	case l.running <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// release frees the slots held by a packet once it has been handled
+func (l *operationLimiter) release() {
+	<-l.running
+	<-l.admitted
+}
+
+// cancel frees the place in the queue held by a packet that will not be handled
+func (l *operationLimiter) cancel() {
+	<-l.admitted
+}
+
+// operationLimiters is a set of operationLimiters that can be safely updated while packets are being dispatched,
+// using the same copy-on-write approach as atomicHandlerTable
+type operationLimiters struct {
+	mu       sync.Mutex
+	limiters atomic.Pointer[map[uint16]*operationLimiter]
+}
+
+// get returns the operationLimiter for the given operation, or nil if the operation is not limited
+func (o *operationLimiters) get(operation uint16) *operationLimiter {
+	limiters := o.limiters.Load()
+	if limiters == nil {
+		return nil
+	}
+	return (*limiters)[operation]
+}
+
+// update copies the operationLimiters, applies f to the copy, and swaps it in
+func (o *operationLimiters) update(f func(map[uint16]*operationLimiter)) {
+	o.mu.Lock()
+	limiters := make(map[uint16]*operationLimiter)
+	if current := o.limiters.Load(); current != nil {
+		for operation, limiter := range *current {
+			limiters[operation] = limiter
+		}
+	}
+	f(limiters)
+	o.limiters.Store(&limiters)
+	o.mu.Unlock()
+}
+
+// SetOperationLimit limits the number of packets with the given operation that the server handles at the same time
+// (across all connections), replacing any previous limit for the operation. Packets with a limited operation are
+// handled separately from all other packets and do not count towards the server's concurrency (see SetConcurrency),
+// so slow operations cannot starve the others.
+//
+// Once the limit has been reached, up to limit.Queue packets wait for a handler to become available. If the queue is
+// full, the connection that the packet arrived on stops reading until there is space in the queue, unless limit.Reject
+// is true, in which case the packet is discarded and an ErrorBusy ERROR packet is sent back instead.
+//
+// Operation limits are not used if the server's concurrency is 1, since packets are then handled one at a time in the
+// order that they arrive. This function can be called while the server is running, and packets that have already
+// been admitted are still handled under the limit that admitted them.
+func (s *Server) SetOperationLimit(operation uint16, limit OperationLimit) error {
+	if operation <= RESERVED9 {
+		return InvalidOperation
+	}
+	if limit.Concurrency <= 0 || limit.Queue < 0 {
+		return InvalidOperationLimit
+	}
+	limiter := newOperationLimiter(limit)
+	s.operationLimiters.update(func(limiters map[uint16]*operationLimiter) {
+		limiters[operation] = limiter
+	})
+	return nil
+}
+
+// RemoveOperationLimit removes the limit for the given operation, so that its packets are handled like all other packets.
+func (s *Server) RemoveOperationLimit(operation uint16) {
+	s.operationLimiters.update(func(limiters map[uint16]*operationLimiter) {
+		delete(limiters, operation)
+	})
+}
+
+// handleLimitedOperation handles the packet with its operation's limiter, and returns false (without taking ownership of
+// the packet) if the operation is not limited. The handle function must call wg.Done once it has handled the packet.
+func (s *Server) handleLimitedOperation(conn *Async, ctx context.Context, wg *sync.WaitGroup, handle func(*packet.Packet), p *packet.Packet) bool {
+	limiter := s.operationLimiters.get(p.Metadata.Operation)
+	if limiter == nil {
+		return false
+	}
+	if !limiter.admit(ctx) {
+		id, operation := p.Metadata.Id, p.Metadata.Operation
+		packet.Put(p)
+		if ctx.Err() == nil {
+			s.preWrite()
+			_ = conn.WriteError(id, ErrorBusy, fmt.Sprintf("operation %d is busy", operation))
+		}
+		return true
+	}
+	wg.Add(1)
+	go func() {
+		if !limiter.acquire(ctx) {
+			limiter.cancel()
+			packet.Put(p)
+			wg.Done()
+			return
+		}
+		handle(p)
+		limiter.release()
+	}()
+	return true
+}
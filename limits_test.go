@@ -0,0 +1,215 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package frisbee
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/loopholelabs/logging"
+	"github.com/loopholelabs/testing/conn/pair"
+
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+func writeLimitTestPackets(t *testing.T, c *Client, operation uint16, count int) {
+	p := packet.Get()
+	p.Metadata.Operation = operation
+	for i := 0; i < count; i++ {
+		p.Metadata.Id = uint16(i)
+		require.NoError(t, c.WritePacket(p))
+	}
+	packet.Put(p)
+	require.NoError(t, c.Flush())
+}
+
+func TestOperationLimitValidation(t *testing.T) {
+	t.Parallel()
+
+	s, err := NewServer(HandlerTable{}, context.Background())
+	require.NoError(t, err)
+	assert.ErrorIs(t, s.SetOperationLimit(PING, OperationLimit{Concurrency: 1}), InvalidOperation)
+	assert.ErrorIs(t, s.SetOperationLimit(10, OperationLimit{}), InvalidOperationLimit)
+	assert.ErrorIs(t, s.SetOperationLimit(10, OperationLimit{Concurrency: 1, Queue: -1}), InvalidOperationLimit)
+
+	require.NoError(t, s.SetOperationLimit(10, OperationLimit{Concurrency: 1}))
+	assert.NotNil(t, s.operationLimiters.get(10))
+	s.RemoveOperationLimit(10)
+	assert.Nil(t, s.operationLimiters.get(10))
+}
+
+func TestOperationLimitIsolation(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+
+	unblock := make(chan struct{})
+	var slowRunning atomic.Int64
+	var fastResponses, slowResponses atomic.Int64
+	s, err := NewServer(HandlerTable{
+		10: func(_ context.Context, incoming *packet.Packet) (*packet.Packet, Action) {
+			return incoming, NONE
+		},
+		11: func(_ context.Context, incoming *packet.Packet) (*packet.Packet, Action) {
+			slowRunning.Add(1)
+			<-unblock
+			return incoming, NONE
+		},
+	}, context.Background(), WithLogger(emptyLogger))
+	require.NoError(t, err)
+	s.SetConcurrency(2)
+	require.NoError(t, s.SetOperationLimit(11, OperationLimit{Concurrency: 1, Queue: 1, Reject: true}))
+
+	c, err := NewClient(HandlerTable{
+		10: func(_ context.Context, _ *packet.Packet) (*packet.Packet, Action) {
+			fastResponses.Add(1)
+			return nil, NONE
+		},
+		11: func(_ context.Context, _ *packet.Packet) (*packet.Packet, Action) {
+			slowResponses.Add(1)
+			return nil, NONE
+		},
+	}, context.Background(), WithLogger(emptyLogger))
+	require.NoError(t, err)
+	var busy atomic.Int64
+	c.ErrorHandler = func(_ context.Context, _ uint16, err *ErrorFrame) {
+		if err.Code == ErrorBusy {
+			busy.Add(1)
+		}
+	}
+
+	serverConn, clientConn, err := pair.New()
+	require.NoError(t, err)
+	go s.ServeConn(serverConn)
+	require.NoError(t, c.FromConn(clientConn))
+
+	// One packet is handled, one is queued, and the rest are rejected
+	writeLimitTestPackets(t, c, 11, 5)
+	require.Eventually(t, func() bool {
+		return busy.Load() == 3
+	}, DefaultDeadline, time.Millisecond*10)
+	assert.Equal(t, int64(1), slowRunning.Load())
+
+	// The blocked operation does not use up the server's concurrency
+	writeLimitTestPackets(t, c, 10, 10)
+	require.Eventually(t, func() bool {
+		return fastResponses.Load() == 10
+	}, DefaultDeadline, time.Millisecond*10)
+
+	close(unblock)
+	require.Eventually(t, func() bool {
+		return slowResponses.Load() == 2
+	}, DefaultDeadline, time.Millisecond*10)
+	assert.Equal(t, int64(2), slowRunning.Load())
+
+	require.NoError(t, c.Close())
+	require.NoError(t, s.Shutdown())
+}
+
+func TestOperationLimitConcurrency(t *testing.T) {
+	t.Parallel()
+
+	const testSize = 50
+	const concurrency = 3
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+
+	var running, maxRunning atomic.Int64
+	var responses atomic.Int64
+	s, err := NewServer(HandlerTable{
+		10: func(_ context.Context, incoming *packet.Packet) (*packet.Packet, Action) {
+			current := running.Add(1)
+			for {
+				previous := maxRunning.Load()
+				if current <= previous || maxRunning.CompareAndSwap(previous, current) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond)
+			running.Add(-1)
+			return incoming, NONE
+		},
+	}, context.Background(), WithLogger(emptyLogger))
+	require.NoError(t, err)
+	s.SetConcurrency(0)
+	require.NoError(t, s.SetOperationLimit(10, OperationLimit{Concurrency: concurrency}))
+
+	// The limit is shared between connections, and blocking (rather than rejecting) means every packet is handled
+	clients := make([]*Client, 2)
+	for i := range clients {
+		clients[i], err = NewClient(HandlerTable{
+			10: func(_ context.Context, _ *packet.Packet) (*packet.Packet, Action) {
+				responses.Add(1)
+				return nil, NONE
+			},
+		}, context.Background(), WithLogger(emptyLogger))
+		require.NoError(t, err)
+		serverConn, clientConn, err := pair.New()
+		require.NoError(t, err)
+		go s.ServeConn(serverConn)
+		require.NoError(t, clients[i].FromConn(clientConn))
+	}
+
+	for _, c := range clients {
+		writeLimitTestPackets(t, c, 10, testSize)
+	}
+	require.Eventually(t, func() bool {
+		return responses.Load() == testSize*int64(len(clients))
+	}, DefaultDeadline, time.Millisecond*10)
+	assert.LessOrEqual(t, maxRunning.Load(), int64(concurrency))
+
+	for _, c := range clients {
+		require.NoError(t, c.Close())
+	}
+	require.NoError(t, s.Shutdown())
+}
+
+func TestOperationLimitCancel(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+
+	unblock := make(chan struct{})
+	var handled atomic.Int64
+	s, err := NewServer(HandlerTable{
+		10: func(_ context.Context, _ *packet.Packet) (*packet.Packet, Action) {
+			handled.Add(1)
+			<-unblock
+			return nil, NONE
+		},
+	}, context.Background(), WithLogger(emptyLogger))
+	require.NoError(t, err)
+	s.SetConcurrency(0)
+	require.NoError(t, s.SetOperationLimit(10, OperationLimit{Concurrency: 1, Queue: 1}))
+	limiter := s.operationLimiters.get(10)
+
+	c, err := NewClient(HandlerTable{}, context.Background(), WithLogger(emptyLogger))
+	require.NoError(t, err)
+	serverConn, clientConn, err := pair.New()
+	require.NoError(t, err)
+	go s.ServeConn(serverConn)
+	require.NoError(t, c.FromConn(clientConn))
+
+	// The second packet waits in the queue, and must be dropped rather than handled once the connection closes
+	writeLimitTestPackets(t, c, 10, 2)
+	require.Eventually(t, func() bool {
+		return handled.Load() == 1 && len(limiter.admitted) == 2
+	}, DefaultDeadline, time.Millisecond*10)
+	require.NoError(t, c.Close())
+	require.Eventually(t, func() bool {
+		return len(limiter.admitted) == 1
+	}, DefaultDeadline, time.Millisecond*10)
+
+	close(unblock)
+	require.Eventually(t, func() bool {
+		return len(limiter.admitted) == 0
+	}, DefaultDeadline, time.Millisecond*10)
+	assert.Equal(t, int64(1), handled.Load())
+
+	require.NoError(t, s.Shutdown())
+}
@@ -0,0 +1,66 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package frisbee
+
+import (
+	"time"
+)
+
+// Metrics is used by frisbee Async connections, Streams, Servers, and Clients to report
+// what is happening internally. Implementations must be thread-safe, and should return quickly
+// since they are called from the read and write paths of every connection.
+//
+// An in-memory implementation that can render the Prometheus text exposition format is
+// available in the github.com/loopholelabs/frisbee-go/pkg/metrics package.
+type Metrics interface {
+	// PacketRead is called whenever a packet is read from a connection, with the number of
+	// bytes (including the packet's metadata) that were read from the wire
+	PacketRead(operation uint16, bytes int)
+
+	// PacketWritten is called whenever a packet is written to a connection's write buffer, with the number of
+	// bytes (including the packet's metadata) that were written
+	PacketWritten(operation uint16, bytes int)
+
+	// Flushed is called whenever a connection's write buffer is flushed to the wire
+	Flushed()
+
+	// IncomingQueueDepth is called whenever a packet is pushed to a connection's incoming packet queue
+	IncomingQueueDepth(depth int)
+
+	// StreamOpened is called whenever a Stream is created
+	StreamOpened()
+
+	// StreamClosed is called whenever a Stream is closed
+	StreamClosed()
+
+	// HandlerLatency is called by Servers and Clients after a Handler function returns
+	HandlerLatency(operation uint16, latency time.Duration)
+
+	// ConnectionOpened is called whenever a frisbee connection is created
+	ConnectionOpened()
+
+	// ConnectionClosed is called whenever a frisbee connection is closed, with the error that
+	// caused it to close (or nil if it was closed gracefully)
+	ConnectionClosed(reason error)
+
+	// PingRTT is called whenever a PONG is received in response to a PING sent by the connection
+	PingRTT(rtt time.Duration)
+
+	// ChecksumMismatch is called whenever a connection reads a packet whose checksum does not match (see WithChecksums)
+	ChecksumMismatch()
+}
+
+// noopMetrics is the default Metrics implementation, and discards everything reported to it
+type noopMetrics struct{}
+
+func (noopMetrics) PacketRead(uint16, int)               {}
+func (noopMetrics) PacketWritten(uint16, int)            {}
+func (noopMetrics) Flushed()                             {}
+func (noopMetrics) IncomingQueueDepth(int)               {}
+func (noopMetrics) StreamOpened()                        {}
+func (noopMetrics) StreamClosed()                        {}
+func (noopMetrics) HandlerLatency(uint16, time.Duration) {}
+func (noopMetrics) ConnectionOpened()                    {}
+func (noopMetrics) ConnectionClosed(error)               {}
+func (noopMetrics) PingRTT(time.Duration)                {}
+func (noopMetrics) ChecksumMismatch()                    {}
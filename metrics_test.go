@@ -0,0 +1,119 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package frisbee
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/loopholelabs/logging"
+
+	"github.com/loopholelabs/frisbee-go/pkg/metrics"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+func TestAsyncMetrics(t *testing.T) {
+	t.Parallel()
+
+	const packetSize = 512
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+	readerMetrics := metrics.New()
+	writerMetrics := metrics.New()
+
+	reader, writer := net.Pipe()
+
+	readerConn := NewAsyncWithOptions(reader, &Options{Logger: emptyLogger, Metrics: readerMetrics})
+	writerConn := NewAsyncWithOptions(writer, &Options{Logger: emptyLogger, Metrics: writerMetrics})
+
+	p := packet.Get()
+	p.Metadata.Id = 64
+	p.Metadata.Operation = 32
+	p.Content.Write(make([]byte, packetSize))
+	p.Metadata.ContentLength = packetSize
+
+	err := writerConn.WritePacket(p)
+	require.NoError(t, err)
+	packet.Put(p)
+
+	p, err = readerConn.ReadPacket()
+	require.NoError(t, err)
+	packet.Put(p)
+
+	writerStream := writerConn.NewStream(1)
+	require.NoError(t, writerStream.Close())
+
+	time.Sleep(DefaultPingInterval * 2)
+
+	err = readerConn.Close()
+	assert.NoError(t, err)
+	err = writerConn.Close()
+	assert.NoError(t, err)
+
+	writerSnapshot := writerMetrics.Snapshot()
+	readerSnapshot := readerMetrics.Snapshot()
+
+	assert.Equal(t, uint64(1), writerSnapshot.Operations[32].PacketsWritten)
+	assert.Equal(t, uint64(packetSize+8), writerSnapshot.Operations[32].BytesWritten)
+	assert.Equal(t, uint64(1), readerSnapshot.Operations[32].PacketsRead)
+	assert.Equal(t, uint64(packetSize+8), readerSnapshot.Operations[32].BytesRead)
+	assert.Equal(t, uint64(1), writerSnapshot.Operations[STREAM].PacketsWritten)
+	assert.Equal(t, uint64(1), writerSnapshot.StreamsOpened)
+	assert.Equal(t, uint64(1), writerSnapshot.StreamsClosed)
+	assert.NotZero(t, writerSnapshot.Flushes)
+	assert.NotZero(t, writerSnapshot.PingRTT.Count)
+	assert.NotZero(t, writerSnapshot.Operations[PONG].PacketsRead)
+	assert.Equal(t, uint64(1), writerSnapshot.ConnectionsOpened)
+	assert.Equal(t, uint64(1), readerSnapshot.ConnectionsOpened)
+	assert.Equal(t, uint64(1), readerSnapshot.ConnectionsClosed[metrics.ReasonGraceful])
+}
+
+func TestServerMetrics(t *testing.T) {
+	t.Parallel()
+
+	const testSize = 10
+
+	serverMetrics := metrics.New()
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+
+	handled := make(chan struct{}, testSize)
+	serverHandlerTable := make(HandlerTable)
+	serverHandlerTable[10] = func(_ context.Context, _ *packet.Packet) (outgoing *packet.Packet, action Action) {
+		handled <- struct{}{}
+		return
+	}
+
+	s, err := NewServer(serverHandlerTable, context.Background(), WithLogger(emptyLogger), WithMetrics(serverMetrics))
+	require.NoError(t, err)
+
+	serverConn, clientConn := net.Pipe()
+	go s.ServeConn(serverConn)
+
+	c, err := NewClient(make(HandlerTable), context.Background(), WithLogger(emptyLogger))
+	require.NoError(t, err)
+	require.NoError(t, c.FromConn(clientConn))
+
+	p := packet.Get()
+	p.Metadata.Operation = 10
+	for i := 0; i < testSize; i++ {
+		require.NoError(t, c.WritePacket(p))
+	}
+	packet.Put(p)
+
+	for i := 0; i < testSize; i++ {
+		<-handled
+	}
+
+	assert.NoError(t, c.Close())
+	assert.NoError(t, s.Shutdown())
+
+	snapshot := serverMetrics.Snapshot()
+	assert.Equal(t, uint64(testSize), snapshot.Operations[10].PacketsRead)
+	assert.Equal(t, uint64(testSize), snapshot.Operations[10].HandlerLatency.Count)
+	assert.Equal(t, uint64(1), snapshot.ConnectionsOpened)
+}
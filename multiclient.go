@@ -0,0 +1,675 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package frisbee
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/loopholelabs/logging/types"
+
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+var (
+	NoBackends        = errors.New("no backend addresses")
+	NoHealthyBackends = errors.New("no healthy backends")
+	InvalidStrategy   = errors.New("invalid balancing strategy")
+	KeyRequired       = errors.New("a key is required when using the ConsistentHash balancing strategy")
+	ResolverNil       = errors.New("resolver cannot be nil")
+	MultiClientClosed = errors.New("multi client closed")
+)
+
+// Strategy is used by a MultiClient to decide which backend a packet is sent to
+type Strategy int
+
+// These are the Strategies that a MultiClient can use:
+const (
+	// RoundRobin sends packets to each healthy backend in turn
+	RoundRobin = Strategy(iota)
+
+	// LeastOutstanding sends packets to the healthy backend with the fewest packets that have not received a response,
+	// where the first packet received from a backend with the same ID as a packet that was sent to it counts as its response.
+	// Packets that do not receive a response within the ResponseTimeout (such as packets that are never answered) stop counting.
+	LeastOutstanding
+
+	// ConsistentHash sends packets to a healthy backend chosen by hashing a key (see MultiClient.WritePacketKey), so
+	// that packets with the same key are sent to the same backend for as long as it remains healthy
+	ConsistentHash
+)
+
+// Resolver returns the addresses of the backends that a MultiClient should connect to
+type Resolver func(ctx context.Context) ([]string, error)
+
+// MultiClientOptions are used to configure how a MultiClient balances packets and checks the health of its backends.
+//
+// Default Values:
+//
+//	options := MultiClientOptions {
+//		Strategy: RoundRobin,
+//		HealthTimeout: DefaultPingInterval * 4,
+//		CheckInterval: DefaultPingInterval,
+//		MinBackoff: time.Millisecond * 100,
+//		MaxBackoff: time.Second * 30,
+//		ResolveInterval: time.Second * 30,
+//		Replicas: 100,
+//		ResponseTimeout: time.Second * 30,
+//	}
+type MultiClientOptions struct {
+	// Strategy is the Strategy used to choose the backend that each packet is sent to
+	Strategy Strategy
+
+	// HealthTimeout is how long a backend can take to respond to a PING before it is considered unhealthy and evicted
+	HealthTimeout time.Duration
+
+	// CheckInterval is how often the health of the backends is checked, and evicted backends are reconnected
+	CheckInterval time.Duration
+
+	// MinBackoff is how long an evicted backend is left alone before it is reconnected. It doubles every time
+	// the backend is evicted or fails to reconnect, up to MaxBackoff, and is reset once the backend reconnects.
+	MinBackoff time.Duration
+
+	// MaxBackoff is the maximum amount of time that an evicted backend is left alone before it is reconnected
+	MaxBackoff time.Duration
+
+	// ResolveInterval is how often the Resolver is called to update the list of backends
+	ResolveInterval time.Duration
+
+	// Replicas is the number of points that each backend is given on the hash ring used by the ConsistentHash Strategy
+	Replicas int
+
+	// ResponseTimeout is how long a packet counts as outstanding for the LeastOutstanding Strategy if it does not receive a
+	// response. Expired packets are forgotten every CheckInterval.
+	ResponseTimeout time.Duration
+}
+
+// backend is a single frisbee Server that a MultiClient is connected to
+type backend struct {
+	addr        string
+	client      *Client
+	connecting  bool
+	backoff     time.Duration
+	retryAt     time.Time
+	outstanding atomic.Int64
+	pendingMu   sync.Mutex
+	pending     map[uint16][]time.Time
+}
+
+// ringPoint is a point on the hash ring used by the ConsistentHash Strategy
+type ringPoint struct {
+	hash    uint64
+	backend *backend
+}
+
+// MultiClient connects to several frisbee Servers at once and balances the packets written to it between them.
+// Backends that do not respond to PINGs in time are evicted, and are reconnected after a backoff.
+//
+// Every backend uses its own Client with the MultiClient's HandlerTable and Options, so incoming
+// packets are handled in the same way regardless of which backend they came from.
+type MultiClient struct {
+	handlerTable *atomicHandlerTable
+	options      []Option
+	logger       types.Logger
+	config       MultiClientOptions
+	resolver     Resolver
+	closed       atomic.Bool
+	wg           sync.WaitGroup
+	next         atomic.Uint64
+
+	mu       sync.RWMutex
+	backends map[string]*backend
+	healthy  []*backend
+	ring     []ringPoint
+
+	baseContext       context.Context
+	baseContextCancel context.CancelFunc
+
+	// ErrorHandler is set as the ErrorHandler of every backend's Client
+	ErrorHandler func(ctx context.Context, id uint16, err *ErrorFrame)
+}
+
+// NewMultiClient returns an uninitialized frisbee MultiClient with the registered HandlerTable. The Connect or
+// ConnectResolver method must then be called to connect to the backends.
+func NewMultiClient(handlerTable HandlerTable, ctx context.Context, config MultiClientOptions, opts ...Option) (*MultiClient, error) {
+	table, err := newAtomicHandlerTable(handlerTable)
+	if err != nil {
+		return nil, err
+	}
+	if config.Strategy < RoundRobin || config.Strategy > ConsistentHash {
+		return nil, InvalidStrategy
+	}
+	if config.HealthTimeout <= 0 {
+		config.HealthTimeout = DefaultPingInterval * 4
+	}
+	if config.CheckInterval <= 0 {
+		config.CheckInterval = DefaultPingInterval
+	}
+	if config.MinBackoff <= 0 {
+		config.MinBackoff = time.Millisecond * 100
+	}
+	if config.MaxBackoff < config.MinBackoff {
+		config.MaxBackoff = time.Second * 30
+		if config.MaxBackoff < config.MinBackoff {
+			config.MaxBackoff = config.MinBackoff
+		}
+	}
+	if config.ResolveInterval <= 0 {
+		config.ResolveInterval = time.Second * 30
+	}
+	if config.Replicas <= 0 {
+		config.Replicas = 100
+	}
+	if config.ResponseTimeout <= 0 {
+		config.ResponseTimeout = time.Second * 30
+	}
+
+	baseContext, baseContextCancel := context.WithCancel(ctx)
+
+	return &MultiClient{
+		handlerTable:      table,
+		options:           opts,
+		logger:            loadOptions(opts...).Logger,
+		config:            config,
+		backends:          make(map[string]*backend),
+		baseContext:       baseContext,
+		baseContextCancel: baseContextCancel,
+	}, nil
+}
+
+// Connect connects to the frisbee Servers at the given addresses, and starts checking their health.
+// It returns an error if none of them could be connected to.
+func (m *MultiClient) Connect(addrs ...string) error {
+	if len(addrs) == 0 {
+		return NoBackends
+	}
+	return m.start(addrs)
+}
+
+// ConnectResolver connects to the frisbee Servers at the addresses returned by the Resolver, and starts checking their health.
+// The Resolver is called again every ResolveInterval, and backends are added or removed to match the addresses it returns.
+// It returns an error if the Resolver fails, or if none of the backends could be connected to.
+func (m *MultiClient) ConnectResolver(resolver Resolver) error {
+	if resolver == nil {
+		return ResolverNil
+	}
+	addrs, err := resolver(m.baseContext)
+	if err != nil {
+		return err
+	}
+	if len(addrs) == 0 {
+		return NoBackends
+	}
+	m.resolver = resolver
+	return m.start(addrs)
+}
+
+// start connects to the initial list of backends and starts the health check loop
+func (m *MultiClient) start(addrs []string) error {
+	m.update(addrs)
+
+	m.mu.RLock()
+	backends := make([]*backend, 0, len(m.backends))
+	for _, b := range m.backends {
+		backends = append(backends, b)
+	}
+	m.mu.RUnlock()
+
+	// The backends are dialed concurrently and without holding the lock, so that unreachable backends only delay Connect once
+	clients := make([]*Client, len(backends))
+	errs := make([]error, len(backends))
+	var wg sync.WaitGroup
+	for i, b := range backends {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			clients[i], errs[i] = m.connect(b)
+		}()
+	}
+	wg.Wait()
+
+	var unused []*Client
+	m.mu.Lock()
+	for i, b := range backends {
+		switch {
+		case errs[i] != nil:
+			m.evict(b)
+		case m.closed.Load() || m.backends[b.addr] != b:
+			unused = append(unused, clients[i])
+		default:
+			b.client = clients[i]
+		}
+	}
+	m.rebuild()
+	healthy := len(m.healthy)
+	m.mu.Unlock()
+
+	for _, client := range unused {
+		_ = client.Close()
+	}
+
+	if healthy == 0 {
+		_ = m.Close()
+		return errors.Join(append([]error{NoHealthyBackends}, errs...)...)
+	}
+
+	m.wg.Add(1)
+	go m.checkLoop()
+	return nil
+}
+
+// update adds backends for the addresses that are not known yet, and removes the backends whose addresses are no longer listed
+func (m *MultiClient) update(addrs []string) {
+	listed := make(map[string]struct{}, len(addrs))
+	m.mu.Lock()
+	for _, addr := range addrs {
+		listed[addr] = struct{}{}
+		if _, ok := m.backends[addr]; !ok {
+			m.backends[addr] = &backend{
+				addr:    addr,
+				pending: make(map[uint16][]time.Time),
+			}
+		}
+	}
+	var removed []*Client
+	for addr, b := range m.backends {
+		if _, ok := listed[addr]; !ok {
+			delete(m.backends, addr)
+			if b.client != nil {
+				removed = append(removed, b.client)
+				b.client = nil
+			}
+		}
+	}
+	m.rebuild()
+	m.mu.Unlock()
+
+	for _, client := range removed {
+		_ = client.Close()
+	}
+}
+
+// connect creates a new Client for the backend and connects it
+func (m *MultiClient) connect(b *backend) (*Client, error) {
+	client, err := NewClient(nil, m.baseContext, m.options...)
+	if err != nil {
+		return nil, err
+	}
+	// Every backend shares the MultiClient's handler table, so that updating it affects all of them
+	client.handlerTable = m.handlerTable
+	client.ErrorHandler = m.ErrorHandler
+	client.intercept = func(p *packet.Packet) bool {
+		b.answer(p.Metadata.Id)
+		return false
+	}
+	b.reset()
+	err = client.Connect(b.addr)
+	if err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// evict marks the backend as unhealthy, and schedules it to be reconnected after its backoff.
+//
+// The MultiClient's lock must be held when calling this function.
+func (m *MultiClient) evict(b *backend) {
+	if b.backoff == 0 {
+		b.backoff = m.config.MinBackoff
+	} else {
+		b.backoff *= 2
+		if b.backoff > m.config.MaxBackoff {
+			b.backoff = m.config.MaxBackoff
+		}
+	}
+	b.retryAt = time.Now().Add(b.backoff)
+}
+
+// rebuild recomputes the list of healthy backends and the hash ring.
+//
+// The MultiClient's lock must be held when calling this function.
+func (m *MultiClient) rebuild() {
+	healthy := make([]*backend, 0, len(m.backends))
+	for _, b := range m.backends {
+		if b.client != nil {
+			healthy = append(healthy, b)
+		}
+	}
+	sort.Slice(healthy, func(i, j int) bool { return healthy[i].addr < healthy[j].addr })
+	m.healthy = healthy
+
+	if m.config.Strategy == ConsistentHash {
+		ring := make([]ringPoint, 0, len(healthy)*m.config.Replicas)
+		for _, b := range healthy {
+			for i := 0; i < m.config.Replicas; i++ {
+				ring = append(ring, ringPoint{hash: hashKey([]byte(b.addr + "#" + strconv.Itoa(i))), backend: b})
+			}
+		}
+		sort.Slice(ring, func(i, j int) bool { return ring[i].hash < ring[j].hash })
+		m.ring = ring
+	}
+}
+
+// checkLoop evicts unhealthy backends, reconnects evicted ones, and calls the Resolver until the MultiClient is closed
+func (m *MultiClient) checkLoop() {
+	defer m.wg.Done()
+
+	ticker := time.NewTicker(m.config.CheckInterval)
+	defer ticker.Stop()
+	lastResolve := time.Now()
+	for {
+		select {
+		case <-m.baseContext.Done():
+			return
+		case now := <-ticker.C:
+			if m.resolver != nil && now.Sub(lastResolve) >= m.config.ResolveInterval {
+				lastResolve = now
+				addrs, err := m.resolver(m.baseContext)
+				if err == nil && len(addrs) > 0 {
+					m.update(addrs)
+				}
+			}
+			m.check(now)
+		}
+	}
+}
+
+// check evicts the backends that are closed or have not responded to a PING within the HealthTimeout,
+// and starts reconnecting the evicted backends whose backoff has elapsed
+func (m *MultiClient) check(now time.Time) {
+	var evicted []*Client
+	m.mu.Lock()
+	for _, b := range m.backends {
+		switch {
+		case b.client != nil:
+			if m.config.Strategy == LeastOutstanding {
+				b.expire(now.Add(-m.config.ResponseTimeout))
+			}
+			if b.client.Closed() || b.client.conn.Load().pingOutstanding(now) > m.config.HealthTimeout {
+				m.Logger().Debug().Msgf("Evicting unhealthy backend %s", b.addr)
+				evicted = append(evicted, b.client)
+				b.client = nil
+				m.evict(b)
+			}
+		case !b.connecting && !now.Before(b.retryAt):
+			b.connecting = true
+			m.wg.Add(1)
+			go m.reconnect(b)
+		}
+	}
+	if len(evicted) > 0 {
+		m.rebuild()
+	}
+	m.mu.Unlock()
+
+	for _, client := range evicted {
+		_ = client.Close()
+	}
+}
+
+// reconnect attempts to reconnect an evicted backend
+func (m *MultiClient) reconnect(b *backend) {
+	defer m.wg.Done()
+
+	client, err := m.connect(b)
+
+	m.mu.Lock()
+	b.connecting = false
+	if m.closed.Load() || m.backends[b.addr] != b {
+		m.mu.Unlock()
+		if client != nil {
+			_ = client.Close()
+		}
+		return
+	}
+	if err != nil {
+		m.Logger().Debug().Err(err).Msgf("Error while reconnecting to backend %s", b.addr)
+		m.evict(b)
+		m.mu.Unlock()
+		return
+	}
+	m.Logger().Debug().Msgf("Reconnected to backend %s", b.addr)
+	b.client = client
+	b.backoff = 0
+	m.rebuild()
+	m.mu.Unlock()
+}
+
+// pick returns the healthy backend that a packet with the given key should be sent to,
+// skipping the given number of backends to fail over when a write fails
+func (m *MultiClient) pick(key []byte, skip int) (*backend, *Client, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.closed.Load() {
+		return nil, nil, MultiClientClosed
+	}
+	if len(m.healthy) == 0 {
+		return nil, nil, NoHealthyBackends
+	}
+
+	var b *backend
+	switch m.config.Strategy {
+	case RoundRobin:
+		b = m.healthy[(m.next.Add(1)-1)%uint64(len(m.healthy))]
+	case LeastOutstanding:
+		start := int((m.next.Add(1) - 1) % uint64(len(m.healthy)))
+		for i := 0; i < len(m.healthy); i++ {
+			candidate := m.healthy[(start+i)%len(m.healthy)]
+			if b == nil || candidate.outstanding.Load() < b.outstanding.Load() {
+				b = candidate
+			}
+		}
+	case ConsistentHash:
+		if key == nil {
+			return nil, nil, KeyRequired
+		}
+		// Failing over walks the ring to the next distinct backend, which is where the key would move if the backend was evicted
+		hash := hashKey(key)
+		start := sort.Search(len(m.ring), func(i int) bool { return m.ring[i].hash >= hash })
+		seen := make([]*backend, 0, skip+1)
+		for i := 0; i < len(m.ring) && len(seen) <= skip; i++ {
+			if candidate := m.ring[(start+i)%len(m.ring)].backend; indexOf(seen, candidate) < 0 {
+				seen = append(seen, candidate)
+			}
+		}
+		b = seen[len(seen)-1]
+		return b, b.client, nil
+	}
+	b = m.healthy[(indexOf(m.healthy, b)+skip)%len(m.healthy)]
+	return b, b.client, nil
+}
+
+// WritePacket sends a frisbee packet.Packet to one of the healthy backends, chosen using the RoundRobin or LeastOutstanding
+// Strategy. If writing to a backend fails, the packet is written to the next healthy backend instead.
+func (m *MultiClient) WritePacket(p *packet.Packet) error {
+	return m.WritePacketKey(nil, p)
+}
+
+// WritePacketKey sends a frisbee packet.Packet to one of the healthy backends. When using the ConsistentHash Strategy,
+// the backend is chosen by hashing the key, and otherwise the key is ignored. If writing to a backend fails,
+// the packet is written to the next healthy backend instead.
+func (m *MultiClient) WritePacketKey(key []byte, p *packet.Packet) error {
+	var err error
+	for skip := 0; ; skip++ {
+		m.mu.RLock()
+		healthy := len(m.healthy)
+		m.mu.RUnlock()
+		if skip > 0 && skip >= healthy {
+			return err
+		}
+
+		var b *backend
+		var client *Client
+		b, client, err = m.pick(key, skip)
+		if err != nil {
+			return err
+		}
+		tracked := m.config.Strategy == LeastOutstanding
+		if tracked {
+			b.track(p.Metadata.Id)
+		}
+		err = client.WritePacket(p)
+		if err == nil {
+			return nil
+		}
+		if tracked {
+			b.answer(p.Metadata.Id)
+		}
+		if errors.Is(err, InvalidOperation) || errors.Is(err, InvalidContentLength) {
+			return err
+		}
+	}
+}
+
+// Flush flushes any queued frisbee Packets to every healthy backend
+func (m *MultiClient) Flush() error {
+	m.mu.RLock()
+	clients := make([]*Client, 0, len(m.healthy))
+	for _, b := range m.healthy {
+		clients = append(clients, b.client)
+	}
+	m.mu.RUnlock()
+
+	var errs []error
+	for _, client := range clients {
+		if err := client.Flush(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// SetHandlerTable replaces the handler table used by every backend with a copy of the given one,
+// and can be called while the MultiClient is connected
+func (m *MultiClient) SetHandlerTable(handlerTable HandlerTable) error {
+	return m.handlerTable.store(handlerTable)
+}
+
+// GetHandlerTable returns a copy of the handler table used by every backend
+func (m *MultiClient) GetHandlerTable() HandlerTable {
+	return m.handlerTable.load()
+}
+
+// RegisterHandler adds (or replaces) the handler for the given operation on every backend,
+// and can be called while the MultiClient is connected
+func (m *MultiClient) RegisterHandler(operation uint16, handler Handler) error {
+	return m.handlerTable.register(operation, handler)
+}
+
+// UnregisterHandler removes the handler for the given operation on every backend,
+// and can be called while the MultiClient is connected
+func (m *MultiClient) UnregisterHandler(operation uint16) {
+	m.handlerTable.unregister(operation)
+}
+
+// Healthy returns the addresses of the backends that are currently healthy
+func (m *MultiClient) Healthy() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	addrs := make([]string, 0, len(m.healthy))
+	for _, b := range m.healthy {
+		addrs = append(addrs, b.addr)
+	}
+	return addrs
+}
+
+// Logger returns the logger used by the MultiClient and its Clients
+func (m *MultiClient) Logger() types.Logger {
+	return m.logger
+}
+
+// Close closes the connections to every backend and stops checking their health
+func (m *MultiClient) Close() error {
+	if !m.closed.CompareAndSwap(false, true) {
+		return MultiClientClosed
+	}
+	m.baseContextCancel()
+
+	m.mu.Lock()
+	var clients []*Client
+	for _, b := range m.backends {
+		if b.client != nil {
+			clients = append(clients, b.client)
+			b.client = nil
+		}
+	}
+	m.rebuild()
+	m.mu.Unlock()
+
+	for _, client := range clients {
+		_ = client.Close()
+	}
+	m.wg.Wait()
+	return nil
+}
+
+// track records that a packet with the given ID was sent to the backend
+func (b *backend) track(id uint16) {
+	b.pendingMu.Lock()
+	b.pending[id] = append(b.pending[id], time.Now())
+	b.pendingMu.Unlock()
+	b.outstanding.Add(1)
+}
+
+// answer records that a packet with the given ID was received from the backend,
+// which answers the oldest packet with the same ID that was sent to it
+func (b *backend) answer(id uint16) {
+	b.pendingMu.Lock()
+	if sent, ok := b.pending[id]; ok {
+		if len(sent) == 1 {
+			delete(b.pending, id)
+		} else {
+			b.pending[id] = sent[1:]
+		}
+		b.outstanding.Add(-1)
+	}
+	b.pendingMu.Unlock()
+}
+
+// expire forgets about the packets that were sent to the backend before the deadline without receiving a response
+func (b *backend) expire(deadline time.Time) {
+	b.pendingMu.Lock()
+	for id, sent := range b.pending {
+		expired := 0
+		for expired < len(sent) && sent[expired].Before(deadline) {
+			expired++
+		}
+		if expired == len(sent) {
+			delete(b.pending, id)
+		} else {
+			b.pending[id] = sent[expired:]
+		}
+		b.outstanding.Add(-int64(expired))
+	}
+	b.pendingMu.Unlock()
+}
+
+// reset forgets about every packet that was sent to the backend
+func (b *backend) reset() {
+	b.pendingMu.Lock()
+	clear(b.pending)
+	b.outstanding.Store(0)
+	b.pendingMu.Unlock()
+}
+
+// hashKey returns the 64-bit FNV-1a hash of the key
+func hashKey(key []byte) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write(key)
+	return h.Sum64()
+}
+
+// indexOf returns the index of the backend in the list, or -1 if it is not in the list
+func indexOf(backends []*backend, b *backend) int {
+	for i, candidate := range backends {
+		if candidate == b {
+			return i
+		}
+	}
+	return -1
+}
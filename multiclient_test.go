@@ -0,0 +1,373 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package frisbee
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/loopholelabs/logging"
+
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+// multiClientTestServer is a frisbee Server that counts the packets it receives,
+// and responds to them if respond is true
+type multiClientTestServer struct {
+	server   *Server
+	addr     string
+	received atomic.Int64
+}
+
+func startMultiClientTestServer(t *testing.T, addr string, respond bool) *multiClientTestServer {
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+	m := new(multiClientTestServer)
+	var err error
+	m.server, err = NewServer(HandlerTable{
+		10: func(_ context.Context, incoming *packet.Packet) (*packet.Packet, Action) {
+			m.received.Add(1)
+			if respond {
+				return incoming, NONE
+			}
+			return nil, NONE
+		},
+	}, context.Background(), WithLogger(emptyLogger))
+	require.NoError(t, err)
+	m.server.SetConcurrency(1)
+
+	listener, err := net.Listen("tcp", addr)
+	require.NoError(t, err)
+	m.addr = listener.Addr().String()
+	go func() {
+		_ = m.server.StartWithListener(listener)
+	}()
+	<-m.server.started()
+	return m
+}
+
+func writeMultiClientTestPacket(t *testing.T, m *MultiClient, id uint16, key []byte) {
+	p := packet.Get()
+	p.Metadata.Id = id
+	p.Metadata.Operation = 10
+	require.NoError(t, m.WritePacketKey(key, p))
+	packet.Put(p)
+}
+
+func TestMultiClientRoundRobin(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+
+	servers := make([]*multiClientTestServer, 3)
+	addrs := make([]string, len(servers))
+	for i := range servers {
+		servers[i] = startMultiClientTestServer(t, "127.0.0.1:0", false)
+		addrs[i] = servers[i].addr
+	}
+
+	_, err := NewMultiClient(make(HandlerTable), context.Background(), MultiClientOptions{Strategy: Strategy(10)})
+	assert.ErrorIs(t, err, InvalidStrategy)
+
+	m, err := NewMultiClient(make(HandlerTable), context.Background(), MultiClientOptions{}, WithLogger(emptyLogger))
+	require.NoError(t, err)
+	assert.ErrorIs(t, m.Connect(), NoBackends)
+	require.NoError(t, m.Connect(addrs...))
+	assert.ElementsMatch(t, addrs, m.Healthy())
+
+	for i := 0; i < 30; i++ {
+		writeMultiClientTestPacket(t, m, uint16(i), nil)
+	}
+	require.NoError(t, m.Flush())
+
+	for _, s := range servers {
+		require.Eventually(t, func() bool {
+			return s.received.Load() == 10
+		}, DefaultDeadline, time.Millisecond*10)
+	}
+
+	require.NoError(t, m.Close())
+	assert.ErrorIs(t, m.Close(), MultiClientClosed)
+	for _, s := range servers {
+		require.NoError(t, s.server.Shutdown())
+	}
+}
+
+func TestMultiClientLeastOutstanding(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+
+	slow := startMultiClientTestServer(t, "127.0.0.1:0", false)
+	fast := startMultiClientTestServer(t, "127.0.0.1:0", true)
+
+	var responses atomic.Int64
+	m, err := NewMultiClient(HandlerTable{
+		10: func(_ context.Context, _ *packet.Packet) (*packet.Packet, Action) {
+			responses.Add(1)
+			return nil, NONE
+		},
+	}, context.Background(), MultiClientOptions{Strategy: LeastOutstanding}, WithLogger(emptyLogger))
+	require.NoError(t, err)
+	require.NoError(t, m.Connect(slow.addr, fast.addr))
+
+	// Packets that the slow server never responds to stay outstanding, so every
+	// packet after the first one that is sent to it is sent to the fast server
+	for i := 0; i < 20; i++ {
+		writeMultiClientTestPacket(t, m, uint16(i), nil)
+		require.NoError(t, m.Flush())
+		require.Eventually(t, func() bool {
+			return responses.Load()+slow.received.Load() == int64(i+1)
+		}, DefaultDeadline, time.Millisecond)
+	}
+	assert.LessOrEqual(t, slow.received.Load(), int64(1))
+	assert.GreaterOrEqual(t, fast.received.Load(), int64(19))
+
+	require.NoError(t, m.Close())
+	require.NoError(t, slow.server.Shutdown())
+	require.NoError(t, fast.server.Shutdown())
+}
+
+func TestMultiClientResponseTimeout(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+
+	slow := startMultiClientTestServer(t, "127.0.0.1:0", false)
+
+	m, err := NewMultiClient(make(HandlerTable), context.Background(), MultiClientOptions{
+		Strategy:        LeastOutstanding,
+		CheckInterval:   time.Millisecond * 10,
+		HealthTimeout:   DefaultDeadline,
+		ResponseTimeout: time.Millisecond * 50,
+	}, WithLogger(emptyLogger))
+	require.NoError(t, err)
+	require.NoError(t, m.Connect(slow.addr))
+
+	// Packets that are never answered stop counting as outstanding once the ResponseTimeout has passed
+	for i := 0; i < 5; i++ {
+		writeMultiClientTestPacket(t, m, uint16(i%2), nil)
+	}
+	m.mu.RLock()
+	b := m.backends[slow.addr]
+	m.mu.RUnlock()
+	assert.Equal(t, int64(5), b.outstanding.Load())
+	require.Eventually(t, func() bool {
+		b.pendingMu.Lock()
+		defer b.pendingMu.Unlock()
+		return b.outstanding.Load() == 0 && len(b.pending) == 0
+	}, DefaultDeadline, time.Millisecond*10)
+
+	require.NoError(t, m.Close())
+	require.NoError(t, slow.server.Shutdown())
+}
+
+func TestMultiClientConsistentHash(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+
+	servers := make([]*multiClientTestServer, 3)
+	addrs := make([]string, len(servers))
+	for i := range servers {
+		servers[i] = startMultiClientTestServer(t, "127.0.0.1:0", false)
+		addrs[i] = servers[i].addr
+	}
+
+	m, err := NewMultiClient(make(HandlerTable), context.Background(), MultiClientOptions{Strategy: ConsistentHash}, WithLogger(emptyLogger))
+	require.NoError(t, err)
+	require.NoError(t, m.Connect(addrs...))
+
+	p := packet.Get()
+	p.Metadata.Operation = 10
+	assert.ErrorIs(t, m.WritePacket(p), KeyRequired)
+	packet.Put(p)
+
+	for i := 0; i < 10; i++ {
+		writeMultiClientTestPacket(t, m, uint16(i), []byte("key"))
+	}
+	require.NoError(t, m.Flush())
+
+	require.Eventually(t, func() bool {
+		var total int64
+		for _, s := range servers {
+			total += s.received.Load()
+		}
+		return total == 10
+	}, DefaultDeadline, time.Millisecond*10)
+
+	var receivers int
+	for _, s := range servers {
+		if received := s.received.Load(); received > 0 {
+			assert.Equal(t, int64(10), received)
+			receivers++
+		}
+	}
+	assert.Equal(t, 1, receivers)
+
+	require.NoError(t, m.Close())
+	for _, s := range servers {
+		require.NoError(t, s.server.Shutdown())
+	}
+}
+
+// reusableListener is a net.Listener that only stops accepting connections when it is closed,
+// so that it can be used by another Server afterwards without giving up its port
+type reusableListener struct {
+	*net.TCPListener
+}
+
+func (l reusableListener) Close() error {
+	return l.SetDeadline(time.Unix(1, 0))
+}
+
+func TestMultiClientFailover(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	startFirst := func() *Server {
+		s, err := NewServer(HandlerTable{}, context.Background(), WithLogger(emptyLogger))
+		require.NoError(t, err)
+		require.NoError(t, listener.(*net.TCPListener).SetDeadline(time.Time{}))
+		go func() {
+			_ = s.StartWithListener(reusableListener{listener.(*net.TCPListener)})
+		}()
+		<-s.started()
+		return s
+	}
+	first := startFirst()
+	firstAddr := listener.Addr().String()
+	second := startMultiClientTestServer(t, "127.0.0.1:0", false)
+
+	m, err := NewMultiClient(make(HandlerTable), context.Background(), MultiClientOptions{
+		CheckInterval: time.Millisecond * 10,
+		MinBackoff:    time.Minute,
+	}, WithLogger(emptyLogger))
+	require.NoError(t, err)
+	require.NoError(t, m.Connect(firstAddr, second.addr))
+
+	require.NoError(t, first.Shutdown())
+	require.Eventually(t, func() bool {
+		healthy := m.Healthy()
+		return len(healthy) == 1 && healthy[0] == second.addr
+	}, DefaultDeadline, time.Millisecond*10)
+
+	for i := 0; i < 10; i++ {
+		writeMultiClientTestPacket(t, m, uint16(i), nil)
+	}
+	require.NoError(t, m.Flush())
+	require.Eventually(t, func() bool {
+		return second.received.Load() == 10
+	}, DefaultDeadline, time.Millisecond*10)
+
+	// Once the server is back, the backend is reconnected when its backoff elapses
+	restarted := startFirst()
+	m.mu.Lock()
+	assert.Equal(t, time.Minute, m.backends[firstAddr].backoff)
+	m.backends[firstAddr].retryAt = time.Now()
+	m.mu.Unlock()
+	require.Eventually(t, func() bool {
+		return len(m.Healthy()) == 2
+	}, DefaultDeadline, time.Millisecond*10)
+
+	require.NoError(t, m.Close())
+	require.NoError(t, restarted.Shutdown())
+	require.NoError(t, listener.Close())
+	require.NoError(t, second.server.Shutdown())
+}
+
+func TestMultiClientPingFailure(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+
+	healthy := startMultiClientTestServer(t, "127.0.0.1:0", false)
+
+	// The blackhole accepts connections but never responds to PINGs
+	blackhole, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	var acceptedMu sync.Mutex
+	var accepted []net.Conn
+	go func() {
+		for {
+			conn, err := blackhole.Accept()
+			if err != nil {
+				return
+			}
+			acceptedMu.Lock()
+			accepted = append(accepted, conn)
+			acceptedMu.Unlock()
+		}
+	}()
+
+	m, err := NewMultiClient(make(HandlerTable), context.Background(), MultiClientOptions{
+		HealthTimeout: time.Millisecond * 100,
+		CheckInterval: time.Millisecond * 10,
+		MinBackoff:    time.Minute,
+	}, WithLogger(emptyLogger))
+	require.NoError(t, err)
+	require.NoError(t, m.Connect(healthy.addr, blackhole.Addr().String()))
+	assert.Len(t, m.Healthy(), 2)
+
+	require.Eventually(t, func() bool {
+		h := m.Healthy()
+		return len(h) == 1 && h[0] == healthy.addr
+	}, DefaultDeadline, time.Millisecond*10)
+
+	require.NoError(t, m.Close())
+	require.NoError(t, healthy.server.Shutdown())
+	require.NoError(t, blackhole.Close())
+	acceptedMu.Lock()
+	for _, conn := range accepted {
+		_ = conn.Close()
+	}
+	acceptedMu.Unlock()
+}
+
+func TestMultiClientResolver(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+
+	first := startMultiClientTestServer(t, "127.0.0.1:0", false)
+	second := startMultiClientTestServer(t, "127.0.0.1:0", false)
+
+	var addrsMu sync.Mutex
+	addrs := []string{first.addr}
+	resolver := func(context.Context) ([]string, error) {
+		addrsMu.Lock()
+		defer addrsMu.Unlock()
+		return append([]string(nil), addrs...), nil
+	}
+
+	m, err := NewMultiClient(make(HandlerTable), context.Background(), MultiClientOptions{
+		CheckInterval:   time.Millisecond * 10,
+		ResolveInterval: time.Millisecond * 10,
+	}, WithLogger(emptyLogger))
+	require.NoError(t, err)
+	assert.ErrorIs(t, m.ConnectResolver(nil), ResolverNil)
+	require.NoError(t, m.ConnectResolver(resolver))
+	assert.Equal(t, []string{first.addr}, m.Healthy())
+
+	addrsMu.Lock()
+	addrs = []string{second.addr}
+	addrsMu.Unlock()
+
+	require.Eventually(t, func() bool {
+		h := m.Healthy()
+		return len(h) == 1 && h[0] == second.addr
+	}, DefaultDeadline, time.Millisecond*10)
+
+	require.NoError(t, m.Close())
+	require.NoError(t, first.server.Shutdown())
+	require.NoError(t, second.server.Shutdown())
+}
@@ -0,0 +1,141 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package frisbee
+
+import (
+	"errors"
+	"time"
+
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+var (
+	HeadersNotNegotiated = errors.New("packet headers cannot be sent before the peer has advertised extensions")
+)
+
+// ExtensionsTimeout is how long Client.Connect and Client.FromConn wait for the server to advertise extensions (see WithExtensions)
+const ExtensionsTimeout = time.Second * 5
+
+// These are the fields of the content of NEGOTIATE packets, which are encoded like packet extensions:
+const (
+	// negotiateCompression lists the compression algorithms that the sender supports, in order of preference
+	negotiateCompression = uint8(1)
+
+	// negotiateVersion is the highest header version that the sender supports
+	negotiateVersion = uint8(2)
+
+	// negotiateSwitch is the header version that the sender uses for every packet that follows
+	negotiateSwitch = uint8(3)
+
+	// negotiateChecksums means that the sender supports checksums, or in a NEGOTIATE packet that also contains
+	// negotiateSwitch, that the sender appends a checksum to every packet that follows
+	negotiateChecksums = uint8(4)
+
+	// negotiateExtensions means that the sender can receive packet headers and trace contexts (see WithExtensions)
+	negotiateExtensions = uint8(5)
+)
+
+// negotiation is the decoded content of a NEGOTIATE packet
+type negotiation struct {
+	compression    []byte
+	hasCompression bool
+	version        uint8
+	switchVersion  uint8
+	checksums      bool
+	extensions     bool
+}
+
+// headerVersion returns the highest header version that is enabled by the Options
+func headerVersion(options *Options) uint8 {
+	if options.HeaderVersion >= metadata.Version2 {
+		return metadata.Version2
+	}
+	return metadata.Version1
+}
+
+// WithExtensions makes the connections of the frisbee client or server advertise that they can receive packet headers
+// (see packet.Packet.Headers) and trace contexts (see InjectTrace) with a NEGOTIATE packet as soon as they are opened.
+//
+// Headers and trace contexts are only sent to peers that have advertised that they support them, so that peers which do not
+// understand them never receive them. Connections advertise it whenever they send a NEGOTIATE packet (which they also do when compression, the version
+// 2 header, or checksums are enabled), and reply to a peer's advertisement with their own, so it is enough for one side of a
+// connection to use this option.
+//
+// Writing a packet with headers before the peer's advertisement has been received fails with HeadersNotNegotiated, and trace
+// contexts are not sent until then. To avoid this, Client.Connect and Client.FromConn wait for up to ExtensionsTimeout for the
+// server's advertisement when this option is used, and Async.ExtensionsChannel can be used to wait for it on other connections.
+func WithExtensions() Option {
+	return func(opts *Options) {
+		opts.Extensions = true
+	}
+}
+
+// newNegotiatePacket returns a NEGOTIATE packet that advertises the compression algorithms, highest header version, checksums,
+// and packet extensions that are supported, or nil if there is nothing to advertise because neither compression, the version 2
+// header, checksums, nor extensions are enabled
+func newNegotiatePacket(compressor *compressor, version uint8, checksums bool, extensions bool) *packet.Packet {
+	var fields metadata.Extensions
+	if compressor != nil {
+		_ = fields.Set(negotiateCompression, compressor.advertisement())
+	}
+	if version >= metadata.Version2 {
+		_ = fields.Set(negotiateVersion, []byte{version})
+	}
+	if checksums {
+		_ = fields.Set(negotiateChecksums, nil)
+	}
+	if len(fields) == 0 && !extensions {
+		return nil
+	}
+	_ = fields.Set(negotiateExtensions, nil)
+	return newNegotiateFieldsPacket(fields)
+}
+
+// newSwitchPacket returns a NEGOTIATE packet that tells the receiver that the sender uses the
+// given header version (and appends checksums if they are used) for every packet that follows
+func newSwitchPacket(version uint8, checksums bool) *packet.Packet {
+	fields := metadata.Extensions{{Type: negotiateSwitch, Value: []byte{version}}}
+	if checksums {
+		fields = append(fields, metadata.Extension{Type: negotiateChecksums})
+	}
+	return newNegotiateFieldsPacket(fields)
+}
+
+// negotiatedFormat returns the header version and whether checksums are used for the packets sent to the other side of
+// a connection, given the highest header version and checksums that are enabled locally and the advertisement of the other side
+func negotiatedFormat(n negotiation, version uint8, checksums bool) (uint8, bool) {
+	if n.version >= metadata.Version2 && version >= metadata.Version2 {
+		return metadata.Version2, n.checksums && checksums
+	}
+	return metadata.Version1, n.checksums && checksums
+}
+
+func newNegotiateFieldsPacket(fields metadata.Extensions) *packet.Packet {
+	p := packet.Get()
+	p.Metadata.Operation = NEGOTIATE
+	p.Content.Write(fields.Encode(nil))
+	p.Metadata.ContentLength = uint32(p.Content.Len())
+	return p
+}
+
+// decodeNegotiation decodes the content of a NEGOTIATE packet
+func decodeNegotiation(content []byte) (n negotiation, err error) {
+	var fields metadata.Extensions
+	if err = fields.Decode(content); err != nil {
+		return
+	}
+	n.compression, n.hasCompression = fields.Get(negotiateCompression)
+	_, n.checksums = fields.Get(negotiateChecksums)
+	_, n.extensions = fields.Get(negotiateExtensions)
+	if value, ok := fields.Get(negotiateVersion); ok && len(value) == 1 {
+		n.version = value[0]
+	}
+	if value, ok := fields.Get(negotiateSwitch); ok {
+		if len(value) != 1 || value[0] < metadata.Version1 || value[0] > metadata.Version2 {
+			return n, metadata.DecodingErr
+		}
+		n.switchVersion = value[0]
+	}
+	return
+}
@@ -8,6 +8,8 @@ import (
 
 	"github.com/loopholelabs/logging/loggers/noop"
 	"github.com/loopholelabs/logging/types"
+
+	"github.com/loopholelabs/frisbee-go/pkg/outbox"
 )
 
 // Option is used to generate frisbee client and server options internally
@@ -20,11 +22,25 @@ type Option func(opts *Options)
 //	options := Options {
 //		KeepAlive: time.Minute * 3,
 //		Logger: &DefaultLogger,
+//		Metrics: noopMetrics{},
 //	}
 type Options struct {
-	KeepAlive time.Duration
-	Logger    types.Logger
-	TLSConfig *tls.Config
+	KeepAlive     time.Duration
+	Logger        types.Logger
+	TLSConfig     *tls.Config
+	Metrics       Metrics
+	Tracer        Tracer
+	Tap           Tap
+	Compression   *CompressionOptions
+	HeaderVersion uint8
+	Checksums     bool
+	Extensions    bool
+	PSK           []byte
+	Sessions      *SessionOptions
+	Reliable      *ReliableOptions
+	Outbox        *outbox.Options
+
+	BroadcastQueueSize int
 }
 
 func loadOptions(options ...Option) *Options {
@@ -41,6 +57,10 @@ func loadOptions(options ...Option) *Options {
 		opts.KeepAlive = time.Minute * 3
 	}
 
+	if opts.Metrics == nil {
+		opts.Metrics = noopMetrics{}
+	}
+
 	return opts
 }
 
@@ -68,8 +88,44 @@ func WithLogger(logger types.Logger) Option {
 // WithTLS sets the TLS configuration for Frisbee. By default, no TLS configuration is used, and
 // Frisbee will use unencrypted TCP connections. If the Frisbee Server is using TLS, then you must pass in
 // a TLS config (even an empty one `&tls.Config{}`) for the Frisbee Client.
+//
+// To rotate certificates without restarting, use a config returned by CertificateReloader.TLSConfig.
 func WithTLS(tlsConfig *tls.Config) Option {
 	return func(opts *Options) {
 		opts.TLSConfig = tlsConfig
 	}
 }
+
+// WithMetrics sets the Metrics implementation that the frisbee client or server (and their connections and streams)
+// report to. By default, nothing is reported.
+func WithMetrics(metrics Metrics) Option {
+	return func(opts *Options) {
+		opts.Metrics = metrics
+	}
+}
+
+// WithTracer sets the Tracer that the frisbee client or server uses to start and end spans
+// around the execution of Handler functions. By default, no spans are created.
+func WithTracer(tracer Tracer) Option {
+	return func(opts *Options) {
+		opts.Tracer = tracer
+	}
+}
+
+// WithTap sets the Tap that observes every packet read from or written to the connections
+// of the frisbee client or server. By default, no Tap is used.
+func WithTap(tap Tap) Option {
+	return func(opts *Options) {
+		opts.Tap = tap
+	}
+}
+
+// WithHeaderVersion sets the highest packet header version that the connections of the frisbee client or server
+// negotiate. By default, only the version 1 header (metadata.Version1) is used. When metadata.Version2 is set and
+// the other side of a connection supports it too, packets are sent using metadata.HeaderV2, which allows 32-bit
+// packet IDs (see metadata.Metadata.SetExtendedId), otherwise the version 1 header is used so older peers keep working.
+func WithHeaderVersion(version uint8) Option {
+	return func(opts *Options) {
+		opts.HeaderVersion = version
+	}
+}
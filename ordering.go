@@ -0,0 +1,201 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package frisbee
+
+import (
+	"context"
+	"sync"
+
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+// OrderingKey returns the key that is used to order an incoming packet (see Server.SetOrdering).
+// Packets from the same connection with the same key are handled one at a time, in the order that they arrived.
+type OrderingKey func(p *packet.Packet) uint64
+
+// OrderByID orders packets that share a Metadata.Id
+func OrderByID(p *packet.Packet) uint64 {
+	return uint64(p.Metadata.Id)
+}
+
+// OrderByOperation orders packets that share a Metadata.Operation
+func OrderByOperation(p *packet.Packet) uint64 {
+	return uint64(p.Metadata.Operation)
+}
+
+// SetOrdering makes the server handle packets from the same connection that share an OrderingKey strictly in
+// the order that they arrived, while packets with different keys are still handled concurrently. If key is nil
+// (the default), packets are handled in whatever order their goroutines are scheduled.
+//
+// Ordering only applies when the server's concurrency is not 1 (see SetConcurrency), since packets are otherwise
+// already handled in order, and does not apply to operations with their own limit (see SetOperationLimit). Packets only
+// count towards the concurrency while they are being handled, so packets that are queued behind their key do not prevent
+// packets with other keys from being handled. At most 256 packets are queued for each key, after which the server stops
+// reading from the connection until the key's queue has space again.
+//
+// This function should not be called once the server has started.
+func (s *Server) SetOrdering(key OrderingKey) {
+	s.orderingKey = key
+}
+
+// maxKeyQueueSize is the maximum number of packets that can be queued behind the packet that is being handled for each
+// OrderingKey, after which the server stops reading from the connection until the key's queue has space again
+const maxKeyQueueSize = 1 << 8
+
+// keyedDispatcher runs a handler for the packets of one connection, so that packets
+// with the same key are handled one at a time while packets with different keys run concurrently
+type keyedDispatcher struct {
+	key     OrderingKey
+	handle  func(*packet.Packet)
+	limiter chan struct{}
+	done    <-chan struct{}
+	wg      *sync.WaitGroup
+	mu      sync.Mutex
+	queues  map[uint64]*keyQueue
+}
+
+// keyQueue holds the packets that are waiting behind the packet that is being handled for a key
+type keyQueue struct {
+	packets []*packet.Packet
+
+	// space is closed (and replaced with nil) when a packet is taken from the queue while dispatch is waiting for it to have space
+	space chan struct{}
+}
+
+// newDispatcher returns a function that takes ownership of a packet and handles it in its own goroutine once the limiter
+// (if it is not nil) has a free slot, and orders the packets if the server has an OrderingKey. The wait group is
+// incremented for every packet that is dispatched, and the returned function returns false (without dispatching the
+// packet) if ctx is done before the packet could be dispatched.
+func (s *Server) newDispatcher(ctx context.Context, wg *sync.WaitGroup, limiter chan struct{}, handle func(*packet.Packet)) func(*packet.Packet) bool {
+	if s.orderingKey == nil {
+		if limiter == nil {
+			return func(p *packet.Packet) bool {
+				wg.Add(1)
+				go handle(p)
+				return true
+			}
+		}
+		return func(p *packet.Packet) bool {
+			select {
+			case limiter <- struct{}{}:
+				wg.Add(1)
+				go func() {
+					handle(p)
+					<-limiter
+				}()
+				return true
+			case <-ctx.Done():
+				packet.Put(p)
+				return false
+			}
+		}
+	}
+	d := &keyedDispatcher{
+		key:     s.orderingKey,
+		handle:  handle,
+		limiter: limiter,
+		done:    ctx.Done(),
+		wg:      wg,
+		queues:  make(map[uint64]*keyQueue),
+	}
+	return d.dispatch
+}
+
+// dispatch queues the packet behind any other packets with the same key (waiting for the key's queue to have space
+// if it is full), and starts a goroutine to handle the key's packets if there isn't one already
+func (d *keyedDispatcher) dispatch(p *packet.Packet) bool {
+	key := d.key(p)
+	d.wg.Add(1)
+	for {
+		d.mu.Lock()
+		queue, ok := d.queues[key]
+		if !ok {
+			d.queues[key] = new(keyQueue)
+			d.mu.Unlock()
+			go d.run(key, p)
+			return true
+		}
+		if len(queue.packets) < maxKeyQueueSize {
+			queue.packets = append(queue.packets, p)
+			d.mu.Unlock()
+			return true
+		}
+		if queue.space == nil {
+			queue.space = make(chan struct{})
+		}
+		space := queue.space
+		d.mu.Unlock()
+		select {
+		case <-space:
+		case <-d.done:
+			packet.Put(p)
+			d.wg.Done()
+			return false
+		}
+	}
+}
+
+// run handles the packets for a key until its queue is empty. A slot is only taken from the limiter while
+// one of the key's packets is being handled, so that keys with queued packets do not starve the other keys.
+func (d *keyedDispatcher) run(key uint64, p *packet.Packet) {
+	for {
+		if !d.acquire() {
+			d.discard(key, p)
+			return
+		}
+		d.handle(p)
+		if d.limiter != nil {
+			<-d.limiter
+		}
+		d.mu.Lock()
+		queue := d.queues[key]
+		if len(queue.packets) == 0 {
+			delete(d.queues, key)
+			d.mu.Unlock()
+			return
+		}
+		p = queue.packets[0]
+		queue.packets[0] = nil
+		queue.packets = queue.packets[1:]
+		if queue.space != nil {
+			close(queue.space)
+			queue.space = nil
+		}
+		d.mu.Unlock()
+	}
+}
+
+// acquire takes a slot from the limiter (if there is one), and returns false if the connection is done
+func (d *keyedDispatcher) acquire() bool {
+	select {
+	case <-d.done:
+		return false
+	default:
+	}
+	if d.limiter == nil {
+		return true
+	}
+	select {
+	case d.limiter <- struct{}{}:
+		return true
+	case <-d.done:
+		return false
+	}
+}
+
+// discard releases the given packet and every packet queued for the key, since the connection is done
+func (d *keyedDispatcher) discard(key uint64, p *packet.Packet) {
+	packet.Put(p)
+	d.wg.Done()
+	d.mu.Lock()
+	queue := d.queues[key]
+	for _, p = range queue.packets {
+		packet.Put(p)
+		d.wg.Done()
+	}
+	delete(d.queues, key)
+	if queue.space != nil {
+		close(queue.space)
+	}
+	d.mu.Unlock()
+}
@@ -0,0 +1,189 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package frisbee
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/loopholelabs/logging"
+	"github.com/loopholelabs/testing/conn/pair"
+
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+func testOrdering(t *testing.T, concurrency uint64) {
+	const keys = 8
+	const packetsPerKey = 25
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+
+	var mu sync.Mutex
+	sequences := make(map[uint16][]byte)
+	var running, maxRunning, handled atomic.Int64
+	s, err := NewServer(HandlerTable{
+		10: func(_ context.Context, incoming *packet.Packet) (*packet.Packet, Action) {
+			current := running.Add(1)
+			for {
+				previous := maxRunning.Load()
+				if current <= previous || maxRunning.CompareAndSwap(previous, current) {
+					break
+				}
+			}
+			time.Sleep(time.Duration(incoming.Content.Bytes()[0]%3) * time.Millisecond)
+			mu.Lock()
+			sequences[incoming.Metadata.Id] = append(sequences[incoming.Metadata.Id], incoming.Content.Bytes()[0])
+			mu.Unlock()
+			running.Add(-1)
+			handled.Add(1)
+			return nil, NONE
+		},
+	}, context.Background(), WithLogger(emptyLogger))
+	require.NoError(t, err)
+	s.SetConcurrency(concurrency)
+	s.SetOrdering(OrderByID)
+
+	c, err := NewClient(HandlerTable{}, context.Background(), WithLogger(emptyLogger))
+	require.NoError(t, err)
+
+	serverConn, clientConn, err := pair.New()
+	require.NoError(t, err)
+	go s.ServeConn(serverConn)
+	require.NoError(t, c.FromConn(clientConn))
+
+	p := packet.Get()
+	p.Metadata.Operation = 10
+	p.Metadata.ContentLength = 1
+	for i := 0; i < packetsPerKey; i++ {
+		for id := uint16(0); id < keys; id++ {
+			p.Metadata.Id = id
+			p.Content.Reset()
+			p.Content.Write([]byte{byte(i)})
+			require.NoError(t, c.WritePacket(p))
+		}
+	}
+	packet.Put(p)
+	require.NoError(t, c.Flush())
+
+	require.Eventually(t, func() bool {
+		return handled.Load() == keys*packetsPerKey
+	}, DefaultDeadline, time.Millisecond*10)
+
+	expected := make([]byte, packetsPerKey)
+	for i := range expected {
+		expected[i] = byte(i)
+	}
+	mu.Lock()
+	for id := uint16(0); id < keys; id++ {
+		assert.Equal(t, expected, sequences[id], "packets with id %d were handled out of order", id)
+	}
+	mu.Unlock()
+	assert.Greater(t, maxRunning.Load(), int64(1))
+
+	require.NoError(t, c.Close())
+	require.NoError(t, s.Shutdown())
+}
+
+func TestOrderingUnlimited(t *testing.T) {
+	t.Parallel()
+	testOrdering(t, 0)
+}
+
+func TestOrderingLimited(t *testing.T) {
+	t.Parallel()
+	testOrdering(t, 4)
+}
+
+func TestOrderingKeys(t *testing.T) {
+	t.Parallel()
+
+	p := packet.Get()
+	p.Metadata.Id = 32
+	p.Metadata.Operation = 64
+	assert.Equal(t, uint64(32), OrderByID(p))
+	assert.Equal(t, uint64(64), OrderByOperation(p))
+	packet.Put(p)
+}
+
+func TestOrderingBlockedKey(t *testing.T) {
+	t.Parallel()
+
+	const concurrency = 4
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+
+	release := make(chan struct{})
+	var mu sync.Mutex
+	handled := make(map[uint16]int)
+	s, err := NewServer(HandlerTable{
+		10: func(_ context.Context, incoming *packet.Packet) (*packet.Packet, Action) {
+			if incoming.Metadata.Id == 0 {
+				<-release
+			}
+			mu.Lock()
+			handled[incoming.Metadata.Id]++
+			mu.Unlock()
+			return nil, NONE
+		},
+	}, context.Background(), WithLogger(emptyLogger))
+	require.NoError(t, err)
+	s.SetConcurrency(concurrency)
+	s.SetOrdering(OrderByID)
+
+	c, err := NewClient(HandlerTable{}, context.Background(), WithLogger(emptyLogger))
+	require.NoError(t, err)
+
+	serverConn, clientConn, err := pair.New()
+	require.NoError(t, err)
+	go s.ServeConn(serverConn)
+	require.NoError(t, c.FromConn(clientConn))
+
+	p := packet.Get()
+	p.Metadata.Operation = 10
+	write := func(id uint16) {
+		p.Metadata.Id = id
+		require.NoError(t, c.WritePacket(p))
+	}
+
+	// The packets queued behind the blocked key do not hold slots, so the other keys are still handled
+	for i := 0; i < concurrency*2; i++ {
+		write(0)
+	}
+	for id := uint16(1); id <= concurrency; id++ {
+		write(id)
+	}
+	require.NoError(t, c.Flush())
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(handled) == concurrency
+	}, DefaultDeadline, time.Millisecond*10)
+
+	// Once the blocked key's queue is full, the server waits for it to have space before reading more packets
+	for i := 0; i < maxKeyQueueSize; i++ {
+		write(0)
+	}
+	write(1)
+	require.NoError(t, c.Flush())
+	time.Sleep(time.Millisecond * 50)
+	mu.Lock()
+	assert.Equal(t, 1, handled[1])
+	mu.Unlock()
+
+	close(release)
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return handled[0] == concurrency*2+maxKeyQueueSize && handled[1] == 2
+	}, DefaultDeadline, time.Millisecond*10)
+	packet.Put(p)
+
+	require.NoError(t, c.Close())
+	require.NoError(t, s.Shutdown())
+}
@@ -0,0 +1,60 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package frisbee
+
+import (
+	"errors"
+
+	"github.com/loopholelabs/frisbee-go/pkg/outbox"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+// WithOutbox enables a disk-backed outbox (see outbox.Outbox) for the frisbee client, which is stored in options.Dir.
+//
+// While the client is not connected (before Connect or FromConn is called, or while it is reconnecting, see WithSessions),
+// packets written with WritePacket are appended to the outbox instead of failing, and once the client is connected they are
+// drained in the order they were written before any new packets are sent. Since the outbox is stored on disk, packets that
+// were not drained when the process stopped are sent by the next client that uses the same directory.
+//
+// The outbox is limited to options.MaxSize bytes, after which WritePacket fails with outbox.FullErr, and packets that are
+// older than options.MaxAge are discarded instead of sent. Every client must use its own directory.
+func WithOutbox(options outbox.Options) Option {
+	return func(opts *Options) {
+		opts.Outbox = &options
+	}
+}
+
+// writeOutbox writes the packet to the current connection if the outbox is empty,
+// and appends it to the outbox if it is not or if the packet cannot be written
+func (c *Client) writeOutbox(p *packet.Packet) error {
+	if p.Metadata.Operation <= RESERVED9 {
+		return InvalidOperation
+	}
+	err := c.outbox.Send(p, func(p *packet.Packet) error {
+		conn := c.conn.Load()
+		if conn == nil {
+			return ConnectionNotInitialized
+		}
+		return conn.WritePacket(p)
+	})
+	if errors.Is(err, outbox.ClosedErr) {
+		return ConnectionClosed
+	}
+	return err
+}
+
+// drainOutbox starts sending the packets in the outbox to the given connection, until
+// the outbox is empty or the connection is closed
+func (c *Client) drainOutbox(conn *Async) {
+	if c.outbox == nil {
+		return
+	}
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		err := c.outbox.Drain(conn.WritePacket)
+		if err != nil && !errors.Is(err, ConnectionClosed) && !errors.Is(err, outbox.ClosedErr) {
+			c.Logger().Error().Err(err).Msg("error while draining outbox")
+		}
+	}()
+}
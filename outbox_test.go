@@ -0,0 +1,84 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package frisbee
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/loopholelabs/logging"
+	"github.com/loopholelabs/testing/conn/pair"
+
+	"github.com/loopholelabs/frisbee-go/pkg/outbox"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+func TestClientOutbox(t *testing.T) {
+	t.Parallel()
+
+	const testSize = 20
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+	options := outbox.Options{Dir: t.TempDir()}
+
+	received := make(chan uint16, testSize*2)
+	serverHandlerTable := make(HandlerTable)
+	serverHandlerTable[32] = func(_ context.Context, incoming *packet.Packet) (outgoing *packet.Packet, action Action) {
+		received <- incoming.Metadata.Id
+		return
+	}
+
+	s, err := NewServer(serverHandlerTable, context.Background(), WithLogger(emptyLogger))
+	require.NoError(t, err)
+	s.SetConcurrency(1)
+
+	// Packets that are written before the client is connected are kept in the outbox, even after it is closed
+	c, err := NewClient(make(HandlerTable), context.Background(), WithLogger(emptyLogger), WithOutbox(options))
+	require.NoError(t, err)
+
+	p := packet.Get()
+	p.Metadata.Operation = PING
+	assert.ErrorIs(t, c.WritePacket(p), InvalidOperation)
+	p.Metadata.Operation = 32
+	for i := 0; i < testSize/2; i++ {
+		p.Metadata.Id = uint16(i)
+		require.NoError(t, c.WritePacket(p))
+	}
+	require.NoError(t, c.Close())
+	assert.ErrorIs(t, c.WritePacket(p), ConnectionClosed)
+
+	c, err = NewClient(make(HandlerTable), context.Background(), WithLogger(emptyLogger), WithOutbox(options))
+	require.NoError(t, err)
+	for i := testSize / 2; i < testSize; i++ {
+		p.Metadata.Id = uint16(i)
+		require.NoError(t, c.WritePacket(p))
+	}
+
+	serverConn, clientConn, err := pair.New()
+	require.NoError(t, err)
+	go s.ServeConn(serverConn)
+	require.NoError(t, c.FromConn(clientConn))
+
+	// Packets written once the client is connected are sent after the ones in the outbox
+	for i := testSize; i < testSize*2; i++ {
+		p.Metadata.Id = uint16(i)
+		require.NoError(t, c.WritePacket(p))
+	}
+	packet.Put(p)
+
+	for i := 0; i < testSize*2; i++ {
+		select {
+		case id := <-received:
+			assert.Equal(t, uint16(i), id)
+		case <-time.After(time.Second * 5):
+			t.Fatal("timed out waiting for packets")
+		}
+	}
+
+	require.NoError(t, c.Close())
+	require.NoError(t, s.Shutdown())
+}
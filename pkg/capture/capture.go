@@ -0,0 +1,131 @@
+// SPDX-License-Identifier: Apache-2.0
+
+// Package capture implements the frisbee packet capture file format, which is used to record
+// the packets that cross a frisbee connection so that they can be inspected or replayed later.
+//
+// A capture file starts with an 8 byte file header, followed by any number of records.
+// All integers are encoded in big-endian byte order.
+//
+//	File Header (8 Bytes):
+//		Magic     [5]byte // "FBCAP"
+//		Version   uint8   // 2
+//		Reserved  uint16  // 0
+//
+//	Record (31 Bytes + Extensions + Content):
+//		Timestamp        int64  // Unix time in nanoseconds
+//		Direction        uint8  // 1 = read, 2 = write
+//		Connection       uint64 // ID of the connection the packet crossed
+//		Id               uint16 // Packet Metadata.Id
+//		Operation        uint16 // Packet Metadata.Operation
+//		ContentLength    uint32 // Packet Metadata.ContentLength
+//		ExtensionsLength uint32 // Length of the encoded packet Extensions (including its Headers)
+//		IdHigh           uint16 // Packet Metadata.IdHigh
+//		Extensions       [ExtensionsLength]byte
+//		Content          [ContentLength]byte
+//
+// Version 1 capture files can still be read, and their records do not have the IdHigh field.
+package capture
+
+import (
+	"errors"
+	"time"
+
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+var (
+	InvalidMagicErr     = errors.New("invalid capture file magic")
+	InvalidVersionErr   = errors.New("unsupported capture file version")
+	InvalidDirectionErr = errors.New("invalid capture record direction")
+	TruncatedRecordErr  = errors.New("truncated capture record")
+	RecordTooLargeErr   = errors.New("capture record is too large")
+)
+
+// MaxExtensionsLength is the largest ExtensionsLength of the records that a Reader accepts
+const MaxExtensionsLength = 1 << 20
+
+// DefaultMaxContentLength is the largest ContentLength of the records that a Reader accepts by default (see Reader.SetMaxContentLength)
+const DefaultMaxContentLength = 64 << 20
+
+// Magic is the magic string that every capture file starts with
+const Magic = "FBCAP"
+
+// Version is the version of the capture file format implemented by this package
+const Version = uint8(2)
+
+// Version1 is the first version of the capture file format, whose records do not record the Metadata.IdHigh of packets
+const Version1 = uint8(1)
+
+const (
+	MagicOffset = 0 // 0
+	MagicSize   = 5
+
+	VersionOffset = MagicOffset + MagicSize // 5
+	VersionSize   = 1
+
+	ReservedOffset = VersionOffset + VersionSize // 6
+	ReservedSize   = 2
+
+	FileHeaderSize = ReservedOffset + ReservedSize // 8
+)
+
+const (
+	TimestampOffset = 0 // 0
+	TimestampSize   = 8
+
+	DirectionOffset = TimestampOffset + TimestampSize // 8
+	DirectionSize   = 1
+
+	ConnectionOffset = DirectionOffset + DirectionSize // 9
+	ConnectionSize   = 8
+
+	IdOffset = ConnectionOffset + ConnectionSize // 17
+	IdSize   = 2
+
+	OperationOffset = IdOffset + IdSize // 19
+	OperationSize   = 2
+
+	ContentLengthOffset = OperationOffset + OperationSize // 21
+	ContentLengthSize   = 4
+
+	ExtensionsLengthOffset = ContentLengthOffset + ContentLengthSize // 25
+	ExtensionsLengthSize   = 4
+
+	RecordHeaderV1Size = ExtensionsLengthOffset + ExtensionsLengthSize // 29
+
+	IdHighOffset = RecordHeaderV1Size // 29
+	IdHighSize   = 2
+
+	RecordHeaderSize = IdHighOffset + IdHighSize // 31
+)
+
+// Direction is the direction in which a recorded packet crossed a connection
+type Direction uint8
+
+const (
+	// DirectionRead is used for packets that were read from a connection
+	DirectionRead = Direction(1)
+
+	// DirectionWrite is used for packets that were written to a connection
+	DirectionWrite = Direction(2)
+)
+
+// String returns the human-readable name of the Direction
+func (d Direction) String() string {
+	switch d {
+	case DirectionRead:
+		return "read"
+	case DirectionWrite:
+		return "write"
+	default:
+		return "unknown"
+	}
+}
+
+// Record is a single packet that was recorded in a capture file
+type Record struct {
+	Timestamp  time.Time
+	Direction  Direction
+	Connection uint64
+	Packet     *packet.Packet
+}
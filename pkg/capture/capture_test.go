@@ -0,0 +1,158 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package capture
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+func TestWriterReader(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "test.fbcap")
+	w, err := Create(path)
+	require.NoError(t, err)
+
+	timestamp := time.Unix(1700000000, 123456789)
+
+	p := packet.Get()
+	p.Metadata.SetExtendedId(0x12340010)
+	p.Metadata.Operation = 32
+	p.Content.Write([]byte("hello"))
+	p.Metadata.ContentLength = 5
+	require.NoError(t, p.Extensions.Set(metadata.ExtensionTrace, []byte("trace")))
+	require.NoError(t, w.WritePacket(timestamp, DirectionWrite, 7, p))
+	packet.Put(p)
+
+	p = packet.Get()
+	p.Metadata.Operation = 1
+	w.Tap(DirectionRead, 8, p)
+	packet.Put(p)
+
+	assert.ErrorIs(t, w.WritePacket(timestamp, Direction(0), 7, packet.Get()), InvalidDirectionErr)
+	require.NoError(t, w.Err())
+	require.NoError(t, w.Close())
+
+	r, err := Open(path)
+	require.NoError(t, err)
+
+	record, err := r.Next()
+	require.NoError(t, err)
+	assert.True(t, timestamp.Equal(record.Timestamp))
+	assert.Equal(t, DirectionWrite, record.Direction)
+	assert.Equal(t, "write", record.Direction.String())
+	assert.Equal(t, uint64(7), record.Connection)
+	assert.Equal(t, uint32(0x12340010), record.Packet.Metadata.ExtendedId())
+	assert.Equal(t, uint16(32), record.Packet.Metadata.Operation)
+	assert.Equal(t, uint32(5), record.Packet.Metadata.ContentLength)
+	assert.Equal(t, []byte("hello"), record.Packet.Content.Bytes())
+	trace, ok := record.Packet.Extensions.Get(metadata.ExtensionTrace)
+	require.True(t, ok)
+	assert.Equal(t, []byte("trace"), trace)
+	packet.Put(record.Packet)
+
+	record, err = r.Next()
+	require.NoError(t, err)
+	assert.Equal(t, DirectionRead, record.Direction)
+	assert.Equal(t, uint64(8), record.Connection)
+	assert.Equal(t, uint16(1), record.Packet.Metadata.Operation)
+	assert.Equal(t, 0, record.Packet.Content.Len())
+	packet.Put(record.Packet)
+
+	_, err = r.Next()
+	assert.ErrorIs(t, err, io.EOF)
+	require.NoError(t, r.Close())
+}
+
+func TestReaderErrors(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewReader(bytes.NewReader([]byte("NOTCAP00")))
+	assert.ErrorIs(t, err, InvalidMagicErr)
+
+	_, err = NewReader(bytes.NewReader([]byte("FB")))
+	assert.ErrorIs(t, err, InvalidMagicErr)
+
+	_, err = NewReader(bytes.NewReader([]byte{'F', 'B', 'C', 'A', 'P', Version + 1, 0, 0}))
+	assert.ErrorIs(t, err, InvalidVersionErr)
+
+	b := new(bytes.Buffer)
+	w, err := NewWriter(b)
+	require.NoError(t, err)
+	p := packet.Get()
+	p.Metadata.Operation = 32
+	p.Content.Write([]byte("truncated"))
+	p.Metadata.ContentLength = 9
+	require.NoError(t, w.WritePacket(time.Now(), DirectionRead, 1, p))
+	packet.Put(p)
+	require.NoError(t, w.Flush())
+
+	r, err := NewReader(bytes.NewReader(b.Bytes()[:b.Len()-1]))
+	require.NoError(t, err)
+	_, err = r.Next()
+	assert.ErrorIs(t, err, TruncatedRecordErr)
+
+	r, err = NewReader(bytes.NewReader(b.Bytes()))
+	require.NoError(t, err)
+	r.SetMaxContentLength(8)
+	_, err = r.Next()
+	assert.ErrorIs(t, err, RecordTooLargeErr)
+
+	// The lengths are checked before anything is allocated for them, even if the record is truncated
+	record := append([]byte(nil), b.Bytes()[:FileHeaderSize+RecordHeaderSize]...)
+	binary.BigEndian.PutUint32(record[FileHeaderSize+ContentLengthOffset:], math.MaxUint32)
+	r, err = NewReader(bytes.NewReader(record))
+	require.NoError(t, err)
+	_, err = r.Next()
+	assert.ErrorIs(t, err, RecordTooLargeErr)
+
+	binary.BigEndian.PutUint32(record[FileHeaderSize+ContentLengthOffset:], 0)
+	binary.BigEndian.PutUint32(record[FileHeaderSize+ExtensionsLengthOffset:], MaxExtensionsLength+1)
+	r, err = NewReader(bytes.NewReader(record))
+	require.NoError(t, err)
+	_, err = r.Next()
+	assert.ErrorIs(t, err, RecordTooLargeErr)
+}
+
+func TestReaderVersion1(t *testing.T) {
+	t.Parallel()
+
+	// Version 1 records are the same as version 2 records without the IdHigh field
+	b := new(bytes.Buffer)
+	w, err := NewWriter(b)
+	require.NoError(t, err)
+	p := packet.Get()
+	p.Metadata.Id = 16
+	p.Metadata.Operation = 32
+	p.Content.Write([]byte("hello"))
+	p.Metadata.ContentLength = 5
+	require.NoError(t, w.WritePacket(time.Now(), DirectionRead, 1, p))
+	packet.Put(p)
+	require.NoError(t, w.Flush())
+
+	v1 := b.Bytes()
+	v1[VersionOffset] = Version1
+	v1 = append(v1[:FileHeaderSize+IdHighOffset], v1[FileHeaderSize+IdHighOffset+IdHighSize:]...)
+
+	r, err := NewReader(bytes.NewReader(v1))
+	require.NoError(t, err)
+	record, err := r.Next()
+	require.NoError(t, err)
+	assert.Equal(t, uint32(16), record.Packet.Metadata.ExtendedId())
+	assert.Equal(t, []byte("hello"), record.Packet.Content.Bytes())
+	packet.Put(record.Packet)
+	_, err = r.Next()
+	assert.ErrorIs(t, err, io.EOF)
+}
@@ -0,0 +1,152 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package capture
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"time"
+
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+// Reader reads records from a capture file
+type Reader struct {
+	reader     *bufio.Reader
+	closer     io.Closer
+	header     [RecordHeaderSize]byte
+	headerSize int
+	extensions []byte
+
+	maxContentLength uint32
+}
+
+// NewReader reads and validates the capture file header from r, and returns a Reader for its records
+func NewReader(r io.Reader) (*Reader, error) {
+	reader := &Reader{
+		reader:           bufio.NewReader(r),
+		maxContentLength: DefaultMaxContentLength,
+	}
+	if closer, ok := r.(io.Closer); ok {
+		reader.closer = closer
+	}
+
+	var header [FileHeaderSize]byte
+	if _, err := io.ReadFull(reader.reader, header[:]); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, InvalidMagicErr
+		}
+		return nil, err
+	}
+	if string(header[MagicOffset:MagicOffset+MagicSize]) != Magic {
+		return nil, InvalidMagicErr
+	}
+	switch header[VersionOffset] {
+	case Version:
+		reader.headerSize = RecordHeaderSize
+	case Version1:
+		reader.headerSize = RecordHeaderV1Size
+	default:
+		return nil, InvalidVersionErr
+	}
+	return reader, nil
+}
+
+// Open opens the capture file at the given path and returns a Reader for it
+func Open(path string) (*Reader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	r, err := NewReader(f)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// SetMaxContentLength sets the largest ContentLength of the records that the Reader accepts, so that corrupted
+// or malicious capture files cannot make it allocate arbitrarily large buffers. By default, DefaultMaxContentLength is used.
+func (r *Reader) SetMaxContentLength(maxContentLength uint32) {
+	r.maxContentLength = maxContentLength
+}
+
+// Next returns the next record in the capture file, or io.EOF once all records have been read.
+//
+// The record's Packet is retrieved from the packet pool, and can be returned with packet.Put once it is no longer needed.
+// Records with more than MaxExtensionsLength bytes of extensions or a ContentLength larger than the Reader's maximum (see
+// SetMaxContentLength) are rejected with RecordTooLargeErr before anything is allocated for them.
+func (r *Reader) Next() (*Record, error) {
+	if _, err := io.ReadFull(r.reader, r.header[:r.headerSize]); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, TruncatedRecordErr
+		}
+		return nil, err
+	}
+
+	record := &Record{
+		Timestamp:  time.Unix(0, int64(binary.BigEndian.Uint64(r.header[TimestampOffset:TimestampOffset+TimestampSize]))),
+		Direction:  Direction(r.header[DirectionOffset]),
+		Connection: binary.BigEndian.Uint64(r.header[ConnectionOffset : ConnectionOffset+ConnectionSize]),
+	}
+	if record.Direction != DirectionRead && record.Direction != DirectionWrite {
+		return nil, InvalidDirectionErr
+	}
+
+	p := packet.Get()
+	p.Metadata.Id = binary.BigEndian.Uint16(r.header[IdOffset : IdOffset+IdSize])
+	if r.headerSize >= IdHighOffset+IdHighSize {
+		p.Metadata.IdHigh = binary.BigEndian.Uint16(r.header[IdHighOffset : IdHighOffset+IdHighSize])
+	}
+	p.Metadata.Operation = binary.BigEndian.Uint16(r.header[OperationOffset : OperationOffset+OperationSize])
+	p.Metadata.ContentLength = binary.BigEndian.Uint32(r.header[ContentLengthOffset : ContentLengthOffset+ContentLengthSize])
+	extensionsLength := int(binary.BigEndian.Uint32(r.header[ExtensionsLengthOffset : ExtensionsLengthOffset+ExtensionsLengthSize]))
+	if extensionsLength > MaxExtensionsLength || p.Metadata.ContentLength > r.maxContentLength {
+		packet.Put(p)
+		return nil, RecordTooLargeErr
+	}
+
+	if extensionsLength > 0 {
+		if cap(r.extensions) < extensionsLength {
+			r.extensions = make([]byte, extensionsLength)
+		}
+		r.extensions = r.extensions[:extensionsLength]
+		if _, err := io.ReadFull(r.reader, r.extensions); err != nil {
+			packet.Put(p)
+			return nil, TruncatedRecordErr
+		}
+		if err := p.Extensions.Decode(r.extensions); err != nil {
+			packet.Put(p)
+			return nil, err
+		}
+		if err := p.Extensions.ExtractHeaders(&p.Headers); err != nil {
+			packet.Put(p)
+			return nil, err
+		}
+	}
+
+	if p.Metadata.ContentLength > 0 {
+		contentLength := int(p.Metadata.ContentLength)
+		p.Content.Grow(contentLength)
+		p.Content.MoveOffset(contentLength)
+		if _, err := io.ReadFull(r.reader, p.Content.Bytes()); err != nil {
+			packet.Put(p)
+			return nil, TruncatedRecordErr
+		}
+	}
+
+	record.Packet = p
+	return record, nil
+}
+
+// Close closes the underlying io.Reader if it is an io.Closer
+func (r *Reader) Close() error {
+	if r.closer != nil {
+		return r.closer.Close()
+	}
+	return nil
+}
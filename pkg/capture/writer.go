@@ -0,0 +1,127 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package capture
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+// Writer writes packets to a capture file. It is safe to use from multiple goroutines.
+type Writer struct {
+	mu     sync.Mutex
+	writer *bufio.Writer
+	closer io.Closer
+	header [RecordHeaderSize]byte
+	err    error
+}
+
+// NewWriter writes the capture file header to w and returns a Writer that appends records to it
+func NewWriter(w io.Writer) (*Writer, error) {
+	writer := &Writer{
+		writer: bufio.NewWriter(w),
+	}
+	if closer, ok := w.(io.Closer); ok {
+		writer.closer = closer
+	}
+
+	var header [FileHeaderSize]byte
+	copy(header[MagicOffset:MagicOffset+MagicSize], Magic)
+	header[VersionOffset] = Version
+	if _, err := writer.writer.Write(header[:]); err != nil {
+		return nil, err
+	}
+	return writer, nil
+}
+
+// Create creates (or truncates) the capture file at the given path and returns a Writer for it
+func Create(path string) (*Writer, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w, err := NewWriter(f)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// WritePacket appends a record for the given packet to the capture file
+func (w *Writer) WritePacket(timestamp time.Time, direction Direction, connection uint64, p *packet.Packet) error {
+	if direction != DirectionRead && direction != DirectionWrite {
+		return InvalidDirectionErr
+	}
+	extensions, err := p.Extensions.WithHeaders(p.Headers)
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return w.err
+	}
+
+	binary.BigEndian.PutUint64(w.header[TimestampOffset:TimestampOffset+TimestampSize], uint64(timestamp.UnixNano()))
+	w.header[DirectionOffset] = byte(direction)
+	binary.BigEndian.PutUint64(w.header[ConnectionOffset:ConnectionOffset+ConnectionSize], connection)
+	binary.BigEndian.PutUint16(w.header[IdOffset:IdOffset+IdSize], p.Metadata.Id)
+	binary.BigEndian.PutUint16(w.header[OperationOffset:OperationOffset+OperationSize], p.Metadata.Operation)
+	binary.BigEndian.PutUint32(w.header[ContentLengthOffset:ContentLengthOffset+ContentLengthSize], uint32(p.Content.Len()))
+	binary.BigEndian.PutUint32(w.header[ExtensionsLengthOffset:ExtensionsLengthOffset+ExtensionsLengthSize], uint32(extensions.EncodedSize()))
+	binary.BigEndian.PutUint16(w.header[IdHighOffset:IdHighOffset+IdHighSize], p.Metadata.IdHigh)
+
+	if _, w.err = w.writer.Write(w.header[:]); w.err != nil {
+		return w.err
+	}
+	if len(extensions) > 0 {
+		if _, w.err = w.writer.Write(extensions.Encode(nil)); w.err != nil {
+			return w.err
+		}
+	}
+	_, w.err = w.writer.Write(p.Content.Bytes())
+	return w.err
+}
+
+// Tap records the given packet with the current time, and is meant to be used as a frisbee Tap.
+// Errors are retained and returned by Err, Flush, and Close.
+func (w *Writer) Tap(direction Direction, connection uint64, p *packet.Packet) {
+	_ = w.WritePacket(time.Now(), direction, connection, p)
+}
+
+// Err returns the first error encountered while writing to the capture file
+func (w *Writer) Err() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+// Flush flushes any buffered records to the underlying io.Writer
+func (w *Writer) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return w.err
+	}
+	w.err = w.writer.Flush()
+	return w.err
+}
+
+// Close flushes any buffered records and closes the underlying io.Writer if it is an io.Closer
+func (w *Writer) Close() error {
+	err := w.Flush()
+	if w.closer != nil {
+		if closeErr := w.closer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
@@ -0,0 +1,100 @@
+// SPDX-License-Identifier: Apache-2.0
+
+// Package codec provides the Codec interface that is used to encode and decode the content of frisbee packets,
+// along with a registry of codecs that are identified by a single byte. The JSON, Gob, Polyglot, and Proto codecs
+// are registered by default.
+package codec
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/loopholelabs/polyglot/v2"
+)
+
+var (
+	InvalidCodecErr     = errors.New("invalid codec")
+	DuplicateCodecErr   = errors.New("codec already registered")
+	ReservedCodecErr    = errors.New("codec ID is reserved")
+	UnknownCodecErr     = errors.New("unknown codec")
+	UnsupportedTypeErr  = errors.New("unsupported type")
+	InvalidEncodingErr  = errors.New("invalid encoding")
+	InvalidTargetErr    = errors.New("decode target must be a non-nil pointer")
+	TruncatedContentErr = errors.New("truncated content")
+)
+
+// These are the IDs of the built-in codecs, IDs up to and including 15 are reserved for frisbee itself:
+const (
+	PolyglotID = uint8(1)
+	JSONID     = uint8(2)
+	GobID      = uint8(3)
+	ProtoID    = uint8(4)
+
+	// ReservedID is the largest codec ID that is reserved for frisbee
+	ReservedID = uint8(15)
+)
+
+// Codec encodes values into packet content and decodes them back
+type Codec interface {
+	// ID returns the identifier of the codec, which is sent alongside packet content so the receiver
+	// knows which Codec to decode it with. It must not be 0.
+	ID() uint8
+
+	// Name returns a human-readable name for the codec (e.g. "json")
+	Name() string
+
+	// Encode appends the encoded value to the buffer
+	Encode(b *polyglot.Buffer, v any) error
+
+	// Decode decodes the data into the value, which must be a pointer
+	Decode(data []byte, v any) error
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[uint8]Codec{
+		PolyglotID: Polyglot,
+		JSONID:     JSON,
+		GobID:      Gob,
+		ProtoID:    Proto,
+	}
+)
+
+// Register adds the Codec to the registry so it can be found with Get and Lookup. Codecs with an ID of 0 or
+// an ID that is already registered are rejected, and codecs with an ID that is reserved for frisbee (up to and
+// including ReservedID) are rejected with ReservedCodecErr.
+func Register(c Codec) error {
+	if c == nil || c.ID() == 0 {
+		return InvalidCodecErr
+	}
+	if c.ID() <= ReservedID {
+		return ReservedCodecErr
+	}
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, ok := registry[c.ID()]; ok {
+		return DuplicateCodecErr
+	}
+	registry[c.ID()] = c
+	return nil
+}
+
+// Get returns the registered Codec with the given ID
+func Get(id uint8) (Codec, bool) {
+	registryMu.RLock()
+	c, ok := registry[id]
+	registryMu.RUnlock()
+	return c, ok
+}
+
+// Lookup returns the registered Codec with the given name
+func Lookup(name string) (Codec, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	for _, c := range registry {
+		if c.Name() == name {
+			return c, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,103 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package codec
+
+import (
+	"testing"
+
+	"github.com/loopholelabs/polyglot/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testMessage struct {
+	Name   string
+	Values []int64
+	Nested *testMessage
+}
+
+type testPolyglot struct {
+	Name string
+}
+
+func (t *testPolyglot) Encode(b *polyglot.Buffer) {
+	polyglot.Encoder(b).String(t.Name)
+}
+
+func (t *testPolyglot) Decode(b []byte) (err error) {
+	t.Name, err = polyglot.Decoder(b).String()
+	return
+}
+
+type testCodec struct {
+	id uint8
+}
+
+func (t testCodec) ID() uint8                          { return t.id }
+func (t testCodec) Name() string                       { return "test" }
+func (t testCodec) Encode(*polyglot.Buffer, any) error { return nil }
+func (t testCodec) Decode([]byte, any) error           { return nil }
+
+func TestRegistry(t *testing.T) {
+	for _, c := range []Codec{Polyglot, JSON, Gob, Proto} {
+		registered, ok := Get(c.ID())
+		require.True(t, ok)
+		assert.Equal(t, c, registered)
+		registered, ok = Lookup(c.Name())
+		require.True(t, ok)
+		assert.Equal(t, c, registered)
+	}
+
+	_, ok := Get(0)
+	assert.False(t, ok)
+	_, ok = Lookup("test")
+	assert.False(t, ok)
+
+	assert.ErrorIs(t, Register(nil), InvalidCodecErr)
+	assert.ErrorIs(t, Register(testCodec{id: 0}), InvalidCodecErr)
+	assert.ErrorIs(t, Register(testCodec{id: JSONID}), ReservedCodecErr)
+	assert.ErrorIs(t, Register(testCodec{id: ReservedID}), ReservedCodecErr)
+
+	require.NoError(t, Register(testCodec{id: ReservedID + 1}))
+	assert.ErrorIs(t, Register(testCodec{id: ReservedID + 1}), DuplicateCodecErr)
+	registryMu.Lock()
+	delete(registry, ReservedID+1)
+	registryMu.Unlock()
+
+	require.NoError(t, Register(testCodec{id: 200}))
+	registered, ok := Lookup("test")
+	require.True(t, ok)
+	assert.Equal(t, uint8(200), registered.ID())
+
+	registryMu.Lock()
+	delete(registry, 200)
+	registryMu.Unlock()
+}
+
+func TestCodecs(t *testing.T) {
+	t.Parallel()
+
+	expected := &testMessage{Name: "outer", Values: []int64{1, -2, 3}, Nested: &testMessage{Name: "inner"}}
+	for _, c := range []Codec{JSON, Gob} {
+		t.Run(c.Name(), func(t *testing.T) {
+			b := polyglot.NewBuffer()
+			require.NoError(t, c.Encode(b, expected))
+
+			actual := new(testMessage)
+			require.NoError(t, c.Decode(b.Bytes(), actual))
+			assert.Equal(t, expected, actual)
+		})
+	}
+
+	t.Run("polyglot", func(t *testing.T) {
+		b := polyglot.NewBuffer()
+		require.NoError(t, Polyglot.Encode(b, &testPolyglot{Name: "polyglot"}))
+
+		actual := new(testPolyglot)
+		require.NoError(t, Polyglot.Decode(b.Bytes(), actual))
+		assert.Equal(t, "polyglot", actual.Name)
+
+		assert.ErrorIs(t, Polyglot.Encode(b, expected), UnsupportedTypeErr)
+		assert.ErrorIs(t, Polyglot.Decode(b.Bytes(), expected), UnsupportedTypeErr)
+	})
+}
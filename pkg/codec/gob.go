@@ -0,0 +1,33 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package codec
+
+import (
+	"bytes"
+	"encoding/gob"
+
+	"github.com/loopholelabs/polyglot/v2"
+)
+
+// Gob is a Codec that uses encoding/gob. Every value is encoded as a self-contained gob
+// stream, so the type information is sent along with each packet.
+var Gob Codec = gobCodec{}
+
+type gobCodec struct{}
+
+func (gobCodec) ID() uint8 { return GobID }
+
+func (gobCodec) Name() string { return "gob" }
+
+func (gobCodec) Encode(b *polyglot.Buffer, v any) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return err
+	}
+	b.Write(buf.Bytes())
+	return nil
+}
+
+func (gobCodec) Decode(data []byte, v any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
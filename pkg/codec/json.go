@@ -0,0 +1,31 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package codec
+
+import (
+	"encoding/json"
+
+	"github.com/loopholelabs/polyglot/v2"
+)
+
+// JSON is a Codec that uses encoding/json
+var JSON Codec = jsonCodec{}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ID() uint8 { return JSONID }
+
+func (jsonCodec) Name() string { return "json" }
+
+func (jsonCodec) Encode(b *polyglot.Buffer, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	b.Write(data)
+	return nil
+}
+
+func (jsonCodec) Decode(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
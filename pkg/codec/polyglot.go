@@ -0,0 +1,42 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package codec
+
+import (
+	"github.com/loopholelabs/polyglot/v2"
+)
+
+// Polyglot is a Codec for values that encode and decode themselves using polyglot, such as the types
+// generated by polyglot's protoc plugin or by frisbee-gen. This is the encoding that frisbee.TypedHandler uses.
+var Polyglot Codec = polyglotCodec{}
+
+type polyglotEncoder interface {
+	Encode(b *polyglot.Buffer)
+}
+
+type polyglotDecoder interface {
+	Decode(b []byte) error
+}
+
+type polyglotCodec struct{}
+
+func (polyglotCodec) ID() uint8 { return PolyglotID }
+
+func (polyglotCodec) Name() string { return "polyglot" }
+
+func (polyglotCodec) Encode(b *polyglot.Buffer, v any) error {
+	encoder, ok := v.(polyglotEncoder)
+	if !ok {
+		return UnsupportedTypeErr
+	}
+	encoder.Encode(b)
+	return nil
+}
+
+func (polyglotCodec) Decode(data []byte, v any) error {
+	decoder, ok := v.(polyglotDecoder)
+	if !ok {
+		return UnsupportedTypeErr
+	}
+	return decoder.Decode(data)
+}
@@ -0,0 +1,467 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package codec
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/loopholelabs/polyglot/v2"
+)
+
+// Proto is a Codec that uses the protobuf wire format, without depending on the protobuf runtime.
+//
+// Values that implement Marshal() ([]byte, error) and Unmarshal([]byte) error (such as gogoproto types) are
+// encoded using those methods. Otherwise, the value must be a pointer to a struct whose fields have protobuf struct tags
+// (e.g. `protobuf:"varint,1,opt,name=id,proto3"`) like the structs generated by protoc-gen-go. Fields without a
+// protobuf tag are ignored, unknown fields are skipped when decoding, and map, oneof, and group fields are not supported.
+var Proto Codec = protoCodec{}
+
+// These are the protobuf wire types:
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+type protoMarshaler interface {
+	Marshal() ([]byte, error)
+}
+
+type protoUnmarshaler interface {
+	Unmarshal(data []byte) error
+}
+
+type protoField struct {
+	index    int
+	number   uint64
+	encoding string
+	wireType uint64
+	repeated bool
+	packed   bool
+}
+
+type protoMessage struct {
+	fields   []protoField
+	byNumber map[uint64]int
+}
+
+var protoMessages sync.Map // map[reflect.Type]*protoMessage
+
+type protoCodec struct{}
+
+func (protoCodec) ID() uint8 { return ProtoID }
+
+func (protoCodec) Name() string { return "proto" }
+
+func (protoCodec) Encode(b *polyglot.Buffer, v any) error {
+	if marshaler, ok := v.(protoMarshaler); ok {
+		data, err := marshaler.Marshal()
+		if err != nil {
+			return err
+		}
+		b.Write(data)
+		return nil
+	}
+	value := reflect.ValueOf(v)
+	if value.Kind() != reflect.Pointer || value.Type().Elem().Kind() != reflect.Struct {
+		return UnsupportedTypeErr
+	}
+	if value.IsNil() {
+		return nil
+	}
+	data, err := appendProtoMessage(nil, value.Elem())
+	if err != nil {
+		return err
+	}
+	b.Write(data)
+	return nil
+}
+
+func (protoCodec) Decode(data []byte, v any) error {
+	if unmarshaler, ok := v.(protoUnmarshaler); ok {
+		return unmarshaler.Unmarshal(data)
+	}
+	value := reflect.ValueOf(v)
+	if value.Kind() != reflect.Pointer || value.IsNil() {
+		return InvalidTargetErr
+	}
+	if value.Type().Elem().Kind() != reflect.Struct {
+		return UnsupportedTypeErr
+	}
+	return decodeProtoMessage(data, value.Elem())
+}
+
+// getProtoMessage returns the protobuf fields of the given struct type, which are parsed once and then cached
+func getProtoMessage(t reflect.Type) (*protoMessage, error) {
+	if m, ok := protoMessages.Load(t); ok {
+		return m.(*protoMessage), nil
+	}
+	m := &protoMessage{byNumber: make(map[uint64]int)}
+	for i := 0; i < t.NumField(); i++ {
+		structField := t.Field(i)
+		if _, ok := structField.Tag.Lookup("protobuf_oneof"); ok {
+			return nil, fmt.Errorf("%w: oneof field %s.%s", UnsupportedTypeErr, t.Name(), structField.Name)
+		}
+		tag, ok := structField.Tag.Lookup("protobuf")
+		if !ok {
+			continue
+		}
+		f, err := parseProtoTag(tag)
+		if err != nil {
+			return nil, fmt.Errorf("%w: field %s.%s", err, t.Name(), structField.Name)
+		}
+		f.index = i
+		if !protoCompatible(f, structField.Type) {
+			return nil, fmt.Errorf("%w: field %s.%s has type %s and encoding %s", UnsupportedTypeErr, t.Name(), structField.Name, structField.Type, f.encoding)
+		}
+		m.byNumber[f.number] = len(m.fields)
+		m.fields = append(m.fields, f)
+	}
+	actual, _ := protoMessages.LoadOrStore(t, m)
+	return actual.(*protoMessage), nil
+}
+
+// parseProtoTag parses a protobuf struct tag such as "bytes,2,rep,name=tags,proto3"
+func parseProtoTag(tag string) (f protoField, err error) {
+	parts := strings.Split(tag, ",")
+	if len(parts) < 3 {
+		return f, InvalidEncodingErr
+	}
+	f.encoding = parts[0]
+	switch f.encoding {
+	case "varint", "zigzag32", "zigzag64":
+		f.wireType = wireVarint
+	case "fixed64":
+		f.wireType = wireFixed64
+	case "fixed32":
+		f.wireType = wireFixed32
+	case "bytes":
+		f.wireType = wireBytes
+	default:
+		return f, UnsupportedTypeErr
+	}
+	f.number, err = strconv.ParseUint(parts[1], 10, 29)
+	if err != nil || f.number == 0 {
+		return f, InvalidEncodingErr
+	}
+	f.repeated = parts[2] == "rep"
+	for _, part := range parts[3:] {
+		if part == "packed" {
+			f.packed = true
+		}
+	}
+	return f, nil
+}
+
+// protoCompatible returns whether values of the given Go type can be encoded with the field's encoding
+func protoCompatible(f protoField, t reflect.Type) bool {
+	if f.repeated {
+		if t.Kind() != reflect.Slice || t.Elem().Kind() == reflect.Uint8 {
+			return false
+		}
+		t = t.Elem()
+	}
+	if t.Kind() == reflect.Pointer {
+		if t.Elem().Kind() == reflect.Struct {
+			return f.encoding == "bytes"
+		}
+		if f.repeated {
+			return false
+		}
+		t = t.Elem()
+	}
+	switch f.encoding {
+	case "varint":
+		switch t.Kind() {
+		case reflect.Bool, reflect.Int32, reflect.Int64, reflect.Uint32, reflect.Uint64:
+			return true
+		}
+	case "zigzag32":
+		return t.Kind() == reflect.Int32
+	case "zigzag64":
+		return t.Kind() == reflect.Int64
+	case "fixed32":
+		switch t.Kind() {
+		case reflect.Float32, reflect.Int32, reflect.Uint32:
+			return true
+		}
+	case "fixed64":
+		switch t.Kind() {
+		case reflect.Float64, reflect.Int64, reflect.Uint64:
+			return true
+		}
+	case "bytes":
+		return t.Kind() == reflect.String || (t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8)
+	}
+	return false
+}
+
+func appendProtoMessage(b []byte, v reflect.Value) ([]byte, error) {
+	m, err := getProtoMessage(v.Type())
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range m.fields {
+		value := v.Field(f.index)
+		switch {
+		case f.repeated && f.packed && f.wireType != wireBytes:
+			if value.Len() == 0 {
+				continue
+			}
+			var packed []byte
+			for i := 0; i < value.Len(); i++ {
+				packed = appendProtoScalar(packed, f.encoding, value.Index(i))
+			}
+			b = binary.AppendUvarint(b, f.number<<3|wireBytes)
+			b = binary.AppendUvarint(b, uint64(len(packed)))
+			b = append(b, packed...)
+		case f.repeated:
+			for i := 0; i < value.Len(); i++ {
+				if b, err = appendProtoValue(b, f, value.Index(i)); err != nil {
+					return nil, err
+				}
+			}
+		case value.Kind() == reflect.Pointer:
+			if value.IsNil() {
+				continue
+			}
+			if b, err = appendProtoValue(b, f, value); err != nil {
+				return nil, err
+			}
+		default:
+			if value.IsZero() {
+				continue
+			}
+			if b, err = appendProtoValue(b, f, value); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return b, nil
+}
+
+// appendProtoValue appends the key and value of a single (non-packed) field
+func appendProtoValue(b []byte, f protoField, v reflect.Value) ([]byte, error) {
+	b = binary.AppendUvarint(b, f.number<<3|f.wireType)
+	if v.Kind() == reflect.Pointer {
+		if v.Type().Elem().Kind() == reflect.Struct {
+			var nested []byte
+			if !v.IsNil() {
+				var err error
+				if nested, err = appendProtoMessage(nil, v.Elem()); err != nil {
+					return nil, err
+				}
+			}
+			b = binary.AppendUvarint(b, uint64(len(nested)))
+			return append(b, nested...), nil
+		}
+		v = v.Elem()
+	}
+	return appendProtoScalar(b, f.encoding, v), nil
+}
+
+// appendProtoScalar appends the value without a key, the type must have been checked with protoCompatible
+func appendProtoScalar(b []byte, encoding string, v reflect.Value) []byte {
+	switch encoding {
+	case "varint":
+		switch v.Kind() {
+		case reflect.Bool:
+			if v.Bool() {
+				return append(b, 1)
+			}
+			return append(b, 0)
+		case reflect.Int32, reflect.Int64:
+			return binary.AppendUvarint(b, uint64(v.Int()))
+		default:
+			return binary.AppendUvarint(b, v.Uint())
+		}
+	case "zigzag32":
+		x := int32(v.Int())
+		return binary.AppendUvarint(b, uint64(uint32(x<<1)^uint32(x>>31)))
+	case "zigzag64":
+		x := v.Int()
+		return binary.AppendUvarint(b, uint64(x<<1)^uint64(x>>63))
+	case "fixed32":
+		switch v.Kind() {
+		case reflect.Float32:
+			return binary.LittleEndian.AppendUint32(b, math.Float32bits(float32(v.Float())))
+		case reflect.Int32:
+			return binary.LittleEndian.AppendUint32(b, uint32(v.Int()))
+		default:
+			return binary.LittleEndian.AppendUint32(b, uint32(v.Uint()))
+		}
+	case "fixed64":
+		switch v.Kind() {
+		case reflect.Float64:
+			return binary.LittleEndian.AppendUint64(b, math.Float64bits(v.Float()))
+		case reflect.Int64:
+			return binary.LittleEndian.AppendUint64(b, uint64(v.Int()))
+		default:
+			return binary.LittleEndian.AppendUint64(b, v.Uint())
+		}
+	default:
+		if v.Kind() == reflect.String {
+			b = binary.AppendUvarint(b, uint64(v.Len()))
+			return append(b, v.String()...)
+		}
+		b = binary.AppendUvarint(b, uint64(v.Len()))
+		return append(b, v.Bytes()...)
+	}
+}
+
+func decodeProtoMessage(data []byte, v reflect.Value) error {
+	m, err := getProtoMessage(v.Type())
+	if err != nil {
+		return err
+	}
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return TruncatedContentErr
+		}
+		data = data[n:]
+		number, wireType := key>>3, key&7
+
+		var raw uint64
+		var bytes []byte
+		switch wireType {
+		case wireVarint:
+			if raw, n = binary.Uvarint(data); n <= 0 {
+				return TruncatedContentErr
+			}
+			data = data[n:]
+		case wireFixed64:
+			if len(data) < 8 {
+				return TruncatedContentErr
+			}
+			raw, data = binary.LittleEndian.Uint64(data), data[8:]
+		case wireFixed32:
+			if len(data) < 4 {
+				return TruncatedContentErr
+			}
+			raw, data = uint64(binary.LittleEndian.Uint32(data)), data[4:]
+		case wireBytes:
+			length, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < length {
+				return TruncatedContentErr
+			}
+			bytes, data = data[n:n+int(length)], data[n+int(length):]
+		default:
+			return fmt.Errorf("%w: wire type %d", InvalidEncodingErr, wireType)
+		}
+
+		i, ok := m.byNumber[number]
+		if !ok {
+			continue
+		}
+		f := m.fields[i]
+		value := v.Field(f.index)
+		if f.repeated && f.wireType != wireBytes && wireType == wireBytes {
+			// Repeated scalars can be packed regardless of the packed option
+			for len(bytes) > 0 {
+				if raw, bytes, err = readProtoScalar(bytes, f.wireType); err != nil {
+					return err
+				}
+				element := reflect.New(value.Type().Elem()).Elem()
+				setProtoScalar(element, f.encoding, raw)
+				value.Set(reflect.Append(value, element))
+			}
+			continue
+		}
+		if wireType != f.wireType {
+			return fmt.Errorf("%w: field %d has wire type %d", InvalidEncodingErr, number, wireType)
+		}
+		if f.repeated {
+			element := reflect.New(value.Type().Elem()).Elem()
+			if err = setProtoValue(element, f.encoding, raw, bytes); err != nil {
+				return err
+			}
+			value.Set(reflect.Append(value, element))
+			continue
+		}
+		if err = setProtoValue(value, f.encoding, raw, bytes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readProtoScalar reads a single varint, fixed32, or fixed64 value from packed data
+func readProtoScalar(data []byte, wireType uint64) (uint64, []byte, error) {
+	switch wireType {
+	case wireVarint:
+		raw, n := binary.Uvarint(data)
+		if n <= 0 {
+			return 0, nil, TruncatedContentErr
+		}
+		return raw, data[n:], nil
+	case wireFixed64:
+		if len(data) < 8 {
+			return 0, nil, TruncatedContentErr
+		}
+		return binary.LittleEndian.Uint64(data), data[8:], nil
+	default:
+		if len(data) < 4 {
+			return 0, nil, TruncatedContentErr
+		}
+		return uint64(binary.LittleEndian.Uint32(data)), data[4:], nil
+	}
+}
+
+// setProtoValue sets a single field value, allocating pointers as required. Nested messages
+// that appear more than once are merged, as they are by the protobuf runtime.
+func setProtoValue(v reflect.Value, encoding string, raw uint64, bytes []byte) error {
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		if v.Type().Elem().Kind() == reflect.Struct {
+			return decodeProtoMessage(bytes, v.Elem())
+		}
+		v = v.Elem()
+	}
+	if encoding == "bytes" {
+		if v.Kind() == reflect.String {
+			v.SetString(string(bytes))
+		} else {
+			v.SetBytes(append([]byte{}, bytes...))
+		}
+		return nil
+	}
+	setProtoScalar(v, encoding, raw)
+	return nil
+}
+
+// setProtoScalar sets a varint, fixed32, or fixed64 value, the type must have been checked with protoCompatible
+func setProtoScalar(v reflect.Value, encoding string, raw uint64) {
+	switch encoding {
+	case "zigzag32":
+		v.SetInt(int64(int32(uint32(raw)>>1) ^ -int32(raw&1)))
+	case "zigzag64":
+		v.SetInt(int64(raw>>1) ^ -int64(raw&1))
+	default:
+		switch v.Kind() {
+		case reflect.Bool:
+			v.SetBool(raw != 0)
+		case reflect.Int32:
+			v.SetInt(int64(int32(raw)))
+		case reflect.Int64:
+			v.SetInt(int64(raw))
+		case reflect.Uint32:
+			v.SetUint(uint64(uint32(raw)))
+		case reflect.Uint64:
+			v.SetUint(raw)
+		case reflect.Float32:
+			v.SetFloat(float64(math.Float32frombits(uint32(raw))))
+		case reflect.Float64:
+			v.SetFloat(math.Float64frombits(raw))
+		}
+	}
+}
@@ -0,0 +1,170 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package codec
+
+import (
+	"math"
+	"testing"
+
+	"github.com/loopholelabs/polyglot/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// protoTest matches the Test1, Test2, and Test4 examples from the protobuf encoding documentation
+type protoTest struct {
+	A int32      `protobuf:"varint,1,opt,name=a,proto3"`
+	B string     `protobuf:"bytes,2,opt,name=b,proto3"`
+	C *protoTest `protobuf:"bytes,3,opt,name=c,proto3"`
+	D []int32    `protobuf:"varint,4,rep,packed,name=d,proto3"`
+
+	Ignored string
+}
+
+type protoScalars struct {
+	Bool     bool         `protobuf:"varint,1,opt,name=bool,proto3"`
+	Int32    int32        `protobuf:"varint,2,opt,name=int32,proto3"`
+	Int64    int64        `protobuf:"varint,3,opt,name=int64,proto3"`
+	Uint32   uint32       `protobuf:"varint,4,opt,name=uint32,proto3"`
+	Uint64   uint64       `protobuf:"varint,5,opt,name=uint64,proto3"`
+	Sint32   int32        `protobuf:"zigzag32,6,opt,name=sint32,proto3"`
+	Sint64   int64        `protobuf:"zigzag64,7,opt,name=sint64,proto3"`
+	Fixed32  uint32       `protobuf:"fixed32,8,opt,name=fixed32,proto3"`
+	Fixed64  uint64       `protobuf:"fixed64,9,opt,name=fixed64,proto3"`
+	Sfixed32 int32        `protobuf:"fixed32,10,opt,name=sfixed32,proto3"`
+	Sfixed64 int64        `protobuf:"fixed64,11,opt,name=sfixed64,proto3"`
+	Float    float32      `protobuf:"fixed32,12,opt,name=float,proto3"`
+	Double   float64      `protobuf:"fixed64,13,opt,name=double,proto3"`
+	Bytes    []byte       `protobuf:"bytes,14,opt,name=bytes,proto3"`
+	Optional *int64       `protobuf:"varint,15,opt,name=optional,proto3,oneof"`
+	Strings  []string     `protobuf:"bytes,16,rep,name=strings,proto3"`
+	Doubles  []float64    `protobuf:"fixed64,17,rep,packed,name=doubles,proto3"`
+	Sint64s  []int64      `protobuf:"zigzag64,18,rep,name=sint64s,proto3"`
+	Children []*protoTest `protobuf:"bytes,19,rep,name=children,proto3"`
+}
+
+type protoMarshalerTest struct {
+	data []byte
+}
+
+func (p *protoMarshalerTest) Marshal() ([]byte, error) {
+	return p.data, nil
+}
+
+func (p *protoMarshalerTest) Unmarshal(data []byte) error {
+	p.data = append(p.data[:0], data...)
+	return nil
+}
+
+func TestProtoWireFormat(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		message *protoTest
+		encoded []byte
+	}{
+		{name: "varint", message: &protoTest{A: 150}, encoded: []byte{0x08, 0x96, 0x01}},
+		{name: "string", message: &protoTest{B: "testing"}, encoded: []byte{0x12, 0x07, 't', 'e', 's', 't', 'i', 'n', 'g'}},
+		{name: "nested", message: &protoTest{C: &protoTest{A: 150}}, encoded: []byte{0x1a, 0x03, 0x08, 0x96, 0x01}},
+		{name: "packed", message: &protoTest{D: []int32{3, 270, 86942}}, encoded: []byte{0x22, 0x06, 0x03, 0x8e, 0x02, 0x9e, 0xa7, 0x05}},
+		{name: "negative", message: &protoTest{A: -1}, encoded: []byte{0x08, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}},
+		{name: "empty", message: &protoTest{}, encoded: nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := polyglot.NewBuffer()
+			require.NoError(t, Proto.Encode(b, test.message))
+			assert.Equal(t, len(test.encoded), b.Len())
+			if len(test.encoded) > 0 {
+				assert.Equal(t, test.encoded, b.Bytes())
+			}
+
+			decoded := new(protoTest)
+			require.NoError(t, Proto.Decode(test.encoded, decoded))
+			assert.Equal(t, test.message, decoded)
+		})
+	}
+}
+
+func TestProtoRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	optional := int64(0)
+	expected := &protoScalars{
+		Bool:     true,
+		Int32:    math.MinInt32,
+		Int64:    math.MinInt64,
+		Uint32:   math.MaxUint32,
+		Uint64:   math.MaxUint64,
+		Sint32:   -42,
+		Sint64:   math.MinInt64,
+		Fixed32:  0xdeadbeef,
+		Fixed64:  0xdeadbeefcafebabe,
+		Sfixed32: -7,
+		Sfixed64: -8,
+		Float:    3.5,
+		Double:   -math.Pi,
+		Bytes:    []byte{0, 1, 2},
+		Optional: &optional,
+		Strings:  []string{"a", "", "c"},
+		Doubles:  []float64{1, 2.5, math.Inf(1)},
+		Sint64s:  []int64{-1, 1, math.MaxInt64},
+		Children: []*protoTest{{A: 1}, {B: "two", D: []int32{-3}}},
+	}
+
+	b := polyglot.NewBuffer()
+	require.NoError(t, Proto.Encode(b, expected))
+	actual := new(protoScalars)
+	require.NoError(t, Proto.Decode(b.Bytes(), actual))
+	assert.Equal(t, expected, actual)
+
+	// Unpacked repeated scalars must be accepted even when the field is packed
+	unpacked := []byte{0x20, 0x03, 0x20, 0x8e, 0x02}
+	decoded := new(protoTest)
+	require.NoError(t, Proto.Decode(unpacked, decoded))
+	assert.Equal(t, []int32{3, 270}, decoded.D)
+
+	// Unknown fields are skipped
+	unknown := []byte{0x08, 0x01, 0x7a, 0x01, 0xff, 0x35, 0x01, 0x02, 0x03, 0x04}
+	decoded = new(protoTest)
+	require.NoError(t, Proto.Decode(unknown, decoded))
+	assert.Equal(t, int32(1), decoded.A)
+}
+
+func TestProtoErrors(t *testing.T) {
+	t.Parallel()
+
+	b := polyglot.NewBuffer()
+	assert.ErrorIs(t, Proto.Encode(b, protoTest{}), UnsupportedTypeErr)
+	assert.ErrorIs(t, Proto.Decode(nil, protoTest{}), InvalidTargetErr)
+	assert.ErrorIs(t, Proto.Decode(nil, (*protoTest)(nil)), InvalidTargetErr)
+
+	assert.ErrorIs(t, Proto.Decode([]byte{0x08}, new(protoTest)), TruncatedContentErr)
+	assert.ErrorIs(t, Proto.Decode([]byte{0x12, 0x05, 'a'}, new(protoTest)), TruncatedContentErr)
+	assert.ErrorIs(t, Proto.Decode([]byte{0x0d, 0x00, 0x00, 0x00, 0x00}, new(protoTest)), InvalidEncodingErr)
+
+	type invalidType struct {
+		Values map[string]string `protobuf:"bytes,1,rep,name=values,proto3"`
+	}
+	assert.ErrorIs(t, Proto.Encode(b, &invalidType{}), UnsupportedTypeErr)
+
+	type invalidEncoding struct {
+		Value string `protobuf:"varint,1,opt,name=value,proto3"`
+	}
+	assert.ErrorIs(t, Proto.Decode(nil, &invalidEncoding{}), UnsupportedTypeErr)
+
+	type oneof struct {
+		Value any `protobuf_oneof:"value"`
+	}
+	assert.ErrorIs(t, Proto.Encode(b, &oneof{}), UnsupportedTypeErr)
+
+	marshaler := &protoMarshalerTest{data: []byte("raw")}
+	require.NoError(t, Proto.Encode(b, marshaler))
+	assert.Equal(t, []byte("raw"), b.Bytes())
+	unmarshaled := new(protoMarshalerTest)
+	require.NoError(t, Proto.Decode(b.Bytes(), unmarshaled))
+	assert.Equal(t, []byte("raw"), unmarshaled.data)
+
+	assert.NoError(t, Proto.Encode(polyglot.NewBuffer(), (*protoTest)(nil)))
+}
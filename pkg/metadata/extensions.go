@@ -0,0 +1,157 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package metadata
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+var (
+	ExtensionTooLargeErr = errors.New("extension value too large")
+)
+
+// These are the extension types that are used by frisbee itself:
+const (
+	// ExtensionTrace carries a W3C traceparent-style trace context
+	ExtensionTrace = uint8(1)
+
+	// ExtensionCodec carries the ID of the codec (see pkg/codec) that the packet content is encoded with
+	ExtensionCodec = uint8(2)
+
+	// ExtensionFlags carries a single byte of flags that describe how the packet was encoded (see FlagCompressionMask)
+	ExtensionFlags = uint8(3)
+
+	// ExtensionHeaders carries the encoded key/value Headers of the packet
+	ExtensionHeaders = uint8(4)
+
+	// ExtensionMessageID carries the big-endian uint64 message ID of a packet that
+	// was sent with reliable delivery, which the receiver acknowledges and deduplicates
+	ExtensionMessageID = uint8(5)
+)
+
+// FlagCompressionMask selects the bits of the ExtensionFlags value that hold the
+// compression algorithm that the packet content was compressed with (0 if it is not compressed)
+const FlagCompressionMask = uint8(0x07)
+
+const (
+	ExtensionTypeOffset = 0 // 0
+	ExtensionTypeSize   = 1
+
+	ExtensionLengthOffset = ExtensionTypeOffset + ExtensionTypeSize // 1
+	ExtensionLengthSize   = 2
+
+	ExtensionHeaderSize = ExtensionLengthOffset + ExtensionLengthSize // 3
+)
+
+// Extension is a single type-length-value header extension
+type Extension struct {
+	Type  uint8
+	Value []byte
+}
+
+// Extensions is an optional collection of header extensions that can be attached to a packet.
+//
+// Each extension is encoded as a 1 byte type, a 2 byte big-endian length, and the value itself.
+type Extensions []Extension
+
+// Get returns the value of the extension with the given type
+func (e Extensions) Get(extensionType uint8) ([]byte, bool) {
+	for i := range e {
+		if e[i].Type == extensionType {
+			return e[i].Value, true
+		}
+	}
+	return nil, false
+}
+
+// Set copies the given value into the extension with the given type, adding the extension if it does not exist
+func (e *Extensions) Set(extensionType uint8, value []byte) error {
+	if len(value) > math.MaxUint16 {
+		return ExtensionTooLargeErr
+	}
+	for i := range *e {
+		if (*e)[i].Type == extensionType {
+			(*e)[i].Value = append((*e)[i].Value[:0], value...)
+			return nil
+		}
+	}
+	if len(*e) < cap(*e) {
+		*e = (*e)[:len(*e)+1]
+		(*e)[len(*e)-1].Type = extensionType
+		(*e)[len(*e)-1].Value = append((*e)[len(*e)-1].Value[:0], value...)
+		return nil
+	}
+	*e = append(*e, Extension{Type: extensionType, Value: append([]byte(nil), value...)})
+	return nil
+}
+
+// Delete removes the extension with the given type
+func (e *Extensions) Delete(extensionType uint8) {
+	for i := range *e {
+		if (*e)[i].Type == extensionType {
+			last := len(*e) - 1
+			(*e)[i], (*e)[last] = (*e)[last], (*e)[i]
+			*e = (*e)[:last]
+			return
+		}
+	}
+}
+
+// Without returns the extensions without the extension with the given type. The extensions are only
+// copied if they contain it, so neither they nor the returned extensions should be modified afterwards.
+func (e Extensions) Without(extensionType uint8) Extensions {
+	for i := range e {
+		if e[i].Type == extensionType {
+			without := make(Extensions, 0, len(e)-1)
+			without = append(without, e[:i]...)
+			return append(without, e[i+1:]...)
+		}
+	}
+	return e
+}
+
+// Reset removes all extensions while retaining the underlying memory
+func (e *Extensions) Reset() {
+	*e = (*e)[:0]
+}
+
+// EncodedSize returns the number of bytes required to encode the extensions
+func (e Extensions) EncodedSize() (size int) {
+	for i := range e {
+		size += ExtensionHeaderSize + len(e[i].Value)
+	}
+	return
+}
+
+// Encode appends the encoded extensions to b and returns the result
+func (e Extensions) Encode(b []byte) []byte {
+	var header [ExtensionHeaderSize]byte
+	for i := range e {
+		header[ExtensionTypeOffset] = e[i].Type
+		binary.BigEndian.PutUint16(header[ExtensionLengthOffset:ExtensionLengthOffset+ExtensionLengthSize], uint16(len(e[i].Value)))
+		b = append(b, header[:]...)
+		b = append(b, e[i].Value...)
+	}
+	return b
+}
+
+// Decode replaces the extensions with the ones encoded in b. The values are copied, so b can be reused.
+func (e *Extensions) Decode(b []byte) error {
+	e.Reset()
+	for len(b) > 0 {
+		if len(b) < ExtensionHeaderSize {
+			return InvalidBufferLengthErr
+		}
+		extensionType := b[ExtensionTypeOffset]
+		length := int(binary.BigEndian.Uint16(b[ExtensionLengthOffset : ExtensionLengthOffset+ExtensionLengthSize]))
+		b = b[ExtensionHeaderSize:]
+		if len(b) < length {
+			return InvalidBufferLengthErr
+		}
+		_ = e.Set(extensionType, b[:length])
+		b = b[length:]
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package metadata
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtensions(t *testing.T) {
+	t.Parallel()
+
+	var e Extensions
+	require.NoError(t, e.Set(ExtensionTrace, []byte("trace")))
+	require.NoError(t, e.Set(32, []byte("value")))
+	require.NoError(t, e.Set(ExtensionTrace, []byte("replaced")))
+	require.ErrorIs(t, e.Set(64, make([]byte, 1<<16)), ExtensionTooLargeErr)
+
+	value, ok := e.Get(ExtensionTrace)
+	require.True(t, ok)
+	assert.Equal(t, []byte("replaced"), value)
+	assert.Len(t, e, 2)
+
+	encoded := e.Encode(nil)
+	assert.Equal(t, e.EncodedSize(), len(encoded))
+
+	var decoded Extensions
+	require.NoError(t, decoded.Decode(encoded))
+	assert.Equal(t, e, decoded)
+
+	encoded[0] = 0
+	assert.Equal(t, ExtensionTrace, decoded[0].Type)
+
+	without := decoded.Without(ExtensionTrace)
+	assert.Equal(t, Extensions{{Type: 32, Value: []byte("value")}}, without)
+	assert.Len(t, decoded, 2)
+	assert.Equal(t, decoded, decoded.Without(64))
+
+	decoded.Delete(ExtensionTrace)
+	_, ok = decoded.Get(ExtensionTrace)
+	assert.False(t, ok)
+	value, ok = decoded.Get(32)
+	require.True(t, ok)
+	assert.Equal(t, []byte("value"), value)
+
+	decoded.Reset()
+	assert.Len(t, decoded, 0)
+	require.NoError(t, decoded.Set(32, []byte("reused")))
+	assert.Equal(t, Extensions{{Type: 32, Value: []byte("reused")}}, decoded)
+
+	assert.ErrorIs(t, decoded.Decode(encoded[:2]), InvalidBufferLengthErr)
+	assert.ErrorIs(t, decoded.Decode(encoded[:5]), InvalidBufferLengthErr)
+}
@@ -0,0 +1,79 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package metadata
+
+import (
+	"encoding/binary"
+)
+
+// These are the versions of the packet header format:
+const (
+	// Version1 is the original 8 byte header (see Metadata)
+	Version1 = uint8(1)
+
+	// Version2 is the extended header (see HeaderV2)
+	Version2 = uint8(2)
+)
+
+const (
+	V2VersionOffset = 0 // 0
+	V2VersionSize   = 1
+
+	V2FlagsOffset = V2VersionOffset + V2VersionSize // 1
+	V2FlagsSize   = 1
+
+	V2OperationOffset = V2FlagsOffset + V2FlagsSize // 2
+	V2OperationSize   = 2
+
+	V2IdOffset = V2OperationOffset + V2OperationSize // 4
+	V2IdSize   = 4
+
+	V2ContentLengthOffset = V2IdOffset + V2IdSize // 8
+	V2ContentLengthSize   = 4
+
+	V2ExtensionsLengthOffset = V2ContentLengthOffset + V2ContentLengthSize // 12
+	V2ExtensionsLengthSize   = 2
+
+	V2Size = V2ExtensionsLengthOffset + V2ExtensionsLengthSize // 14
+)
+
+// HeaderV2 is the version 2 packet header, which is V2Size bytes in length and is
+// immediately followed by ExtensionsLength bytes of encoded Extensions and then the packet content.
+//
+// Unlike the version 1 header, it carries a flags byte, a 32-bit ID, and the packet's extensions inline,
+// so no separate EXTENSION packet is required.
+type HeaderV2 struct {
+	Flags            uint8  // 1 Byte
+	Operation        uint16 // 2 Bytes
+	Id               uint32 // 4 Bytes
+	ContentLength    uint32 // 4 Bytes
+	ExtensionsLength uint16 // 2 Bytes
+}
+
+// Encode appends the encoded header to b and returns the result
+func (h *HeaderV2) Encode(b []byte) []byte {
+	var encoded [V2Size]byte
+	encoded[V2VersionOffset] = Version2
+	encoded[V2FlagsOffset] = h.Flags
+	binary.BigEndian.PutUint16(encoded[V2OperationOffset:V2OperationOffset+V2OperationSize], h.Operation)
+	binary.BigEndian.PutUint32(encoded[V2IdOffset:V2IdOffset+V2IdSize], h.Id)
+	binary.BigEndian.PutUint32(encoded[V2ContentLengthOffset:V2ContentLengthOffset+V2ContentLengthSize], h.ContentLength)
+	binary.BigEndian.PutUint16(encoded[V2ExtensionsLengthOffset:V2ExtensionsLengthOffset+V2ExtensionsLengthSize], h.ExtensionsLength)
+	return append(b, encoded[:]...)
+}
+
+// Decode decodes the header from the first V2Size bytes of b
+func (h *HeaderV2) Decode(b []byte) error {
+	if len(b) < V2Size {
+		return InvalidBufferLengthErr
+	}
+	if b[V2VersionOffset] != Version2 {
+		return DecodingErr
+	}
+	h.Flags = b[V2FlagsOffset]
+	h.Operation = binary.BigEndian.Uint16(b[V2OperationOffset : V2OperationOffset+V2OperationSize])
+	h.Id = binary.BigEndian.Uint32(b[V2IdOffset : V2IdOffset+V2IdSize])
+	h.ContentLength = binary.BigEndian.Uint32(b[V2ContentLengthOffset : V2ContentLengthOffset+V2ContentLengthSize])
+	h.ExtensionsLength = binary.BigEndian.Uint16(b[V2ExtensionsLengthOffset : V2ExtensionsLengthOffset+V2ExtensionsLengthSize])
+	return nil
+}
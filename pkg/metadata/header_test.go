@@ -0,0 +1,48 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package metadata
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHeaderV2(t *testing.T) {
+	t.Parallel()
+
+	header := HeaderV2{
+		Flags:            0x05,
+		Operation:        PacketProbe,
+		Id:               0xDEADBEEF,
+		ContentLength:    512,
+		ExtensionsLength: 16,
+	}
+
+	encoded := header.Encode([]byte{0xFF})
+	require.Len(t, encoded, V2Size+1)
+	assert.Equal(t, []byte{0xFF, Version2, 0x05, 0x00, 0x0C, 0xDE, 0xAD, 0xBE, 0xEF, 0x00, 0x00, 0x02, 0x00, 0x00, 0x10}, encoded)
+
+	var decoded HeaderV2
+	require.NoError(t, decoded.Decode(encoded[1:]))
+	assert.Equal(t, header, decoded)
+
+	assert.ErrorIs(t, decoded.Decode(encoded[1:V2Size]), InvalidBufferLengthErr)
+	encoded[1] = Version1
+	assert.ErrorIs(t, decoded.Decode(encoded[1:]), DecodingErr)
+}
+
+func TestExtendedId(t *testing.T) {
+	t.Parallel()
+
+	var m Metadata
+	m.SetExtendedId(0x12345678)
+	assert.Equal(t, uint16(0x1234), m.IdHigh)
+	assert.Equal(t, uint16(0x5678), m.Id)
+	assert.Equal(t, uint32(0x12345678), m.ExtendedId())
+
+	m.Id = 1
+	m.IdHigh = 0
+	assert.Equal(t, uint32(1), m.ExtendedId())
+}
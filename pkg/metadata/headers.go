@@ -0,0 +1,166 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package metadata
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var (
+	HeadersTooLargeErr = errors.New("headers too large")
+)
+
+// MaxHeadersSize is the maximum number of bytes that the Headers of a packet can use when they are encoded
+const MaxHeadersSize = 8 << 10
+
+// Header is a single key/value packet header
+type Header struct {
+	Key   string
+	Value []byte
+}
+
+// Headers is an optional collection of key/value headers (such as auth tokens, tenant IDs, or content types)
+// that can be attached to a packet.
+//
+// On the wire, the headers are sent as the value of the ExtensionHeaders extension, where each header is encoded as a
+// uvarint key length, the key, a uvarint value length, and the value itself. The encoded headers can use at most
+// MaxHeadersSize bytes.
+type Headers []Header
+
+// Get returns the value of the header with the given key
+func (h Headers) Get(key string) ([]byte, bool) {
+	for i := range h {
+		if h[i].Key == key {
+			return h[i].Value, true
+		}
+	}
+	return nil, false
+}
+
+// Set copies the given value into the header with the given key, adding the header if it does not exist.
+// If the encoded headers would be larger than MaxHeadersSize, nothing is changed and HeadersTooLargeErr is returned.
+func (h *Headers) Set(key string, value []byte) error {
+	for i := range *h {
+		if (*h)[i].Key == key {
+			if h.EncodedSize()-encodedHeaderSize(key, (*h)[i].Value)+encodedHeaderSize(key, value) > MaxHeadersSize {
+				return HeadersTooLargeErr
+			}
+			(*h)[i].Value = append((*h)[i].Value[:0], value...)
+			return nil
+		}
+	}
+	if h.EncodedSize()+encodedHeaderSize(key, value) > MaxHeadersSize {
+		return HeadersTooLargeErr
+	}
+	if len(*h) < cap(*h) {
+		*h = (*h)[:len(*h)+1]
+		(*h)[len(*h)-1].Key = key
+		(*h)[len(*h)-1].Value = append((*h)[len(*h)-1].Value[:0], value...)
+		return nil
+	}
+	*h = append(*h, Header{Key: key, Value: append([]byte(nil), value...)})
+	return nil
+}
+
+// Delete removes the header with the given key
+func (h *Headers) Delete(key string) {
+	for i := range *h {
+		if (*h)[i].Key == key {
+			last := len(*h) - 1
+			(*h)[i], (*h)[last] = (*h)[last], (*h)[i]
+			*h = (*h)[:last]
+			return
+		}
+	}
+}
+
+// Reset removes all headers while retaining the underlying memory
+func (h *Headers) Reset() {
+	*h = (*h)[:0]
+}
+
+// EncodedSize returns the number of bytes required to encode the headers
+func (h Headers) EncodedSize() (size int) {
+	for i := range h {
+		size += encodedHeaderSize(h[i].Key, h[i].Value)
+	}
+	return
+}
+
+// Encode appends the encoded headers to b and returns the result
+func (h Headers) Encode(b []byte) []byte {
+	for i := range h {
+		b = binary.AppendUvarint(b, uint64(len(h[i].Key)))
+		b = append(b, h[i].Key...)
+		b = binary.AppendUvarint(b, uint64(len(h[i].Value)))
+		b = append(b, h[i].Value...)
+	}
+	return b
+}
+
+// Decode replaces the headers with the ones encoded in b. The values are copied, so b can be reused.
+func (h *Headers) Decode(b []byte) error {
+	h.Reset()
+	if len(b) > MaxHeadersSize {
+		return HeadersTooLargeErr
+	}
+	for len(b) > 0 {
+		key, rest, err := decodeHeaderField(b)
+		if err != nil {
+			return err
+		}
+		value, rest, err := decodeHeaderField(rest)
+		if err != nil {
+			return err
+		}
+		_ = h.Set(string(key), value)
+		b = rest
+	}
+	return nil
+}
+
+// WithHeaders returns the extensions along with the given headers encoded as the ExtensionHeaders extension,
+// without modifying the extensions themselves. If there are no headers, the extensions are returned as they are.
+func (e Extensions) WithHeaders(headers Headers) (Extensions, error) {
+	if len(headers) == 0 {
+		return e, nil
+	}
+	if headers.EncodedSize() > MaxHeadersSize {
+		return e, HeadersTooLargeErr
+	}
+	return append(e[:len(e):len(e)], Extension{Type: ExtensionHeaders, Value: headers.Encode(nil)}), nil
+}
+
+// ExtractHeaders decodes the ExtensionHeaders extension (if there is one) into the given headers,
+// and then removes it from the extensions
+func (e *Extensions) ExtractHeaders(headers *Headers) error {
+	value, ok := e.Get(ExtensionHeaders)
+	if !ok {
+		return nil
+	}
+	err := headers.Decode(value)
+	e.Delete(ExtensionHeaders)
+	return err
+}
+
+func encodedHeaderSize(key string, value []byte) int {
+	return uvarintSize(len(key)) + len(key) + uvarintSize(len(value)) + len(value)
+}
+
+func uvarintSize(n int) (size int) {
+	for size = 1; n >= 0x80; size++ {
+		n >>= 7
+	}
+	return
+}
+
+// decodeHeaderField decodes a uvarint length-prefixed field from b, and returns it along with the rest of b
+func decodeHeaderField(b []byte) ([]byte, []byte, error) {
+	length, n := binary.Uvarint(b)
+	if n <= 0 || length > uint64(len(b)-n) {
+		return nil, nil, InvalidBufferLengthErr
+	}
+	b = b[n:]
+	return b[:length], b[length:], nil
+}
@@ -0,0 +1,76 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package metadata
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHeaders(t *testing.T) {
+	t.Parallel()
+
+	var h Headers
+	require.NoError(t, h.Set("authorization", []byte("token")))
+	require.NoError(t, h.Set("tenant", []byte("acme")))
+	require.NoError(t, h.Set("authorization", []byte("replaced")))
+	require.ErrorIs(t, h.Set("large", make([]byte, MaxHeadersSize)), HeadersTooLargeErr)
+
+	value, ok := h.Get("authorization")
+	require.True(t, ok)
+	assert.Equal(t, []byte("replaced"), value)
+	assert.Len(t, h, 2)
+
+	encoded := h.Encode(nil)
+	assert.Equal(t, h.EncodedSize(), len(encoded))
+	assert.Equal(t, byte(len("authorization")), encoded[0])
+
+	var decoded Headers
+	require.NoError(t, decoded.Decode(encoded))
+	assert.Equal(t, h, decoded)
+
+	decoded.Delete("authorization")
+	_, ok = decoded.Get("authorization")
+	assert.False(t, ok)
+	value, ok = decoded.Get("tenant")
+	require.True(t, ok)
+	assert.Equal(t, []byte("acme"), value)
+
+	decoded.Reset()
+	assert.Len(t, decoded, 0)
+
+	assert.ErrorIs(t, decoded.Decode(encoded[:5]), InvalidBufferLengthErr)
+	assert.ErrorIs(t, decoded.Decode([]byte{0x80}), InvalidBufferLengthErr)
+	assert.ErrorIs(t, decoded.Decode(make([]byte, MaxHeadersSize+1)), HeadersTooLargeErr)
+}
+
+func TestExtensionsWithHeaders(t *testing.T) {
+	t.Parallel()
+
+	e := make(Extensions, 0, 4)
+	require.NoError(t, e.Set(ExtensionTrace, []byte("trace")))
+
+	withoutHeaders, err := e.WithHeaders(nil)
+	require.NoError(t, err)
+	assert.Equal(t, e, withoutHeaders)
+
+	h := Headers{{Key: "tenant", Value: []byte("acme")}}
+	withHeaders, err := e.WithHeaders(h)
+	require.NoError(t, err)
+	assert.Len(t, e, 1)
+	assert.Len(t, withHeaders, 2)
+
+	var decoded Extensions
+	require.NoError(t, decoded.Decode(withHeaders.Encode(nil)))
+	var extracted Headers
+	require.NoError(t, decoded.ExtractHeaders(&extracted))
+	assert.Equal(t, h, extracted)
+	_, ok := decoded.Get(ExtensionHeaders)
+	assert.False(t, ok)
+	assert.Len(t, decoded, 1)
+
+	_, err = e.WithHeaders(Headers{{Key: "large", Value: make([]byte, MaxHeadersSize)}})
+	assert.ErrorIs(t, err, HeadersTooLargeErr)
+}
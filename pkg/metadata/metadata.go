@@ -33,11 +33,26 @@ const (
 	Size = ContentLengthOffset + ContentLengthSize // 8
 )
 
-// Metadata is 8 bytes in length
+// Metadata is 8 bytes in length when it is encoded with the version 1 header
 type Metadata struct {
 	Id            uint16 // 2 Bytes
 	Operation     uint16 // 2 Bytes
 	ContentLength uint32 // 4 Bytes
+
+	// IdHigh holds the upper 16 bits of a 32-bit packet ID, which can only be
+	// sent using the version 2 header (see HeaderV2) and is ignored by Encode
+	IdHigh uint16
+}
+
+// ExtendedId returns the full 32-bit packet ID
+func (fm *Metadata) ExtendedId() uint32 {
+	return uint32(fm.IdHigh)<<16 | uint32(fm.Id)
+}
+
+// SetExtendedId sets the full 32-bit packet ID
+func (fm *Metadata) SetExtendedId(id uint32) {
+	fm.IdHigh = uint16(id >> 16)
+	fm.Id = uint16(id)
 }
 
 // Encode Metadata
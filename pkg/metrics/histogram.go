@@ -0,0 +1,58 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// HistogramSnapshot is a point-in-time copy of a histogram, where Counts[i] is the
+// cumulative number of observations less than or equal to Buckets[i] seconds
+type HistogramSnapshot struct {
+	Buckets []float64
+	Counts  []uint64
+	Count   uint64
+	Sum     time.Duration
+}
+
+// histogram counts observations in DefaultBuckets, with an extra bucket for observations
+// larger than the last one, so that the total count is always the sum of the buckets
+type histogram struct {
+	counts []atomic.Uint64
+	sum    atomic.Int64
+}
+
+func newHistogram() *histogram {
+	return &histogram{
+		counts: make([]atomic.Uint64, len(DefaultBuckets)+1),
+	}
+}
+
+func (h *histogram) observe(d time.Duration) {
+	seconds := d.Seconds()
+	i := 0
+	for i < len(DefaultBuckets) && seconds > DefaultBuckets[i] {
+		i++
+	}
+	h.counts[i].Add(1)
+	h.sum.Add(int64(d))
+}
+
+func (h *histogram) snapshot() HistogramSnapshot {
+	s := HistogramSnapshot{
+		Buckets: DefaultBuckets,
+		Counts:  make([]uint64, len(DefaultBuckets)),
+		Sum:     time.Duration(h.sum.Load()),
+	}
+	// The count is computed from the same loads as the buckets, so that it is never smaller than any of them
+	var cumulative uint64
+	for i := range h.counts {
+		cumulative += h.counts[i].Load()
+		if i < len(s.Counts) {
+			s.Counts[i] = cumulative
+		}
+	}
+	s.Count = cumulative
+	return s
+}
@@ -0,0 +1,233 @@
+// SPDX-License-Identifier: Apache-2.0
+
+// Package metrics provides an in-memory implementation of the frisbee.Metrics interface,
+// which can be rendered in the Prometheus text exposition format without any external dependencies.
+package metrics
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// These are the reasons that are recorded when a connection is closed:
+const (
+	ReasonGraceful = "graceful"
+	ReasonEOF      = "eof"
+	ReasonTimeout  = "timeout"
+	ReasonClosed   = "closed"
+	ReasonError    = "error"
+)
+
+// DefaultBuckets are the upper bounds (in seconds) of the histogram buckets used
+// for handler latencies and ping round-trip times
+var DefaultBuckets = []float64{.00005, .0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Memory is a thread-safe, in-memory implementation of the frisbee.Metrics interface
+type Memory struct {
+	operationsMu sync.RWMutex
+	operations   map[uint16]*operation
+
+	flushes            atomic.Uint64
+	incomingQueueDepth atomic.Int64
+	streamsOpened      atomic.Uint64
+	streamsClosed      atomic.Uint64
+	connectionsOpened  atomic.Uint64
+	checksumMismatches atomic.Uint64
+
+	connectionsClosedMu sync.Mutex
+	connectionsClosed   map[string]uint64
+
+	pingRTT *histogram
+}
+
+type operation struct {
+	packetsRead    atomic.Uint64
+	bytesRead      atomic.Uint64
+	packetsWritten atomic.Uint64
+	bytesWritten   atomic.Uint64
+	handlerLatency *histogram
+}
+
+// OperationSnapshot contains the metrics recorded for a single packet operation
+type OperationSnapshot struct {
+	PacketsRead    uint64
+	BytesRead      uint64
+	PacketsWritten uint64
+	BytesWritten   uint64
+	HandlerLatency HistogramSnapshot
+}
+
+// Snapshot is a point-in-time copy of the metrics recorded by Memory
+type Snapshot struct {
+	Operations         map[uint16]OperationSnapshot
+	Flushes            uint64
+	IncomingQueueDepth int64
+	StreamsOpened      uint64
+	StreamsClosed      uint64
+	ConnectionsOpened  uint64
+	ConnectionsClosed  map[string]uint64
+	PingRTT            HistogramSnapshot
+	ChecksumMismatches uint64
+}
+
+// New returns a new, empty Memory metrics implementation
+func New() *Memory {
+	return &Memory{
+		operations:        make(map[uint16]*operation),
+		connectionsClosed: make(map[string]uint64),
+		pingRTT:           newHistogram(),
+	}
+}
+
+// PacketRead implements frisbee.Metrics
+func (m *Memory) PacketRead(op uint16, bytes int) {
+	o := m.operation(op)
+	o.packetsRead.Add(1)
+	o.bytesRead.Add(uint64(bytes))
+}
+
+// PacketWritten implements frisbee.Metrics
+func (m *Memory) PacketWritten(op uint16, bytes int) {
+	o := m.operation(op)
+	o.packetsWritten.Add(1)
+	o.bytesWritten.Add(uint64(bytes))
+}
+
+// Flushed implements frisbee.Metrics
+func (m *Memory) Flushed() {
+	m.flushes.Add(1)
+}
+
+// IncomingQueueDepth implements frisbee.Metrics
+func (m *Memory) IncomingQueueDepth(depth int) {
+	m.incomingQueueDepth.Store(int64(depth))
+}
+
+// StreamOpened implements frisbee.Metrics
+func (m *Memory) StreamOpened() {
+	m.streamsOpened.Add(1)
+}
+
+// StreamClosed implements frisbee.Metrics
+func (m *Memory) StreamClosed() {
+	m.streamsClosed.Add(1)
+}
+
+// HandlerLatency implements frisbee.Metrics
+func (m *Memory) HandlerLatency(op uint16, latency time.Duration) {
+	m.operation(op).handlerLatency.observe(latency)
+}
+
+// ConnectionOpened implements frisbee.Metrics
+func (m *Memory) ConnectionOpened() {
+	m.connectionsOpened.Add(1)
+}
+
+// ConnectionClosed implements frisbee.Metrics
+func (m *Memory) ConnectionClosed(reason error) {
+	r := Reason(reason)
+	m.connectionsClosedMu.Lock()
+	m.connectionsClosed[r]++
+	m.connectionsClosedMu.Unlock()
+}
+
+// PingRTT implements frisbee.Metrics
+func (m *Memory) PingRTT(rtt time.Duration) {
+	m.pingRTT.observe(rtt)
+}
+
+// ChecksumMismatch implements frisbee.Metrics
+func (m *Memory) ChecksumMismatch() {
+	m.checksumMismatches.Add(1)
+}
+
+// Snapshot returns a point-in-time copy of the recorded metrics
+func (m *Memory) Snapshot() Snapshot {
+	s := Snapshot{
+		Operations:         make(map[uint16]OperationSnapshot),
+		Flushes:            m.flushes.Load(),
+		IncomingQueueDepth: m.incomingQueueDepth.Load(),
+		StreamsOpened:      m.streamsOpened.Load(),
+		StreamsClosed:      m.streamsClosed.Load(),
+		ConnectionsOpened:  m.connectionsOpened.Load(),
+		ConnectionsClosed:  make(map[string]uint64),
+		PingRTT:            m.pingRTT.snapshot(),
+		ChecksumMismatches: m.checksumMismatches.Load(),
+	}
+
+	m.operationsMu.RLock()
+	for op, o := range m.operations {
+		s.Operations[op] = OperationSnapshot{
+			PacketsRead:    o.packetsRead.Load(),
+			BytesRead:      o.bytesRead.Load(),
+			PacketsWritten: o.packetsWritten.Load(),
+			BytesWritten:   o.bytesWritten.Load(),
+			HandlerLatency: o.handlerLatency.snapshot(),
+		}
+	}
+	m.operationsMu.RUnlock()
+
+	m.connectionsClosedMu.Lock()
+	for reason, count := range m.connectionsClosed {
+		s.ConnectionsClosed[reason] = count
+	}
+	m.connectionsClosedMu.Unlock()
+
+	return s
+}
+
+// Reason classifies the error that caused a connection to close into
+// one of ReasonGraceful, ReasonEOF, ReasonTimeout, ReasonClosed, or ReasonError
+func Reason(err error) string {
+	if err == nil {
+		return ReasonGraceful
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return ReasonEOF
+	}
+	if errors.Is(err, net.ErrClosed) || errors.Is(err, io.ErrClosedPipe) {
+		return ReasonClosed
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return ReasonTimeout
+	}
+	return ReasonError
+}
+
+func (m *Memory) operation(op uint16) *operation {
+	m.operationsMu.RLock()
+	o, ok := m.operations[op]
+	m.operationsMu.RUnlock()
+	if ok {
+		return o
+	}
+
+	m.operationsMu.Lock()
+	if o, ok = m.operations[op]; !ok {
+		o = &operation{
+			handlerLatency: newHistogram(),
+		}
+		m.operations[op] = o
+	}
+	m.operationsMu.Unlock()
+	return o
+}
+
+func (m *Memory) sortedOperations() []uint16 {
+	m.operationsMu.RLock()
+	ops := make([]uint16, 0, len(m.operations))
+	for op := range m.operations {
+		ops = append(ops, op)
+	}
+	m.operationsMu.RUnlock()
+	sort.Slice(ops, func(i, j int) bool {
+		return ops[i] < ops[j]
+	})
+	return ops
+}
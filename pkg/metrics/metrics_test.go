@@ -0,0 +1,124 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemory(t *testing.T) {
+	t.Parallel()
+
+	m := New()
+
+	m.PacketRead(10, 16)
+	m.PacketRead(10, 24)
+	m.PacketWritten(11, 8)
+	m.Flushed()
+	m.IncomingQueueDepth(3)
+	m.StreamOpened()
+	m.StreamOpened()
+	m.StreamClosed()
+	m.HandlerLatency(10, time.Millisecond*2)
+	m.ConnectionOpened()
+	m.ConnectionClosed(nil)
+	m.ConnectionClosed(io.EOF)
+	m.ConnectionClosed(os.ErrDeadlineExceeded)
+	m.ConnectionClosed(errors.New("unknown"))
+	m.PingRTT(time.Microsecond * 200)
+
+	s := m.Snapshot()
+	assert.Equal(t, uint64(2), s.Operations[10].PacketsRead)
+	assert.Equal(t, uint64(40), s.Operations[10].BytesRead)
+	assert.Equal(t, uint64(1), s.Operations[11].PacketsWritten)
+	assert.Equal(t, uint64(8), s.Operations[11].BytesWritten)
+	assert.Equal(t, uint64(1), s.Operations[10].HandlerLatency.Count)
+	assert.Equal(t, time.Millisecond*2, s.Operations[10].HandlerLatency.Sum)
+	assert.Equal(t, uint64(1), s.Flushes)
+	assert.Equal(t, int64(3), s.IncomingQueueDepth)
+	assert.Equal(t, uint64(2), s.StreamsOpened)
+	assert.Equal(t, uint64(1), s.StreamsClosed)
+	assert.Equal(t, uint64(1), s.ConnectionsOpened)
+	assert.Equal(t, map[string]uint64{ReasonGraceful: 1, ReasonEOF: 1, ReasonTimeout: 1, ReasonError: 1}, s.ConnectionsClosed)
+	assert.Equal(t, uint64(1), s.PingRTT.Count)
+
+	for i, bucket := range s.PingRTT.Buckets {
+		if bucket < 0.0002 {
+			assert.Equal(t, uint64(0), s.PingRTT.Counts[i])
+		} else {
+			assert.Equal(t, uint64(1), s.PingRTT.Counts[i])
+		}
+	}
+}
+
+func TestHistogramSnapshot(t *testing.T) {
+	t.Parallel()
+
+	h := newHistogram()
+	h.observe(time.Hour)
+	s := h.snapshot()
+	assert.Equal(t, uint64(1), s.Count)
+	assert.Equal(t, uint64(0), s.Counts[len(s.Counts)-1])
+
+	// Snapshots taken while observations are being made never count fewer observations in total than in any bucket
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 10000; i++ {
+			h.observe(time.Microsecond)
+		}
+	}()
+	for {
+		s = h.snapshot()
+		for _, count := range s.Counts {
+			require.LessOrEqual(t, count, s.Count)
+		}
+		select {
+		case <-done:
+			assert.Equal(t, uint64(10001), h.snapshot().Count)
+			return
+		default:
+		}
+	}
+}
+
+func TestWritePrometheus(t *testing.T) {
+	t.Parallel()
+
+	m := New()
+	m.PacketRead(12, 8)
+	m.PacketRead(10, 16)
+	m.HandlerLatency(10, time.Millisecond)
+	m.ConnectionClosed(nil)
+
+	b := new(bytes.Buffer)
+	require.NoError(t, m.WritePrometheus(b))
+	out := b.String()
+
+	assert.Contains(t, out, "# TYPE frisbee_packets_read_total counter\n")
+	assert.Contains(t, out, "frisbee_packets_read_total{operation=\"10\"} 1\n")
+	assert.Contains(t, out, "frisbee_bytes_read_total{operation=\"12\"} 8\n")
+	assert.Less(t, strings.Index(out, "operation=\"10\"} 1"), strings.Index(out, "operation=\"12\"} 1"))
+	assert.Contains(t, out, "frisbee_handler_latency_seconds_bucket{operation=\"10\",le=\"0.001\"} 1\n")
+	assert.Contains(t, out, "frisbee_handler_latency_seconds_bucket{operation=\"10\",le=\"+Inf\"} 1\n")
+	assert.Contains(t, out, "frisbee_handler_latency_seconds_sum{operation=\"10\"} 0.001\n")
+	assert.Contains(t, out, "frisbee_connections_closed_total{reason=\"graceful\"} 1\n")
+	assert.Contains(t, out, "frisbee_ping_rtt_seconds_count 0\n")
+	assert.Contains(t, out, "frisbee_checksum_mismatches_total 0\n")
+
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		assert.Len(t, strings.Fields(line), 2, line)
+	}
+}
@@ -0,0 +1,99 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+// ContentType is the content type of the Prometheus text exposition format
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// WritePrometheus renders the recorded metrics to w using the Prometheus text exposition format
+func (m *Memory) WritePrometheus(w io.Writer) error {
+	s := m.Snapshot()
+	ops := m.sortedOperations()
+	b := new(bytes.Buffer)
+
+	writeHeader(b, "frisbee_packets_read_total", "counter", "Number of packets read, by operation.")
+	for _, op := range ops {
+		fmt.Fprintf(b, "frisbee_packets_read_total{operation=\"%d\"} %d\n", op, s.Operations[op].PacketsRead)
+	}
+	writeHeader(b, "frisbee_bytes_read_total", "counter", "Number of bytes read (including metadata), by operation.")
+	for _, op := range ops {
+		fmt.Fprintf(b, "frisbee_bytes_read_total{operation=\"%d\"} %d\n", op, s.Operations[op].BytesRead)
+	}
+	writeHeader(b, "frisbee_packets_written_total", "counter", "Number of packets written, by operation.")
+	for _, op := range ops {
+		fmt.Fprintf(b, "frisbee_packets_written_total{operation=\"%d\"} %d\n", op, s.Operations[op].PacketsWritten)
+	}
+	writeHeader(b, "frisbee_bytes_written_total", "counter", "Number of bytes written (including metadata), by operation.")
+	for _, op := range ops {
+		fmt.Fprintf(b, "frisbee_bytes_written_total{operation=\"%d\"} %d\n", op, s.Operations[op].BytesWritten)
+	}
+	writeHeader(b, "frisbee_handler_latency_seconds", "histogram", "Latency of handler functions, by operation.")
+	for _, op := range ops {
+		writeHistogram(b, "frisbee_handler_latency_seconds", "operation=\""+strconv.Itoa(int(op))+"\"", s.Operations[op].HandlerLatency)
+	}
+
+	writeHeader(b, "frisbee_flushes_total", "counter", "Number of times a write buffer was flushed.")
+	fmt.Fprintf(b, "frisbee_flushes_total %d\n", s.Flushes)
+	writeHeader(b, "frisbee_incoming_queue_depth", "gauge", "Depth of the most recently updated incoming packet queue.")
+	fmt.Fprintf(b, "frisbee_incoming_queue_depth %d\n", s.IncomingQueueDepth)
+	writeHeader(b, "frisbee_streams_opened_total", "counter", "Number of streams opened.")
+	fmt.Fprintf(b, "frisbee_streams_opened_total %d\n", s.StreamsOpened)
+	writeHeader(b, "frisbee_streams_closed_total", "counter", "Number of streams closed.")
+	fmt.Fprintf(b, "frisbee_streams_closed_total %d\n", s.StreamsClosed)
+	writeHeader(b, "frisbee_connections_opened_total", "counter", "Number of connections opened.")
+	fmt.Fprintf(b, "frisbee_connections_opened_total %d\n", s.ConnectionsOpened)
+
+	reasons := make([]string, 0, len(s.ConnectionsClosed))
+	for reason := range s.ConnectionsClosed {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	writeHeader(b, "frisbee_connections_closed_total", "counter", "Number of connections closed, by reason.")
+	for _, reason := range reasons {
+		fmt.Fprintf(b, "frisbee_connections_closed_total{reason=%q} %d\n", reason, s.ConnectionsClosed[reason])
+	}
+
+	writeHeader(b, "frisbee_ping_rtt_seconds", "histogram", "Round-trip time of PING packets.")
+	writeHistogram(b, "frisbee_ping_rtt_seconds", "", s.PingRTT)
+
+	writeHeader(b, "frisbee_checksum_mismatches_total", "counter", "Number of packets read whose checksum did not match.")
+	fmt.Fprintf(b, "frisbee_checksum_mismatches_total %d\n", s.ChecksumMismatches)
+
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// ServeHTTP implements http.Handler so that Memory can be used directly as a Prometheus scrape endpoint
+func (m *Memory) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", ContentType)
+	_ = m.WritePrometheus(w)
+}
+
+func writeHeader(b *bytes.Buffer, name string, kind string, help string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func writeHistogram(b *bytes.Buffer, name string, labels string, h HistogramSnapshot) {
+	separator := ""
+	if labels != "" {
+		separator = ","
+	}
+	for i, bucket := range h.Buckets {
+		fmt.Fprintf(b, "%s_bucket{%s%sle=\"%s\"} %d\n", name, labels, separator, strconv.FormatFloat(bucket, 'g', -1, 64), h.Counts[i])
+	}
+	fmt.Fprintf(b, "%s_bucket{%s%sle=\"+Inf\"} %d\n", name, labels, separator, h.Count)
+	if labels != "" {
+		labels = "{" + labels + "}"
+	}
+	fmt.Fprintf(b, "%s_sum%s %s\n", name, labels, strconv.FormatFloat(h.Sum.Seconds(), 'g', -1, 64))
+	fmt.Fprintf(b, "%s_count%s %d\n", name, labels, h.Count)
+}
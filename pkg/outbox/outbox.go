@@ -0,0 +1,580 @@
+// SPDX-License-Identifier: Apache-2.0
+
+// Package outbox implements a disk-backed outbox, which is a log of packets that could not be sent yet
+// (for example, because a client is disconnected) and that are sent in order once they can be.
+//
+// The outbox is stored in a directory as a sequence of segment files named after their sequence number
+// (for example, "00000000000000000001.seg"), and a cursor file that records how far the oldest segment has
+// been drained. New packets are appended to the newest segment until it reaches the segment size, after which
+// a new segment is started, and segments are deleted once they have been drained or have expired.
+// All integers are encoded in big-endian byte order.
+//
+//	Record (26 Bytes + Extensions + Content):
+//		Length           uint32 // Length of the rest of the record after the Checksum
+//		Checksum         uint32 // CRC32C of the rest of the record after the Checksum
+//		Timestamp        int64  // Unix time in nanoseconds at which the packet was appended
+//		Id               uint16 // Packet Metadata.Id
+//		IdHigh           uint16 // Packet Metadata.IdHigh
+//		Operation        uint16 // Packet Metadata.Operation
+//		ExtensionsLength uint32 // Length of the encoded packet Extensions (including its Headers)
+//		Extensions       [ExtensionsLength]byte
+//		Content          [Length - 20 - ExtensionsLength]byte
+//
+//	Cursor (16 Bytes):
+//		Segment uint64 // Sequence number of the segment that is being drained
+//		Offset  uint64 // Offset of the next record to drain in the segment
+//
+// Records that were only partially written when the process stopped are discarded when the outbox is opened.
+package outbox
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+var (
+	FullErr                 = errors.New("outbox is full")
+	ClosedErr               = errors.New("outbox is closed")
+	InvalidContentLengthErr = errors.New("invalid content length")
+	InvalidDirErr           = errors.New("outbox directory must be set")
+)
+
+const (
+	// DefaultSegmentSize is the default size at which a new segment is started
+	DefaultSegmentSize = 4 << 20
+
+	// DefaultMaxSize is the default maximum size of all the segments of an outbox
+	DefaultMaxSize = 256 << 20
+)
+
+const (
+	LengthOffset = 0 // 0
+	LengthSize   = 4
+
+	ChecksumOffset = LengthOffset + LengthSize // 4
+	ChecksumSize   = 4
+
+	TimestampOffset = ChecksumOffset + ChecksumSize // 8
+	TimestampSize   = 8
+
+	IdOffset = TimestampOffset + TimestampSize // 16
+	IdSize   = 2
+
+	IdHighOffset = IdOffset + IdSize // 18
+	IdHighSize   = 2
+
+	OperationOffset = IdHighOffset + IdHighSize // 20
+	OperationSize   = 2
+
+	ExtensionsLengthOffset = OperationOffset + OperationSize // 22
+	ExtensionsLengthSize   = 4
+
+	RecordHeaderSize = ExtensionsLengthOffset + ExtensionsLengthSize // 26
+)
+
+const (
+	// segmentExtension is the file extension of segment files
+	segmentExtension = ".seg"
+
+	// cursorFile is the name of the cursor file
+	cursorFile = "cursor"
+
+	// cursorSize is the size of the cursor file
+	cursorSize = 16
+)
+
+// castagnoli is the CRC32C table used to compute record checksums
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// Options configures an Outbox
+//
+// Default Values:
+//
+//	options := Options {
+//		SegmentSize: DefaultSegmentSize,
+//		MaxSize: DefaultMaxSize,
+//	}
+type Options struct {
+	// Dir is the directory that the outbox is stored in, which is created if it does not exist.
+	// Only one Outbox can use a directory at a time.
+	Dir string
+
+	// SegmentSize is the size at which a new segment is started
+	SegmentSize int64
+
+	// MaxSize is the maximum size of all the segments, after which appending fails with FullErr
+	MaxSize int64
+
+	// MaxAge is how long packets are kept in the outbox before they are discarded instead of sent (0 keeps them forever)
+	MaxAge time.Duration
+
+	// Sync makes every append wait for the packet to be written to stable storage, so that it survives a power
+	// failure and not only a process restart
+	Sync bool
+}
+
+// segment is a single segment file
+type segment struct {
+	seq      uint64
+	size     int64
+	modified time.Time
+}
+
+// Outbox is a disk-backed log of packets that is drained in order. It is safe to use from multiple goroutines.
+type Outbox struct {
+	options  Options
+	mu       sync.Mutex
+	segments []segment
+	size     int64
+	lastSeq  uint64
+
+	// writer is the newest segment opened for appending, and reader is the oldest segment opened for draining
+	writer     *os.File
+	reader     *os.File
+	readOffset int64
+
+	buf    []byte
+	closed bool
+}
+
+// Open opens (or creates) the outbox in the directory given by the options
+func Open(options Options) (*Outbox, error) {
+	if options.Dir == "" {
+		return nil, InvalidDirErr
+	}
+	if options.SegmentSize <= 0 {
+		options.SegmentSize = DefaultSegmentSize
+	}
+	if options.MaxSize <= 0 {
+		options.MaxSize = DefaultMaxSize
+	}
+	if err := os.MkdirAll(options.Dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	o := &Outbox{
+		options: options,
+	}
+	entries, err := os.ReadDir(options.Dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, segmentExtension) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExtension), 10, 64)
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		o.segments = append(o.segments, segment{seq: seq, size: info.Size(), modified: info.ModTime()})
+	}
+	sort.Slice(o.segments, func(i, j int) bool {
+		return o.segments[i].seq < o.segments[j].seq
+	})
+	for _, s := range o.segments {
+		o.size += s.size
+	}
+
+	cursorSeq, cursorOffset, err := o.readCursor()
+	if err != nil {
+		return nil, err
+	}
+	o.lastSeq = cursorSeq
+	for len(o.segments) > 0 && o.segments[0].seq < cursorSeq {
+		if err = o.removeFirst(); err != nil {
+			return nil, err
+		}
+	}
+	if len(o.segments) > 0 {
+		last := &o.segments[len(o.segments)-1]
+		o.lastSeq = last.seq
+		if err = o.truncate(last); err != nil {
+			return nil, err
+		}
+		if o.segments[0].seq == cursorSeq {
+			o.readOffset = min(int64(cursorOffset), o.segments[0].size)
+		}
+	}
+	if err = o.expire(time.Now()); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// Empty returns whether every packet in the outbox has been drained
+func (o *Outbox) Empty() bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.empty()
+}
+
+// Size returns the size of all the segments of the outbox
+func (o *Outbox) Size() int64 {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.size
+}
+
+// Append appends a copy of the packet to the outbox. If the outbox would grow larger than its maximum size
+// (even after expired segments are deleted), nothing is appended and FullErr is returned.
+func (o *Outbox) Append(p *packet.Packet) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.append(p)
+}
+
+// Send passes the packet to send if the outbox is empty, and appends it to the outbox instead if it is not (so
+// that packets are sent in order) or if send returns an error. The packet is not retained by the outbox.
+func (o *Outbox) Send(p *packet.Packet, send func(*packet.Packet) error) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.closed {
+		return ClosedErr
+	}
+	if o.empty() && send(p) == nil {
+		return nil
+	}
+	return o.append(p)
+}
+
+// Drain passes every packet in the outbox to send in the order they were appended (discarding the ones older
+// than MaxAge), until the outbox is empty or send returns an error. A packet for which send returns an error stays
+// in the outbox, and is passed to send again by the next call to Drain. The packets are put back into the packet
+// pool once send returns, so send must not retain them.
+//
+// Packets that were drained since the outbox was last closed (or since the last call to Drain) may be drained again
+// if the process stops before the outbox is closed.
+func (o *Outbox) Drain(send func(*packet.Packet) error) (err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.closed {
+		return ClosedErr
+	}
+	defer func() {
+		if cursorErr := o.writeCursor(); err == nil {
+			err = cursorErr
+		}
+	}()
+	now := time.Now()
+	if err = o.expire(now); err != nil {
+		return err
+	}
+	for {
+		var p *packet.Packet
+		var next int64
+		p, next, err = o.next(now)
+		if err != nil || p == nil {
+			return err
+		}
+		err = send(p)
+		packet.Put(p)
+		if err != nil {
+			return err
+		}
+		o.readOffset = next
+	}
+}
+
+// Close saves how far the outbox has been drained and closes its segment files
+func (o *Outbox) Close() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.closed {
+		return ClosedErr
+	}
+	o.closed = true
+	err := o.writeCursor()
+	if o.writer != nil {
+		err = errors.Join(err, o.writer.Sync(), o.writer.Close())
+		o.writer = nil
+	}
+	if o.reader != nil {
+		err = errors.Join(err, o.reader.Close())
+		o.reader = nil
+	}
+	return err
+}
+
+func (o *Outbox) empty() bool {
+	return len(o.segments) == 0 || (len(o.segments) == 1 && o.readOffset >= o.segments[0].size)
+}
+
+func (o *Outbox) append(p *packet.Packet) error {
+	if o.closed {
+		return ClosedErr
+	}
+	if int(p.Metadata.ContentLength) != p.Content.Len() {
+		return InvalidContentLengthErr
+	}
+	extensions, err := p.Extensions.WithHeaders(p.Headers)
+	if err != nil {
+		return err
+	}
+	extensionsLength := extensions.EncodedSize()
+	length := RecordHeaderSize + extensionsLength + p.Content.Len()
+	if o.size+int64(length) > o.options.MaxSize {
+		if err = o.expire(time.Now()); err != nil {
+			return err
+		}
+		if o.size+int64(length) > o.options.MaxSize {
+			return FullErr
+		}
+	}
+
+	if cap(o.buf) < length {
+		o.buf = make([]byte, length)
+	}
+	b := o.buf[:RecordHeaderSize]
+	binary.BigEndian.PutUint32(b[LengthOffset:LengthOffset+LengthSize], uint32(length-TimestampOffset))
+	binary.BigEndian.PutUint64(b[TimestampOffset:TimestampOffset+TimestampSize], uint64(time.Now().UnixNano()))
+	binary.BigEndian.PutUint16(b[IdOffset:IdOffset+IdSize], p.Metadata.Id)
+	binary.BigEndian.PutUint16(b[IdHighOffset:IdHighOffset+IdHighSize], p.Metadata.IdHigh)
+	binary.BigEndian.PutUint16(b[OperationOffset:OperationOffset+OperationSize], p.Metadata.Operation)
+	binary.BigEndian.PutUint32(b[ExtensionsLengthOffset:ExtensionsLengthOffset+ExtensionsLengthSize], uint32(extensionsLength))
+	b = extensions.Encode(b)
+	b = append(b, p.Content.Bytes()...)
+	binary.BigEndian.PutUint32(b[ChecksumOffset:ChecksumOffset+ChecksumSize], crc32.Checksum(b[TimestampOffset:], castagnoli))
+
+	if err = o.openWriter(); err != nil {
+		return err
+	}
+	if _, err = o.writer.Write(b); err != nil {
+		return err
+	}
+	if o.options.Sync {
+		if err = o.writer.Sync(); err != nil {
+			return err
+		}
+	}
+	last := &o.segments[len(o.segments)-1]
+	last.size += int64(length)
+	last.modified = time.Now()
+	o.size += int64(length)
+	return nil
+}
+
+// openWriter opens the newest segment for appending, and starts a new segment if there is none or if it is full
+func (o *Outbox) openWriter() error {
+	if len(o.segments) == 0 || o.segments[len(o.segments)-1].size >= o.options.SegmentSize {
+		if o.writer != nil {
+			err := errors.Join(o.writer.Sync(), o.writer.Close())
+			o.writer = nil
+			if err != nil {
+				return err
+			}
+		}
+		o.lastSeq++
+		o.segments = append(o.segments, segment{seq: o.lastSeq, modified: time.Now()})
+	}
+	if o.writer != nil {
+		return nil
+	}
+	last := o.segments[len(o.segments)-1]
+	f, err := os.OpenFile(o.segmentPath(last.seq), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		if last.size == 0 {
+			o.segments = o.segments[:len(o.segments)-1]
+		}
+		return err
+	}
+	o.writer = f
+	return nil
+}
+
+// next reads the next packet to drain along with the offset of the record after it, deleting segments that have been
+// drained and skipping expired packets. If a record is corrupt, the rest of its segment is skipped.
+func (o *Outbox) next(now time.Time) (*packet.Packet, int64, error) {
+	for {
+		if len(o.segments) == 0 {
+			return nil, 0, nil
+		}
+		first := o.segments[0]
+		if o.readOffset >= first.size {
+			if err := o.removeFirst(); err != nil {
+				return nil, 0, err
+			}
+			continue
+		}
+		if o.reader == nil {
+			f, err := os.Open(o.segmentPath(first.seq))
+			if err != nil {
+				return nil, 0, err
+			}
+			o.reader = f
+		}
+		record, err := o.readRecord(o.reader, o.readOffset, first.size)
+		if err != nil {
+			o.readOffset = first.size
+			continue
+		}
+		next := o.readOffset + int64(len(record))
+		timestamp := time.Unix(0, int64(binary.BigEndian.Uint64(record[TimestampOffset:TimestampOffset+TimestampSize])))
+		if o.options.MaxAge > 0 && now.Sub(timestamp) > o.options.MaxAge {
+			o.readOffset = next
+			continue
+		}
+		p, err := decodeRecord(record)
+		if err != nil {
+			o.readOffset = next
+			continue
+		}
+		return p, next, nil
+	}
+}
+
+// readRecord reads and verifies the record at the given offset of a segment of the given size
+func (o *Outbox) readRecord(f *os.File, offset int64, size int64) ([]byte, error) {
+	if offset+TimestampOffset > size {
+		return nil, io.ErrUnexpectedEOF
+	}
+	var header [TimestampOffset]byte
+	if _, err := f.ReadAt(header[:], offset); err != nil {
+		return nil, err
+	}
+	length := int64(binary.BigEndian.Uint32(header[LengthOffset:LengthOffset+LengthSize])) + TimestampOffset
+	if length < RecordHeaderSize || offset+length > size {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if int64(cap(o.buf)) < length {
+		o.buf = make([]byte, length)
+	}
+	record := o.buf[:length]
+	if _, err := f.ReadAt(record, offset); err != nil {
+		return nil, err
+	}
+	if crc32.Checksum(record[TimestampOffset:], castagnoli) != binary.BigEndian.Uint32(record[ChecksumOffset:ChecksumOffset+ChecksumSize]) {
+		return nil, fmt.Errorf("corrupt outbox record at offset %d", offset)
+	}
+	return record, nil
+}
+
+// decodeRecord decodes the packet in a record that has been verified by readRecord
+func decodeRecord(record []byte) (*packet.Packet, error) {
+	extensionsLength := int(binary.BigEndian.Uint32(record[ExtensionsLengthOffset : ExtensionsLengthOffset+ExtensionsLengthSize]))
+	if RecordHeaderSize+extensionsLength > len(record) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	p := packet.Get()
+	p.Metadata.Id = binary.BigEndian.Uint16(record[IdOffset : IdOffset+IdSize])
+	p.Metadata.IdHigh = binary.BigEndian.Uint16(record[IdHighOffset : IdHighOffset+IdHighSize])
+	p.Metadata.Operation = binary.BigEndian.Uint16(record[OperationOffset : OperationOffset+OperationSize])
+	if err := p.Extensions.Decode(record[RecordHeaderSize : RecordHeaderSize+extensionsLength]); err != nil {
+		packet.Put(p)
+		return nil, err
+	}
+	if err := p.Extensions.ExtractHeaders(&p.Headers); err != nil {
+		packet.Put(p)
+		return nil, err
+	}
+	p.Content.Write(record[RecordHeaderSize+extensionsLength:])
+	p.Metadata.ContentLength = uint32(p.Content.Len())
+	return p, nil
+}
+
+// truncate discards the records at the end of the segment that were only partially written
+func (o *Outbox) truncate(s *segment) error {
+	f, err := os.OpenFile(o.segmentPath(s.seq), os.O_RDWR, 0o600)
+	if err != nil {
+		return err
+	}
+	var offset int64
+	for offset < s.size {
+		record, err := o.readRecord(f, offset, s.size)
+		if err != nil {
+			break
+		}
+		offset += int64(len(record))
+	}
+	if offset < s.size {
+		err = f.Truncate(offset)
+		o.size -= s.size - offset
+		s.size = offset
+	}
+	return errors.Join(err, f.Close())
+}
+
+// expire deletes the oldest segments whose newest packet is older than MaxAge
+func (o *Outbox) expire(now time.Time) error {
+	if o.options.MaxAge <= 0 {
+		return nil
+	}
+	for len(o.segments) > 0 && now.Sub(o.segments[0].modified) > o.options.MaxAge {
+		if err := o.removeFirst(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeFirst deletes the oldest segment
+func (o *Outbox) removeFirst() error {
+	if o.reader != nil {
+		_ = o.reader.Close()
+		o.reader = nil
+	}
+	if len(o.segments) == 1 && o.writer != nil {
+		_ = o.writer.Close()
+		o.writer = nil
+	}
+	first := o.segments[0]
+	if err := os.Remove(o.segmentPath(first.seq)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	o.segments = o.segments[1:]
+	o.size -= first.size
+	o.readOffset = 0
+	return nil
+}
+
+// readCursor reads the cursor file, and returns zero values if it does not exist
+func (o *Outbox) readCursor() (uint64, uint64, error) {
+	b, err := os.ReadFile(filepath.Join(o.options.Dir, cursorFile))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return 0, 0, nil
+		}
+		return 0, 0, err
+	}
+	if len(b) != cursorSize {
+		return 0, 0, nil
+	}
+	return binary.BigEndian.Uint64(b[:8]), binary.BigEndian.Uint64(b[8:]), nil
+}
+
+// writeCursor atomically replaces the cursor file with how far the oldest segment has been drained
+func (o *Outbox) writeCursor() error {
+	var b [cursorSize]byte
+	if len(o.segments) > 0 {
+		binary.BigEndian.PutUint64(b[:8], o.segments[0].seq)
+		binary.BigEndian.PutUint64(b[8:], uint64(o.readOffset))
+	} else {
+		binary.BigEndian.PutUint64(b[:8], o.lastSeq+1)
+	}
+	path := filepath.Join(o.options.Dir, cursorFile)
+	if err := os.WriteFile(path+".tmp", b[:], 0o600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+func (o *Outbox) segmentPath(seq uint64) string {
+	return filepath.Join(o.options.Dir, fmt.Sprintf("%020d%s", seq, segmentExtension))
+}
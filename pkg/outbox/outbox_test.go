@@ -0,0 +1,183 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package outbox
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+func newTestPacket(id uint16, content string) *packet.Packet {
+	p := packet.Get()
+	p.Metadata.Id = id
+	p.Metadata.Operation = 32
+	p.Content.Write([]byte(content))
+	p.Metadata.ContentLength = uint32(len(content))
+	return p
+}
+
+func appendTestPackets(t *testing.T, o *Outbox, from, to int) {
+	for i := from; i < to; i++ {
+		p := newTestPacket(uint16(i), "content")
+		require.NoError(t, o.Append(p))
+		packet.Put(p)
+	}
+}
+
+// drainTestPackets drains the outbox and returns the IDs of the packets that were drained
+func drainTestPackets(t *testing.T, o *Outbox) (ids []uint16) {
+	require.NoError(t, o.Drain(func(p *packet.Packet) error {
+		ids = append(ids, p.Metadata.Id)
+		return nil
+	}))
+	return
+}
+
+func segmentFiles(t *testing.T, dir string) []string {
+	files, err := filepath.Glob(filepath.Join(dir, "*"+segmentExtension))
+	require.NoError(t, err)
+	return files
+}
+
+func TestOutbox(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	o, err := Open(Options{Dir: dir, SegmentSize: 128})
+	require.NoError(t, err)
+	assert.True(t, o.Empty())
+
+	p := newTestPacket(1, "hello")
+	p.Metadata.IdHigh = 2
+	require.NoError(t, p.Extensions.Set(metadata.ExtensionTrace, []byte("trace")))
+	require.NoError(t, p.SetHeader("tenant", "a"))
+	require.NoError(t, o.Append(p))
+	packet.Put(p)
+	appendTestPackets(t, o, 2, 10)
+	assert.False(t, o.Empty())
+	assert.Greater(t, len(segmentFiles(t, dir)), 1)
+
+	// Draining stops at the first packet that cannot be sent, which is sent again by the next call
+	var ids []uint16
+	failed := errors.New("failed")
+	assert.ErrorIs(t, o.Drain(func(p *packet.Packet) error {
+		if p.Metadata.Id == 1 {
+			assert.Equal(t, uint16(2), p.Metadata.IdHigh)
+			assert.Equal(t, []byte("hello"), p.Content.Bytes())
+			trace, ok := p.Extensions.Get(metadata.ExtensionTrace)
+			require.True(t, ok)
+			assert.Equal(t, []byte("trace"), trace)
+			tenant, ok := p.Header("tenant")
+			require.True(t, ok)
+			assert.Equal(t, "a", tenant)
+		}
+		if p.Metadata.Id == 5 {
+			return failed
+		}
+		ids = append(ids, p.Metadata.Id)
+		return nil
+	}), failed)
+	assert.Equal(t, []uint16{1, 2, 3, 4}, ids)
+	assert.Equal(t, []uint16{5, 6, 7, 8, 9}, drainTestPackets(t, o))
+	assert.True(t, o.Empty())
+	assert.Equal(t, 0, len(segmentFiles(t, dir)))
+	assert.Equal(t, int64(0), o.Size())
+
+	// Packets are only sent directly while the outbox is empty
+	var sent []uint16
+	send := func(p *packet.Packet) error {
+		if p.Metadata.Id == 11 {
+			return failed
+		}
+		sent = append(sent, p.Metadata.Id)
+		return nil
+	}
+	for i := 10; i < 13; i++ {
+		p := newTestPacket(uint16(i), "content")
+		require.NoError(t, o.Send(p, send))
+		packet.Put(p)
+	}
+	assert.Equal(t, []uint16{10}, sent)
+	assert.Equal(t, []uint16{11, 12}, drainTestPackets(t, o))
+
+	require.NoError(t, o.Close())
+	assert.ErrorIs(t, o.Append(newTestPacket(0, "")), ClosedErr)
+	assert.ErrorIs(t, o.Close(), ClosedErr)
+}
+
+func TestOutboxReopen(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	o, err := Open(Options{Dir: dir, SegmentSize: 128})
+	require.NoError(t, err)
+	appendTestPackets(t, o, 0, 10)
+
+	count := 0
+	require.Error(t, o.Drain(func(p *packet.Packet) error {
+		if count == 3 {
+			return errors.New("disconnected")
+		}
+		count++
+		return nil
+	}))
+	require.NoError(t, o.Close())
+
+	// A partially written record at the end of the newest segment is discarded
+	files := segmentFiles(t, dir)
+	f, err := os.OpenFile(files[len(files)-1], os.O_WRONLY|os.O_APPEND, 0o600)
+	require.NoError(t, err)
+	_, err = f.Write([]byte{0, 0, 0, 100, 1, 2})
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	o, err = Open(Options{Dir: dir, SegmentSize: 128})
+	require.NoError(t, err)
+	appendTestPackets(t, o, 10, 12)
+	assert.Equal(t, []uint16{3, 4, 5, 6, 7, 8, 9, 10, 11}, drainTestPackets(t, o))
+	require.NoError(t, o.Close())
+
+	o, err = Open(Options{Dir: dir})
+	require.NoError(t, err)
+	assert.True(t, o.Empty())
+	assert.Nil(t, drainTestPackets(t, o))
+	require.NoError(t, o.Close())
+}
+
+func TestOutboxLimits(t *testing.T) {
+	t.Parallel()
+
+	p := newTestPacket(0, "content")
+	recordSize := int64(RecordHeaderSize + p.Content.Len())
+	packet.Put(p)
+
+	o, err := Open(Options{Dir: t.TempDir(), SegmentSize: recordSize, MaxSize: recordSize * 3})
+	require.NoError(t, err)
+	appendTestPackets(t, o, 0, 3)
+	p = newTestPacket(3, "content")
+	assert.ErrorIs(t, o.Append(p), FullErr)
+	packet.Put(p)
+	assert.Equal(t, recordSize*3, o.Size())
+	assert.Equal(t, []uint16{0, 1, 2}, drainTestPackets(t, o))
+	require.NoError(t, o.Close())
+
+	o, err = Open(Options{Dir: t.TempDir(), SegmentSize: recordSize, MaxAge: time.Millisecond * 50})
+	require.NoError(t, err)
+	appendTestPackets(t, o, 0, 2)
+	time.Sleep(time.Millisecond * 100)
+	appendTestPackets(t, o, 2, 3)
+	assert.Equal(t, []uint16{2}, drainTestPackets(t, o))
+	require.NoError(t, o.Close())
+
+	_, err = Open(Options{})
+	assert.ErrorIs(t, err, InvalidDirErr)
+}
@@ -17,20 +17,51 @@ import (
 //			ContentLength uint32 // 4 Bytes
 //		}
 //		Content *content.Content
+//		Extensions metadata.Extensions
+//		Headers metadata.Headers
 //	}
 //
 // The ID field can be used however the user sees fit, however ContentLength must match the length of the content being
 // delivered with the frisbee packet (see the Async.WritePacket function for more details), and the Operation field must be greater than uint16(9).
+//
+// Extensions are optional header extensions (such as trace contexts) that are sent alongside the packet
+// when they are present, and are empty for most packets.
+//
+// Headers are optional key/value headers (such as auth tokens or tenant IDs) that are sent alongside the packet
+// using the metadata.ExtensionHeaders extension (but only to peers that have advertised that they support them,
+// see frisbee.WithExtensions), and can be read and modified using the Header, SetHeader, and DeleteHeader functions.
 type Packet struct {
-	Metadata *metadata.Metadata
-	Content  *polyglot.Buffer
+	Metadata   *metadata.Metadata
+	Content    *polyglot.Buffer
+	Extensions metadata.Extensions
+	Headers    metadata.Headers
+}
+
+// Header returns the value of the header with the given key
+func (p *Packet) Header(key string) (string, bool) {
+	value, ok := p.Headers.Get(key)
+	return string(value), ok
+}
+
+// SetHeader sets the value of the header with the given key, and returns
+// metadata.HeadersTooLargeErr if the headers would be larger than metadata.MaxHeadersSize
+func (p *Packet) SetHeader(key string, value string) error {
+	return p.Headers.Set(key, []byte(value))
+}
+
+// DeleteHeader removes the header with the given key
+func (p *Packet) DeleteHeader(key string) {
+	p.Headers.Delete(key)
 }
 
 func (p *Packet) Reset() {
 	p.Metadata.Id = 0
+	p.Metadata.IdHigh = 0
 	p.Metadata.Operation = 0
 	p.Metadata.ContentLength = 0
 	p.Content.Reset()
+	p.Extensions.Reset()
+	p.Headers.Reset()
 }
 
 func New() *Packet {
@@ -0,0 +1,285 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package frisbee
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/loopholelabs/logging/types"
+
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+var (
+	InvalidRoute = errors.New("invalid route, operations cannot be reserved values and first cannot be greater than last")
+	UpstreamNil  = errors.New("upstream cannot be nil")
+)
+
+// Router is used by a Proxy to select the Upstream that a packet should be sent to. It is called with every
+// packet that does not match a route registered with Proxy.Route, and with the first packet of every new stream
+// (whose Operation is STREAM) if no Upstream was registered with Proxy.RouteStreams. If it returns nil, there is no
+// Upstream for the packet and an ERROR packet is sent back instead.
+type Router func(p *packet.Packet) *Upstream
+
+type proxyRoute struct {
+	first    uint16
+	last     uint16
+	upstream *Upstream
+}
+
+// Proxy accepts connections from frisbee Clients and forwards the packets it receives to pools of upstream
+// frisbee Servers (see Upstream), either by operation range or using a Router function.
+//
+// The IDs of forwarded packets are rewritten so that packets from different clients can share the same upstream
+// connection, and the first packet that the upstream server sends back with a rewritten ID is treated as the
+// response and forwarded back to the client with the original ID. Streams are mapped across the Proxy in the same way.
+// If a packet cannot be forwarded, or no response is received before the upstream connection closes or the Upstream
+// times out, the client receives an ERROR packet with the packet's original ID instead (see ErrorFrame).
+type Proxy struct {
+	listener      net.Listener
+	options       *Options
+	router        Router
+	routes        []proxyRoute
+	streams       *Upstream
+	shutdown      atomic.Bool
+	wg            sync.WaitGroup
+	streamsWg     sync.WaitGroup
+	connections   map[*Async]struct{}
+	connectionsMu sync.Mutex
+	startedCh     chan struct{}
+}
+
+// NewProxy returns an uninitialized frisbee Proxy that uses the given Router (which may be nil) for
+// packets that do not match a route. The Start method must then be called to start the proxy and listen for connections.
+func NewProxy(router Router, opts ...Option) *Proxy {
+	return &Proxy{
+		options:     loadOptions(opts...),
+		router:      router,
+		connections: make(map[*Async]struct{}),
+		startedCh:   make(chan struct{}),
+	}
+}
+
+// Route forwards the packets whose operations are between first and last (inclusive) to the given Upstream.
+// Routes are checked in the order they were registered, before the Router function.
+//
+// This function should not be called once the proxy has started.
+func (p *Proxy) Route(first uint16, last uint16, upstream *Upstream) error {
+	if first <= RESERVED9 || first > last {
+		return InvalidRoute
+	}
+	if upstream == nil {
+		return UpstreamNil
+	}
+	p.routes = append(p.routes, proxyRoute{first: first, last: last, upstream: upstream})
+	return nil
+}
+
+// RouteStreams forwards every new stream to the given Upstream instead of using the Router function.
+//
+// This function should not be called once the proxy has started.
+func (p *Proxy) RouteStreams(upstream *Upstream) error {
+	if upstream == nil {
+		return UpstreamNil
+	}
+	p.streams = upstream
+	return nil
+}
+
+// Start will start the frisbee proxy and its reactor goroutines to receive and forward incoming connections.
+func (p *Proxy) Start(addr string) error {
+	var listener net.Listener
+	var err error
+	if p.options.TLSConfig != nil {
+		listener, err = tls.Listen("tcp", addr, p.options.TLSConfig)
+	} else {
+		listener, err = net.Listen("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	return p.StartWithListener(listener)
+}
+
+// StartWithListener will start the frisbee proxy and its reactor goroutines
+// to receive and forward incoming connections with a given net.Listener.
+func (p *Proxy) StartWithListener(listener net.Listener) error {
+	if listener == nil {
+		return ListenerNil
+	}
+	p.listener = listener
+	p.wg.Add(1)
+	close(p.startedCh)
+	return p.handleListener()
+}
+
+// started returns a channel that will be closed when the proxy has successfully started
+//
+// This is meant to only be used for testing purposes.
+func (p *Proxy) started() <-chan struct{} {
+	return p.startedCh
+}
+
+func (p *Proxy) handleListener() error {
+	var backoff time.Duration
+	for {
+		newConn, err := p.listener.Accept()
+		if err != nil {
+			if p.shutdown.Load() {
+				p.wg.Done()
+				return nil
+			}
+			if ne, ok := err.(temporary); ok && ne.Temporary() {
+				if backoff == 0 {
+					backoff = minBackoff
+				} else {
+					backoff *= 2
+				}
+				if backoff > maxBackoff {
+					backoff = maxBackoff
+				}
+				p.Logger().Warn().Err(err).Msgf("Temporary Accept Error, retrying in %s", backoff)
+				time.Sleep(backoff)
+				if p.shutdown.Load() {
+					p.wg.Done()
+					return nil
+				}
+				continue
+			}
+			p.wg.Done()
+			return err
+		}
+		backoff = 0
+
+		p.wg.Add(1)
+		go p.serveConn(newConn)
+	}
+}
+
+// ServeConn takes a net.Conn and starts a goroutine to forward its packets using the Proxy.
+func (p *Proxy) ServeConn(conn net.Conn) {
+	p.wg.Add(1)
+	go p.serveConn(conn)
+}
+
+// serveConn takes a net.Conn and forwards its packets using the Proxy
+// and assumes that the proxy's wait group has been incremented by 1.
+func (p *Proxy) serveConn(newConn net.Conn) {
+	switch v := newConn.(type) {
+	case *net.TCPConn:
+		_ = v.SetKeepAlive(true)
+		_ = v.SetKeepAlivePeriod(p.options.KeepAlive)
+	}
+
+	frisbeeConn := NewAsyncWithOptions(newConn, p.options, p.handleStream)
+	p.connectionsMu.Lock()
+	if p.shutdown.Load() {
+		p.connectionsMu.Unlock()
+		_ = frisbeeConn.Close()
+		p.wg.Done()
+		return
+	}
+	p.connections[frisbeeConn] = struct{}{}
+	p.connectionsMu.Unlock()
+
+	for {
+		incoming, err := frisbeeConn.ReadPacket()
+		if err != nil {
+			p.Logger().Debug().Err(err).Msg("error while reading from proxied frisbee connection")
+			break
+		}
+		p.forward(frisbeeConn, incoming)
+	}
+	_ = frisbeeConn.Close()
+
+	p.connectionsMu.Lock()
+	delete(p.connections, frisbeeConn)
+	p.connectionsMu.Unlock()
+	p.wg.Done()
+}
+
+// route returns the Upstream for the given packet, or nil if there is none
+func (p *Proxy) route(incoming *packet.Packet) *Upstream {
+	for _, r := range p.routes {
+		if incoming.Metadata.Operation >= r.first && incoming.Metadata.Operation <= r.last {
+			return r.upstream
+		}
+	}
+	if p.router != nil {
+		return p.router(incoming)
+	}
+	return nil
+}
+
+// forward sends the incoming packet to its Upstream, or sends an ERROR packet back if there is no Upstream for it
+func (p *Proxy) forward(conn *Async, incoming *packet.Packet) {
+	if incoming.Metadata.Operation <= RESERVED9 {
+		packet.Put(incoming)
+		return
+	}
+	upstream := p.route(incoming)
+	if upstream == nil {
+		id, operation := incoming.Metadata.Id, incoming.Metadata.Operation
+		packet.Put(incoming)
+		_ = conn.WriteError(id, ErrorNoRoute, fmt.Sprintf("no route for operation %d", operation))
+		return
+	}
+	upstream.forward(conn, incoming)
+}
+
+// handleStream is the NewStreamHandler for proxied connections, and forwards new streams to their Upstream
+func (p *Proxy) handleStream(stream *Stream) {
+	p.connectionsMu.Lock()
+	if p.shutdown.Load() {
+		p.connectionsMu.Unlock()
+		_ = stream.Close()
+		return
+	}
+	p.streamsWg.Add(1)
+	p.connectionsMu.Unlock()
+	defer p.streamsWg.Done()
+
+	first, err := stream.ReadPacket()
+	if err != nil {
+		return
+	}
+	upstream := p.streams
+	if upstream == nil {
+		upstream = p.route(first)
+	}
+	if upstream == nil {
+		packet.Put(first)
+		_ = stream.Close()
+		return
+	}
+	upstream.forwardStream(stream, first)
+}
+
+// Logger returns the proxy's logger
+func (p *Proxy) Logger() types.Logger {
+	return p.options.Logger
+}
+
+// Shutdown shuts down the frisbee proxy and closes all the connections it has accepted. Upstreams are not closed.
+func (p *Proxy) Shutdown() error {
+	if p.shutdown.CompareAndSwap(false, true) {
+		p.connectionsMu.Lock()
+		for c := range p.connections {
+			_ = c.Close()
+			delete(p.connections, c)
+		}
+		p.connectionsMu.Unlock()
+		defer p.streamsWg.Wait()
+		defer p.wg.Wait()
+		if p.listener != nil {
+			return p.listener.Close()
+		}
+	}
+	return nil
+}
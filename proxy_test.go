@@ -0,0 +1,367 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package frisbee
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/loopholelabs/logging"
+
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+// startProxyTestServer starts a frisbee Server with the given HandlerTable and returns its address
+func startProxyTestServer(t *testing.T, handlerTable HandlerTable, streamHandler func(context.Context, *Stream)) (*Server, string) {
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+	s, err := NewServer(handlerTable, context.Background(), WithLogger(emptyLogger))
+	require.NoError(t, err)
+	if streamHandler != nil {
+		require.NoError(t, s.SetStreamHandler(streamHandler))
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		_ = s.StartWithListener(listener)
+	}()
+	<-s.started()
+
+	return s, listener.Addr().String()
+}
+
+// startTestProxy starts the given Proxy and returns its address
+func startTestProxy(t *testing.T, proxy *Proxy) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		_ = proxy.StartWithListener(listener)
+	}()
+	<-proxy.started()
+
+	return listener.Addr().String()
+}
+
+func readProxyTestPacket(t *testing.T, conn *Async) *packet.Packet {
+	readCh := make(chan *packet.Packet, 1)
+	go func() {
+		p, err := conn.ReadPacket()
+		assert.NoError(t, err)
+		readCh <- p
+	}()
+	select {
+	case p := <-readCh:
+		require.NotNil(t, p)
+		return p
+	case <-time.After(DefaultDeadline):
+		t.Fatal("timed out waiting for packet")
+		return nil
+	}
+}
+
+func TestProxy(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+
+	echo := func(tag byte) Handler {
+		return func(_ context.Context, incoming *packet.Packet) (*packet.Packet, Action) {
+			incoming.Content.Write([]byte{tag})
+			incoming.Metadata.ContentLength++
+			return incoming, NONE
+		}
+	}
+
+	firstServer, firstAddr := startProxyTestServer(t, HandlerTable{10: echo('a')}, nil)
+	secondServer, secondAddr := startProxyTestServer(t, HandlerTable{20: echo('b')}, nil)
+
+	first, err := NewUpstream(context.Background(), firstAddr, 2, WithLogger(emptyLogger))
+	require.NoError(t, err)
+	second, err := NewUpstream(context.Background(), secondAddr, 1, WithLogger(emptyLogger))
+	require.NoError(t, err)
+
+	proxy := NewProxy(func(p *packet.Packet) *Upstream {
+		if p.Metadata.Operation == 20 {
+			return second
+		}
+		return nil
+	}, WithLogger(emptyLogger))
+	assert.ErrorIs(t, proxy.Route(RESERVED9, 15, first), InvalidRoute)
+	assert.ErrorIs(t, proxy.Route(16, 15, first), InvalidRoute)
+	assert.ErrorIs(t, proxy.Route(10, 15, nil), UpstreamNil)
+	require.NoError(t, proxy.Route(10, 15, first))
+	proxyAddr := startTestProxy(t, proxy)
+
+	clients := make([]*Async, 3)
+	for i := range clients {
+		clients[i], err = ConnectAsync(proxyAddr, time.Minute, emptyLogger, nil)
+		require.NoError(t, err)
+	}
+
+	// Every client uses the same packet ID, so the proxy has to rewrite them to tell the responses apart
+	for i, c := range clients {
+		p := packet.Get()
+		p.Metadata.Id = 7
+		p.Metadata.Operation = 10
+		p.Content.Write([]byte{byte(i)})
+		p.Metadata.ContentLength = 1
+		require.NoError(t, c.WritePacket(p))
+		p.Metadata.Operation = 20
+		require.NoError(t, c.WritePacket(p))
+		packet.Put(p)
+	}
+
+	for i, c := range clients {
+		responses := make(map[uint16][]byte)
+		for j := 0; j < 2; j++ {
+			p := readProxyTestPacket(t, c)
+			assert.Equal(t, uint16(7), p.Metadata.Id)
+			responses[p.Metadata.Operation] = append([]byte(nil), p.Content.Bytes()...)
+			packet.Put(p)
+		}
+		assert.Equal(t, []byte{byte(i), 'a'}, responses[10])
+		assert.Equal(t, []byte{byte(i), 'b'}, responses[20])
+	}
+
+	p := packet.Get()
+	p.Metadata.Id = 8
+	p.Metadata.Operation = 30
+	require.NoError(t, clients[0].WritePacket(p))
+	packet.Put(p)
+
+	p = readProxyTestPacket(t, clients[0])
+	assert.Equal(t, uint16(8), p.Metadata.Id)
+	errorFrame, err := ParseErrorFrame(p)
+	require.NoError(t, err)
+	assert.Equal(t, ErrorNoRoute, errorFrame.Code)
+	packet.Put(p)
+
+	for _, c := range clients {
+		require.NoError(t, c.Close())
+	}
+	require.NoError(t, proxy.Shutdown())
+	require.NoError(t, first.Close())
+	require.NoError(t, second.Close())
+	assert.ErrorIs(t, second.Close(), UpstreamClosed)
+	require.NoError(t, firstServer.Shutdown())
+	require.NoError(t, secondServer.Shutdown())
+}
+
+func TestProxyUpstreamFailure(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+
+	received := make(chan struct{}, 1)
+	s, addr := startProxyTestServer(t, HandlerTable{
+		10: func(_ context.Context, _ *packet.Packet) (*packet.Packet, Action) {
+			received <- struct{}{}
+			return nil, CLOSE
+		},
+	}, nil)
+
+	upstream, err := NewUpstream(context.Background(), addr, 1, WithLogger(emptyLogger))
+	require.NoError(t, err)
+	proxy := NewProxy(nil, WithLogger(emptyLogger))
+	require.NoError(t, proxy.Route(10, 10, upstream))
+	proxyAddr := startTestProxy(t, proxy)
+
+	errCh := make(chan *ErrorFrame, 1)
+	idCh := make(chan uint16, 1)
+	c, err := NewClient(make(HandlerTable), context.Background(), WithLogger(emptyLogger))
+	require.NoError(t, err)
+	c.ErrorHandler = func(_ context.Context, id uint16, err *ErrorFrame) {
+		idCh <- id
+		errCh <- err
+	}
+	require.NoError(t, c.Connect(proxyAddr))
+
+	p := packet.Get()
+	p.Metadata.Id = 3
+	p.Metadata.Operation = 10
+	require.NoError(t, c.WritePacket(p))
+	packet.Put(p)
+
+	<-received
+	assert.Equal(t, uint16(3), <-idCh)
+	assert.Equal(t, ErrorUpstreamClosed, (<-errCh).Code)
+
+	require.NoError(t, s.Shutdown())
+
+	// Once the upstream server is gone, the proxy cannot reconnect to it
+	p = packet.Get()
+	p.Metadata.Id = 4
+	p.Metadata.Operation = 10
+	require.NoError(t, c.WritePacket(p))
+	packet.Put(p)
+
+	assert.Equal(t, uint16(4), <-idCh)
+	assert.Equal(t, ErrorUpstreamUnavailable, (<-errCh).Code)
+
+	require.NoError(t, c.Close())
+	require.NoError(t, proxy.Shutdown())
+	require.NoError(t, upstream.Close())
+}
+
+func TestProxyStream(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+
+	s, addr := startProxyTestServer(t, make(HandlerTable), func(_ context.Context, stream *Stream) {
+		for {
+			p, err := stream.ReadPacket()
+			if err != nil {
+				return
+			}
+			p.Content.Write([]byte("!"))
+			p.Metadata.ContentLength++
+			err = stream.WritePacket(p)
+			packet.Put(p)
+			if err != nil {
+				return
+			}
+		}
+	})
+
+	upstream, err := NewUpstream(context.Background(), addr, 1, WithLogger(emptyLogger))
+	require.NoError(t, err)
+	proxy := NewProxy(nil, WithLogger(emptyLogger))
+	require.NoError(t, proxy.RouteStreams(upstream))
+	proxyAddr := startTestProxy(t, proxy)
+
+	c, err := ConnectAsync(proxyAddr, time.Minute, emptyLogger, nil, func(s *Stream) {
+		_ = s.Close()
+	})
+	require.NoError(t, err)
+
+	stream := c.NewStream(42)
+	for _, message := range []string{"hello", "world"} {
+		p := packet.Get()
+		p.Content.Write([]byte(message))
+		p.Metadata.ContentLength = uint32(len(message))
+		require.NoError(t, stream.WritePacket(p))
+		packet.Put(p)
+
+		p, err = stream.ReadPacket()
+		require.NoError(t, err)
+		assert.Equal(t, uint16(42), p.Metadata.Id)
+		assert.Equal(t, message+"!", string(p.Content.Bytes()))
+		packet.Put(p)
+	}
+	require.NoError(t, stream.Close())
+
+	require.NoError(t, c.Close())
+	require.NoError(t, proxy.Shutdown())
+	require.NoError(t, upstream.Close())
+	require.NoError(t, s.Shutdown())
+}
+
+func TestProxyLateResponse(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+
+	// The upstream server handles one packet at a time, so responses are sent in the order the packets were forwarded
+	release := make(chan struct{})
+	oneWay := make(chan uint16, 1)
+	s, err := NewServer(HandlerTable{
+		10: func(_ context.Context, incoming *packet.Packet) (*packet.Packet, Action) {
+			if string(incoming.Content.Bytes()) == "slow" {
+				<-release
+			}
+			return incoming, NONE
+		},
+		11: func(_ context.Context, incoming *packet.Packet) (*packet.Packet, Action) {
+			oneWay <- incoming.Metadata.Id
+			return incoming, NONE
+		},
+	}, context.Background(), WithLogger(emptyLogger))
+	require.NoError(t, err)
+	s.SetConcurrency(1)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		_ = s.StartWithListener(listener)
+	}()
+	<-s.started()
+
+	upstream, err := NewUpstream(context.Background(), listener.Addr().String(), 1, WithLogger(emptyLogger))
+	require.NoError(t, err)
+	upstream.SetTimeout(time.Millisecond * 100)
+	assert.ErrorIs(t, upstream.SetOneWay(RESERVED9, 11), InvalidRoute)
+	require.NoError(t, upstream.SetOneWay(11, 11))
+	proxy := NewProxy(nil, WithLogger(emptyLogger))
+	require.NoError(t, proxy.Route(10, 11, upstream))
+	proxyAddr := startTestProxy(t, proxy)
+
+	slow, err := ConnectAsync(proxyAddr, time.Minute, emptyLogger, nil)
+	require.NoError(t, err)
+	fast, err := ConnectAsync(proxyAddr, time.Minute, emptyLogger, nil)
+	require.NoError(t, err)
+
+	write := func(c *Async, id uint16, operation uint16, content string) {
+		p := packet.Get()
+		p.Metadata.Id = id
+		p.Metadata.Operation = operation
+		p.Content.Write([]byte(content))
+		p.Metadata.ContentLength = uint32(len(content))
+		require.NoError(t, c.WritePacket(p))
+		packet.Put(p)
+	}
+
+	// Once the slow packet stops waiting for its response, the next packet would be
+	// given the same upstream ID if expired IDs could be reused straight away
+	uc := upstream.pool[0]
+	write(slow, 1, 10, "slow")
+	require.Eventually(t, func() bool {
+		uc.mu.Lock()
+		defer uc.mu.Unlock()
+		for id := range uc.expired {
+			uc.nextID = id
+			return len(uc.pending) == 0
+		}
+		return false
+	}, time.Second*5, time.Millisecond*10)
+	p := readProxyTestPacket(t, slow)
+	assert.Equal(t, uint16(1), p.Metadata.Id)
+	errorFrame, err := ParseErrorFrame(p)
+	require.NoError(t, err)
+	assert.Equal(t, ErrorUpstreamTimeout, errorFrame.Code)
+	packet.Put(p)
+	write(fast, 2, 10, "fast")
+	require.Eventually(t, func() bool {
+		uc.mu.Lock()
+		defer uc.mu.Unlock()
+		return len(uc.pending) == 1
+	}, time.Second*5, time.Millisecond*10)
+
+	// The late response to the slow packet arrives first, and must not be sent to the fast connection
+	close(release)
+	p = readProxyTestPacket(t, fast)
+	assert.Equal(t, uint16(2), p.Metadata.Id)
+	assert.Equal(t, "fast", string(p.Content.Bytes()))
+	packet.Put(p)
+
+	// One-way packets are not waited for, and the responses to them are discarded
+	write(fast, 3, 11, "one-way")
+	assert.Equal(t, uint16(oneWayID), <-oneWay)
+	uc.mu.Lock()
+	assert.Empty(t, uc.pending)
+	uc.mu.Unlock()
+	write(fast, 4, 10, "fast")
+	p = readProxyTestPacket(t, fast)
+	assert.Equal(t, uint16(4), p.Metadata.Id)
+	packet.Put(p)
+
+	require.NoError(t, slow.Close())
+	require.NoError(t, fast.Close())
+	require.NoError(t, proxy.Shutdown())
+	require.NoError(t, upstream.Close())
+	require.NoError(t, s.Shutdown())
+}
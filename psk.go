@@ -0,0 +1,312 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package frisbee
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"sync"
+)
+
+var (
+	InvalidPSK         = errors.New("pre-shared key must be at least 16 bytes long")
+	PSKHandshakeFailed = errors.New("pre-shared key handshake failed")
+	PSKRecordTooLarge  = errors.New("pre-shared key record too large")
+	PSKSequenceWrapped = errors.New("pre-shared key sequence number exhausted")
+)
+
+const (
+	// MinPSKSize is the minimum length of a pre-shared key
+	MinPSKSize = 16
+
+	// pskMagic is sent at the start of the handshake, followed by pskVersion and a random nonce
+	pskMagic = "FBPSK"
+
+	// pskVersion is the version of the handshake and record format
+	pskVersion = uint8(1)
+
+	// pskNonceSize is the size of the random nonce that each side sends during the handshake
+	pskNonceSize = 32
+
+	// pskHelloSize is the size of the handshake message that each side sends
+	pskHelloSize = len(pskMagic) + 1 + pskNonceSize
+
+	// pskKeySize is the size of the AES-256 session keys
+	pskKeySize = 32
+
+	// pskLengthSize is the size of the length that every record starts with
+	pskLengthSize = 4
+
+	// pskMaxPlaintext is the maximum number of bytes that are encrypted in a single record
+	pskMaxPlaintext = 1 << 14
+)
+
+// pskInfo is used to derive the session keys from the pre-shared key
+var pskInfo = []byte("frisbee psk v1 session keys")
+
+// WithPSK enables the pre-shared key encryption mode for the connections of the frisbee client or server, which encrypts
+// everything sent over the underlying net.Conn without requiring TLS (see PSKConn). Both sides of a connection must use
+// the same key, which must be at least MinPSKSize bytes long and should be generated randomly.
+func WithPSK(key []byte) Option {
+	return func(opts *Options) {
+		opts.PSK = key
+	}
+}
+
+// PSKConn is a net.Conn that encrypts everything written to it and decrypts everything read from it using AES-256-GCM
+// with session keys derived from a pre-shared key, for peers that cannot use TLS. It is used to wrap the net.Conn of
+// Async and Sync connections when the WithPSK option is set.
+//
+// The handshake is performed on the first Read or Write (or by calling Handshake). Both sides send a random nonce, and a
+// separate session key for each direction is derived from the pre-shared key and both nonces using HKDF-SHA256, so the keys
+// are unique to every connection. Each side then sends an empty record to prove that it knows the pre-shared key.
+//
+// Every record is encoded as a big-endian uint32 length followed by the encrypted data, and is encrypted using
+// a per-direction sequence number as its nonce, so records that are replayed, reordered, or dropped cannot be decrypted.
+// An error is returned by Read if a record cannot be decrypted, after which the connection should be closed.
+type PSKConn struct {
+	net.Conn
+	key []byte
+
+	handshakeMu  sync.Mutex
+	handshakeErr error
+	handshaked   bool
+
+	writeMu     sync.Mutex
+	writeCipher cipher.AEAD
+	writeSeq    uint64
+	writeBuf    []byte
+
+	readMu     sync.Mutex
+	readCipher cipher.AEAD
+	readSeq    uint64
+	readBuf    []byte
+	plaintext  []byte
+}
+
+// NewPSKConn wraps the given net.Conn with the pre-shared key encryption mode, using the given key
+func NewPSKConn(conn net.Conn, key []byte) *PSKConn {
+	return &PSKConn{
+		Conn: conn,
+		key:  key,
+	}
+}
+
+// Handshake performs the handshake if it has not been performed yet, and returns PSKHandshakeFailed
+// if the other side of the connection does not use the same pre-shared key
+func (c *PSKConn) Handshake() error {
+	c.handshakeMu.Lock()
+	defer c.handshakeMu.Unlock()
+	if !c.handshaked {
+		c.handshaked = true
+		c.handshakeErr = c.handshake()
+	}
+	return c.handshakeErr
+}
+
+// Read implements net.Conn
+func (c *PSKConn) Read(b []byte) (int, error) {
+	if err := c.Handshake(); err != nil {
+		return 0, err
+	}
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	for len(c.plaintext) == 0 {
+		var err error
+		c.plaintext, err = c.readRecord()
+		if err != nil {
+			return 0, err
+		}
+	}
+	n := copy(b, c.plaintext)
+	c.plaintext = c.plaintext[n:]
+	return n, nil
+}
+
+// Write implements net.Conn
+func (c *PSKConn) Write(b []byte) (int, error) {
+	if err := c.Handshake(); err != nil {
+		return 0, err
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	var written int
+	for len(b) > 0 {
+		chunk := b
+		if len(chunk) > pskMaxPlaintext {
+			chunk = chunk[:pskMaxPlaintext]
+		}
+		if err := c.writeRecord(chunk, nil); err != nil {
+			return written, err
+		}
+		written += len(chunk)
+		b = b[len(chunk):]
+	}
+	return written, nil
+}
+
+// handshake exchanges nonces with the other side of the connection, derives the session keys,
+// and exchanges empty records to make sure that both sides use the same pre-shared key
+func (c *PSKConn) handshake() error {
+	if len(c.key) < MinPSKSize {
+		return InvalidPSK
+	}
+	hello := make([]byte, pskHelloSize)
+	copy(hello, pskMagic)
+	hello[len(pskMagic)] = pskVersion
+	nonce := hello[len(pskMagic)+1:]
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	peerHello := make([]byte, pskHelloSize)
+	if err := c.exchange(func() error {
+		_, err := c.Conn.Write(hello)
+		return err
+	}, func() error {
+		_, err := io.ReadFull(c.Conn, peerHello)
+		return err
+	}); err != nil {
+		return err
+	}
+	if string(peerHello[:len(pskMagic)]) != pskMagic || peerHello[len(pskMagic)] != pskVersion {
+		return PSKHandshakeFailed
+	}
+	peerNonce := peerHello[len(pskMagic)+1:]
+
+	// The side with the smaller nonce uses the first key to write, so both sides agree on the keys without having roles
+	order := bytes.Compare(nonce, peerNonce)
+	if order == 0 {
+		return PSKHandshakeFailed
+	}
+	salt := make([]byte, 0, 2*pskNonceSize)
+	if order < 0 {
+		salt = append(append(salt, nonce...), peerNonce...)
+	} else {
+		salt = append(append(salt, peerNonce...), nonce...)
+	}
+	keys := hkdf(c.key, salt, pskInfo, 2*pskKeySize)
+	writeKey, readKey := keys[:pskKeySize], keys[pskKeySize:]
+	if order > 0 {
+		writeKey, readKey = readKey, writeKey
+	}
+
+	var err error
+	if c.writeCipher, err = newPSKCipher(writeKey); err != nil {
+		return err
+	}
+	if c.readCipher, err = newPSKCipher(readKey); err != nil {
+		return err
+	}
+
+	// The nonces are authenticated by the empty records, so that a tampered handshake is detected
+	return c.exchange(func() error {
+		return c.writeRecord(nil, salt)
+	}, func() error {
+		plaintext, err := c.readRecordWithData(salt)
+		if err != nil || len(plaintext) != 0 {
+			return PSKHandshakeFailed
+		}
+		return nil
+	})
+}
+
+// exchange runs write and read at the same time, since both sides of the connection write before they read
+// during the handshake, and returns the first error
+func (c *PSKConn) exchange(write func() error, read func() error) error {
+	writeErr := make(chan error, 1)
+	go func() {
+		writeErr <- write()
+	}()
+	readErr := read()
+	if err := <-writeErr; err != nil {
+		return err
+	}
+	return readErr
+}
+
+// writeRecord encrypts the plaintext (authenticating the additional data) and writes it as a single record
+func (c *PSKConn) writeRecord(plaintext []byte, additionalData []byte) error {
+	if c.writeSeq == math.MaxUint64 {
+		return PSKSequenceWrapped
+	}
+	var nonce [12]byte
+	binary.BigEndian.PutUint64(nonce[4:], c.writeSeq)
+	c.writeSeq++
+
+	c.writeBuf = binary.BigEndian.AppendUint32(c.writeBuf[:0], uint32(len(plaintext)+c.writeCipher.Overhead()))
+	c.writeBuf = c.writeCipher.Seal(c.writeBuf, nonce[:], plaintext, additionalData)
+	_, err := c.Conn.Write(c.writeBuf)
+	return err
+}
+
+// readRecord reads and decrypts a single record
+func (c *PSKConn) readRecord() ([]byte, error) {
+	return c.readRecordWithData(nil)
+}
+
+// readRecordWithData reads and decrypts a single record, which must authenticate the given additional data.
+// The returned plaintext is only valid until the next record is read.
+func (c *PSKConn) readRecordWithData(additionalData []byte) ([]byte, error) {
+	if c.readSeq == math.MaxUint64 {
+		return nil, PSKSequenceWrapped
+	}
+	var length [pskLengthSize]byte
+	if _, err := io.ReadFull(c.Conn, length[:]); err != nil {
+		return nil, err
+	}
+	size := int(binary.BigEndian.Uint32(length[:]))
+	if size < c.readCipher.Overhead() || size > pskMaxPlaintext+c.readCipher.Overhead() {
+		return nil, PSKRecordTooLarge
+	}
+	if cap(c.readBuf) < size {
+		c.readBuf = make([]byte, size)
+	}
+	c.readBuf = c.readBuf[:size]
+	if _, err := io.ReadFull(c.Conn, c.readBuf); err != nil {
+		return nil, err
+	}
+
+	var nonce [12]byte
+	binary.BigEndian.PutUint64(nonce[4:], c.readSeq)
+	c.readSeq++
+	return c.readCipher.Open(c.readBuf[:0], nonce[:], c.readBuf, additionalData)
+}
+
+// newPSKCipher returns an AES-256-GCM cipher that uses the given key
+func newPSKCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// hkdf derives length bytes from the secret using HKDF-SHA256 (RFC 5869)
+func hkdf(secret []byte, salt []byte, info []byte, length int) []byte {
+	extract := hmac.New(sha256.New, salt)
+	extract.Write(secret)
+	prk := extract.Sum(nil)
+
+	expand := hmac.New(sha256.New, prk)
+	okm := make([]byte, 0, length+sha256.Size)
+	var previous []byte
+	for counter := byte(1); len(okm) < length; counter++ {
+		expand.Reset()
+		expand.Write(previous)
+		expand.Write(info)
+		expand.Write([]byte{counter})
+		previous = expand.Sum(nil)
+		okm = append(okm, previous...)
+	}
+	return okm[:length]
+}
@@ -0,0 +1,156 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package frisbee
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/loopholelabs/logging"
+
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+var testPSK = []byte("0123456789abcdef0123456789abcdef")
+
+// recordingConn is a net.Conn that records everything written to it instead of sending it
+type recordingConn struct {
+	net.Conn
+	written bytes.Buffer
+}
+
+func (c *recordingConn) Write(b []byte) (int, error) {
+	return c.written.Write(b)
+}
+
+func newPSKPair(t *testing.T, key []byte, peerKey []byte) (*PSKConn, *PSKConn, net.Conn, net.Conn) {
+	reader, writer := net.Pipe()
+	readerConn, writerConn := NewPSKConn(reader, key), NewPSKConn(writer, peerKey)
+	readerErr := make(chan error, 1)
+	go func() {
+		readerErr <- readerConn.Handshake()
+	}()
+	writerErr := writerConn.Handshake()
+	if err := <-readerErr; err != nil || writerErr != nil {
+		_ = reader.Close()
+		_ = writer.Close()
+		require.ErrorIs(t, err, PSKHandshakeFailed)
+		require.ErrorIs(t, writerErr, PSKHandshakeFailed)
+		return nil, nil, nil, nil
+	}
+	return readerConn, writerConn, reader, writer
+}
+
+func TestHKDF(t *testing.T) {
+	t.Parallel()
+
+	// Test Case 1 from RFC 5869
+	secret := bytes.Repeat([]byte{0x0b}, 22)
+	salt, _ := hex.DecodeString("000102030405060708090a0b0c")
+	info, _ := hex.DecodeString("f0f1f2f3f4f5f6f7f8f9")
+	expected, _ := hex.DecodeString("3cb25f25faacd57a90434f64d0362f2a2d2d0a90cf1a5a4c5db02d56ecc4c5bf34007208d5b887185865")
+	assert.Equal(t, expected, hkdf(secret, salt, info, 42))
+}
+
+func TestPSKConn(t *testing.T) {
+	t.Parallel()
+
+	readerConn, writerConn, reader, writer := newPSKPair(t, testPSK, testPSK)
+	require.NotNil(t, readerConn)
+
+	data := make([]byte, 3*pskMaxPlaintext+1)
+	_, err := rand.Read(data)
+	require.NoError(t, err)
+
+	writeErr := make(chan error, 1)
+	go func() {
+		_, err := writerConn.Write(data)
+		writeErr <- err
+	}()
+	read := make([]byte, len(data))
+	_, err = io.ReadFull(readerConn, read)
+	require.NoError(t, err)
+	require.NoError(t, <-writeErr)
+	assert.Equal(t, data, read)
+
+	// Records are encrypted, and replaying a record fails because of its sequence number
+	recorder := &recordingConn{Conn: writer}
+	writerConn.Conn = recorder
+	_, err = writerConn.Write([]byte("replayed"))
+	require.NoError(t, err)
+	record := recorder.written.Bytes()
+	assert.NotContains(t, string(record), "replayed")
+
+	go func() {
+		_, _ = writer.Write(record)
+		_, _ = writer.Write(record)
+	}()
+	read = make([]byte, len("replayed"))
+	_, err = io.ReadFull(readerConn, read)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("replayed"), read)
+	_, err = readerConn.Read(read)
+	assert.Error(t, err)
+
+	_ = reader.Close()
+	_ = writer.Close()
+}
+
+func TestPSKConnHandshake(t *testing.T) {
+	t.Parallel()
+
+	readerConn, _, _, _ := newPSKPair(t, testPSK, []byte("fedcba9876543210fedcba9876543210"))
+	assert.Nil(t, readerConn)
+
+	reader, writer := net.Pipe()
+	short := NewPSKConn(reader, []byte("short"))
+	assert.ErrorIs(t, short.Handshake(), InvalidPSK)
+	_, err := short.Write([]byte("data"))
+	assert.ErrorIs(t, err, InvalidPSK)
+	_ = reader.Close()
+	_ = writer.Close()
+}
+
+func TestAsyncPSK(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+
+	reader, writer := net.Pipe()
+	readerConn := NewAsyncWithOptions(reader, &Options{Logger: emptyLogger, PSK: testPSK, Checksums: true})
+	writerConn := NewAsyncWithOptions(writer, &Options{Logger: emptyLogger, PSK: testPSK, Checksums: true})
+
+	syncReader, syncWriter := net.Pipe()
+	syncReaderConn := NewSyncWithOptions(syncReader, &Options{Logger: emptyLogger, PSK: testPSK})
+	syncWriterConn := NewSyncWithOptions(syncWriter, &Options{Logger: emptyLogger, PSK: testPSK})
+
+	p := newHeaderTestPacket(16, 10, []byte("encrypted"))
+	require.NoError(t, writerConn.WritePacket(p))
+	require.NoError(t, writerConn.Flush())
+	syncWriteErr := make(chan error, 1)
+	go func() {
+		syncWriteErr <- syncWriterConn.WritePacket(p)
+	}()
+
+	for _, conn := range []Conn{readerConn, syncReaderConn} {
+		read, err := conn.ReadPacket()
+		require.NoError(t, err)
+		assert.Equal(t, uint16(16), read.Metadata.Id)
+		assert.Equal(t, []byte("encrypted"), read.Content.Bytes())
+		packet.Put(read)
+	}
+	require.NoError(t, <-syncWriteErr)
+	packet.Put(p)
+
+	assert.NoError(t, readerConn.Close())
+	assert.NoError(t, writerConn.Close())
+	assert.NoError(t, syncReaderConn.Close())
+	assert.NoError(t, syncWriterConn.Close())
+}
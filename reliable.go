@@ -0,0 +1,344 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package frisbee
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+var (
+	ReliableDeliveryDisabled = errors.New("reliable delivery is not enabled")
+	DeliveryFailed           = errors.New("packet was not acknowledged after the maximum number of attempts")
+	InvalidMessageID         = errors.New("invalid message ID")
+)
+
+const (
+	// DefaultReliableTimeout is the default amount of time to wait for a packet to be acknowledged before it is sent again
+	DefaultReliableTimeout = time.Second * 5
+
+	// DefaultReliableMaxAttempts is the default number of times a packet is sent before its delivery fails
+	DefaultReliableMaxAttempts = 5
+
+	// DefaultReliableDedupWindow is the default number of recently received message IDs that are remembered for deduplication
+	DefaultReliableDedupWindow = 1 << 14
+
+	// messageIDSize is the size of the message IDs carried by the ExtensionMessageID extension and ACK packets
+	messageIDSize = 8
+)
+
+// ReliableOptions configures the at-least-once delivery of packets that are written with WriteReliable.
+//
+// Every packet that is written with WriteReliable is given a message ID (carried by the metadata.ExtensionMessageID
+// extension), and is sent again every Timeout until the other side of the connection acknowledges it with an ACK packet,
+// or until it has been sent MaxAttempts times. The receiver acknowledges every such packet as soon as it is read, and discards
+// the ones whose message IDs are among the last DedupWindow that it received, so retried packets are only handled once.
+// Clients also send every unacknowledged packet again as soon as they have reconnected (see WithSessions).
+//
+// Both sides of the connection must enable reliable delivery, otherwise packets are never acknowledged.
+//
+// Default Values:
+//
+//	options := ReliableOptions {
+//		Timeout: DefaultReliableTimeout,
+//		MaxAttempts: DefaultReliableMaxAttempts,
+//		DedupWindow: DefaultReliableDedupWindow,
+//	}
+type ReliableOptions struct {
+	// Timeout is how long to wait for a packet to be acknowledged before it is sent again
+	Timeout time.Duration
+
+	// MaxAttempts is the number of times a packet is sent before its delivery fails with DeliveryFailed
+	MaxAttempts int
+
+	// DedupWindow is the number of recently received message IDs that are remembered for deduplication
+	DedupWindow int
+}
+
+// WithReliableDelivery enables at-least-once delivery for the packets that are written with WriteReliable
+// by the frisbee client or server (and their connections), see ReliableOptions.
+func WithReliableDelivery(options ReliableOptions) Option {
+	return func(opts *Options) {
+		if options.Timeout <= 0 {
+			options.Timeout = DefaultReliableTimeout
+		}
+		if options.MaxAttempts <= 0 {
+			options.MaxAttempts = DefaultReliableMaxAttempts
+		}
+		if options.DedupWindow <= 0 {
+			options.DedupWindow = DefaultReliableDedupWindow
+		}
+		opts.Reliable = &options
+	}
+}
+
+// Delivery is returned by WriteReliable, and completes once the packet has been acknowledged
+// by the other side of the connection, or once its delivery has failed
+type Delivery struct {
+	id   uint64
+	done chan struct{}
+	err  error
+}
+
+func newDelivery(id uint64) *Delivery {
+	return &Delivery{
+		id:   id,
+		done: make(chan struct{}),
+	}
+}
+
+// ID returns the message ID of the packet
+func (d *Delivery) ID() uint64 {
+	return d.id
+}
+
+// Done returns a channel that is closed once the delivery has completed
+func (d *Delivery) Done() <-chan struct{} {
+	return d.done
+}
+
+// Err returns nil if the packet was acknowledged, DeliveryFailed if it was not acknowledged after the maximum number of
+// attempts, or ConnectionClosed if the connection or client was closed first. It must only be called once Done is closed.
+func (d *Delivery) Err() error {
+	return d.err
+}
+
+// Wait blocks until the delivery has completed and returns its error, or returns the context's error if it is cancelled first
+func (d *Delivery) Wait(ctx context.Context) error {
+	select {
+	case <-d.done:
+		return d.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (d *Delivery) complete(err error) {
+	d.err = err
+	close(d.done)
+}
+
+// pendingDelivery is a packet that has not been acknowledged yet
+type pendingDelivery struct {
+	packet   *packet.Packet
+	attempts int
+	timer    *time.Timer
+	delivery *Delivery
+}
+
+// deliveries tracks the packets that are written with WriteReliable until they are acknowledged
+type deliveries struct {
+	options *ReliableOptions
+	write   func(*packet.Packet) error
+	mu      sync.Mutex
+	next    uint64
+	pending map[uint64]*pendingDelivery
+	closed  bool
+}
+
+// newDeliveries returns a new deliveries that uses the given function to send (and resend) packets. Message IDs
+// start at a random value, so that the receivers can deduplicate the packets of different senders together.
+func newDeliveries(options *ReliableOptions, write func(*packet.Packet) error) *deliveries {
+	var start [messageIDSize]byte
+	_, _ = rand.Read(start[:])
+	return &deliveries{
+		options: options,
+		write:   write,
+		next:    binary.BigEndian.Uint64(start[:]),
+		pending: make(map[uint64]*pendingDelivery),
+	}
+}
+
+// send writes a copy of the packet with a new message ID, and sends it again until it is acknowledged.
+//
+// Since the copy may still be being written when it is acknowledged, it is not returned to the packet pool.
+func (d *deliveries) send(p *packet.Packet) (*Delivery, error) {
+	if int(p.Metadata.ContentLength) != p.Content.Len() {
+		return nil, InvalidContentLength
+	}
+	c := clonePacket(p)
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return nil, ConnectionClosed
+	}
+	d.next++
+	id := d.next
+	var encoded [messageIDSize]byte
+	binary.BigEndian.PutUint64(encoded[:], id)
+	if err := c.Extensions.Set(metadata.ExtensionMessageID, encoded[:]); err != nil {
+		d.mu.Unlock()
+		return nil, err
+	}
+	pending := &pendingDelivery{
+		packet:   c,
+		attempts: 1,
+		delivery: newDelivery(id),
+	}
+	pending.timer = time.AfterFunc(d.options.Timeout, func() {
+		d.retry(id)
+	})
+	d.pending[id] = pending
+	d.mu.Unlock()
+
+	// If the packet cannot be written now (for example, because the connection dropped), it is sent again later
+	_ = d.write(c)
+	return pending.delivery, nil
+}
+
+// retry sends the packet with the given message ID again, or fails its delivery
+// if it has already been sent the maximum number of times
+func (d *deliveries) retry(id uint64) {
+	d.mu.Lock()
+	pending, ok := d.pending[id]
+	if !ok {
+		d.mu.Unlock()
+		return
+	}
+	if pending.attempts >= d.options.MaxAttempts {
+		delete(d.pending, id)
+		d.mu.Unlock()
+		pending.delivery.complete(DeliveryFailed)
+		return
+	}
+	pending.attempts++
+	pending.timer.Reset(d.options.Timeout)
+	d.mu.Unlock()
+	_ = d.write(pending.packet)
+}
+
+// resend sends every packet that has not been acknowledged again (in the order they were first sent),
+// without counting it as an attempt, which is used once a client has reconnected
+func (d *deliveries) resend() {
+	d.mu.Lock()
+	next := d.next
+	pending := make([]*pendingDelivery, 0, len(d.pending))
+	for _, p := range d.pending {
+		p.timer.Reset(d.options.Timeout)
+		pending = append(pending, p)
+	}
+	d.mu.Unlock()
+	// Message IDs can wrap around, so they are ordered by how long before the latest one they were sent
+	sort.Slice(pending, func(i, j int) bool {
+		return next-pending[i].delivery.id > next-pending[j].delivery.id
+	})
+	for _, p := range pending {
+		_ = d.write(p.packet)
+	}
+}
+
+// acknowledge completes the delivery of the packet with the given message ID
+func (d *deliveries) acknowledge(id uint64) {
+	d.mu.Lock()
+	pending, ok := d.pending[id]
+	if ok {
+		delete(d.pending, id)
+		pending.timer.Stop()
+	}
+	d.mu.Unlock()
+	if ok {
+		pending.delivery.complete(nil)
+	}
+}
+
+// close fails every delivery that has not completed with ConnectionClosed
+func (d *deliveries) close() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.closed = true
+	for id, pending := range d.pending {
+		pending.timer.Stop()
+		delete(d.pending, id)
+		pending.delivery.complete(ConnectionClosed)
+	}
+}
+
+// deduplicator remembers a bounded number of recently received message IDs
+type deduplicator struct {
+	mu    sync.Mutex
+	seen  map[uint64]struct{}
+	order []uint64
+	next  int
+}
+
+func newDeduplicator(window int) *deduplicator {
+	return &deduplicator{
+		seen:  make(map[uint64]struct{}, window),
+		order: make([]uint64, 0, window),
+	}
+}
+
+// duplicate returns whether the message ID has been received recently, and remembers it if it has not
+func (d *deduplicator) duplicate(id uint64) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.seen[id]; ok {
+		return true
+	}
+	if len(d.order) < cap(d.order) {
+		d.order = append(d.order, id)
+	} else {
+		delete(d.seen, d.order[d.next])
+		d.order[d.next] = id
+		d.next = (d.next + 1) % len(d.order)
+	}
+	d.seen[id] = struct{}{}
+	return false
+}
+
+// WriteReliable writes a copy of the packet with at-least-once delivery (see WithReliableDelivery),
+// and returns a Delivery that completes once the other side of the connection has acknowledged it.
+// Packets written with WriteReliable that are not acknowledged fail with ConnectionClosed once the connection is closed.
+func (c *Async) WriteReliable(p *packet.Packet) (*Delivery, error) {
+	if p.Metadata.Operation <= RESERVED9 {
+		return nil, InvalidOperation
+	}
+	if c.deliveries == nil {
+		return nil, ReliableDeliveryDisabled
+	}
+	return c.deliveries.send(p)
+}
+
+// receiveReliable acknowledges a packet that carries a message ID, and returns whether it is a duplicate that should be
+// discarded. If reliable delivery is not enabled, the packet is neither acknowledged nor deduplicated.
+func (c *Async) receiveReliable(p *packet.Packet, id []byte) (bool, error) {
+	defer p.Extensions.Delete(metadata.ExtensionMessageID)
+	if c.received == nil {
+		return false, nil
+	}
+	if len(id) != messageIDSize {
+		return false, InvalidMessageID
+	}
+	ack := packet.Get()
+	ack.Metadata.Id = p.Metadata.Id
+	ack.Metadata.Operation = ACK
+	ack.Content.Write(id)
+	ack.Metadata.ContentLength = messageIDSize
+	duplicate := c.received.duplicate(binary.BigEndian.Uint64(id))
+	err := c.writePacket(ack, false)
+	packet.Put(ack)
+	return duplicate, err
+}
+
+// receiveAck completes the deliveries of the message IDs in an ACK packet
+func (c *Async) receiveAck(p *packet.Packet) error {
+	ids := p.Content.Bytes()
+	if len(ids)%messageIDSize != 0 {
+		return InvalidMessageID
+	}
+	if c.deliveries == nil {
+		return nil
+	}
+	for ; len(ids) > 0; ids = ids[messageIDSize:] {
+		c.deliveries.acknowledge(binary.BigEndian.Uint64(ids))
+	}
+	return nil
+}
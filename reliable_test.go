@@ -0,0 +1,183 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package frisbee
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/loopholelabs/logging"
+	"github.com/loopholelabs/testing/conn/pair"
+
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+func TestDeduplicator(t *testing.T) {
+	t.Parallel()
+
+	d := newDeduplicator(2)
+	assert.False(t, d.duplicate(1))
+	assert.False(t, d.duplicate(2))
+	assert.True(t, d.duplicate(1))
+	assert.False(t, d.duplicate(3))
+	assert.True(t, d.duplicate(2))
+	assert.False(t, d.duplicate(1))
+}
+
+func TestReliableDelivery(t *testing.T) {
+	t.Parallel()
+
+	const testSize = 100
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+	options := ReliableOptions{Timeout: time.Millisecond * 50}
+
+	received := make(chan uint16, testSize)
+	serverHandlerTable := make(HandlerTable)
+	serverHandlerTable[32] = func(_ context.Context, incoming *packet.Packet) (outgoing *packet.Packet, action Action) {
+		_, ok := incoming.Extensions.Get(metadata.ExtensionMessageID)
+		assert.False(t, ok)
+		received <- incoming.Metadata.Id
+		return
+	}
+
+	s, err := NewServer(serverHandlerTable, context.Background(), WithLogger(emptyLogger), WithReliableDelivery(options))
+	require.NoError(t, err)
+	s.SetConcurrency(1)
+
+	serverConn, clientConn, err := pair.New()
+	require.NoError(t, err)
+	go s.ServeConn(serverConn)
+
+	c, err := NewClient(make(HandlerTable), context.Background(), WithLogger(emptyLogger), WithReliableDelivery(options))
+	require.NoError(t, err)
+
+	p := packet.Get()
+	p.Metadata.Operation = 32
+	_, err = c.WriteReliable(p)
+	assert.ErrorIs(t, err, ConnectionNotInitialized)
+
+	require.NoError(t, c.FromConn(clientConn))
+
+	p.Metadata.Operation = PING
+	_, err = c.WriteReliable(p)
+	assert.ErrorIs(t, err, InvalidOperation)
+	p.Metadata.Operation = 32
+
+	deliveries := make([]*Delivery, testSize)
+	for i := range deliveries {
+		p.Metadata.Id = uint16(i)
+		deliveries[i], err = c.WriteReliable(p)
+		require.NoError(t, err)
+	}
+	packet.Put(p)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	for i, delivery := range deliveries {
+		require.NoError(t, delivery.Wait(ctx))
+		assert.Equal(t, uint16(i), <-received)
+	}
+	assert.Equal(t, 0, len(received))
+
+	require.NoError(t, c.Close())
+	require.NoError(t, s.Shutdown())
+}
+
+func TestReliableDeduplication(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+	options := loadOptions(WithLogger(emptyLogger), WithReliableDelivery(ReliableOptions{}))
+
+	reader, writer := net.Pipe()
+	readerConn := NewAsyncWithOptions(reader, options)
+	writerConn := NewAsyncWithOptions(writer, loadOptions(WithLogger(emptyLogger)))
+
+	// The same message is written twice (as if it was retried), and is only read once but acknowledged twice
+	var id [messageIDSize]byte
+	binary.BigEndian.PutUint64(id[:], 1234)
+	for i := 0; i < 2; i++ {
+		p := packet.Get()
+		p.Metadata.Id = uint16(i)
+		p.Metadata.Operation = 32
+		require.NoError(t, p.Extensions.Set(metadata.ExtensionMessageID, id[:]))
+		require.NoError(t, writerConn.WritePacket(p))
+		packet.Put(p)
+	}
+	p := packet.Get()
+	p.Metadata.Id = 2
+	p.Metadata.Operation = 32
+	require.NoError(t, writerConn.WritePacket(p))
+	packet.Put(p)
+	require.NoError(t, writerConn.Flush())
+
+	for _, expected := range []uint16{0, 2} {
+		p, err := readerConn.ReadPacket()
+		require.NoError(t, err)
+		assert.Equal(t, expected, p.Metadata.Id)
+		packet.Put(p)
+	}
+
+	// The writer does not use reliable delivery, so the ACK packets are discarded
+	p = packet.Get()
+	p.Metadata.Operation = 32
+	_, err := writerConn.WriteReliable(p)
+	assert.ErrorIs(t, err, ReliableDeliveryDisabled)
+	packet.Put(p)
+
+	require.NoError(t, readerConn.Close())
+	require.NoError(t, writerConn.Close())
+}
+
+func TestReliableDeliveryFailed(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+	options := loadOptions(WithLogger(emptyLogger), WithReliableDelivery(ReliableOptions{Timeout: time.Millisecond * 10, MaxAttempts: 3}))
+
+	// The reader does not use reliable delivery, so it never acknowledges the packet and reads every attempt
+	reader, writer := net.Pipe()
+	readerConn := NewAsyncWithOptions(reader, loadOptions(WithLogger(emptyLogger)))
+	writerConn := NewAsyncWithOptions(writer, options)
+
+	p := packet.Get()
+	p.Metadata.Operation = 32
+	delivery, err := writerConn.WriteReliable(p)
+	require.NoError(t, err)
+	packet.Put(p)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	assert.ErrorIs(t, delivery.Wait(ctx), DeliveryFailed)
+	for i := 0; i < 3; i++ {
+		p, err := readerConn.ReadPacket()
+		require.NoError(t, err)
+		packet.Put(p)
+	}
+
+	require.NoError(t, readerConn.Close())
+	require.NoError(t, writerConn.Close())
+
+	// Deliveries that are pending when the connection is closed fail with ConnectionClosed
+	reader, writer = net.Pipe()
+	readerConn = NewAsyncWithOptions(reader, loadOptions(WithLogger(emptyLogger)))
+	writerConn = NewAsyncWithOptions(writer, loadOptions(WithLogger(emptyLogger), WithReliableDelivery(ReliableOptions{Timeout: time.Minute})))
+	p = packet.Get()
+	p.Metadata.Operation = 32
+	delivery, err = writerConn.WriteReliable(p)
+	require.NoError(t, err)
+	packet.Put(p)
+	require.NoError(t, writerConn.Close())
+	<-delivery.Done()
+	assert.ErrorIs(t, delivery.Err(), ConnectionClosed)
+
+	require.NoError(t, readerConn.Close())
+}
@@ -0,0 +1,134 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package frisbee
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/loopholelabs/frisbee-go/pkg/capture"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+// ReplayOptions are used to configure how the packets in a capture file are replayed.
+//
+// Default Values:
+//
+//	options := ReplayOptions {
+//		Speed: 0,
+//		Direction: capture.DirectionRead,
+//		Connection: 0,
+//	}
+type ReplayOptions struct {
+	// Speed is a multiplier applied to the original timing of the capture, so 1 replays packets
+	// at their original pace and 10 replays them ten times faster. If Speed is 0, packets are
+	// replayed as quickly as possible.
+	Speed float64
+
+	// Direction selects which recorded packets are replayed. It defaults to capture.DirectionRead,
+	// which replays the packets that were received by the connection that was captured.
+	Direction capture.Direction
+
+	// Connection restricts the replay to the packets of a single connection ID. If it is 0,
+	// the packets of every connection in the capture are replayed.
+	Connection uint64
+}
+
+// Writer is implemented by anything that frisbee packets can be written to, such as an *Async, *Sync, or *Client
+type Writer interface {
+	WritePacket(*packet.Packet) error
+}
+
+// ReplayHandlerTable feeds the packets in a capture into the handler functions of the given HandlerTable,
+// in the same way a Server would. Packets that use reserved operations or that do not have a handler are skipped.
+//
+// If onResponse is not nil, it is called with every record and the packet returned by its handler (which may be nil).
+func ReplayHandlerTable(ctx context.Context, reader *capture.Reader, handlerTable HandlerTable, options ReplayOptions, onResponse func(*capture.Record, *packet.Packet)) error {
+	return replay(ctx, reader, options, func(record *capture.Record) error {
+		handlerFunc := handlerTable[record.Packet.Metadata.Operation]
+		if handlerFunc == nil {
+			return nil
+		}
+		packetCtx := ctx
+		if trace, ok := ExtractTrace(record.Packet); ok {
+			packetCtx = ContextWithTrace(packetCtx, trace)
+		}
+		outgoing, _ := handlerFunc(packetCtx, record.Packet)
+		stripRequest(record.Packet, outgoing)
+		if onResponse != nil {
+			onResponse(record, outgoing)
+		}
+		if outgoing != nil && outgoing != record.Packet {
+			packet.Put(outgoing)
+		}
+		return nil
+	})
+}
+
+// ReplayConn writes the packets in a capture to a live connection, such as a *Client connected to a Server.
+// Packets that use reserved operations are skipped.
+func ReplayConn(ctx context.Context, reader *capture.Reader, conn Writer, options ReplayOptions) error {
+	return replay(ctx, reader, options, func(record *capture.Record) error {
+		return conn.WritePacket(record.Packet)
+	})
+}
+
+// replay reads every record from the capture, waits until the (scaled) time at which the record
+// was originally captured, and then calls f with it
+func replay(ctx context.Context, reader *capture.Reader, options ReplayOptions, f func(*capture.Record) error) error {
+	if options.Direction == 0 {
+		options.Direction = capture.DirectionRead
+	}
+
+	var first time.Time
+	start := time.Now()
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	<-timer.C
+
+	for {
+		record, err := reader.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+
+		if record.Direction != options.Direction || record.Packet.Metadata.Operation <= RESERVED9 ||
+			(options.Connection != 0 && record.Connection != options.Connection) {
+			packet.Put(record.Packet)
+			continue
+		}
+
+		if first.IsZero() {
+			first = record.Timestamp
+		}
+
+		if options.Speed > 0 {
+			wait := time.Until(start.Add(time.Duration(float64(record.Timestamp.Sub(first)) / options.Speed)))
+			if wait > 0 {
+				timer.Reset(wait)
+				select {
+				case <-ctx.Done():
+					packet.Put(record.Packet)
+					return ctx.Err()
+				case <-timer.C:
+				}
+			}
+		}
+
+		if err = ctx.Err(); err != nil {
+			packet.Put(record.Packet)
+			return err
+		}
+
+		err = f(record)
+		packet.Put(record.Packet)
+		if err != nil {
+			return err
+		}
+	}
+}
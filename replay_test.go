@@ -0,0 +1,183 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package frisbee
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/loopholelabs/logging"
+
+	"github.com/loopholelabs/frisbee-go/pkg/capture"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+func TestAsyncTap(t *testing.T) {
+	t.Parallel()
+
+	const testSize = 10
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+
+	b := new(bytes.Buffer)
+	w, err := capture.NewWriter(b)
+	require.NoError(t, err)
+
+	reader, writer := net.Pipe()
+	readerConn := NewAsyncWithOptions(reader, &Options{Logger: emptyLogger, Tap: w})
+	writerConn := NewAsyncWithOptions(writer, &Options{Logger: emptyLogger, Tap: w})
+	assert.NotEqual(t, readerConn.ID(), writerConn.ID())
+
+	p := packet.Get()
+	p.Metadata.Operation = 10
+	for i := 0; i < testSize; i++ {
+		p.Metadata.Id = uint16(i)
+		p.Content.Reset()
+		p.Content.Write([]byte{byte(i)})
+		p.Metadata.ContentLength = 1
+		require.NoError(t, writerConn.WritePacket(p))
+	}
+	packet.Put(p)
+
+	for i := 0; i < testSize; i++ {
+		p, err = readerConn.ReadPacket()
+		require.NoError(t, err)
+		packet.Put(p)
+	}
+
+	assert.NoError(t, readerConn.Close())
+	assert.NoError(t, writerConn.Close())
+	require.NoError(t, w.Flush())
+
+	r, err := capture.NewReader(bytes.NewReader(b.Bytes()))
+	require.NoError(t, err)
+
+	var read, written int
+	for {
+		record, err := r.Next()
+		if err != nil {
+			break
+		}
+		if record.Packet.Metadata.Operation == 10 {
+			switch record.Direction {
+			case capture.DirectionRead:
+				assert.Equal(t, readerConn.ID(), record.Connection)
+				assert.Equal(t, []byte{byte(read)}, record.Packet.Content.Bytes())
+				read++
+			case capture.DirectionWrite:
+				assert.Equal(t, writerConn.ID(), record.Connection)
+				written++
+			}
+		}
+		packet.Put(record.Packet)
+	}
+	assert.Equal(t, testSize, read)
+	assert.Equal(t, testSize, written)
+}
+
+func writeTestCapture(t testing.TB, testSize int, interval time.Duration) []byte {
+	b := new(bytes.Buffer)
+	w, err := capture.NewWriter(b)
+	require.NoError(t, err)
+
+	start := time.Now()
+	p := packet.Get()
+	for i := 0; i < testSize; i++ {
+		p.Metadata.Id = uint16(i)
+		p.Metadata.Operation = 10
+		require.NoError(t, w.WritePacket(start.Add(interval*time.Duration(i)), capture.DirectionRead, 1, p))
+		require.NoError(t, w.WritePacket(start.Add(interval*time.Duration(i)), capture.DirectionWrite, 1, p))
+		p.Metadata.Operation = PING
+		require.NoError(t, w.WritePacket(start.Add(interval*time.Duration(i)), capture.DirectionRead, 1, p))
+	}
+	packet.Put(p)
+	require.NoError(t, w.Flush())
+	return b.Bytes()
+}
+
+func TestReplayHandlerTable(t *testing.T) {
+	t.Parallel()
+
+	const testSize = 10
+	const interval = time.Millisecond * 20
+
+	captured := writeTestCapture(t, testSize, interval)
+
+	var ids []uint16
+	handlerTable := make(HandlerTable)
+	handlerTable[10] = func(_ context.Context, incoming *packet.Packet) (outgoing *packet.Packet, action Action) {
+		ids = append(ids, incoming.Metadata.Id)
+		outgoing = incoming
+		return
+	}
+
+	r, err := capture.NewReader(bytes.NewReader(captured))
+	require.NoError(t, err)
+
+	var responses int
+	start := time.Now()
+	err = ReplayHandlerTable(context.Background(), r, handlerTable, ReplayOptions{Speed: 1}, func(record *capture.Record, outgoing *packet.Packet) {
+		assert.Equal(t, record.Packet, outgoing)
+		responses++
+	})
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, time.Since(start), interval*(testSize-1))
+	assert.Equal(t, testSize, responses)
+	for i, id := range ids {
+		assert.Equal(t, uint16(i), id)
+	}
+
+	r, err = capture.NewReader(bytes.NewReader(captured))
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = ReplayHandlerTable(ctx, r, handlerTable, ReplayOptions{}, nil)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestReplayConn(t *testing.T) {
+	t.Parallel()
+
+	const testSize = 10
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+	captured := writeTestCapture(t, testSize, time.Second)
+
+	received := make(chan uint16, testSize)
+	serverHandlerTable := make(HandlerTable)
+	serverHandlerTable[10] = func(_ context.Context, incoming *packet.Packet) (outgoing *packet.Packet, action Action) {
+		received <- incoming.Metadata.Id
+		return
+	}
+
+	s, err := NewServer(serverHandlerTable, context.Background(), WithLogger(emptyLogger))
+	require.NoError(t, err)
+	s.SetConcurrency(1)
+
+	serverConn, clientConn := net.Pipe()
+	go s.ServeConn(serverConn)
+
+	c, err := NewClient(make(HandlerTable), context.Background(), WithLogger(emptyLogger))
+	require.NoError(t, err)
+	require.NoError(t, c.FromConn(clientConn))
+
+	r, err := capture.NewReader(bytes.NewReader(captured))
+	require.NoError(t, err)
+
+	start := time.Now()
+	require.NoError(t, ReplayConn(context.Background(), r, c, ReplayOptions{Speed: 100}))
+	assert.Less(t, time.Since(start), time.Second)
+
+	for i := 0; i < testSize; i++ {
+		assert.Equal(t, uint16(i), <-received)
+	}
+
+	assert.NoError(t, c.Close())
+	assert.NoError(t, s.Shutdown())
+}
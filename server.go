@@ -34,16 +34,23 @@ var (
 // Server accepts connections from frisbee Clients and can send and receive frisbee Packets
 type Server struct {
 	listener      net.Listener
-	handlerTable  HandlerTable
+	handlerTable  *atomicHandlerTable
 	shutdown      atomic.Bool
 	options       *Options
 	wg            sync.WaitGroup
-	connections   map[*Async]struct{}
+	connections   map[*Async]*serverConn
+	groups        map[string]map[*Async]struct{}
 	connectionsMu sync.Mutex
+	sessions      map[SessionID]*serverSession
+	sessionsMu    sync.Mutex
+	received      *deduplicator
 	startedCh     chan struct{}
 	concurrency   uint64
 	limiter       chan struct{}
 
+	operationLimiters operationLimiters
+	orderingKey       OrderingKey
+
 	baseContext       context.Context
 	baseContextCancel context.CancelFunc
 
@@ -57,7 +64,8 @@ type Server struct {
 	streamHandler func(*Stream)
 
 	// ConnContext is used to define a connection-specific context based on the incoming connection
-	// and is run whenever a new connection is opened
+	// and is run whenever a new connection is opened. If sessions are enabled (see WithSessions), it is
+	// only run for the first connection of a session, and resumed connections use the context that it returned.
 	ConnContext func(context.Context, *Async) context.Context
 
 	// StreamContext is used to define a stream-specific context based on the incoming stream
@@ -65,11 +73,14 @@ type Server struct {
 	StreamContext func(context.Context, *Stream) context.Context
 
 	// PacketContext is used to define a handler-specific contexts based on the incoming packet
-	// and is run whenever a new packet arrives
+	// and is run whenever a new packet arrives. If the incoming packet carries a TraceContext,
+	// it will already have been added to the context (see TraceFromContext). It can also read
+	// and modify the packet's Headers (see packet.Packet.Header) before the handler runs.
 	PacketContext func(context.Context, *packet.Packet) context.Context
 
 	// UpdateContext is used to update a handler-specific context whenever the returned
-	// Action from a handler is UPDATE
+	// Action from a handler is UPDATE, and the returned context is used for every packet
+	// that arrives on the connection after that
 	UpdateContext func(context.Context, *Async) context.Context
 }
 
@@ -82,7 +93,9 @@ func NewServer(handlerTable HandlerTable, ctx context.Context, opts ...Option) (
 
 	s := &Server{
 		options:           options,
-		connections:       make(map[*Async]struct{}),
+		connections:       make(map[*Async]*serverConn),
+		groups:            make(map[string]map[*Async]struct{}),
+		sessions:          make(map[SessionID]*serverSession),
 		startedCh:         make(chan struct{}),
 		baseContext:       baseContext,
 		baseContextCancel: baseContextCancel,
@@ -91,7 +104,13 @@ func NewServer(handlerTable HandlerTable, ctx context.Context, opts ...Option) (
 		streamHandler:     defaultStreamHandler,
 	}
 
-	return s, s.SetHandlerTable(handlerTable)
+	if options.Reliable != nil {
+		s.received = newDeduplicator(options.Reliable.DedupWindow)
+	}
+
+	var err error
+	s.handlerTable, err = newAtomicHandlerTable(handlerTable)
+	return s, err
 }
 
 // SetOnClosed sets the onClosed function for the server. If f is nil, it returns an error.
@@ -124,25 +143,30 @@ func (s *Server) SetStreamHandler(f func(context.Context, *Stream)) error {
 	return nil
 }
 
-// SetHandlerTable sets the handler table for the server.
+// SetHandlerTable replaces the handler table for the server with a copy of the given one.
 //
-// This function should not be called once the server has started.
+// This function can be called while the server is running, and packets that are already
+// being handled will finish with the handler that they started with.
 func (s *Server) SetHandlerTable(handlerTable HandlerTable) error {
-	for i := uint16(0); i < RESERVED9; i++ {
-		if _, ok := handlerTable[i]; ok {
-			return InvalidHandlerTable
-		}
-	}
-
-	s.handlerTable = handlerTable
-	return nil
+	return s.handlerTable.store(handlerTable)
 }
 
-// GetHandlerTable gets the handler table for the server.
-//
-// This function should not be called once the server has started.
+// GetHandlerTable returns a copy of the handler table for the server. Modifying the copy
+// has no effect, use SetHandlerTable, RegisterHandler, or UnregisterHandler instead.
 func (s *Server) GetHandlerTable() HandlerTable {
-	return s.handlerTable
+	return s.handlerTable.load()
+}
+
+// RegisterHandler adds (or replaces) the handler for the given operation,
+// and can be called while the server is running.
+func (s *Server) RegisterHandler(operation uint16, handler Handler) error {
+	return s.handlerTable.register(operation, handler)
+}
+
+// UnregisterHandler removes the handler for the given operation, and can be called while the server is running.
+// Packets with the operation that arrive afterwards are discarded.
+func (s *Server) UnregisterHandler(operation uint16) {
+	s.handlerTable.unregister(operation)
 }
 
 // SetConcurrency sets the maximum number of concurrent goroutines that will be created
@@ -152,6 +176,8 @@ func (s *Server) GetHandlerTable() HandlerTable {
 // between connections. If the concurrency is set to a value != 1, then the handlers
 // must also be thread-safe if they share resources per connection.
 //
+// Packets with operations that have their own limit (see SetOperationLimit) do not count towards the concurrency.
+//
 // This function should not be called once the server has started.
 func (s *Server) SetConcurrency(concurrency uint64) {
 	s.concurrency = concurrency
@@ -234,18 +260,54 @@ func (s *Server) handleListener() error {
 	}
 }
 
-func (s *Server) createHandler(conn *Async, closed *atomic.Bool, wg *sync.WaitGroup, ctx context.Context, cancel context.CancelFunc) func(*packet.Packet) {
+// connContext holds the context of a connection whose packets are handled concurrently,
+// which is replaced whenever a handler returns UPDATE
+type connContext struct {
+	mu  sync.Mutex
+	ctx atomic.Pointer[context.Context]
+}
+
+func newConnContext(ctx context.Context) *connContext {
+	c := new(connContext)
+	c.ctx.Store(&ctx)
+	return c
+}
+
+// load returns the current context of the connection
+func (c *connContext) load() context.Context {
+	return *c.ctx.Load()
+}
+
+// update replaces the context of the connection using the given UpdateContext function
+func (c *connContext) update(conn *Async, updateContext func(context.Context, *Async) context.Context) {
+	if updateContext == nil {
+		return
+	}
+	c.mu.Lock()
+	ctx := updateContext(c.load(), conn)
+	c.ctx.Store(&ctx)
+	c.mu.Unlock()
+}
+
+// shutdownFromHandler shuts down the server when a handler returns SHUTDOWN. This happens in its
+// own goroutine, since Shutdown waits for every connection to finish, including the handler's own.
+func (s *Server) shutdownFromHandler() {
+	s.Logger().Debug().Msg("Shutting down server because of SHUTDOWN action")
+	go func() {
+		if err := s.Shutdown(); err != nil {
+			s.Logger().Error().Err(err).Msg("error while shutting down server because of SHUTDOWN action")
+		}
+	}()
+}
+
+func (s *Server) createHandler(conn *Async, closed *atomic.Bool, wg *sync.WaitGroup, ctx *connContext, cancel context.CancelFunc) func(*packet.Packet) {
 	return func(p *packet.Packet) {
-		handlerFunc := s.handlerTable[p.Metadata.Operation]
+		handlerFunc := s.handlerTable.get(p.Metadata.Operation)
 		if handlerFunc != nil {
-			packetCtx := ctx
-			if s.PacketContext != nil {
-				packetCtx = s.PacketContext(packetCtx, p)
-			}
-			outgoing, action := handlerFunc(packetCtx, p)
+			outgoing, action := s.options.handlePacket(ctx.load(), s.PacketContext, handlerFunc, p)
 			if outgoing != nil && outgoing.Metadata.ContentLength == uint32(outgoing.Content.Len()) {
 				s.preWrite()
-				err := conn.WritePacket(outgoing)
+				err := conn.writeResponse(outgoing)
 				if outgoing != p {
 					packet.Put(outgoing)
 				}
@@ -270,6 +332,15 @@ func (s *Server) createHandler(conn *Async, closed *atomic.Bool, wg *sync.WaitGr
 					s.onClosed(conn, nil)
 				}
 				cancel()
+			case UPDATE:
+				ctx.update(conn, s.UpdateContext)
+			case SHUTDOWN:
+				_ = conn.Close()
+				if closed.CompareAndSwap(false, true) {
+					s.onClosed(conn, nil)
+				}
+				cancel()
+				s.shutdownFromHandler()
 			}
 		} else {
 			packet.Put(p)
@@ -291,16 +362,12 @@ func (s *Server) handleSinglePacket(frisbeeConn *Async, connCtx context.Context)
 		return
 	}
 	for {
-		handlerFunc = s.handlerTable[p.Metadata.Operation]
+		handlerFunc = s.handlerTable.get(p.Metadata.Operation)
 		if handlerFunc != nil {
-			packetCtx := connCtx
-			if s.PacketContext != nil {
-				packetCtx = s.PacketContext(packetCtx, p)
-			}
-			outgoing, action = handlerFunc(packetCtx, p)
+			outgoing, action = s.options.handlePacket(connCtx, s.PacketContext, handlerFunc, p)
 			if outgoing != nil && outgoing.Metadata.ContentLength == uint32(outgoing.Content.Len()) {
 				s.preWrite()
-				err = frisbeeConn.WritePacket(outgoing)
+				err = frisbeeConn.writeResponse(outgoing)
 				if outgoing != p {
 					packet.Put(outgoing)
 				}
@@ -319,6 +386,15 @@ func (s *Server) handleSinglePacket(frisbeeConn *Async, connCtx context.Context)
 				_ = frisbeeConn.Close()
 				s.onClosed(frisbeeConn, nil)
 				return
+			case UPDATE:
+				if s.UpdateContext != nil {
+					connCtx = s.UpdateContext(connCtx, frisbeeConn)
+				}
+			case SHUTDOWN:
+				_ = frisbeeConn.Close()
+				s.onClosed(frisbeeConn, nil)
+				s.shutdownFromHandler()
+				return
 			}
 		} else {
 			packet.Put(p)
@@ -332,7 +408,9 @@ func (s *Server) handleSinglePacket(frisbeeConn *Async, connCtx context.Context)
 	}
 }
 
-func (s *Server) handleUnlimitedPacket(frisbeeConn *Async, connCtx context.Context) {
+// handleConcurrentPackets handles the packets of the connection in their own goroutines, and
+// limits the number of packets that are handled at once to the size of limiter if it is not nil
+func (s *Server) handleConcurrentPackets(frisbeeConn *Async, connCtx context.Context, limiter chan struct{}) {
 	p, err := frisbeeConn.ReadPacket()
 	if err != nil {
 		_ = frisbeeConn.Close()
@@ -342,58 +420,24 @@ func (s *Server) handleUnlimitedPacket(frisbeeConn *Async, connCtx context.Conte
 	wg := new(sync.WaitGroup)
 	var closed atomic.Bool
 	connCtx, cancel := context.WithCancel(connCtx)
-	handle := s.createHandler(frisbeeConn, &closed, wg, connCtx, cancel)
+	handle := s.createHandler(frisbeeConn, &closed, wg, newConnContext(connCtx), cancel)
+	dispatch := s.newDispatcher(connCtx, wg, limiter, handle)
 	for {
-		wg.Add(1)
-		go handle(p)
-		p, err = frisbeeConn.ReadPacket()
-		if err != nil {
+		if !s.handleLimitedOperation(frisbeeConn, connCtx, wg, handle, p) && !dispatch(p) {
 			_ = frisbeeConn.Close()
 			if closed.CompareAndSwap(false, true) {
 				s.onClosed(frisbeeConn, err)
 			}
-			cancel()
 			wg.Wait()
 			return
 		}
-	}
-}
-
-func (s *Server) handleLimitedPacket(frisbeeConn *Async, connCtx context.Context) {
-	p, err := frisbeeConn.ReadPacket()
-	if err != nil {
-		_ = frisbeeConn.Close()
-		s.onClosed(frisbeeConn, err)
-		return
-	}
-	wg := new(sync.WaitGroup)
-	var closed atomic.Bool
-	connCtx, cancel := context.WithCancel(connCtx)
-	handler := s.createHandler(frisbeeConn, &closed, wg, connCtx, cancel)
-	handle := func(p *packet.Packet) {
-		handler(p)
-		<-s.limiter
-	}
-	for {
-		select {
-		case s.limiter <- struct{}{}:
-			wg.Add(1)
-			go handle(p)
-			p, err = frisbeeConn.ReadPacket()
-			if err != nil {
-				_ = frisbeeConn.Close()
-				if closed.CompareAndSwap(false, true) {
-					s.onClosed(frisbeeConn, err)
-				}
-				cancel()
-				wg.Wait()
-				return
-			}
-		case <-connCtx.Done():
+		p, err = frisbeeConn.ReadPacket()
+		if err != nil {
 			_ = frisbeeConn.Close()
 			if closed.CompareAndSwap(false, true) {
 				s.onClosed(frisbeeConn, err)
 			}
+			cancel()
 			wg.Wait()
 			return
 		}
@@ -428,31 +472,48 @@ func (s *Server) serveConn(newConn net.Conn) {
 		}
 	}
 
-	frisbeeConn := NewAsync(newConn, s.Logger(), s.streamHandler)
+	var frisbeeConn *Async
+	var resolved chan *serverSession
+	if s.options.Sessions != nil {
+		resolved = make(chan *serverSession, 1)
+		frisbeeConn = newAsync(newConn, s.options, connState{sessionHandler: s.acceptSession(resolved), received: s.received}, s.streamHandler)
+	} else {
+		frisbeeConn = newAsync(newConn, s.options, connState{received: s.received}, s.streamHandler)
+	}
 	connCtx := s.baseContext
 	s.connectionsMu.Lock()
 	if s.shutdown.Load() {
+		s.connectionsMu.Unlock()
+		_ = frisbeeConn.Close()
 		s.wg.Done()
 		return
 	}
-	s.connections[frisbeeConn] = struct{}{}
+	s.connections[frisbeeConn] = new(serverConn)
 	s.connectionsMu.Unlock()
-	if s.ConnContext != nil {
+	var ss *serverSession
+	if resolved != nil {
+		select {
+		case ss = <-resolved:
+		case <-frisbeeConn.CloseChannel():
+		}
+	}
+	if ss != nil {
+		connCtx = s.sessionContext(ss, frisbeeConn)
+	} else if s.ConnContext != nil {
 		connCtx = s.ConnContext(connCtx, frisbeeConn)
 	}
 	switch s.concurrency {
 	case 0:
-		s.handleUnlimitedPacket(frisbeeConn, connCtx)
+		s.handleConcurrentPackets(frisbeeConn, connCtx, nil)
 	case 1:
 		s.handleSinglePacket(frisbeeConn, connCtx)
 	default:
-		s.handleLimitedPacket(frisbeeConn, connCtx)
+		s.handleConcurrentPackets(frisbeeConn, connCtx, s.limiter)
 	}
-	s.connectionsMu.Lock()
-	if !s.shutdown.Load() {
-		delete(s.connections, frisbeeConn)
+	if ss != nil {
+		s.releaseSession(ss, frisbeeConn)
 	}
-	s.connectionsMu.Unlock()
+	s.removeConnection(frisbeeConn)
 	s.wg.Done()
 }
 
@@ -470,7 +531,9 @@ func (s *Server) Shutdown() error {
 			_ = c.Close()
 			delete(s.connections, c)
 		}
+		clear(s.groups)
 		s.connectionsMu.Unlock()
+		s.closeSessions()
 		defer s.wg.Wait()
 		if s.listener != nil {
 			return s.listener.Close()
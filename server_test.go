@@ -191,7 +191,7 @@ func TestServerStaleCloseSingle(t *testing.T) {
 	packet.Put(p)
 	<-finished
 
-	_, err = c.conn.ReadPacket()
+	_, err = c.conn.Load().ReadPacket()
 	assert.ErrorIs(t, err, ConnectionClosed)
 
 	err = c.Close()
@@ -468,7 +468,7 @@ func TestServerStaleCloseUnlimited(t *testing.T) {
 	packet.Put(p)
 	<-finished
 
-	_, err = c.conn.ReadPacket()
+	_, err = c.conn.Load().ReadPacket()
 	assert.ErrorIs(t, err, ConnectionClosed)
 
 	err = c.Close()
@@ -748,7 +748,7 @@ func TestServerStaleCloseLimited(t *testing.T) {
 	packet.Put(p)
 	<-finished
 
-	_, err = c.conn.ReadPacket()
+	_, err = c.conn.Load().ReadPacket()
 	assert.ErrorIs(t, err, ConnectionClosed)
 
 	err = c.Close()
@@ -0,0 +1,549 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package frisbee
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+var (
+	SessionRejected         = errors.New("session could not be resumed")
+	SessionExpired          = errors.New("session expired before the connection could be resumed")
+	SessionHandshakeTimeout = errors.New("timed out waiting for the session to be accepted")
+	InvalidSessionPacket    = errors.New("invalid session packet")
+)
+
+const (
+	// DefaultSessionGraceWindow is the default amount of time that a session can be resumed for after its connection drops
+	DefaultSessionGraceWindow = time.Second * 30
+
+	// DefaultSessionBufferSize is the default maximum number of unacknowledged packets that are kept for retransmission
+	DefaultSessionBufferSize = 1 << 10
+)
+
+// These are the kinds of SESSION packets, which are stored in the packet's Metadata.Id:
+const (
+	// sessionHello is sent by the client to start a new session (with an empty SessionID) or to resume one
+	sessionHello = uint16(iota + 1)
+
+	// sessionAccept is sent by the server in response to a sessionHello, with the SessionID of the session that
+	// the connection belongs to, which is a new session if the requested one could not be resumed
+	sessionAccept
+
+	// sessionAck is sent by both sides to acknowledge the packets that they have received
+	sessionAck
+)
+
+// These are the fields of the content of SESSION packets, which are encoded like packet extensions:
+const (
+	// sessionFieldID is the SessionID
+	sessionFieldID = uint8(1)
+
+	// sessionFieldReceived is the number of packets of the session that the sender has received
+	sessionFieldReceived = uint8(2)
+)
+
+// SessionID identifies a session
+type SessionID [16]byte
+
+// SessionOptions configures the sessions of a frisbee client or server.
+//
+// A session outlives the connection that it was started on. Both sides of a connection count the packets of the session
+// that they send and receive (every packet except PING, PONG, EXTENSION, NEGOTIATE, and SESSION packets), and keep the packets
+// that they sent in a bounded retransmit buffer until the other side acknowledges them with a SESSION packet.
+//
+// When the connection of a Client drops, the Client redials the server for up to GraceWindow and resumes the session on the new
+// connection: both sides tell each other how many packets they received, retransmit the ones that were lost, and the Server
+// handles the packets of the new connection with the original connection's context (see Server.ConnContext). If more than
+// BufferSize packets were unacknowledged when the connection dropped, the session cannot be resumed and a new one is started.
+//
+// Default Values:
+//
+//	options := SessionOptions {
+//		GraceWindow: DefaultSessionGraceWindow,
+//		BufferSize: DefaultSessionBufferSize,
+//	}
+type SessionOptions struct {
+	// GraceWindow is how long a session can be resumed for after its connection drops
+	GraceWindow time.Duration
+
+	// BufferSize is the maximum number of unacknowledged packets that are kept for retransmission
+	BufferSize int
+}
+
+// WithSessions enables sessions for the frisbee client or server, see SessionOptions. Both the client and the server
+// must enable sessions, and a Client that connects to a Server which does not is closed with SessionHandshakeTimeout.
+func WithSessions(options SessionOptions) Option {
+	return func(opts *Options) {
+		if options.GraceWindow <= 0 {
+			options.GraceWindow = DefaultSessionGraceWindow
+		}
+		if options.BufferSize <= 0 {
+			options.BufferSize = DefaultSessionBufferSize
+		}
+		opts.Sessions = &options
+	}
+}
+
+// sessionMessage is the decoded content of a SESSION packet
+type sessionMessage struct {
+	kind     uint16
+	id       SessionID
+	received uint64
+}
+
+// sessionHandler is called by an Async connection for every sessionHello or sessionAccept packet that it receives
+type sessionHandler func(conn *Async, m *sessionMessage) error
+
+// isSessionPacket returns whether packets with the given operation are counted and retransmitted by sessions
+func isSessionPacket(operation uint16) bool {
+	return operation > RESERVED9 || operation == STREAM || operation == ERROR
+}
+
+// newSessionPacket returns a SESSION packet of the given kind
+func newSessionPacket(kind uint16, id SessionID, received uint64) *packet.Packet {
+	var encoded [8]byte
+	binary.BigEndian.PutUint64(encoded[:], received)
+	fields := metadata.Extensions{{Type: sessionFieldID, Value: id[:]}, {Type: sessionFieldReceived, Value: encoded[:]}}
+
+	p := packet.Get()
+	p.Metadata.Id = kind
+	p.Metadata.Operation = SESSION
+	p.Content.Write(fields.Encode(nil))
+	p.Metadata.ContentLength = uint32(p.Content.Len())
+	return p
+}
+
+// decodeSessionMessage decodes the content of a SESSION packet
+func decodeSessionMessage(p *packet.Packet) (m sessionMessage, err error) {
+	var fields metadata.Extensions
+	if err = fields.Decode(p.Content.Bytes()); err != nil {
+		return
+	}
+	id, ok := fields.Get(sessionFieldID)
+	if !ok || len(id) != len(m.id) {
+		return m, InvalidSessionPacket
+	}
+	received, ok := fields.Get(sessionFieldReceived)
+	if !ok || len(received) != 8 {
+		return m, InvalidSessionPacket
+	}
+	m.kind = p.Metadata.Id
+	copy(m.id[:], id)
+	m.received = binary.BigEndian.Uint64(received)
+	return
+}
+
+// newSessionID returns a random SessionID
+func newSessionID() (id SessionID, err error) {
+	_, err = rand.Read(id[:])
+	return
+}
+
+// session holds the state of a session that is kept across connections
+type session struct {
+	mu           sync.Mutex
+	id           SessionID
+	bufferSize   int
+	ackThreshold uint64
+
+	// sent and received are the number of packets of the session that have been sent and received
+	sent     uint64
+	received uint64
+
+	// acknowledged is the number of received packets that have been acknowledged to the other side
+	acknowledged uint64
+
+	// buffer holds copies of the unacknowledged packets that were sent, where the last one is packet number sent
+	buffer []*packet.Packet
+}
+
+func newSession(id SessionID, options *SessionOptions) *session {
+	s := &session{
+		id:           id,
+		bufferSize:   options.BufferSize,
+		ackThreshold: uint64(options.BufferSize / 4),
+	}
+	if s.ackThreshold == 0 {
+		s.ackThreshold = 1
+	}
+	return s
+}
+
+// ID returns the SessionID
+func (s *session) ID() SessionID {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.id
+}
+
+// record keeps a copy of a packet that was sent until it is acknowledged, discarding the oldest packet if the buffer is full
+func (s *session) record(p *packet.Packet) {
+	c := clonePacket(p)
+	s.mu.Lock()
+	s.sent++
+	if len(s.buffer) == s.bufferSize {
+		packet.Put(s.buffer[0])
+		s.buffer[0] = nil
+		s.buffer = s.buffer[1:]
+	}
+	s.buffer = append(s.buffer, c)
+	s.mu.Unlock()
+}
+
+// clonePacket returns a copy of the given packet (including its extensions and headers) from the packet pool
+func clonePacket(p *packet.Packet) *packet.Packet {
+	c := packet.Get()
+	c.Metadata.Id = p.Metadata.Id
+	c.Metadata.IdHigh = p.Metadata.IdHigh
+	c.Metadata.Operation = p.Metadata.Operation
+	c.Metadata.ContentLength = p.Metadata.ContentLength
+	c.Content.Write(p.Content.Bytes())
+	for _, extension := range p.Extensions {
+		_ = c.Extensions.Set(extension.Type, extension.Value)
+	}
+	for _, header := range p.Headers {
+		_ = c.Headers.Set(header.Key, header.Value)
+	}
+	return c
+}
+
+// receive counts a packet that was received, and returns whether enough packets have been
+// received since the last acknowledgement that another one should be sent
+func (s *session) receive() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.received++
+	return s.received-s.acknowledged >= s.ackThreshold
+}
+
+// unacknowledged returns whether packets have been received since the last acknowledgement
+func (s *session) unacknowledged() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.received != s.acknowledged
+}
+
+// acknowledge discards the buffered packets that the other side has received
+func (s *session) acknowledge(received uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if received > s.sent {
+		return InvalidSessionPacket
+	}
+	for len(s.buffer) > 0 && s.sent-uint64(len(s.buffer)) < received {
+		packet.Put(s.buffer[0])
+		s.buffer[0] = nil
+		s.buffer = s.buffer[1:]
+	}
+	return nil
+}
+
+// resume discards the buffered packets that the other side has received, and returns the ones that must be retransmitted.
+// If the other side has not received packets that are no longer buffered, SessionRejected is returned.
+func (s *session) resume(received uint64) ([]*packet.Packet, error) {
+	if err := s.acknowledge(received); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sent-uint64(len(s.buffer)) != received {
+		return nil, SessionRejected
+	}
+	return append([]*packet.Packet(nil), s.buffer...), nil
+}
+
+// reset discards the state of the session and gives it the new SessionID
+func (s *session) reset(id SessionID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.id = id
+	s.sent = 0
+	s.received = 0
+	s.acknowledged = 0
+	for i := range s.buffer {
+		packet.Put(s.buffer[i])
+		s.buffer[i] = nil
+	}
+	s.buffer = s.buffer[:0]
+}
+
+// receivedCount returns the number of packets of the session that have been received, and acknowledges them
+func (s *session) receivedCount() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.acknowledged = s.received
+	return s.received
+}
+
+// writeSessionAck acknowledges the packets of the session that have been received
+func (c *Async) writeSessionAck(s *session) error {
+	p := newSessionPacket(sessionAck, s.ID(), s.receivedCount())
+	err := c.writePacket(p, false)
+	packet.Put(p)
+	return err
+}
+
+// receiveSession handles a SESSION packet from the other side of the connection
+func (c *Async) receiveSession(p *packet.Packet) error {
+	m, err := decodeSessionMessage(p)
+	if err != nil {
+		return err
+	}
+	if m.kind == sessionAck {
+		if s := c.session.Load(); s != nil {
+			return s.acknowledge(m.received)
+		}
+		return nil
+	}
+	if c.sessionHandler == nil {
+		c.Logger().Debug().Msg("SESSION Packet discarded by read loop because sessions are not enabled")
+		return nil
+	}
+	if m.kind == sessionHello && !c.sessionPending.CompareAndSwap(true, false) {
+		return InvalidSessionPacket
+	}
+	return c.sessionHandler(c, &m)
+}
+
+// serverSession is a session that a Server keeps while its connection is open, and for the GraceWindow after it drops
+type serverSession struct {
+	session *session
+
+	// ctx is the context of the connection that the session was started on (see Server.ConnContext)
+	ctx context.Context
+
+	// conn is the connection that the session is currently on, or nil if the session is waiting to be resumed
+	conn *Async
+
+	// expiry removes the session once its GraceWindow has passed
+	expiry *time.Timer
+}
+
+// acceptSession returns a sessionHandler that starts or resumes a session when a client sends a sessionHello,
+// and then sends the serverSession (or nil, if the client does not use sessions) to the resolved channel
+func (s *Server) acceptSession(resolved chan<- *serverSession) sessionHandler {
+	return func(conn *Async, m *sessionMessage) error {
+		if m == nil {
+			resolved <- nil
+			return nil
+		}
+		if m.kind != sessionHello {
+			return InvalidSessionPacket
+		}
+		ss, packets, err := s.resumeSession(conn, m)
+		if err != nil {
+			return err
+		}
+		p := newSessionPacket(sessionAccept, ss.session.ID(), ss.session.receivedCount())
+		err = conn.writePacket(p, false)
+		packet.Put(p)
+		for i := 0; i < len(packets) && err == nil; i++ {
+			err = conn.retransmitPacket(packets[i])
+		}
+		if err != nil {
+			return err
+		}
+		conn.session.Store(ss.session)
+		resolved <- ss
+		return nil
+	}
+}
+
+// resumeSession returns the session that the client asked to resume (after closing its previous connection) along with
+// the packets that must be retransmitted, or starts a new session if the requested one does not exist or cannot be resumed
+func (s *Server) resumeSession(conn *Async, m *sessionMessage) (*serverSession, []*packet.Packet, error) {
+	s.sessionsMu.Lock()
+	if ss, ok := s.sessions[m.id]; ok {
+		if ss.expiry != nil {
+			ss.expiry.Stop()
+			ss.expiry = nil
+		}
+		previous := ss.conn
+		ss.conn = conn
+		s.sessionsMu.Unlock()
+		if previous != nil {
+			_ = previous.Close()
+		}
+		packets, err := ss.session.resume(m.received)
+		if err == nil {
+			return ss, packets, nil
+		}
+		s.Logger().Warn().Err(err).Msg("Starting a new session because the requested session could not be resumed")
+		s.sessionsMu.Lock()
+		delete(s.sessions, m.id)
+	}
+	defer s.sessionsMu.Unlock()
+	id, err := newSessionID()
+	if err != nil {
+		return nil, nil, err
+	}
+	ss := &serverSession{
+		session: newSession(id, s.options.Sessions),
+		conn:    conn,
+	}
+	s.sessions[id] = ss
+	return ss, nil, nil
+}
+
+// sessionContext returns the context of the connection that the session was started on, or runs ConnContext
+// (and stores the result in the session) if the connection is the first one of the session
+func (s *Server) sessionContext(ss *serverSession, conn *Async) context.Context {
+	s.sessionsMu.Lock()
+	defer s.sessionsMu.Unlock()
+	if ss.ctx == nil {
+		ss.ctx = s.baseContext
+		if s.ConnContext != nil {
+			ss.ctx = s.ConnContext(ss.ctx, conn)
+		}
+	}
+	return ss.ctx
+}
+
+// releaseSession starts the GraceWindow of the session once the given connection has been closed,
+// unless the session has already been resumed on another connection
+func (s *Server) releaseSession(ss *serverSession, conn *Async) {
+	s.sessionsMu.Lock()
+	defer s.sessionsMu.Unlock()
+	if ss.conn != conn || s.shutdown.Load() {
+		return
+	}
+	ss.conn = nil
+	ss.expiry = time.AfterFunc(s.options.Sessions.GraceWindow, func() {
+		s.sessionsMu.Lock()
+		defer s.sessionsMu.Unlock()
+		if ss.conn == nil && s.sessions[ss.session.ID()] == ss {
+			delete(s.sessions, ss.session.ID())
+			ss.session.reset(SessionID{})
+		}
+	})
+}
+
+// closeSessions discards every session once the server has been shut down
+func (s *Server) closeSessions() {
+	s.sessionsMu.Lock()
+	defer s.sessionsMu.Unlock()
+	for id, ss := range s.sessions {
+		if ss.expiry != nil {
+			ss.expiry.Stop()
+		}
+		delete(s.sessions, id)
+	}
+}
+
+// connectSession dials the server and starts the client's session on the new connection, or resumes it
+// by retransmitting the packets that the server has not received
+func (c *Client) connectSession() (*Async, error) {
+	conn, err := dial(c.addr, c.options)
+	if err != nil {
+		return nil, err
+	}
+	accepted := make(chan error, 1)
+	frisbeeConn := newAsync(conn, c.options, connState{session: c.session, sessionHandler: c.resumeSession(accepted), deliveries: c.deliveries, received: c.received}, c.streamHandler...)
+
+	p := newSessionPacket(sessionHello, c.session.ID(), c.session.receivedCount())
+	err = frisbeeConn.writePacket(p, true)
+	packet.Put(p)
+	if err == nil {
+		err = frisbeeConn.Flush()
+	}
+	if err == nil {
+		timer := time.NewTimer(DefaultDeadline)
+		select {
+		case err = <-accepted:
+		case <-frisbeeConn.CloseChannel():
+			err = frisbeeConn.Error()
+			if err == nil {
+				err = ConnectionClosed
+			}
+		case <-timer.C:
+			err = SessionHandshakeTimeout
+		}
+		timer.Stop()
+	}
+	if err != nil {
+		_ = frisbeeConn.Close()
+		return nil, err
+	}
+	return frisbeeConn, nil
+}
+
+// resumeSession returns a sessionHandler that handles the sessionAccept that the server responds to a sessionHello with,
+// and then sends the result to the accepted channel
+func (c *Client) resumeSession(accepted chan<- error) sessionHandler {
+	var done bool
+	return func(conn *Async, m *sessionMessage) error {
+		if m.kind != sessionAccept || done {
+			return InvalidSessionPacket
+		}
+		done = true
+		if id := c.session.ID(); m.id != id {
+			if id != (SessionID{}) {
+				c.Logger().Warn().Msg("Started a new session because the previous session could not be resumed by the server")
+			}
+			c.session.reset(m.id)
+			accepted <- nil
+			return nil
+		}
+		packets, err := c.session.resume(m.received)
+		for i := 0; i < len(packets) && err == nil; i++ {
+			err = conn.retransmitPacket(packets[i])
+		}
+		if err != nil {
+			// The next connection starts a new session, since this one cannot be resumed
+			c.session.reset(SessionID{})
+		}
+		accepted <- err
+		return err
+	}
+}
+
+// reconnect redials the server and resumes the client's session until it succeeds, the client is closed,
+// or the session's GraceWindow has passed
+func (c *Client) reconnect() error {
+	c.Logger().Warn().Err(c.conn.Load().Error()).Msgf("Connection to %s lost, resuming session", c.addr)
+	deadline := time.Now().Add(c.options.Sessions.GraceWindow)
+	var backoff time.Duration
+	for {
+		conn, err := c.connectSession()
+		if err == nil {
+			c.conn.Store(conn)
+			if c.closed.Load() {
+				_ = conn.Close()
+				return ConnectionClosed
+			}
+			c.waitExtensions(conn)
+			if c.deliveries != nil {
+				c.deliveries.resend()
+			}
+			c.drainOutbox(conn)
+			c.Logger().Info().Msgf("Resumed session with %s", c.addr)
+			return nil
+		}
+		if backoff == 0 {
+			backoff = minBackoff
+		} else {
+			backoff *= 2
+		}
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+		if time.Now().Add(backoff).After(deadline) {
+			return SessionExpired
+		}
+		c.Logger().Debug().Err(err).Msgf("Unable to resume session with %s, retrying in %s", c.addr, backoff)
+		select {
+		case <-time.After(backoff):
+		case <-c.baseContext.Done():
+			return ConnectionClosed
+		}
+	}
+}
@@ -0,0 +1,216 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package frisbee
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/loopholelabs/logging"
+
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+// sessionRelay relays connections to a server, and can discard what the client sends or drop every connection
+type sessionRelay struct {
+	listener net.Listener
+	target   string
+	discard  atomic.Bool
+	wg       sync.WaitGroup
+	mu       sync.Mutex
+	conns    []net.Conn
+}
+
+func newSessionRelay(t *testing.T, target string) *sessionRelay {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	r := &sessionRelay{listener: listener, target: target}
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		for {
+			client, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			server, err := net.Dial("tcp", target)
+			if err != nil {
+				_ = client.Close()
+				continue
+			}
+			r.mu.Lock()
+			r.conns = append(r.conns, client, server)
+			r.mu.Unlock()
+			r.wg.Add(2)
+			go func() {
+				defer r.wg.Done()
+				buf := make([]byte, 1<<12)
+				for {
+					n, err := client.Read(buf)
+					if err != nil {
+						_ = server.Close()
+						return
+					}
+					if !r.discard.Load() {
+						_, _ = server.Write(buf[:n])
+					}
+				}
+			}()
+			go func() {
+				defer r.wg.Done()
+				_, _ = io.Copy(client, server)
+				_ = client.Close()
+			}()
+		}
+	}()
+	return r
+}
+
+// drop closes every relayed connection
+func (r *sessionRelay) drop() {
+	r.mu.Lock()
+	for _, conn := range r.conns {
+		_ = conn.Close()
+	}
+	r.conns = nil
+	r.mu.Unlock()
+}
+
+func (r *sessionRelay) close() {
+	_ = r.listener.Close()
+	r.drop()
+	r.wg.Wait()
+}
+
+func TestSession(t *testing.T) {
+	t.Parallel()
+
+	s := newSession(SessionID{1}, &SessionOptions{BufferSize: 4})
+	p := packet.Get()
+	p.Metadata.Operation = 32
+	for i := uint16(1); i <= 6; i++ {
+		p.Metadata.Id = i
+		s.record(p)
+	}
+	packet.Put(p)
+
+	require.NoError(t, s.acknowledge(3))
+	packets, err := s.resume(4)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(packets))
+	assert.Equal(t, uint16(5), packets[0].Metadata.Id)
+	assert.Equal(t, uint16(6), packets[1].Metadata.Id)
+
+	assert.ErrorIs(t, s.acknowledge(7), InvalidSessionPacket)
+	_, err = s.resume(1)
+	assert.ErrorIs(t, err, SessionRejected)
+
+	assert.True(t, s.receive())
+	assert.True(t, s.unacknowledged())
+	assert.Equal(t, uint64(1), s.receivedCount())
+	assert.False(t, s.unacknowledged())
+
+	s.reset(SessionID{2})
+	assert.Equal(t, SessionID{2}, s.ID())
+	_, err = s.resume(0)
+	assert.NoError(t, err)
+}
+
+func TestSessionResume(t *testing.T) {
+	t.Parallel()
+
+	const testSize = 100
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+	options := SessionOptions{GraceWindow: time.Second * 5}
+
+	received := make(chan uint16, testSize*2)
+	contexts := make(chan context.Context, testSize*2)
+	serverHandlerTable := make(HandlerTable)
+	serverHandlerTable[32] = func(ctx context.Context, incoming *packet.Packet) (outgoing *packet.Packet, action Action) {
+		contexts <- ctx
+		received <- incoming.Metadata.Id
+		return
+	}
+
+	s, err := NewServer(serverHandlerTable, context.Background(), WithLogger(emptyLogger), WithSessions(options))
+	require.NoError(t, err)
+	s.SetConcurrency(1)
+
+	var connContexts atomic.Int32
+	s.ConnContext = func(ctx context.Context, c *Async) context.Context {
+		connContexts.Add(1)
+		return context.WithValue(ctx, serverConnContextKey, c)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		_ = s.StartWithListener(listener)
+	}()
+	<-s.started()
+
+	relay := newSessionRelay(t, listener.Addr().String())
+
+	c, err := NewClient(make(HandlerTable), context.Background(), WithLogger(emptyLogger), WithSessions(options))
+	require.NoError(t, err)
+	require.NoError(t, c.Connect(relay.listener.Addr().String()))
+
+	p := packet.Get()
+	p.Metadata.Operation = 32
+	write := func(from, to int) {
+		for i := from; i < to; i++ {
+			p.Metadata.Id = uint16(i)
+			require.NoError(t, c.WritePacket(p))
+		}
+		require.NoError(t, c.Flush())
+	}
+
+	write(0, testSize/2)
+	var original context.Context
+	for i := 0; i < testSize/2; i++ {
+		assert.Equal(t, uint16(i), <-received)
+		original = <-contexts
+	}
+
+	// The packets written while the relay discards them are lost along with the connection, and must be retransmitted
+	relay.discard.Store(true)
+	conn := c.conn.Load()
+	write(testSize/2, testSize)
+	time.Sleep(time.Millisecond * 50)
+	relay.drop()
+	relay.discard.Store(false)
+	require.Eventually(t, func() bool {
+		current := c.conn.Load()
+		return current != conn && !current.Closed()
+	}, time.Second*5, time.Millisecond*10)
+
+	for i := testSize / 2; i < testSize; i++ {
+		select {
+		case id := <-received:
+			assert.Equal(t, uint16(i), id)
+		case <-time.After(time.Second * 5):
+			t.Fatal("timed out waiting for retransmitted packets")
+		}
+		assert.Equal(t, original, <-contexts)
+	}
+	assert.Equal(t, int32(1), connContexts.Load())
+	select {
+	case <-c.CloseChannel():
+		t.Fatal("client closed while resuming the session")
+	default:
+	}
+	packet.Put(p)
+
+	require.NoError(t, c.Close())
+	relay.close()
+	require.NoError(t, s.Shutdown())
+}
@@ -16,16 +16,29 @@ const DefaultStreamBufferSize = 1 << 12
 
 type NewStreamHandler func(*Stream)
 
+// streamConn is the connection (either an Async or a Sync connection) that a Stream belongs to
+type streamConn interface {
+	// writeStreamPacket writes a STREAM packet, closing the connection if the write fails
+	writeStreamPacket(p *packet.Packet) error
+
+	// deleteStream removes the stream with the given id from the connection
+	deleteStream(id uint16)
+
+	// streamMetrics returns the Metrics that stream events are recorded with
+	streamMetrics() Metrics
+}
+
 type Stream struct {
 	id      uint16
-	conn    *Async
+	conn    streamConn
 	closed  atomic.Bool
 	queue   *queue.Circular[packet.Packet, *packet.Packet]
 	staleMu sync.Mutex
 	stale   []*packet.Packet
 }
 
-func newStream(id uint16, conn *Async) *Stream {
+func newStream(id uint16, conn streamConn) *Stream {
+	conn.streamMetrics().StreamOpened()
 	return &Stream{
 		id:    id,
 		conn:  conn,
@@ -79,7 +92,7 @@ func (s *Stream) WritePacket(p *packet.Packet) error {
 	}
 	p.Metadata.Id = s.id
 	p.Metadata.Operation = STREAM
-	return s.conn.writePacket(p, true)
+	return s.conn.writeStreamPacket(p)
 }
 
 // ID returns the stream's ID.
@@ -87,9 +100,18 @@ func (s *Stream) ID() uint16 {
 	return s.id
 }
 
-// Conn returns the connection that the stream is associated with.
+// Conn returns the connection that the stream is associated with,
+// or nil if the stream belongs to a Sync connection (see SyncConn).
 func (s *Stream) Conn() *Async {
-	return s.conn
+	conn, _ := s.conn.(*Async)
+	return conn
+}
+
+// SyncConn returns the Sync connection that the stream is associated with,
+// or nil if the stream belongs to an Async connection (see Conn).
+func (s *Stream) SyncConn() *Sync {
+	conn, _ := s.conn.(*Sync)
+	return conn
 }
 
 // Close will close the stream and prevent any further reads or writes.
@@ -103,17 +125,16 @@ func (s *Stream) closeSend(lock bool) error {
 		s.queue.Close()
 		s.stale = s.queue.Drain()
 		s.staleMu.Unlock()
+		s.conn.streamMetrics().StreamClosed()
 
 		p := packet.Get()
 		p.Metadata.Id = s.id
 		p.Metadata.Operation = STREAM
-		err := s.conn.writePacket(p, true)
+		err := s.conn.writeStreamPacket(p)
 		packet.Put(p)
 
 		if lock {
-			s.conn.streamsMu.Lock()
-			delete(s.conn.streams, s.id)
-			s.conn.streamsMu.Unlock()
+			s.conn.deleteStream(s.id)
 		}
 
 		return err
@@ -128,6 +149,7 @@ func (s *Stream) close() {
 	if s.closed.CompareAndSwap(false, true) {
 		s.queue.Close()
 		s.stale = s.queue.Drain()
+		s.conn.streamMetrics().StreamClosed()
 	}
 	s.staleMu.Unlock()
 }
@@ -5,15 +5,13 @@ package frisbee
 import (
 	"context"
 	"crypto/tls"
-	"encoding/binary"
 	"errors"
-	"io"
 	"net"
 	"sync"
 	"sync/atomic"
 	"time"
 
-	"github.com/loopholelabs/logging/loggers/noop"
+	"github.com/loopholelabs/common/pkg/queue"
 	"github.com/loopholelabs/logging/types"
 
 	"github.com/loopholelabs/frisbee-go/internal/dialer"
@@ -24,30 +22,76 @@ import (
 // Sync is the underlying synchronous frisbee connection which has extremely efficient read and write logic and
 // can handle the specific frisbee requirements. This is not meant to be used on its own, and instead is
 // meant to be used by frisbee client and server implementations
+//
+// PING packets are answered with PONG packets while reading, and Heartbeat can be used to send PING packets
+// to the peer. Packets are read from the underlying net.Conn by ReadPacket until the first stream is created
+// (see NewStream and SetNewStreamHandler), after which they are read in the background and demultiplexed
+// between ReadPacket and the connection's streams.
 type Sync struct {
 	sync.Mutex
-	conn   net.Conn
-	closed atomic.Bool
-	logger types.Logger
-	error  atomic.Value
-	ctxMu  sync.RWMutex
-	ctx    context.Context
+	conn    net.Conn
+	closed  atomic.Bool
+	closeCh chan struct{}
+	logger  types.Logger
+	error   atomic.Value
+	ctxMu   sync.RWMutex
+	ctx     context.Context
+	metrics Metrics
+	wg      sync.WaitGroup
+
+	pingSent  atomic.Int64
+	heartbeat atomic.Bool
+
+	headerVersion   uint8
+	writeVersion    uint8
+	switchVersion   uint8
+	checksums       bool
+	writeChecksums  bool
+	switchChecksums bool
+	advertised      bool
+	extensions      bool
+	peerExtensions  bool
+	header          []byte
+	readVersion     uint8
+	readChecksums   bool
+	readHeader      [metadata.V2Size]byte
+
+	readMu             sync.Mutex
+	demux              atomic.Bool
+	demuxOnce          sync.Once
+	incoming           *queue.Circular[packet.Packet, *packet.Packet]
+	staleMu            sync.Mutex
+	stale              []*packet.Packet
+	streamsMu          sync.Mutex
+	streams            map[uint16]*Stream
+	newStreamHandlerMu sync.Mutex
+	newStreamHandler   NewStreamHandler
 }
 
 // ConnectSync creates a new TCP connection (using net.Dial) and wraps it in a frisbee connection
 func ConnectSync(addr string, keepAlive time.Duration, logger types.Logger, TLSConfig *tls.Config) (*Sync, error) {
+	return ConnectSyncWithOptions(addr, loadOptions(WithKeepAlive(keepAlive), WithLogger(logger), WithTLS(TLSConfig)))
+}
+
+// ConnectSyncWithOptions creates a new TCP connection (using net.Dial) and wraps it in a frisbee connection
+// that is configured using the given Options
+func ConnectSyncWithOptions(addr string, options *Options, streamHandler ...NewStreamHandler) (*Sync, error) {
+	if options == nil {
+		options = loadOptions()
+	}
+
 	var conn net.Conn
 	var err error
 
 	d := dialer.NewRetry()
 
-	if TLSConfig != nil {
-		conn, err = d.DialTLS("tcp", addr, TLSConfig)
+	if options.TLSConfig != nil {
+		conn, err = d.DialTLS("tcp", addr, options.TLSConfig)
 	} else {
 		conn, err = d.Dial("tcp", addr)
 		if err == nil {
 			_ = conn.(*net.TCPConn).SetKeepAlive(true)
-			_ = conn.(*net.TCPConn).SetKeepAlivePeriod(keepAlive)
+			_ = conn.(*net.TCPConn).SetKeepAlivePeriod(options.KeepAlive)
 		}
 	}
 
@@ -55,18 +99,46 @@ func ConnectSync(addr string, keepAlive time.Duration, logger types.Logger, TLSC
 		return nil, err
 	}
 
-	return NewSync(conn, logger), nil
+	return NewSyncWithOptions(conn, options, streamHandler...), nil
 }
 
 // NewSync takes an existing net.Conn object and wraps it in a frisbee connection
 func NewSync(c net.Conn, logger types.Logger) (conn *Sync) {
+	return NewSyncWithOptions(c, loadOptions(WithLogger(logger)))
+}
+
+// NewSyncWithOptions takes an existing net.Conn object and wraps it in a frisbee connection
+// that is configured using the given Options. If a NewStreamHandler is given, packets are
+// demultiplexed in the background straight away (see SetNewStreamHandler). If a pre-shared
+// key is set (see WithPSK), the net.Conn is wrapped in a PSKConn first.
+func NewSyncWithOptions(c net.Conn, options *Options, streamHandler ...NewStreamHandler) (conn *Sync) {
+	if options == nil {
+		options = loadOptions()
+	} else {
+		options = loadOptions(WithOptions(*options))
+	}
+
+	if options.PSK != nil {
+		c = NewPSKConn(c, options.PSK)
+	}
+
 	conn = &Sync{
-		conn:   c,
-		logger: logger,
+		conn:          c,
+		closeCh:       make(chan struct{}),
+		logger:        options.Logger,
+		metrics:       options.Metrics,
+		streams:       make(map[uint16]*Stream),
+		headerVersion: headerVersion(options),
+		writeVersion:  metadata.Version1,
+		readVersion:   metadata.Version1,
+		checksums:     options.Checksums,
+		extensions:    options.Extensions,
 	}
 
-	if logger == nil {
-		conn.logger = noop.New(types.InfoLevel)
+	conn.metrics.ConnectionOpened()
+
+	if len(streamHandler) > 0 && streamHandler[0] != nil {
+		conn.SetNewStreamHandler(streamHandler[0])
 	}
 	return
 }
@@ -126,60 +198,249 @@ func (c *Sync) RemoteAddr() net.Addr {
 // WritePacket takes a packet.Packet and sends it synchronously.
 //
 // If packet.Metadata.ContentLength == 0, then the content array must be nil. Otherwise, it is required that packet.Metadata.ContentLength == len(content).
+//
+// If the version 2 header, checksums, or extensions are enabled (see WithHeaderVersion, WithChecksums, and WithExtensions), the first
+// call also advertises them to the peer, and the calls after the peer has advertised them too tell the peer that they are used from
+// then on. Packets with headers can only be written once the peer's advertisement of extensions has been read, and
+// HeadersNotNegotiated is returned until then.
 func (c *Sync) WritePacket(p *packet.Packet) error {
 	if int(p.Metadata.ContentLength) != p.Content.Len() {
 		return InvalidContentLength
 	}
 
-	var encodedMetadata [metadata.Size]byte
-
-	binary.BigEndian.PutUint16(encodedMetadata[metadata.IdOffset:metadata.IdOffset+metadata.IdSize], p.Metadata.Id)
-	binary.BigEndian.PutUint16(encodedMetadata[metadata.OperationOffset:metadata.OperationOffset+metadata.OperationSize], p.Metadata.Operation)
-	binary.BigEndian.PutUint32(encodedMetadata[metadata.ContentLengthOffset:metadata.ContentLengthOffset+metadata.ContentLengthSize], p.Metadata.ContentLength)
-
 	c.Lock()
 	if c.closed.Load() {
 		c.Unlock()
 		return ConnectionClosed
 	}
 
-	_, err := c.conn.Write(encodedMetadata[:])
-	if err != nil {
-		c.Unlock()
-		if c.closed.Load() {
-			c.Logger().Debug().Err(ConnectionClosed).Uint16("Packet ID", p.Metadata.Id).Msg("error while writing encoded metadata")
-			return ConnectionClosed
+	if !c.advertised {
+		c.advertised = true
+		// If the peer has already advertised extensions, this side's advertisement is sent in reply
+		if negotiatePacket := newNegotiatePacket(nil, c.headerVersion, c.checksums, c.extensions || c.peerExtensions); negotiatePacket != nil {
+			err := c.writeLocked(negotiatePacket)
+			packet.Put(negotiatePacket)
+			if err != nil {
+				c.Unlock()
+				return err
+			}
 		}
-		c.Logger().Debug().Err(err).Uint16("Packet ID", p.Metadata.Id).Msg("error while writing encoded metadata")
-		return c.closeWithError(err)
 	}
-	if p.Metadata.ContentLength != 0 {
-		_, err = c.conn.Write(p.Content.Bytes()[:p.Metadata.ContentLength])
+	if c.switchVersion != 0 && (c.switchVersion != c.writeVersion || c.switchChecksums != c.writeChecksums) {
+		switchPacket := newSwitchPacket(c.switchVersion, c.switchChecksums)
+		err := c.writeLocked(switchPacket)
+		packet.Put(switchPacket)
 		if err != nil {
 			c.Unlock()
-			if c.closed.Load() {
-				c.Logger().Debug().Err(ConnectionClosed).Uint16("Packet ID", p.Metadata.Id).Msg("error while writing encoded metadata")
-				return ConnectionClosed
-			}
-			c.Logger().Debug().Err(err).Uint16("Packet ID", p.Metadata.Id).Msg("error while writing encoded metadata")
-			return c.closeWithError(err)
+			return err
 		}
+		c.writeVersion = c.switchVersion
+		c.writeChecksums = c.switchChecksums
 	}
 
+	err := c.writeLocked(p)
 	c.Unlock()
+	return err
+}
+
+// writeLocked writes the packet to the underlying net.Conn using the current header version (followed by
+// its checksum if checksums are used), and must be called with the lock held
+func (c *Sync) writeLocked(p *packet.Packet) error {
+	var extensionsSize int
+	var err error
+	extensions := p.Extensions
+	if c.peerExtensions {
+		extensions, err = extensions.WithHeaders(p.Headers)
+		if err != nil {
+			return err
+		}
+	} else {
+		if len(p.Headers) > 0 {
+			return HeadersNotNegotiated
+		}
+		extensions = extensions.Without(metadata.ExtensionTrace)
+	}
+	c.header, extensionsSize, err = appendHeader(c.header[:0], c.writeVersion, c.writeChecksums, p, int(p.Metadata.ContentLength), extensions)
+	if err != nil {
+		return err
+	}
+	headerSize := len(c.header)
+	content := p.Content.Bytes()[:p.Metadata.ContentLength]
+
+	_, err = c.conn.Write(c.header)
+	if err == nil && p.Metadata.ContentLength != 0 {
+		_, err = c.conn.Write(content)
+	}
+	if err == nil && c.writeChecksums {
+		c.header = appendChecksum(c.header, c.header[extensionsSize:headerSize], content)
+		_, err = c.conn.Write(c.header[headerSize:])
+	}
+	if err != nil {
+		if c.closed.Load() {
+			c.Logger().Debug().Err(ConnectionClosed).Uint16("Packet ID", p.Metadata.Id).Msg("error while writing packet")
+			return ConnectionClosed
+		}
+		c.Logger().Debug().Err(err).Uint16("Packet ID", p.Metadata.Id).Msg("error while writing packet")
+		return c.closeWithError(err)
+	}
+
+	if extensionsSize > 0 {
+		c.metrics.PacketWritten(EXTENSION, extensionsSize)
+	}
+	c.metrics.PacketWritten(p.Metadata.Operation, len(c.header)-extensionsSize+int(p.Metadata.ContentLength))
 	return nil
 }
 
 // ReadPacket is a blocking function that will wait until a frisbee packet is available and then return it (and its content).
 // In the event that the connection is closed, ReadPacket will return an error.
+//
+// PING and PONG packets are handled by ReadPacket and are never returned, and neither are STREAM packets
+// (which are discarded if no streams have been created).
 func (c *Sync) ReadPacket() (*packet.Packet, error) {
+	if !c.demux.Load() {
+		c.readMu.Lock()
+		for !c.demux.Load() {
+			p, err := c.receive()
+			if err != nil {
+				c.readMu.Unlock()
+				return nil, err
+			}
+			if p.Metadata.Operation != STREAM {
+				c.readMu.Unlock()
+				return p, nil
+			}
+			if c.demux.Load() {
+				// A stream was created while this packet was being read
+				c.routeStream(p)
+			} else {
+				c.Logger().Debug().Msg("STREAM Packet discarded by ReadPacket")
+				packet.Put(p)
+			}
+		}
+		c.readMu.Unlock()
+	}
+
+	if c.closed.Load() {
+		return c.popStale()
+	}
+
+	readPacket, err := c.incoming.Pop()
+	if err != nil {
+		if c.closed.Load() {
+			return c.popStale()
+		}
+		c.Logger().Debug().Err(err).Msg("error while popping from packet queue")
+		return nil, err
+	}
+
+	return readPacket, nil
+}
+
+// popStale returns the packets that were read before the connection was closed
+func (c *Sync) popStale() (*packet.Packet, error) {
+	c.staleMu.Lock()
+	if len(c.stale) > 0 {
+		var p *packet.Packet
+		p, c.stale = c.stale[0], c.stale[1:]
+		c.staleMu.Unlock()
+		return p, nil
+	}
+	c.staleMu.Unlock()
+	c.Logger().Debug().Err(ConnectionClosed).Msg("error while popping from packet queue")
+	return nil, ConnectionClosed
+}
+
+// receive reads the next packet from the underlying net.Conn that is not a PING or PONG packet,
+// and answers PING packets as they arrive
+func (c *Sync) receive() (*packet.Packet, error) {
+	for {
+		p, err := c.readWithExtensions()
+		if err != nil {
+			return nil, err
+		}
+		switch p.Metadata.Operation {
+		case PING:
+			c.Logger().Trace().Msg("PING Packet received, sending back PONG packet")
+			packet.Put(p)
+			err = c.WritePacket(PONGPacket)
+			if err != nil {
+				return nil, err
+			}
+		case PONG:
+			c.Logger().Trace().Msg("PONG Packet received")
+			packet.Put(p)
+			if sent := c.pingSent.Swap(0); sent != 0 {
+				c.metrics.PingRTT(time.Duration(time.Now().UnixNano() - sent))
+			}
+		case NEGOTIATE:
+			// Sync connections do not support compression, so only the header version and checksums are negotiated
+			c.Logger().Trace().Msg("NEGOTIATE Packet received")
+			n, err := decodeNegotiation(p.Content.Bytes())
+			packet.Put(p)
+			if err != nil {
+				c.Logger().Debug().Err(err).Msg("error while decoding NEGOTIATE packet")
+				return nil, c.closeWithError(err)
+			}
+			if n.extensions {
+				c.Lock()
+				c.peerExtensions = true
+				c.Unlock()
+			}
+			if n.switchVersion != 0 {
+				c.readVersion = n.switchVersion
+				c.readChecksums = n.checksums
+			} else if version, checksums := negotiatedFormat(n, c.headerVersion, c.checksums); version >= metadata.Version2 || checksums {
+				c.Lock()
+				c.switchVersion = version
+				c.switchChecksums = checksums
+				c.Unlock()
+			}
+		default:
+			return p, nil
+		}
+	}
+}
+
+// readWithExtensions reads a single frisbee packet from the underlying net.Conn, along with its extensions and headers
+func (c *Sync) readWithExtensions() (*packet.Packet, error) {
+	p, err := c.readPacket()
+	if err != nil {
+		return nil, err
+	}
+	if p.Metadata.Operation == EXTENSION {
+		extensions := p
+		p, err = c.readPacket()
+		if err != nil {
+			packet.Put(extensions)
+			return nil, err
+		}
+		if p.Metadata.Id == extensions.Metadata.Id {
+			err = p.Extensions.Decode(extensions.Content.Bytes())
+		}
+		packet.Put(extensions)
+		if err != nil {
+			packet.Put(p)
+			c.Logger().Debug().Err(err).Msg("error while decoding packet extensions")
+			return nil, c.closeWithError(err)
+		}
+	}
+	if err = p.Extensions.ExtractHeaders(&p.Headers); err != nil {
+		packet.Put(p)
+		c.Logger().Debug().Err(err).Msg("error while decoding packet headers")
+		return nil, c.closeWithError(err)
+	}
+	return p, nil
+}
+
+// readPacket reads a single frisbee packet from the underlying net.Conn
+func (c *Sync) readPacket() (*packet.Packet, error) {
 	if c.closed.Load() {
 		return nil, ConnectionClosed
 	}
-	var encodedPacket [metadata.Size]byte
 
-	_, err := io.ReadAtLeast(c.conn, encodedPacket[:], metadata.Size)
+	p, size, err := readPacket(c.conn, c.readVersion, c.readChecksums, c.readHeader[:])
 	if err != nil {
+		reportChecksumMismatch(c.metrics, err)
 		if c.closed.Load() {
 			c.Logger().Debug().Err(ConnectionClosed).Msg("error while reading from underlying net.Conn")
 			return nil, ConnectionClosed
@@ -187,28 +448,171 @@ func (c *Sync) ReadPacket() (*packet.Packet, error) {
 		c.Logger().Debug().Err(err).Msg("error while reading from underlying net.Conn")
 		return nil, c.closeWithError(err)
 	}
-	p := packet.Get()
 
-	p.Metadata.Id = binary.BigEndian.Uint16(encodedPacket[metadata.IdOffset : metadata.IdOffset+metadata.IdSize])
-	p.Metadata.Operation = binary.BigEndian.Uint16(encodedPacket[metadata.OperationOffset : metadata.OperationOffset+metadata.OperationSize])
-	p.Metadata.ContentLength = binary.BigEndian.Uint32(encodedPacket[metadata.ContentLengthOffset : metadata.ContentLengthOffset+metadata.ContentLengthSize])
+	c.metrics.PacketRead(p.Metadata.Operation, size)
+	return p, nil
+}
+
+// NewStream returns a new stream that can be used to send and receive packets.
+//
+// Once a stream has been created, packets are read from the underlying net.Conn in the
+// background and ReadPacket returns the packets that do not belong to a stream.
+func (c *Sync) NewStream(id uint16) (stream *Stream) {
+	c.startDemux()
+	c.streamsMu.Lock()
+	if stream = c.streams[id]; stream == nil {
+		stream = newStream(id, c)
+		c.streams[id] = stream
+	}
+	c.streamsMu.Unlock()
+	return
+}
+
+// SetNewStreamHandler sets the callback handler for streams opened by the peer, and if it is not nil,
+// starts reading packets from the underlying net.Conn in the background (see NewStream).
+//
+// If no handler is set then packets for new streams will be dropped. The handler is called in its own
+// goroutine to avoid blocking the reads, which means that the handler must be thread-safe.
+func (c *Sync) SetNewStreamHandler(handler NewStreamHandler) {
+	c.newStreamHandlerMu.Lock()
+	c.newStreamHandler = handler
+	c.newStreamHandlerMu.Unlock()
+	if handler != nil {
+		c.startDemux()
+	}
+}
+
+// Heartbeat starts sending a PING packet every interval (or DefaultPingInterval if the interval is not positive)
+// until the connection is closed. Async connections are closed when nothing has been read from them for
+// DefaultDeadline, so this keeps idle connections to Async peers open. Calling Heartbeat more than once has no effect.
+func (c *Sync) Heartbeat(interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultPingInterval
+	}
+	if c.closed.Load() || !c.heartbeat.CompareAndSwap(false, true) {
+		return
+	}
+	c.wg.Add(1)
+	go c.pingLoop(interval)
+}
+
+// writeStreamPacket implements streamConn
+func (c *Sync) writeStreamPacket(p *packet.Packet) error {
+	return c.WritePacket(p)
+}
+
+// deleteStream implements streamConn
+func (c *Sync) deleteStream(id uint16) {
+	c.streamsMu.Lock()
+	delete(c.streams, id)
+	c.streamsMu.Unlock()
+}
+
+// streamMetrics implements streamConn
+func (c *Sync) streamMetrics() Metrics {
+	return c.metrics
+}
+
+// startDemux starts reading packets from the underlying net.Conn in the background, if it has not been started already
+func (c *Sync) startDemux() {
+	c.demuxOnce.Do(func() {
+		if c.closed.Load() {
+			return
+		}
+		c.incoming = queue.NewCircular[packet.Packet, *packet.Packet](DefaultBufferSize)
+		c.wg.Add(1)
+		c.demux.Store(true)
+		go c.readLoop()
+	})
+}
 
-	if p.Metadata.ContentLength > 0 {
-		contentLength := int(p.Metadata.ContentLength)
-		p.Content.Grow(contentLength)
-		p.Content.MoveOffset(contentLength)
-		_, err = io.ReadAtLeast(c.conn, p.Content.Bytes(), contentLength)
+// routeStream delivers a STREAM packet to its stream, and creates the stream (calling the
+// NewStreamHandler) if the packet is the first one for a stream opened by the peer
+func (c *Sync) routeStream(p *packet.Packet) {
+	c.streamsMu.Lock()
+	stream := c.streams[p.Metadata.Id]
+	if p.Metadata.ContentLength == 0 {
+		if stream != nil {
+			stream.close()
+			delete(c.streams, p.Metadata.Id)
+		}
+		c.streamsMu.Unlock()
+		packet.Put(p)
+		return
+	}
+	if stream == nil {
+		c.newStreamHandlerMu.Lock()
+		newStreamHandler := c.newStreamHandler
+		c.newStreamHandlerMu.Unlock()
+		if newStreamHandler == nil {
+			c.streamsMu.Unlock()
+			c.Logger().Debug().Msg("STREAM Packet discarded by read loop")
+			packet.Put(p)
+			return
+		}
+		stream = newStream(p.Metadata.Id, c)
+		c.streams[p.Metadata.Id] = stream
+		go newStreamHandler(stream)
+	}
+	c.streamsMu.Unlock()
+	if err := stream.queue.Push(p); err != nil {
+		c.Logger().Debug().Err(err).Msg("STREAM Packet discarded by read loop because the stream is closed")
+		packet.Put(p)
+	}
+}
+
+func (c *Sync) readLoop() {
+	// Any ReadPacket call that is already reading from the net.Conn must finish first
+	c.readMu.Lock()
+	c.readMu.Unlock()
+	for {
+		p, err := c.receive()
 		if err != nil {
-			if c.closed.Load() {
-				c.Logger().Debug().Err(ConnectionClosed).Msg("error while reading from underlying net.Conn")
-				return nil, ConnectionClosed
-			}
-			c.Logger().Debug().Err(err).Msg("error while reading from underlying net.Conn")
-			return nil, c.closeWithError(err)
+			break
 		}
+		if p.Metadata.Operation == STREAM {
+			c.routeStream(p)
+			continue
+		}
+		err = c.incoming.Push(p)
+		if err != nil {
+			c.Logger().Debug().Err(err).Msg("error while pushing to incoming packet queue")
+			packet.Put(p)
+			break
+		}
+		c.metrics.IncomingQueueDepth(c.incoming.Length())
 	}
 
-	return p, nil
+	c.staleMu.Lock()
+	c.incoming.Close()
+	c.stale = c.incoming.Drain()
+	c.staleMu.Unlock()
+
+	c.streamsMu.Lock()
+	for id, stream := range c.streams {
+		stream.close()
+		delete(c.streams, id)
+	}
+	c.streamsMu.Unlock()
+	c.wg.Done()
+}
+
+func (c *Sync) pingLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.closeCh:
+			c.wg.Done()
+			return
+		case <-ticker.C:
+			c.pingSent.CompareAndSwap(0, time.Now().UnixNano())
+			if err := c.WritePacket(PINGPacket); err != nil {
+				c.wg.Done()
+				return
+			}
+		}
+	}
 }
 
 // SetContext allows users to save a context within a connection
@@ -242,7 +646,16 @@ func (c *Sync) Error() error {
 
 // Raw shuts off all of frisbee's underlying functionality and converts the frisbee connection into a normal TCP connection (net.Conn)
 func (c *Sync) Raw() net.Conn {
-	_ = c.close()
+	if c.close() == nil {
+		c.metrics.ConnectionClosed(nil)
+	}
+	if c.demux.Load() {
+		_ = c.conn.SetReadDeadline(pastTime)
+		c.wg.Wait()
+		_ = c.conn.SetReadDeadline(emptyTime)
+	} else {
+		c.wg.Wait()
+	}
 	return c.conn
 }
 
@@ -250,14 +663,18 @@ func (c *Sync) Raw() net.Conn {
 func (c *Sync) Close() error {
 	err := c.close()
 	if errors.Is(err, ConnectionClosed) {
+		c.wg.Wait()
 		return nil
 	}
+	c.metrics.ConnectionClosed(nil)
 	_ = c.conn.Close()
+	c.wg.Wait()
 	return err
 }
 
 func (c *Sync) close() error {
 	if c.closed.CompareAndSwap(false, true) {
+		close(c.closeCh)
 		return nil
 	}
 	return ConnectionClosed
@@ -272,6 +689,7 @@ func (c *Sync) closeWithError(err error) error {
 		c.Logger().Debug().Err(err).Msgf("closing connection with error")
 	}
 	c.error.Store(err)
+	c.metrics.ConnectionClosed(err)
 	_ = c.conn.Close()
 	return err
 }
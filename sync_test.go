@@ -6,7 +6,9 @@ import (
 	"crypto/rand"
 	"io"
 	"net"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -367,3 +369,170 @@ func BenchmarkSyncThroughputNetwork(b *testing.B) {
 	_ = readerConn.Close()
 	_ = writerConn.Close()
 }
+
+// pingMetrics is a Metrics implementation that counts the ping round-trip times that are recorded
+type pingMetrics struct {
+	noopMetrics
+	pings atomic.Int64
+}
+
+func (m *pingMetrics) PingRTT(time.Duration) {
+	m.pings.Add(1)
+}
+
+func TestSyncPing(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+
+	syncConn, asyncConn, err := pair.New()
+	require.NoError(t, err)
+
+	readerConn := NewSync(syncConn, emptyLogger)
+	writerConn := NewAsync(asyncConn, emptyLogger)
+
+	// The Async connection sends PING packets while waiting, which must be answered and never returned
+	time.Sleep(DefaultPingInterval * 2)
+	p := packet.Get()
+	p.Metadata.Id = 64
+	p.Metadata.Operation = 32
+	require.NoError(t, writerConn.WritePacket(p))
+	require.NoError(t, writerConn.Flush())
+	packet.Put(p)
+
+	p, err = readerConn.ReadPacket()
+	require.NoError(t, err)
+	assert.Equal(t, uint16(64), p.Metadata.Id)
+	assert.Equal(t, uint16(32), p.Metadata.Operation)
+	packet.Put(p)
+
+	require.Eventually(t, func() bool {
+		return writerConn.pingOutstanding(time.Now()) < DefaultPingInterval
+	}, DefaultDeadline, time.Millisecond*10)
+
+	require.NoError(t, readerConn.Close())
+	require.NoError(t, writerConn.Close())
+}
+
+func TestSyncHeartbeat(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+
+	syncConn, asyncConn, err := pair.New()
+	require.NoError(t, err)
+
+	metrics := new(pingMetrics)
+	readerConn := NewSyncWithOptions(syncConn, loadOptions(WithLogger(emptyLogger), WithMetrics(metrics)))
+	writerConn := NewAsync(asyncConn, emptyLogger)
+
+	readerConn.Heartbeat(time.Millisecond * 10)
+	readerConn.Heartbeat(time.Millisecond * 10)
+
+	// PONG packets are only consumed while the Sync connection is being read from
+	done := make(chan struct{})
+	go func() {
+		_, err := readerConn.ReadPacket()
+		assert.ErrorIs(t, err, ConnectionClosed)
+		close(done)
+	}()
+
+	require.Eventually(t, func() bool {
+		return metrics.pings.Load() >= 3
+	}, DefaultDeadline, time.Millisecond*10)
+
+	require.NoError(t, readerConn.Close())
+	<-done
+	require.NoError(t, writerConn.Close())
+}
+
+func TestSyncStreams(t *testing.T) {
+	t.Parallel()
+
+	const testSize = 10
+	const packetSize = 64
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+
+	syncConn, asyncConn, err := pair.New()
+	require.NoError(t, err)
+
+	// Streams opened by the Sync connection are handed to the test, and streams opened by the
+	// Async connection are echoed back by the Sync connection
+	asyncStreams := make(chan *Stream, 1)
+	writerConn := NewAsync(asyncConn, emptyLogger, func(stream *Stream) {
+		asyncStreams <- stream
+	})
+	echoed := make(chan struct{})
+	var readerConn *Sync
+	readerConn = NewSyncWithOptions(syncConn, loadOptions(WithLogger(emptyLogger)), func(stream *Stream) {
+		assert.Equal(t, readerConn, stream.SyncConn())
+		assert.Nil(t, stream.Conn())
+		for {
+			p, err := stream.ReadPacket()
+			if err != nil {
+				assert.ErrorIs(t, err, StreamClosed)
+				close(echoed)
+				return
+			}
+			assert.NoError(t, stream.WritePacket(p))
+			packet.Put(p)
+		}
+	})
+
+	data := make([]byte, packetSize)
+	_, _ = rand.Read(data)
+
+	stream := writerConn.NewStream(1)
+	for i := 0; i < testSize; i++ {
+		p := packet.Get()
+		p.Content.Write(data)
+		p.Metadata.ContentLength = packetSize
+		require.NoError(t, stream.WritePacket(p))
+		packet.Put(p)
+	}
+	require.NoError(t, writerConn.Flush())
+	for i := 0; i < testSize; i++ {
+		p, err := stream.ReadPacket()
+		require.NoError(t, err)
+		assert.Equal(t, data, p.Content.Bytes())
+		packet.Put(p)
+	}
+
+	// Packets that do not belong to a stream are still returned by ReadPacket
+	p := packet.Get()
+	p.Metadata.Id = 64
+	p.Metadata.Operation = 32
+	require.NoError(t, writerConn.WritePacket(p))
+	require.NoError(t, writerConn.Flush())
+	packet.Put(p)
+	p, err = readerConn.ReadPacket()
+	require.NoError(t, err)
+	assert.Equal(t, uint16(64), p.Metadata.Id)
+	packet.Put(p)
+
+	syncStream := readerConn.NewStream(2)
+	p = packet.Get()
+	p.Content.Write(data)
+	p.Metadata.ContentLength = packetSize
+	require.NoError(t, syncStream.WritePacket(p))
+	packet.Put(p)
+	remoteStream := <-asyncStreams
+	assert.Equal(t, uint16(2), remoteStream.ID())
+	p, err = remoteStream.ReadPacket()
+	require.NoError(t, err)
+	assert.Equal(t, data, p.Content.Bytes())
+	packet.Put(p)
+
+	require.NoError(t, remoteStream.Close())
+	require.NoError(t, writerConn.Flush())
+	_, err = syncStream.ReadPacket()
+	assert.ErrorIs(t, err, StreamClosed)
+
+	require.NoError(t, stream.Close())
+	require.NoError(t, writerConn.Flush())
+	<-echoed
+
+	require.NoError(t, readerConn.Close())
+	require.NoError(t, writerConn.Close())
+}
@@ -0,0 +1,23 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package frisbee
+
+import (
+	"sync/atomic"
+
+	"github.com/loopholelabs/frisbee-go/pkg/capture"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+// asyncIDs is used to assign every Async connection a unique ID
+var asyncIDs atomic.Uint64
+
+// Tap is used to observe every packet that is read from or written to an Async connection,
+// including PING, PONG, and STREAM packets. The *capture.Writer type implements Tap, and
+// can be used to record packets to a capture file.
+//
+// Tap is called from the read and write paths of the connection, so it must be thread-safe,
+// it must return quickly, and it must not retain or modify the packet.
+type Tap interface {
+	Tap(direction capture.Direction, connection uint64, p *packet.Packet)
+}
@@ -0,0 +1,137 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package frisbee
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+var (
+	InvalidTraceparent = errors.New("invalid traceparent")
+)
+
+const (
+	// traceparentVersion is the only W3C traceparent version that is currently supported
+	traceparentVersion = "00"
+
+	// traceparentLength is the length of a version 00 W3C traceparent string
+	traceparentLength = 55
+
+	// encodedTraceSize is the size of a TraceContext encoded as a packet extension
+	encodedTraceSize = 16 + 8 + 1
+)
+
+type traceContextKey struct{}
+
+// TraceContext is a W3C traceparent-style trace context that can be propagated across
+// frisbee connections using packet extensions
+type TraceContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Flags   byte
+}
+
+// Tracer is used to start and end spans around the execution of Handler functions in a frisbee Server or Client
+type Tracer interface {
+	// StartSpan is called before a Handler function is run. The context contains the TraceContext
+	// of the incoming packet (if it has one), and the returned context is passed to the Handler function.
+	StartSpan(ctx context.Context, incoming *packet.Packet) context.Context
+
+	// EndSpan is called after a Handler function returns with the context returned by StartSpan,
+	// and the outgoing packet and Action returned by the Handler function
+	EndSpan(ctx context.Context, outgoing *packet.Packet, action Action)
+}
+
+// ParseTraceparent parses a W3C traceparent string (e.g. "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+func ParseTraceparent(traceparent string) (t TraceContext, err error) {
+	if len(traceparent) != traceparentLength || traceparent[:2] != traceparentVersion ||
+		traceparent[2] != '-' || traceparent[35] != '-' || traceparent[52] != '-' {
+		return t, InvalidTraceparent
+	}
+	if _, err = hex.Decode(t.TraceID[:], []byte(traceparent[3:35])); err != nil {
+		return t, errors.Join(InvalidTraceparent, err)
+	}
+	if _, err = hex.Decode(t.SpanID[:], []byte(traceparent[36:52])); err != nil {
+		return t, errors.Join(InvalidTraceparent, err)
+	}
+	var flags [1]byte
+	if _, err = hex.Decode(flags[:], []byte(traceparent[53:])); err != nil {
+		return t, errors.Join(InvalidTraceparent, err)
+	}
+	t.Flags = flags[0]
+	if !t.IsValid() {
+		return t, InvalidTraceparent
+	}
+	return t, nil
+}
+
+// IsValid returns whether both the TraceID and SpanID are non-zero
+func (t TraceContext) IsValid() bool {
+	return t.TraceID != [16]byte{} && t.SpanID != [8]byte{}
+}
+
+// Sampled returns whether the sampled flag is set
+func (t TraceContext) Sampled() bool {
+	return t.Flags&0x01 == 0x01
+}
+
+// String returns the W3C traceparent representation of the TraceContext
+func (t TraceContext) String() string {
+	b := make([]byte, 0, traceparentLength)
+	b = append(b, traceparentVersion...)
+	b = append(b, '-')
+	b = hex.AppendEncode(b, t.TraceID[:])
+	b = append(b, '-')
+	b = hex.AppendEncode(b, t.SpanID[:])
+	b = append(b, '-')
+	b = hex.AppendEncode(b, []byte{t.Flags})
+	return string(b)
+}
+
+// ContextWithTrace returns a copy of ctx that carries the given TraceContext
+func ContextWithTrace(ctx context.Context, t TraceContext) context.Context {
+	return context.WithValue(ctx, traceContextKey{}, t)
+}
+
+// TraceFromContext returns the TraceContext carried by ctx, if there is one
+func TraceFromContext(ctx context.Context) (TraceContext, bool) {
+	t, ok := ctx.Value(traceContextKey{}).(TraceContext)
+	return t, ok
+}
+
+// InjectTrace attaches the TraceContext carried by ctx (if there is one) to the given packet
+// so that it can be extracted by the receiver. It returns whether a TraceContext was attached.
+//
+// The TraceContext is only sent to peers that have advertised that they support it, so clients that propagate
+// trace contexts should use WithExtensions, which makes them wait for the server's advertisement when they connect.
+func InjectTrace(ctx context.Context, p *packet.Packet) bool {
+	t, ok := TraceFromContext(ctx)
+	if !ok || !t.IsValid() {
+		return false
+	}
+	var encoded [encodedTraceSize]byte
+	copy(encoded[:16], t.TraceID[:])
+	copy(encoded[16:24], t.SpanID[:])
+	encoded[24] = t.Flags
+	return p.Extensions.Set(metadata.ExtensionTrace, encoded[:]) == nil
+}
+
+// ExtractTrace returns the TraceContext attached to the given packet, if there is one
+func ExtractTrace(p *packet.Packet) (t TraceContext, ok bool) {
+	if len(p.Extensions) == 0 {
+		return
+	}
+	encoded, ok := p.Extensions.Get(metadata.ExtensionTrace)
+	if !ok || len(encoded) != encodedTraceSize {
+		return t, false
+	}
+	copy(t.TraceID[:], encoded[:16])
+	copy(t.SpanID[:], encoded[16:24])
+	t.Flags = encoded[24]
+	return t, t.IsValid()
+}
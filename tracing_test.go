@@ -0,0 +1,170 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package frisbee
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/loopholelabs/logging"
+
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+const testTraceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+type testTracer struct {
+	started atomic.Int32
+	ended   atomic.Int32
+}
+
+type testSpanKey struct{}
+
+func (t *testTracer) StartSpan(ctx context.Context, _ *packet.Packet) context.Context {
+	t.started.Add(1)
+	return context.WithValue(ctx, testSpanKey{}, true)
+}
+
+func (t *testTracer) EndSpan(ctx context.Context, _ *packet.Packet, _ Action) {
+	if ctx.Value(testSpanKey{}) != nil {
+		t.ended.Add(1)
+	}
+}
+
+func TestParseTraceparent(t *testing.T) {
+	t.Parallel()
+
+	trace, err := ParseTraceparent(testTraceparent)
+	require.NoError(t, err)
+	assert.True(t, trace.IsValid())
+	assert.True(t, trace.Sampled())
+	assert.Equal(t, testTraceparent, trace.String())
+
+	for _, invalid := range []string{
+		"",
+		"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7_01",
+		"00-zzf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+	} {
+		_, err = ParseTraceparent(invalid)
+		assert.ErrorIs(t, err, InvalidTraceparent, invalid)
+	}
+}
+
+func TestTraceExtensions(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+
+	trace, err := ParseTraceparent(testTraceparent)
+	require.NoError(t, err)
+	ctx := ContextWithTrace(context.Background(), trace)
+
+	p := packet.Get()
+	_, ok := ExtractTrace(p)
+	assert.False(t, ok)
+	assert.False(t, InjectTrace(context.Background(), p))
+	require.True(t, InjectTrace(ctx, p))
+	p.Metadata.Id = 32
+	p.Metadata.Operation = 64
+	p.Content.Write([]byte("traced"))
+	p.Metadata.ContentLength = 6
+
+	// Only the readers advertise extensions, since trace contexts are only sent to peers that support them
+	asyncReader, asyncWriter := net.Pipe()
+	readerConn := NewAsyncWithOptions(asyncReader, &Options{Logger: emptyLogger, Extensions: true})
+	writerConn := NewAsync(asyncWriter, emptyLogger)
+
+	syncReader, syncWriter := net.Pipe()
+	syncReaderConn := NewSyncWithOptions(syncReader, &Options{Logger: emptyLogger, Extensions: true})
+	syncWriterConn := NewSync(syncWriter, emptyLogger)
+
+	expectExtensions(t, writerConn)
+	syncAdvertised := make(chan error, 1)
+	go func() {
+		syncAdvertised <- syncReaderConn.WritePacket(newHeaderTestPacket(0, 32, nil))
+	}()
+	advertisement, err := syncWriterConn.ReadPacket()
+	require.NoError(t, err)
+	packet.Put(advertisement)
+	require.NoError(t, <-syncAdvertised)
+
+	require.NoError(t, writerConn.WritePacket(p))
+	syncWriteErr := make(chan error, 1)
+	go func() {
+		syncWriteErr <- syncWriterConn.WritePacket(p)
+	}()
+
+	for _, conn := range []Conn{readerConn, syncReaderConn} {
+		read, err := conn.ReadPacket()
+		require.NoError(t, err)
+		assert.Equal(t, uint16(32), read.Metadata.Id)
+		assert.Equal(t, []byte("traced"), read.Content.Bytes())
+		extracted, ok := ExtractTrace(read)
+		require.True(t, ok)
+		assert.Equal(t, trace, extracted)
+		packet.Put(read)
+	}
+	require.NoError(t, <-syncWriteErr)
+
+	p.Reset()
+	assert.Empty(t, p.Extensions)
+	packet.Put(p)
+
+	assert.NoError(t, readerConn.Close())
+	assert.NoError(t, writerConn.Close())
+	assert.NoError(t, syncReaderConn.Close())
+	assert.NoError(t, syncWriterConn.Close())
+}
+
+func TestServerTracing(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+	tracer := new(testTracer)
+
+	trace, err := ParseTraceparent(testTraceparent)
+	require.NoError(t, err)
+
+	received := make(chan TraceContext, 2)
+	serverHandlerTable := make(HandlerTable)
+	serverHandlerTable[10] = func(ctx context.Context, _ *packet.Packet) (outgoing *packet.Packet, action Action) {
+		trace, _ := TraceFromContext(ctx)
+		received <- trace
+		return
+	}
+
+	s, err := NewServer(serverHandlerTable, context.Background(), WithLogger(emptyLogger), WithTracer(tracer), WithExtensions())
+	require.NoError(t, err)
+
+	serverConn, clientConn := net.Pipe()
+	go s.ServeConn(serverConn)
+
+	c, err := NewClient(make(HandlerTable), context.Background(), WithLogger(emptyLogger), WithExtensions())
+	require.NoError(t, err)
+	require.NoError(t, c.FromConn(clientConn))
+
+	p := packet.Get()
+	p.Metadata.Operation = 10
+	require.NoError(t, c.WritePacketContext(ContextWithTrace(context.Background(), trace), p))
+	assert.Equal(t, trace, <-received)
+	packet.Put(p)
+
+	p = packet.Get()
+	p.Metadata.Operation = 10
+	require.NoError(t, c.WritePacketContext(context.Background(), p))
+	assert.Equal(t, TraceContext{}, <-received)
+	packet.Put(p)
+
+	assert.NoError(t, c.Close())
+	assert.NoError(t, s.Shutdown())
+
+	assert.Equal(t, int32(2), tracer.started.Load())
+	assert.Equal(t, int32(2), tracer.ended.Load())
+}
@@ -0,0 +1,96 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package frisbee
+
+import (
+	"context"
+	"errors"
+
+	"github.com/loopholelabs/polyglot/v2"
+
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+// Message is implemented by pointers to types that can be encoded to and decoded from
+// packet content using polyglot, such as the types generated by polyglot's protoc plugin
+type Message[T any] interface {
+	*T
+	Encode(b *polyglot.Buffer)
+	Decode(b []byte) error
+}
+
+// TypedHandler converts a function that handles decoded requests into a Handler.
+//
+// The returned Handler decodes the content of each incoming packet into a new Req, and encodes the Resp that
+// the function returns as the content of the response, which uses the same ID and operation as the incoming packet.
+// If the function returns a nil Resp and a nil error, no response is sent.
+//
+// If the content cannot be decoded, an ERROR packet with ErrorInvalidRequest is sent back instead of calling the
+// function, and if the function returns an error, an ERROR packet is sent back with the error's message (see ErrorFrame).
+// Functions can return an *ErrorFrame to choose the ErrorCode, otherwise ErrorUnknown is used.
+func TypedHandler[Req, Resp any, ReqPtr Message[Req], RespPtr Message[Resp]](handler func(context.Context, ReqPtr) (RespPtr, error)) Handler {
+	return func(ctx context.Context, incoming *packet.Packet) (*packet.Packet, Action) {
+		req := ReqPtr(new(Req))
+		if err := req.Decode(incoming.Content.Bytes()); err != nil {
+			return NewErrorPacket(incoming.Metadata.Id, ErrorInvalidRequest, err.Error()), NONE
+		}
+		resp, err := handler(ctx, req)
+		if err != nil {
+			return errorResponse(incoming.Metadata.Id, err), NONE
+		}
+		if resp == nil {
+			return nil, NONE
+		}
+		incoming.Content.Reset()
+		incoming.Extensions.Reset()
+		incoming.Headers.Reset()
+		resp.Encode(incoming.Content)
+		incoming.Metadata.ContentLength = uint32(incoming.Content.Len())
+		return incoming, NONE
+	}
+}
+
+// errorResponse returns an ERROR packet for an error returned by a typed handler function,
+// using the ErrorCode of the error if it is an *ErrorFrame and ErrorUnknown otherwise
+func errorResponse(id uint16, err error) *packet.Packet {
+	var errorFrame *ErrorFrame
+	if errors.As(err, &errorFrame) {
+		return NewErrorPacket(id, errorFrame.Code, errorFrame.Message)
+	}
+	return NewErrorPacket(id, ErrorUnknown, err.Error())
+}
+
+// RegisterTyped adds a TypedHandler for the given operation to the HandlerTable.
+// Servers and Clients that are already running can use RegisterHandler with a TypedHandler instead.
+func RegisterTyped[Req, Resp any, ReqPtr Message[Req], RespPtr Message[Resp]](handlerTable HandlerTable, operation uint16, handler func(context.Context, ReqPtr) (RespPtr, error)) error {
+	if operation <= RESERVED9 {
+		return InvalidOperation
+	}
+	if handler == nil {
+		return HandlerNil
+	}
+	handlerTable[operation] = TypedHandler[Req, Resp, ReqPtr, RespPtr](handler)
+	return nil
+}
+
+// CallTyped encodes the request as the content of a packet with the given operation, sends it using
+// Client.Call, and decodes the content of the response into a new Resp. ERROR responses are returned
+// as *ErrorFrame errors.
+func CallTyped[Req, Resp any, ReqPtr Message[Req], RespPtr Message[Resp]](ctx context.Context, c *Client, operation uint16, req ReqPtr) (RespPtr, error) {
+	p := packet.Get()
+	p.Metadata.Operation = operation
+	req.Encode(p.Content)
+	p.Metadata.ContentLength = uint32(p.Content.Len())
+	response, err := c.Call(ctx, p)
+	packet.Put(p)
+	if err != nil {
+		return nil, err
+	}
+	resp := RespPtr(new(Resp))
+	err = resp.Decode(response.Content.Bytes())
+	packet.Put(response)
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
@@ -0,0 +1,123 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package frisbee
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/loopholelabs/logging"
+	"github.com/loopholelabs/polyglot/v2"
+	"github.com/loopholelabs/testing/conn/pair"
+
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+type typedTestMessage struct {
+	Value string
+}
+
+func (m *typedTestMessage) Encode(b *polyglot.Buffer) {
+	polyglot.Encoder(b).String(m.Value)
+}
+
+func (m *typedTestMessage) Decode(b []byte) (err error) {
+	m.Value, err = polyglot.Decoder(b).String()
+	return
+}
+
+func TestTyped(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+
+	unblock := make(chan struct{})
+	handlerTable := make(HandlerTable)
+	require.NoError(t, RegisterTyped(handlerTable, 10, func(_ context.Context, req *typedTestMessage) (*typedTestMessage, error) {
+		return &typedTestMessage{Value: strings.ToUpper(req.Value)}, nil
+	}))
+	require.NoError(t, RegisterTyped(handlerTable, 11, func(_ context.Context, _ *typedTestMessage) (*typedTestMessage, error) {
+		return nil, &ErrorFrame{Code: ErrorBusy, Message: "try again later"}
+	}))
+	require.NoError(t, RegisterTyped(handlerTable, 12, func(_ context.Context, _ *typedTestMessage) (*typedTestMessage, error) {
+		return nil, errors.New("handler failed")
+	}))
+	require.NoError(t, RegisterTyped(handlerTable, 13, func(_ context.Context, _ *typedTestMessage) (*typedTestMessage, error) {
+		<-unblock
+		return nil, nil
+	}))
+	assert.ErrorIs(t, RegisterTyped(handlerTable, PING, func(_ context.Context, _ *typedTestMessage) (*typedTestMessage, error) {
+		return nil, nil
+	}), InvalidOperation)
+
+	s, err := NewServer(handlerTable, context.Background(), WithLogger(emptyLogger))
+	require.NoError(t, err)
+	s.SetConcurrency(0)
+
+	c, err := NewClient(HandlerTable{}, context.Background(), WithLogger(emptyLogger))
+	require.NoError(t, err)
+
+	serverConn, clientConn, err := pair.New()
+	require.NoError(t, err)
+	go s.ServeConn(serverConn)
+	require.NoError(t, c.FromConn(clientConn))
+
+	resp, err := CallTyped[typedTestMessage, typedTestMessage](context.Background(), c, 10, &typedTestMessage{Value: "hello"})
+	require.NoError(t, err)
+	assert.Equal(t, "HELLO", resp.Value)
+
+	// Concurrent calls each receive their own response
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			value := fmt.Sprintf("value-%d", i)
+			resp, err := CallTyped[typedTestMessage, typedTestMessage](context.Background(), c, 10, &typedTestMessage{Value: value})
+			if assert.NoError(t, err) {
+				assert.Equal(t, strings.ToUpper(value), resp.Value)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	var errorFrame *ErrorFrame
+	_, err = CallTyped[typedTestMessage, typedTestMessage](context.Background(), c, 11, &typedTestMessage{})
+	require.ErrorAs(t, err, &errorFrame)
+	assert.Equal(t, ErrorBusy, errorFrame.Code)
+	assert.Equal(t, "try again later", errorFrame.Message)
+
+	_, err = CallTyped[typedTestMessage, typedTestMessage](context.Background(), c, 12, &typedTestMessage{})
+	require.ErrorAs(t, err, &errorFrame)
+	assert.Equal(t, ErrorUnknown, errorFrame.Code)
+	assert.Equal(t, "handler failed", errorFrame.Message)
+
+	// Content that cannot be decoded is rejected without calling the handler
+	p := packet.Get()
+	p.Metadata.Operation = 10
+	p.Content.Write([]byte{0xFF})
+	p.Metadata.ContentLength = 1
+	_, err = c.Call(context.Background(), p)
+	packet.Put(p)
+	require.ErrorAs(t, err, &errorFrame)
+	assert.Equal(t, ErrorInvalidRequest, errorFrame.Code)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	_, err = CallTyped[typedTestMessage, typedTestMessage](ctx, c, 13, &typedTestMessage{})
+	cancel()
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	close(unblock)
+
+	require.NoError(t, c.Close())
+	_, err = CallTyped[typedTestMessage, typedTestMessage](context.Background(), c, 10, &typedTestMessage{})
+	assert.ErrorIs(t, err, ConnectionClosed)
+	require.NoError(t, s.Shutdown())
+}
@@ -0,0 +1,528 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package frisbee
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+var (
+	InvalidUpstreamSize = errors.New("upstream size must be greater than 0")
+	UpstreamClosed      = errors.New("upstream closed")
+	NoFreeIDs           = errors.New("no free packet IDs on upstream connection")
+	UpstreamBusy        = errors.New("too many packets are waiting for the upstream connection")
+	UpstreamTimeout     = errors.New("timed out waiting for the upstream response")
+)
+
+// DefaultUpstreamTimeout is the default amount of time that an Upstream waits for the
+// response to a forwarded packet before it stops waiting
+const DefaultUpstreamTimeout = time.Second * 30
+
+const (
+	// oneWayID is the packet ID that one-way packets are forwarded with (see Upstream.SetOneWay),
+	// which is never used for packets that are waiting for a response
+	oneWayID = math.MaxUint16
+
+	// maxUpstreamQueued is the maximum number of packets that wait for an upstream connection to be opened
+	maxUpstreamQueued = 1 << 10
+)
+
+// Upstream is a pool of Clients connected to the same upstream frisbee Server, used by a Proxy to forward packets.
+//
+// Connections are opened lazily, and every connection that was proxied to the Upstream is pinned to one of the
+// Clients in the pool so that the order of its packets is preserved. If a Client's connection closes, it is
+// replaced the next time a packet is forwarded to it. Packets that are forwarded while a Client is connecting
+// are queued until it has connected, so proxied connections never wait for the upstream server themselves.
+type Upstream struct {
+	addr    string
+	ctx     context.Context
+	options []Option
+	timeout time.Duration
+	oneWay  []proxyRoute
+	pool    []*upstreamConn
+	closed  atomic.Bool
+	wg      sync.WaitGroup
+}
+
+// pendingPacket is a packet that was forwarded to an Upstream and is waiting for a response
+type pendingPacket struct {
+	client  *Client
+	conn    *Async
+	id      uint16
+	expires time.Time
+}
+
+// queuedPacket is a packet that is waiting for an upstream connection to be opened
+type queuedPacket struct {
+	conn     *Async
+	incoming *packet.Packet
+}
+
+// upstreamConn is a single Client in an Upstream's pool
+type upstreamConn struct {
+	upstream *Upstream
+	mu       sync.Mutex
+	client   *Client
+
+	// connecting is closed once the connection that is being opened has connected (or failed to), and
+	// is nil when no connection is being opened. While it is not nil, forwarded packets are queued.
+	connecting chan struct{}
+	connectErr error
+	queued     []queuedPacket
+
+	pending map[uint16]pendingPacket
+	// expired holds the IDs of packets that stopped waiting for a response, and when they can be used again,
+	// so that late responses are discarded instead of being matched with newer packets
+	expired      map[uint16]time.Time
+	nextID       uint16
+	streams      map[uint16]struct{}
+	nextStreamID uint16
+}
+
+// NewUpstream returns an Upstream with a pool of size Clients that connect to the frisbee Server at addr
+// using the given options. The Clients are canceled when ctx is.
+func NewUpstream(ctx context.Context, addr string, size int, opts ...Option) (*Upstream, error) {
+	if size < 1 {
+		return nil, InvalidUpstreamSize
+	}
+	u := &Upstream{
+		addr:    addr,
+		ctx:     ctx,
+		options: opts,
+		timeout: DefaultUpstreamTimeout,
+		pool:    make([]*upstreamConn, size),
+	}
+	for i := range u.pool {
+		u.pool[i] = &upstreamConn{
+			upstream: u,
+			pending:  make(map[uint16]pendingPacket),
+			expired:  make(map[uint16]time.Time),
+			streams:  make(map[uint16]struct{}),
+		}
+	}
+	return u, nil
+}
+
+// SetTimeout sets how long the Upstream waits for the response to a forwarded packet before it stops
+// waiting. The downstream connection is sent an ERROR packet with the ErrorUpstreamTimeout code in place of
+// the response, and a response that arrives after the timeout is discarded. The packet's upstream ID is not reused for another timeout, so that a late response cannot be mistaken for the response
+// to a newer packet. If timeout is not greater than 0, DefaultUpstreamTimeout is used.
+//
+// This function should not be called once the proxy has started.
+func (u *Upstream) SetTimeout(timeout time.Duration) {
+	if timeout <= 0 {
+		timeout = DefaultUpstreamTimeout
+	}
+	u.timeout = timeout
+}
+
+// SetOneWay forwards the packets whose operations are between first and last (inclusive) without waiting for
+// a response, for operations that the upstream server never responds to. They do not use up upstream packet IDs,
+// and anything that the upstream server sends back for them is discarded.
+//
+// This function should not be called once the proxy has started.
+func (u *Upstream) SetOneWay(first uint16, last uint16) error {
+	if first <= RESERVED9 || first > last {
+		return InvalidRoute
+	}
+	u.oneWay = append(u.oneWay, proxyRoute{first: first, last: last})
+	return nil
+}
+
+// Close closes every Client in the Upstream's pool. Packets that are waiting for
+// a response receive an ERROR packet with the ErrorUpstreamClosed code.
+func (u *Upstream) Close() error {
+	if !u.closed.CompareAndSwap(false, true) {
+		return UpstreamClosed
+	}
+	for _, uc := range u.pool {
+		uc.mu.Lock()
+		client := uc.client
+		uc.mu.Unlock()
+		if client != nil {
+			_ = client.Close()
+		}
+	}
+	u.wg.Wait()
+	return nil
+}
+
+// pick returns the connection in the pool that the given proxied connection is pinned to
+func (u *Upstream) pick(conn *Async) *upstreamConn {
+	return u.pool[conn.ID()%uint64(len(u.pool))]
+}
+
+// isOneWay returns whether packets with the given operation are forwarded without waiting for a response
+func (u *Upstream) isOneWay(operation uint16) bool {
+	for _, r := range u.oneWay {
+		if operation >= r.first && operation <= r.last {
+			return true
+		}
+	}
+	return false
+}
+
+// forward sends the incoming packet from the proxied connection to the Upstream, and takes ownership of it.
+// If the upstream connection is being opened, the packet is queued until it has connected.
+func (u *Upstream) forward(conn *Async, incoming *packet.Packet) {
+	uc := u.pick(conn)
+
+	uc.mu.Lock()
+	if u.closed.Load() {
+		uc.mu.Unlock()
+		id := incoming.Metadata.Id
+		packet.Put(incoming)
+		_ = conn.WriteError(id, ErrorUpstreamUnavailable, UpstreamClosed.Error())
+		return
+	}
+	if uc.connecting == nil && uc.client != nil && !uc.client.Closed() {
+		client := uc.client
+		uc.mu.Unlock()
+		uc.send(client, conn, incoming)
+		return
+	}
+	if len(uc.queued) >= maxUpstreamQueued {
+		uc.mu.Unlock()
+		id := incoming.Metadata.Id
+		packet.Put(incoming)
+		_ = conn.WriteError(id, ErrorTooManyRequests, UpstreamBusy.Error())
+		return
+	}
+	uc.queued = append(uc.queued, queuedPacket{conn: conn, incoming: incoming})
+	uc.startConnecting()
+	uc.mu.Unlock()
+}
+
+// send writes the incoming packet from the proxied connection to the given Client with a new packet ID,
+// and waits for its response unless it is one-way. It takes ownership of the packet.
+func (uc *upstreamConn) send(client *Client, conn *Async, incoming *packet.Packet) {
+	id := incoming.Metadata.Id
+	if uc.upstream.isOneWay(incoming.Metadata.Operation) {
+		incoming.Metadata.Id = oneWayID
+		err := client.WritePacket(incoming)
+		packet.Put(incoming)
+		if err != nil {
+			_ = conn.WriteError(id, ErrorUpstreamUnavailable, err.Error())
+		}
+		return
+	}
+
+	uc.mu.Lock()
+	upstreamID, err := uc.allocate()
+	if err != nil {
+		uc.mu.Unlock()
+		packet.Put(incoming)
+		_ = conn.WriteError(id, ErrorTooManyRequests, err.Error())
+		return
+	}
+	uc.pending[upstreamID] = pendingPacket{
+		client:  client,
+		conn:    conn,
+		id:      id,
+		expires: time.Now().Add(uc.upstream.timeout),
+	}
+	uc.mu.Unlock()
+
+	incoming.Metadata.Id = upstreamID
+	err = client.WritePacket(incoming)
+	packet.Put(incoming)
+	if err != nil {
+		// If the connection closed, the pending packet may already have received an ERROR packet
+		uc.mu.Lock()
+		pending, ok := uc.pending[upstreamID]
+		if ok && pending.conn == conn && pending.client == client {
+			delete(uc.pending, upstreamID)
+		} else {
+			ok = false
+		}
+		uc.mu.Unlock()
+		if ok {
+			_ = conn.WriteError(id, ErrorUpstreamUnavailable, err.Error())
+		}
+	}
+}
+
+// forwardStream maps the stream from the proxied connection to a new stream on the Upstream, and copies
+// packets in both directions until one of them is closed. It takes ownership of the first packet.
+func (u *Upstream) forwardStream(stream *Stream, first *packet.Packet) {
+	uc := u.pick(stream.Conn())
+
+	client, err := uc.wait()
+	if err != nil {
+		packet.Put(first)
+		_ = stream.Close()
+		return
+	}
+	uc.mu.Lock()
+	streamID, err := uc.allocateStream()
+	if err != nil {
+		uc.mu.Unlock()
+		packet.Put(first)
+		_ = stream.Close()
+		return
+	}
+	uc.streams[streamID] = struct{}{}
+	uc.mu.Unlock()
+
+	upstreamStream := client.Stream(streamID)
+	err = upstreamStream.WritePacket(first)
+	packet.Put(first)
+	if err == nil {
+		done := make(chan struct{})
+		go func() {
+			pipeStream(stream, upstreamStream)
+			close(done)
+		}()
+		pipeStream(upstreamStream, stream)
+		<-done
+	} else {
+		_ = upstreamStream.Close()
+		_ = stream.Close()
+	}
+
+	uc.mu.Lock()
+	delete(uc.streams, streamID)
+	uc.mu.Unlock()
+}
+
+// pipeStream copies packets from src to dst until either of them is closed, and then closes both
+func pipeStream(dst *Stream, src *Stream) {
+	for {
+		p, err := src.ReadPacket()
+		if err != nil {
+			_ = dst.Close()
+			return
+		}
+		err = dst.WritePacket(p)
+		packet.Put(p)
+		if err != nil {
+			_ = src.Close()
+			return
+		}
+	}
+}
+
+// wait returns the connection's Client, and waits for a new one to connect if there is none or it has been closed
+func (uc *upstreamConn) wait() (*Client, error) {
+	uc.mu.Lock()
+	if uc.upstream.closed.Load() {
+		uc.mu.Unlock()
+		return nil, UpstreamClosed
+	}
+	if uc.connecting == nil && uc.client != nil && !uc.client.Closed() {
+		client := uc.client
+		uc.mu.Unlock()
+		return client, nil
+	}
+	connecting := uc.startConnecting()
+	uc.mu.Unlock()
+
+	<-connecting
+	uc.mu.Lock()
+	defer uc.mu.Unlock()
+	if uc.connectErr != nil {
+		return nil, uc.connectErr
+	}
+	if uc.client == nil {
+		return nil, UpstreamClosed
+	}
+	return uc.client, nil
+}
+
+// startConnecting starts connecting a new Client if one is not already being connected,
+// and returns a channel that is closed once it has connected (or failed to).
+//
+// The connection's lock must be held when calling this function.
+func (uc *upstreamConn) startConnecting() chan struct{} {
+	if uc.connecting == nil {
+		uc.connecting = make(chan struct{})
+		uc.upstream.wg.Add(1)
+		go uc.connect()
+	}
+	return uc.connecting
+}
+
+// connect connects a new Client without holding the connection's lock, and then sends the packets that were
+// queued while it was connecting (or sends an ERROR packet for each of them if it could not connect)
+func (uc *upstreamConn) connect() {
+	defer uc.upstream.wg.Done()
+
+	client, err := uc.dial()
+
+	uc.mu.Lock()
+	if err == nil && uc.upstream.closed.Load() {
+		// The Upstream was closed while connecting, and did not see the new Client
+		_ = client.Close()
+		client, err = nil, UpstreamClosed
+	}
+	uc.connectErr = err
+	if err != nil {
+		queued := uc.queued
+		uc.queued = nil
+		close(uc.connecting)
+		uc.connecting = nil
+		uc.mu.Unlock()
+		for _, q := range queued {
+			id := q.incoming.Metadata.Id
+			packet.Put(q.incoming)
+			_ = q.conn.WriteError(id, ErrorUpstreamUnavailable, err.Error())
+		}
+		return
+	}
+	uc.client = client
+	uc.upstream.wg.Add(1)
+	go uc.watch(client)
+
+	// Packets are still queued until the queue is empty, so that they are sent in order
+	for len(uc.queued) > 0 {
+		queued := uc.queued
+		uc.queued = nil
+		uc.mu.Unlock()
+		for _, q := range queued {
+			uc.send(client, q.conn, q.incoming)
+		}
+		uc.mu.Lock()
+	}
+	close(uc.connecting)
+	uc.connecting = nil
+	uc.mu.Unlock()
+}
+
+// dial returns a new Client that is connected to the upstream server
+func (uc *upstreamConn) dial() (*Client, error) {
+	client, err := NewClient(make(HandlerTable), uc.upstream.ctx, uc.upstream.options...)
+	if err != nil {
+		return nil, err
+	}
+	client.intercept = func(p *packet.Packet) bool {
+		return uc.handleResponse(client, p)
+	}
+	// Incoming stream packets are discarded by connections without a stream handler,
+	// even for streams that were opened locally, so streams opened by the upstream are closed instead
+	err = client.Connect(uc.upstream.addr, func(s *Stream) {
+		_ = s.Close()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// allocate returns a packet ID that is not being used by a pending packet, and
+// that was not used by a packet whose response may still arrive.
+//
+// The connection's lock must be held when calling this function.
+func (uc *upstreamConn) allocate() (uint16, error) {
+	// One ID is reserved for one-way packets
+	if len(uc.pending)+len(uc.expired) >= math.MaxUint16 {
+		return 0, NoFreeIDs
+	}
+	for {
+		id := uc.nextID
+		uc.nextID++
+		if id == oneWayID {
+			continue
+		}
+		if _, ok := uc.pending[id]; ok {
+			continue
+		}
+		if _, ok := uc.expired[id]; ok {
+			continue
+		}
+		return id, nil
+	}
+}
+
+// allocateStream returns a stream ID that is not being used by a proxied stream.
+//
+// The connection's lock must be held when calling this function.
+func (uc *upstreamConn) allocateStream() (uint16, error) {
+	if len(uc.streams) > math.MaxUint16 {
+		return 0, NoFreeIDs
+	}
+	for {
+		id := uc.nextStreamID
+		uc.nextStreamID++
+		if _, ok := uc.streams[id]; !ok {
+			return id, nil
+		}
+	}
+}
+
+// handleResponse is the Client's intercept function, and forwards responses back to the proxied connection
+// that is waiting for them. Packets that nothing is waiting for are discarded.
+func (uc *upstreamConn) handleResponse(client *Client, p *packet.Packet) bool {
+	uc.mu.Lock()
+	pending, ok := uc.pending[p.Metadata.Id]
+	if ok && pending.client == client {
+		delete(uc.pending, p.Metadata.Id)
+	} else {
+		ok = false
+	}
+	uc.mu.Unlock()
+
+	if ok && (p.Metadata.Operation > RESERVED9 || p.Metadata.Operation == ERROR) {
+		p.Metadata.Id = pending.id
+		_ = pending.conn.writePacket(p, true)
+	}
+	packet.Put(p)
+	return true
+}
+
+// watch expires pending packets until the client is closed, and then sends an ERROR packet for every
+// packet that is still pending on it
+func (uc *upstreamConn) watch(client *Client) {
+	defer uc.upstream.wg.Done()
+
+	ticker := time.NewTicker(uc.upstream.timeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-client.CloseChannel():
+			// Make sure the client's goroutines are stopped, since its connection may have been closed underneath it
+			_ = client.Close()
+			var closed []pendingPacket
+			uc.mu.Lock()
+			if uc.client == client {
+				uc.client = nil
+			}
+			for id, p := range uc.pending {
+				if p.client == client {
+					delete(uc.pending, id)
+					closed = append(closed, p)
+				}
+			}
+			uc.mu.Unlock()
+			for _, p := range closed {
+				_ = p.conn.WriteError(p.id, ErrorUpstreamClosed, UpstreamClosed.Error())
+			}
+			return
+		case now := <-ticker.C:
+			var timedOut []pendingPacket
+			uc.mu.Lock()
+			for id, p := range uc.pending {
+				if p.client == client && now.After(p.expires) {
+					delete(uc.pending, id)
+					uc.expired[id] = now.Add(uc.upstream.timeout)
+					timedOut = append(timedOut, p)
+				}
+			}
+			for id, reusable := range uc.expired {
+				if now.After(reusable) {
+					delete(uc.expired, id)
+				}
+			}
+			uc.mu.Unlock()
+			for _, p := range timedOut {
+				_ = p.conn.WriteError(p.id, ErrorUpstreamTimeout, UpstreamTimeout.Error())
+			}
+		}
+	}
+}